@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/illegalcall/viper-client/docs"
@@ -15,8 +18,10 @@ import (
 	"github.com/illegalcall/viper-client/internal/middleware"
 	"github.com/illegalcall/viper-client/internal/relay"
 	"github.com/illegalcall/viper-client/internal/rpc"
+	"github.com/illegalcall/viper-client/internal/secrets"
 	"github.com/illegalcall/viper-client/internal/stats"
 	"github.com/illegalcall/viper-client/internal/utils"
+	"github.com/lib/pq"
 	"go.uber.org/zap"
 )
 
@@ -32,35 +37,218 @@ func main() {
 	}
 	defer logger.Sync()
 
-	// Connect to the database
-	database, err := db.New(config.DatabaseURL)
-	if err != nil {
-		logger.Fatal("Failed to connect to database",
-			zap.Error(err),
-			zap.String("database_url", config.DatabaseURL))
-	}
-	defer database.Close()
+	// Connect to the database, unless the gateway runs from an endpoints file alone
+	var database *db.DB
+	var sqlDB *sql.DB
+	if config.DatabaseURL != "" {
+		database, err = db.New(config.DatabaseURL)
+		if err != nil {
+			logger.Fatal("Failed to connect to database",
+				zap.Error(err),
+				zap.String("database_url", config.DatabaseURL))
+		}
+		defer database.Close()
+		sqlDB = database.DB
 
-	// Run migrations
-	if err := database.MigrateDB(""); err != nil {
-		logger.Fatal("Failed to run migrations",
-			zap.Error(err))
+		// Run migrations
+		if err := database.MigrateDB(""); err != nil {
+			logger.Fatal("Failed to run migrations",
+				zap.Error(err))
+		}
+		logger.Info("Database migrations completed successfully")
+	} else {
+		logger.Info("Running without a database, relaying for the apps of the endpoints file",
+			zap.String("endpoints_file", config.EndpointsFile))
 	}
-	logger.Info("Database migrations completed successfully")
 
 	// Initialize services
-	appsService := apps.NewService(database.DB)
-	statsService := stats.NewService(database.DB)
-	chainsService := chains.NewService(database.DB)
+	appsService := apps.NewService(sqlDB)
+	statsService := stats.NewService(sqlDB)
+	chainsService := chains.NewService(sqlDB)
 
-	// Initialize RPC components
-	endpointManager := rpc.NewDBEndpointManager(database.DB)
+	// Initialize RPC components, reading chains and endpoints from the endpoints file when one is set
+	var endpointStore rpc.EndpointStore
+	var dbEndpointManager *rpc.DBEndpointManager
+	var fileEndpointManager *rpc.FileEndpointManager
+	if config.EndpointsFile != "" {
+		fileEndpointManager = rpc.NewFileEndpointManager(config.EndpointsFile)
+		fileEndpointManager.SetExcludeFailedProbes(config.ExcludeFailedProbes)
+		if err := fileEndpointManager.Reload(); err != nil {
+			logger.Fatal("Failed to load endpoints file", zap.Error(err))
+		}
+		endpointStore = fileEndpointManager
+	} else {
+		dbEndpointManager = rpc.NewDBEndpointManager(sqlDB)
+		dbEndpointManager.SetExcludeFailedProbes(config.ExcludeFailedProbes)
+		if config.SecretsKey != "" {
+			secretsCipher, err := secrets.NewCipher(config.SecretsKey)
+			if err != nil {
+				logger.Fatal("Invalid endpoint secrets key", zap.Error(err))
+			}
+			dbEndpointManager.SetCipher(secretsCipher)
+			// Keys left in endpoint URLs would otherwise be stored and shown in plain text
+			if moved, err := dbEndpointManager.MoveURLCredentials(); err != nil {
+				logger.Warn("Failed to move credentials out of endpoint URLs", zap.Error(err))
+			} else if moved > 0 {
+				logger.Info("Moved credentials out of endpoint URLs", zap.Int("endpoints", moved))
+			}
+		}
+		endpointStore = dbEndpointManager
+	}
+	breakers := rpc.NewCircuitBreakers(config.Breaker)
+	syncTracker := rpc.NewSyncTracker(int64(config.MaxBlockLag))
+	for chainID, maxLag := range config.ChainMaxBlockLag {
+		syncTracker.SetChainMaxLag(chainID, int64(maxLag))
+	}
+	// Keep the active endpoints in memory instead of reading them from the database on every request
+	var activeEndpoints rpc.EndpointManager = endpointStore
+	var endpointCache *rpc.CachedEndpointManager
+	if dbEndpointManager != nil && !config.EndpointCacheDisabled {
+		endpointCache = rpc.NewCachedEndpointManager(dbEndpointManager)
+		endpointCache.SetTTL(config.EndpointCacheTTL)
+		activeEndpoints = endpointCache
+	}
+	endpointManager := rpc.NewSyncLagEndpointManager(
+		rpc.NewBreakerEndpointManager(activeEndpoints, breakers),
+		syncTracker,
+	)
+	geozoneRouter := rpc.NewGeozoneRouter(config.DefaultGeozone, config.GeozoneFallback)
 	rpcDispatcher := rpc.NewDispatcher(endpointManager)
+	rpcDispatcher.SetCircuitBreakers(breakers)
+	rpcDispatcher.SetGeozoneRouter(geozoneRouter)
+	rpcDispatcher.SetSyncTracker(syncTracker)
+	capabilityRouter := rpc.NewCapabilityRouter(endpointStore)
+	capabilityRouter.SetSyncTracker(syncTracker)
+	if err := capabilityRouter.Reload(); err != nil {
+		logger.Warn("Failed to load routing rules", zap.Error(err))
+	}
+	rpcDispatcher.SetCapabilityRouter(capabilityRouter)
+	chainAdapters := rpc.NewAdapterRegistry(endpointStore)
+	if err := chainAdapters.Reload(); err != nil {
+		logger.Warn("Failed to load chain protocols", zap.Error(err))
+	}
+	rpcDispatcher.SetAdapterRegistry(chainAdapters)
+	for _, chainID := range config.BroadcastChains {
+		rpcDispatcher.EnableBroadcast(chainID)
+	}
+	if config.SelectionStrategy != "" {
+		if err := rpcDispatcher.SetDefaultStrategy(config.SelectionStrategy); err != nil {
+			logger.Fatal("Invalid endpoint selection strategy", zap.Error(err))
+		}
+	}
+	if config.MaxAttempts > 0 {
+		rpcDispatcher.SetRetryPolicy(rpc.RetryPolicy{MaxAttempts: config.MaxAttempts})
+	}
+	rpcDispatcher.SetBatchLimits(config.MaxBatchSize, config.BatchChunkSize)
+	rpcDispatcher.SetMaxResponseSize(config.MaxResponseSize)
+	if len(config.StreamMethods) > 0 {
+		rpcDispatcher.SetStreamMethods(config.StreamMethods)
+	}
+	if !config.CacheDisabled {
+		responseCache := rpc.NewResponseCache(config.CacheSize)
+		for chainID, blockTime := range config.BlockTimes {
+			responseCache.SetBlockTime(chainID, blockTime)
+		}
+		rpcDispatcher.SetResponseCache(responseCache)
+	}
+	rpcDispatcher.SetCoalescing(!config.CoalescingDisabled)
+	// Usage, spend and broadcast outcomes are only kept with a database
+	var usageStore rpc.UsageStore
+	var spendTracker *rpc.SpendTracker
+	if dbEndpointManager != nil {
+		usageStore = dbEndpointManager
+		spendTracker = rpc.NewSpendTracker(dbEndpointManager)
+		rpcDispatcher.SetSpendTracker(spendTracker)
+		rpcDispatcher.SetBroadcastStore(dbEndpointManager)
+	}
+	endpointLimits := rpc.NewEndpointLimits(usageStore)
+	if config.RateLimitMaxWait > 0 {
+		endpointLimits.SetMaxWait(config.RateLimitMaxWait)
+	}
+	if err := endpointLimits.Load(); err != nil {
+		logger.Warn("Failed to load endpoint usage", zap.Error(err))
+	}
+	rpcDispatcher.SetEndpointLimits(endpointLimits)
+	if config.CostMaxLatency > 0 || config.CostMaxErrorRate > 0 {
+		costPolicy := rpc.DefaultCostPolicy()
+		if config.CostMaxLatency > 0 {
+			costPolicy.MaxLatency = config.CostMaxLatency
+		}
+		if config.CostMaxErrorRate > 0 {
+			costPolicy.MaxErrorRate = config.CostMaxErrorRate
+		}
+		rpcDispatcher.SetCostPolicy(costPolicy)
+	}
+	for chainID, strategy := range config.ChainStrategies {
+		if err := rpcDispatcher.SetChainStrategy(chainID, strategy); err != nil {
+			logger.Fatal("Invalid endpoint selection strategy",
+				zap.Int("chain_id", chainID),
+				zap.Error(err))
+		}
+	}
+	for chainID, methods := range config.HedgeMethods {
+		rpcDispatcher.SetHedgePolicy(chainID, rpc.HedgePolicy{
+			Methods:    methods,
+			Percentile: config.HedgePercentile,
+		})
+	}
 
-	relayService := relay.NewService(database.DB, appsService, rpcDispatcher)
+	// Start the background endpoint health checker
+	healthCtx, stopHealthChecks := context.WithCancel(context.Background())
+	defer stopHealthChecks()
+	healthChecker := rpc.NewHealthChecker(endpointStore, config.HealthCheckInterval)
+	healthChecker.SetSyncTracker(syncTracker)
+	healthChecker.SetAdapterRegistry(chainAdapters)
+	go healthChecker.Start(healthCtx)
+	go capabilityRouter.Start(healthCtx, config.RoutingRefresh)
+	go chainAdapters.Start(healthCtx, config.RoutingRefresh)
+	go endpointLimits.Start(healthCtx, config.UsageFlushInterval)
+	if spendTracker != nil {
+		go spendTracker.Start(healthCtx, config.SpendFlushInterval)
+	}
+	if fileEndpointManager != nil {
+		go fileEndpointManager.Start(healthCtx, config.EndpointsFileInterval)
+	}
+	if endpointCache != nil {
+		// Endpoint changes made in the database invalidate the cache through LISTEN/NOTIFY
+		listener := pq.NewListener(config.DatabaseURL, 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+			if err != nil {
+				logger.Warn("Endpoint change listener failed", zap.Error(err))
+			}
+		})
+		defer listener.Close()
+		if err := listener.Listen(rpc.EndpointChangesChannel); err != nil {
+			logger.Warn("Failed to listen for endpoint changes, relying on the cache TTL", zap.Error(err))
+		}
+		go endpointCache.Watch(healthCtx, listener.Notify)
+		go endpointCache.Start(healthCtx, config.HealthFlushInterval)
+	}
+
+	// Without a database, only the apps of the endpoints file may relay
+	var appStore relay.AppStore = appsService
+	if database == nil {
+		appStore = fileEndpointManager
+	}
+	relayService := relay.NewService(sqlDB, appStore, rpcDispatcher)
 
 	// Initialize Viper Network handler
 	viperNetworkHandler := rpc.NewViperNetworkHandler(endpointManager)
+	viperNetworkHandler.SetGeozoneRouter(geozoneRouter)
+	viperNetworkHandler.SetMaxResponseSize(config.MaxResponseSize)
+
+	// Resolve the chain relays through the Viper Network target from the chain mapping,
+	// adding the chains the network serves that are not mapped yet
+	viperChains := rpc.NewViperChainRegistry(endpointStore)
+	if err := viperChains.Reload(); err != nil {
+		logger.Warn("Failed to load Viper chains", zap.Error(err))
+	}
+	rpcDispatcher.SetViperChains(viperChains)
+	go func() {
+		if err := viperChains.Sync(healthCtx, viperNetworkHandler); err != nil {
+			logger.Warn("Failed to sync Viper chains", zap.Error(err))
+		}
+	}()
+	go viperChains.Start(healthCtx, config.RoutingRefresh)
 
 	// Configure default rate limits (requests per second and burst capacity)
 	defaultRateLimit := 30
@@ -100,6 +288,14 @@ func main() {
 	// @Failure 503 {object} api.ErrorResponse "Service is unhealthy"
 	// @Router /health [get]
 	router.GET("/health", func(c *gin.Context) {
+		if database == nil {
+			c.JSON(http.StatusOK, gin.H{
+				"status":  "success",
+				"message": "Service is healthy and running without a database",
+			})
+			return
+		}
+
 		// Test database connection
 		err := database.Ping()
 		if err != nil {
@@ -117,7 +313,7 @@ func main() {
 	})
 
 	// Initialize and register Viper Network handler
-	viperNetworkAPIHandler := api.NewViperNetworkHandler(viperNetworkHandler, appsService)
+	viperNetworkAPIHandler := api.NewViperNetworkHandler(viperNetworkHandler, appStore)
 	viperNetworkAPIHandler.RegisterRoutes(router)
 
 	// Initialize and register relay handler at root path
@@ -125,21 +321,50 @@ func main() {
 	relayGroup := router.Group("/")
 	relayHandler.RegisterRoutes(relayGroup)
 
-	// API routes - protected by Auto Authentication middleware
-	apiGroup := router.Group("/api")
-	apiGroup.Use(middleware.AutoAuthMiddleware(database))
+	// Initialize and register the WebSocket gateway next to the relay
+	webSocketHandler := api.NewWebSocketHandler(relayService, rpc.NewWSGateway(endpointManager))
+	webSocketHandler.RegisterRoutes(relayGroup)
+
+	// Routes managing users, apps and the gateway authenticate against the database
+	if database != nil {
+		// API routes - protected by Auto Authentication middleware
+		apiGroup := router.Group("/api")
+		apiGroup.Use(middleware.AutoAuthMiddleware(database))
+
+		// Initialize and register apps handler
+		appsHandler := api.NewAppsHandler(appsService)
+		appsHandler.RegisterRoutes(apiGroup)
 
-	// Initialize and register apps handler
-	appsHandler := api.NewAppsHandler(appsService)
-	appsHandler.RegisterRoutes(apiGroup)
+		// Initialize and register stats handler
+		statsHandler := api.NewStatsHandler(statsService)
+		statsHandler.RegisterRoutes(apiGroup)
 
-	// Initialize and register stats handler
-	statsHandler := api.NewStatsHandler(statsService)
-	statsHandler.RegisterRoutes(apiGroup)
+		// Initialize and register chains handler
+		chainsHandler := api.NewChainsHandler(chainsService)
+		chainsHandler.RegisterRoutes(apiGroup)
 
-	// Initialize and register chains handler
-	chainsHandler := api.NewChainsHandler(chainsService)
-	chainsHandler.RegisterRoutes(apiGroup)
+		// Internal routes - protected by Auto Authentication middleware
+		internalGroup := router.Group("/internal")
+		internalGroup.Use(middleware.AutoAuthMiddleware(database))
+
+		// Initialize and register internal RPC handler
+		rpcHandler := api.NewRPCHandler(rpcDispatcher, breakers)
+		rpcHandler.RegisterRoutes(internalGroup)
+
+		if dbEndpointManager != nil {
+			// Initialize and register endpoint credentials handler
+			endpointHandler := api.NewEndpointHandler(dbEndpointManager)
+			endpointHandler.RegisterRoutes(internalGroup)
+
+			// Initialize and register upstream spend handler
+			spendHandler := api.NewSpendHandler(dbEndpointManager)
+			spendHandler.RegisterRoutes(internalGroup)
+		}
+
+		// Initialize and register Viper chain mapping handler
+		viperChainHandler := api.NewViperChainHandler(viperChains, viperNetworkHandler)
+		viperChainHandler.RegisterRoutes(internalGroup)
+	}
 
 	// Sample protected endpoint
 	// @Summary Get user profile
@@ -1,11 +1,13 @@
 package api
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/illegalcall/viper-client/internal/apps"
+	"github.com/illegalcall/viper-client/internal/models"
 )
 
 // CreateAppRequest represents the request to create a new app
@@ -93,6 +95,15 @@ func (h *AppsHandler) createApp(c *gin.Context) {
 		Description    string   `json:"description"`
 		AllowedOrigins []string `json:"allowed_origins"`
 		AllowedChains  []int    `json:"allowed_chains" binding:"required"`
+		Geozone        string   `json:"geozone"`
+		// Number of endpoints consensus reads are sent to; 0 disables them
+		ConsensusEndpoints int `json:"consensus_endpoints"`
+		// Chain relays through the Viper Network target by default; 0 uses the default target
+		ViperChainID int `json:"viper_chain_id"`
+		// JSON-RPC methods the app may call, wildcards such as debug_* allowed; empty allows all
+		AllowedMethods []string `json:"allowed_methods"`
+		// JSON-RPC methods the app may never call, taking precedence over AllowedMethods
+		DeniedMethods []string `json:"denied_methods"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -103,14 +114,25 @@ func (h *AppsHandler) createApp(c *gin.Context) {
 	}
 
 	createReq := apps.CreateAppRequest{
-		UserID:         userID,
-		Name:           req.Name,
-		Description:    req.Description,
-		AllowedOrigins: req.AllowedOrigins,
-		AllowedChains:  req.AllowedChains,
+		UserID:             userID,
+		Name:               req.Name,
+		Description:        req.Description,
+		AllowedOrigins:     req.AllowedOrigins,
+		AllowedChains:      req.AllowedChains,
+		Geozone:            req.Geozone,
+		ConsensusEndpoints: req.ConsensusEndpoints,
+		ViperChainID:       req.ViperChainID,
+		AllowedMethods:     req.AllowedMethods,
+		DeniedMethods:      req.DeniedMethods,
 	}
 
 	result, err := h.appsService.CreateApp(createReq)
+	if errors.Is(err, models.ErrInvalidMethodPattern) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to create app: " + err.Error(),
@@ -261,7 +283,12 @@ func (h *AppsHandler) updateApp(c *gin.Context) {
 	// Update the app
 	updatedApp, err := h.appsService.UpdateApp(id, userID, req)
 	if err != nil {
-		if err.Error() == "app not found" {
+		if errors.Is(err, models.ErrInvalidMethodPattern) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		} else if err.Error() == "app not found" {
 			c.JSON(http.StatusNotFound, gin.H{
 				"error": "App not found",
 			})
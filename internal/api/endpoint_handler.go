@@ -0,0 +1,175 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/illegalcall/viper-client/internal/models"
+	"github.com/illegalcall/viper-client/internal/rpc"
+)
+
+// EndpointAuthStore reads and writes the encrypted upstream credentials of endpoints
+type EndpointAuthStore interface {
+	GetEndpointAuth(id int) (*models.EndpointAuth, error)
+	SetEndpointAuth(id int, auth *models.EndpointAuth) error
+}
+
+// EndpointAuthResponse represents the redacted upstream credentials of an endpoint
+// @Description Upstream credentials of an RPC endpoint with every secret value redacted
+type EndpointAuthResponse struct {
+	EndpointID int                  `json:"endpoint_id" example:"1"`
+	Auth       *models.EndpointAuth `json:"auth"`
+}
+
+// EndpointHandler manages the upstream credentials of RPC endpoints
+type EndpointHandler struct {
+	store EndpointAuthStore
+}
+
+// NewEndpointHandler creates a new handler for endpoint credentials
+func NewEndpointHandler(store EndpointAuthStore) *EndpointHandler {
+	return &EndpointHandler{
+		store: store,
+	}
+}
+
+// RegisterRoutes registers the endpoint credential routes
+func (h *EndpointHandler) RegisterRoutes(router *gin.RouterGroup) {
+	router.GET("/rpc/endpoints/:id/auth", h.getAuth)
+	router.PUT("/rpc/endpoints/:id/auth", h.setAuth)
+	router.DELETE("/rpc/endpoints/:id/auth", h.deleteAuth)
+}
+
+// getAuth returns the redacted upstream credentials of an endpoint
+// @Summary Get endpoint credentials
+// @Description Retrieves the upstream credentials configured for an RPC endpoint. Header and query parameter names are shown, every secret value is redacted.
+// @Tags RPC
+// @Accept json
+// @Produce json
+// @Param id path int true "Endpoint ID"
+// @Success 200 {object} EndpointAuthResponse "Redacted endpoint credentials"
+// @Failure 400 {object} ErrorResponse "Invalid endpoint ID"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 404 {object} ErrorResponse "Endpoint not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Security BearerAuth
+// @Router /internal/rpc/endpoints/{id}/auth [get]
+func (h *EndpointHandler) getAuth(c *gin.Context) {
+	id, ok := endpointID(c)
+	if !ok {
+		return
+	}
+
+	auth, err := h.store.GetEndpointAuth(id)
+	if err != nil {
+		endpointError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, EndpointAuthResponse{
+		EndpointID: id,
+		Auth:       auth,
+	})
+}
+
+// setAuth encrypts and stores the upstream credentials of an endpoint
+// @Summary Set endpoint credentials
+// @Description Replaces the upstream credentials sent to an RPC endpoint: custom headers, a bearer token, basic auth, query parameter keys and a key appended to the URL path. Credentials are stored encrypted and never returned.
+// @Tags RPC
+// @Accept json
+// @Produce json
+// @Param id path int true "Endpoint ID"
+// @Param auth body models.EndpointAuth true "Endpoint credentials"
+// @Success 200 {object} EndpointAuthResponse "Redacted endpoint credentials"
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 404 {object} ErrorResponse "Endpoint not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Security BearerAuth
+// @Router /internal/rpc/endpoints/{id}/auth [put]
+func (h *EndpointHandler) setAuth(c *gin.Context) {
+	id, ok := endpointID(c)
+	if !ok {
+		return
+	}
+
+	var auth models.EndpointAuth
+	if err := c.ShouldBindJSON(&auth); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request body",
+		})
+		return
+	}
+
+	if err := h.store.SetEndpointAuth(id, &auth); err != nil {
+		endpointError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, EndpointAuthResponse{
+		EndpointID: id,
+		Auth:       &auth,
+	})
+}
+
+// deleteAuth removes the upstream credentials of an endpoint
+// @Summary Delete endpoint credentials
+// @Description Removes the upstream credentials of an RPC endpoint
+// @Tags RPC
+// @Accept json
+// @Produce json
+// @Param id path int true "Endpoint ID"
+// @Success 200 {object} rpc.SuccessResponse "Credentials removed"
+// @Failure 400 {object} ErrorResponse "Invalid endpoint ID"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 404 {object} ErrorResponse "Endpoint not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Security BearerAuth
+// @Router /internal/rpc/endpoints/{id}/auth [delete]
+func (h *EndpointHandler) deleteAuth(c *gin.Context) {
+	id, ok := endpointID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.store.SetEndpointAuth(id, nil); err != nil {
+		endpointError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Endpoint credentials removed",
+	})
+}
+
+// endpointID parses the endpoint ID path parameter, answering with an error when it is invalid
+func endpointID(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid endpoint ID",
+		})
+		return 0, false
+	}
+	return id, true
+}
+
+// endpointError answers with the status matching a credential store error
+func endpointError(c *gin.Context, err error) {
+	switch err {
+	case sql.ErrNoRows:
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Endpoint not found",
+		})
+	case rpc.ErrNoSecretsKey:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to access endpoint credentials",
+		})
+	}
+}
@@ -1,12 +1,14 @@
 package api
 
 import (
+	"errors"
 	"io"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/illegalcall/viper-client/internal/relay"
+	"github.com/illegalcall/viper-client/internal/rpc"
 )
 
 // RelayHandler handles relay-related API requests
@@ -25,6 +27,8 @@ func NewRelayHandler(relayService *relay.Service) *RelayHandler {
 func (h *RelayHandler) RegisterRoutes(router *gin.RouterGroup) {
 	// Public route - requires API key in query params
 	router.POST("/relay", h.handleRelay)
+	// Relays through the Viper Network to the chain named in the path
+	router.POST("/relay/viper/:chainId", h.handleViperRelay)
 }
 
 // handleRelay handles the relay request
@@ -35,23 +39,21 @@ func (h *RelayHandler) RegisterRoutes(router *gin.RouterGroup) {
 // @Produce json
 // @Param api_key query string true "API Key"
 // @Param chain_id query int true "Chain ID"
-// @Param request body object true "RPC Request"
+// @Param geozone query string false "Preferred geozone (overrides the app's configured zone)"
+// @Param X-Geozone header string false "Preferred geozone (overrides the app's configured zone)"
+// @Param X-Cache-Bypass header bool false "Skip cached responses and query the upstream endpoint"
+// @Param X-Consensus header string false "Send reads to several endpoints and return the majority answer: true or the number of endpoints"
+// @Param request body object true "RPC Request: a JSON-RPC call or batch, or {\"path\": \"/cosmos/...\"} for Cosmos REST chains"
 // @Success 200 {object} relay.RelayResponse "RPC Response"
+// @Success 204 "Batch of notifications only"
 // @Failure 400 {object} ErrorResponse "Bad request"
 // @Failure 401 {object} ErrorResponse "Unauthorized"
 // @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 429 {object} ErrorResponse "Every endpoint of the chain is at its rate limit or quota"
 // @Failure 500 {object} ErrorResponse "Internal server error"
+// @Failure 502 {object} ErrorResponse "Upstream response too large"
 // @Router /api/relay [post]
 func (h *RelayHandler) handleRelay(c *gin.Context) {
-	// Get API key and chain ID from query parameters
-	apiKey := c.Query("api_key")
-	if apiKey == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "API key is required",
-		})
-		return
-	}
-
 	chainIDStr := c.Query("chain_id")
 	if chainIDStr == "" {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -69,6 +71,48 @@ func (h *RelayHandler) handleRelay(c *gin.Context) {
 		return
 	}
 
+	h.relay(c, chainID, 0)
+}
+
+// handleViperRelay handles a relay through the Viper Network to the chain named in the path
+// @Summary Relay RPC request through the Viper Network
+// @Description Forwards an RPC request through the Viper Network to the given chain. The app must be allowed to use the Viper Network chain.
+// @Tags Relay
+// @Accept json
+// @Produce json
+// @Param chainId path int true "Chain ID of the chain to relay to"
+// @Param api_key query string true "API Key"
+// @Param request body object true "RPC Request"
+// @Success 200 {object} relay.RelayResponse "RPC Response"
+// @Success 204 "Batch of notifications only"
+// @Failure 400 {object} ErrorResponse "Bad request or chain not served by the Viper Network"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /api/relay/viper/{chainId} [post]
+func (h *RelayHandler) handleViperRelay(c *gin.Context) {
+	viperChainID, err := strconv.Atoi(c.Param("chainId"))
+	if err != nil || viperChainID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid chain ID",
+		})
+		return
+	}
+
+	h.relay(c, rpc.ViperNetworkChainID, viperChainID)
+}
+
+// relay relays the request body to the chain, targeting viperChainID on the Viper Network when set
+func (h *RelayHandler) relay(c *gin.Context, chainID, viperChainID int) {
+	// Get API key from query parameters
+	apiKey := c.Query("api_key")
+	if apiKey == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "API key is required",
+		})
+		return
+	}
+
 	// Read request body
 	requestBody, err := io.ReadAll(c.Request.Body)
 	if err != nil {
@@ -78,15 +122,63 @@ func (h *RelayHandler) handleRelay(c *gin.Context) {
 		return
 	}
 
+	// Preferred geozone may come from a header or query parameter
+	geozone := c.GetHeader("X-Geozone")
+	if geozone == "" {
+		geozone = c.Query("geozone")
+	}
+
+	// Consensus may be requested with "true" or with the number of endpoints to ask
+	consensus, consensusEndpoints := false, 0
+	if header := c.GetHeader("X-Consensus"); header != "" {
+		if n, err := strconv.Atoi(header); err == nil {
+			consensus = n > 0
+			if n > 1 {
+				consensusEndpoints = n
+			}
+		} else {
+			consensus, _ = strconv.ParseBool(header)
+		}
+	}
+
 	// Create relay request
 	req := relay.RelayRequest{
 		APIKey:  apiKey,
 		ChainID: chainID,
 		Request: requestBody,
+		Geozone: geozone,
+		// Cached responses are skipped on request, e.g. to confirm a fresh chain head
+		BypassCache:        c.GetHeader("X-Cache-Bypass") == "true" || c.GetHeader("Cache-Control") == "no-cache",
+		Consensus:          consensus,
+		ConsensusEndpoints: consensusEndpoints,
+		ViperChainID:       viperChainID,
 	}
 
-	// Forward the request
-	response, err := h.relayService.Relay(c.Request.Context(), req)
+	// Forward the request, streaming large responses inside the usual envelope
+	streamed := false
+	response, err := h.relayService.RelayStream(c.Request.Context(), req, func() io.Writer {
+		streamed = true
+		c.Header("Content-Type", "application/json; charset=utf-8")
+		c.Status(http.StatusOK)
+		c.Writer.WriteString(`{"response":`)
+		return c.Writer
+	})
+	if streamed {
+		// The status is already sent, so a failed stream can only be cut short
+		if err != nil {
+			c.Error(err)
+			c.Abort()
+			return
+		}
+		c.Writer.WriteString("}")
+		return
+	}
+	if errors.Is(err, rpc.ErrInvalidViperParams) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
 	if err != nil {
 		switch err.Error() {
 		case "invalid API key":
@@ -97,6 +189,19 @@ func (h *RelayHandler) handleRelay(c *gin.Context) {
 			c.JSON(http.StatusForbidden, gin.H{
 				"error": "Chain not allowed for this app",
 			})
+		case rpc.ErrInvalidRequest.Error(), rpc.ErrInvalidRESTRequest.Error(), rpc.ErrBatchNotSupported.Error(),
+			rpc.ErrEmptyBatch.Error(), rpc.ErrBatchTooLarge.Error(), rpc.ErrUnknownViperChain.Error():
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+		case rpc.ErrResponseTooLarge.Error():
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error": err.Error(),
+			})
+		case rpc.ErrEndpointsRateLimited.Error():
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error": err.Error(),
+			})
 		default:
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": "Failed to relay request: " + err.Error(),
@@ -105,6 +210,12 @@ func (h *RelayHandler) handleRelay(c *gin.Context) {
 		return
 	}
 
+	// A batch made only of notifications gets no answer
+	if len(response.Response) == 0 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
 	// Return the response
 	c.JSON(http.StatusOK, response)
 }
@@ -0,0 +1,204 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/illegalcall/viper-client/internal/rpc"
+)
+
+// BreakersResponse represents the response for circuit breaker state
+// @Description Circuit breaker state of all RPC endpoints
+type BreakersResponse struct {
+	// Breaker state per endpoint
+	Breakers []rpc.BreakerStatus `json:"breakers"`
+}
+
+// ScoresResponse represents the response for endpoint scores
+// @Description Latency-aware scores of all RPC endpoints
+type ScoresResponse struct {
+	// Score per endpoint
+	Scores []rpc.EndpointScore `json:"scores"`
+}
+
+// HedgingResponse represents the response for hedged request counters
+// @Description Hedged request counters of all chains with hedging enabled
+type HedgingResponse struct {
+	// Counters per chain
+	Hedging []rpc.HedgeStats `json:"hedging"`
+}
+
+// CacheResponse represents the response for response cache counters
+// @Description Response cache counters
+type CacheResponse struct {
+	// Cache counters
+	Cache rpc.CacheStats `json:"cache"`
+}
+
+// LagResponse represents the response for endpoint sync lag
+// @Description Sync lag of all RPC endpoints with a known block height
+type LagResponse struct {
+	// Lag per endpoint
+	Lag []rpc.EndpointLag `json:"lag"`
+}
+
+// LimitsResponse represents the response for endpoint limits
+// @Description Outbound rate limits, monthly quotas and usage of all RPC endpoints
+type LimitsResponse struct {
+	// Limits and usage per endpoint
+	Limits []rpc.EndpointLimitStatus `json:"limits"`
+}
+
+// BroadcastResponse represents the response for transaction broadcast outcomes
+// @Description Per-endpoint outcomes of a broadcast transaction
+type BroadcastResponse struct {
+	// Outcome per endpoint
+	Outcomes []rpc.BroadcastOutcome `json:"outcomes"`
+}
+
+// RPCHandler exposes internal state of the RPC dispatching layer
+type RPCHandler struct {
+	dispatcher *rpc.Dispatcher
+	breakers   *rpc.CircuitBreakers
+}
+
+// NewRPCHandler creates a new handler for internal RPC routes
+func NewRPCHandler(dispatcher *rpc.Dispatcher, breakers *rpc.CircuitBreakers) *RPCHandler {
+	return &RPCHandler{
+		dispatcher: dispatcher,
+		breakers:   breakers,
+	}
+}
+
+// RegisterRoutes registers the internal RPC routes
+func (h *RPCHandler) RegisterRoutes(router *gin.RouterGroup) {
+	router.GET("/rpc/breakers", h.getBreakers)
+	router.GET("/rpc/scores", h.getScores)
+	router.GET("/rpc/hedging", h.getHedging)
+	router.GET("/rpc/cache", h.getCache)
+	router.GET("/rpc/lag", h.getLag)
+	router.GET("/rpc/limits", h.getLimits)
+	router.GET("/rpc/broadcasts/:txHash", h.getBroadcast)
+}
+
+// getBreakers returns the circuit breaker state of all endpoints
+// @Summary Get circuit breaker state
+// @Description Retrieves the circuit breaker state of every RPC endpoint that has received traffic
+// @Tags RPC
+// @Accept json
+// @Produce json
+// @Success 200 {object} BreakersResponse "Circuit breaker state"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Security BearerAuth
+// @Router /internal/rpc/breakers [get]
+func (h *RPCHandler) getBreakers(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"breakers": h.breakers.Status(),
+	})
+}
+
+// getScores returns the latency-aware score of all endpoints
+// @Summary Get endpoint scores
+// @Description Retrieves the EWMA latency, error rate and resulting selection score of every RPC endpoint that has served traffic
+// @Tags RPC
+// @Accept json
+// @Produce json
+// @Success 200 {object} ScoresResponse "Endpoint scores"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Security BearerAuth
+// @Router /internal/rpc/scores [get]
+func (h *RPCHandler) getScores(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"scores": h.dispatcher.EndpointScores(),
+	})
+}
+
+// getHedging returns the hedged request counters of all chains
+// @Summary Get hedging statistics
+// @Description Retrieves how many requests were eligible for hedging, how many extra upstream calls were made and how often the hedge won
+// @Tags RPC
+// @Accept json
+// @Produce json
+// @Success 200 {object} HedgingResponse "Hedging statistics"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Security BearerAuth
+// @Router /internal/rpc/hedging [get]
+func (h *RPCHandler) getHedging(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"hedging": h.dispatcher.HedgeStats(),
+	})
+}
+
+// getCache returns the response cache counters
+// @Summary Get response cache statistics
+// @Description Retrieves hit, miss, bypass and eviction counters of the JSON-RPC response cache
+// @Tags RPC
+// @Accept json
+// @Produce json
+// @Success 200 {object} CacheResponse "Response cache statistics"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Security BearerAuth
+// @Router /internal/rpc/cache [get]
+func (h *RPCHandler) getCache(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"cache": h.dispatcher.CacheStats(),
+	})
+}
+
+// getLag returns how far each endpoint trails its chain head
+// @Summary Get endpoint sync lag
+// @Description Retrieves the latest block height of every RPC endpoint, the chain head, the resulting lag and whether the endpoint is excluded from selection
+// @Tags RPC
+// @Accept json
+// @Produce json
+// @Success 200 {object} LagResponse "Endpoint sync lag"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Security BearerAuth
+// @Router /internal/rpc/lag [get]
+func (h *RPCHandler) getLag(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"lag": h.dispatcher.EndpointLags(),
+	})
+}
+
+// getLimits returns the outbound limits and usage of all endpoints
+// @Summary Get endpoint limits
+// @Description Retrieves the outbound rate limit, remaining burst, monthly quota and usage of every RPC endpoint that has seen traffic, and until when throttled endpoints are backed off
+// @Tags RPC
+// @Accept json
+// @Produce json
+// @Success 200 {object} LimitsResponse "Endpoint limits"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Security BearerAuth
+// @Router /internal/rpc/limits [get]
+func (h *RPCHandler) getLimits(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"limits": h.dispatcher.EndpointLimits(),
+	})
+}
+
+// getBroadcast returns which endpoints accepted a broadcast transaction
+// @Summary Get transaction broadcast outcomes
+// @Description Retrieves the outcome of submitting a transaction to each RPC endpoint when broadcast mode is enabled for its chain
+// @Tags RPC
+// @Accept json
+// @Produce json
+// @Param txHash path string true "Transaction hash"
+// @Success 200 {object} BroadcastResponse "Broadcast outcomes"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Security BearerAuth
+// @Router /internal/rpc/broadcasts/{txHash} [get]
+func (h *RPCHandler) getBroadcast(c *gin.Context) {
+	outcomes, err := h.dispatcher.BroadcastOutcomes(c.Param("txHash"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to retrieve broadcast outcomes: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"outcomes": outcomes,
+	})
+}
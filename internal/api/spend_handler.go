@@ -0,0 +1,147 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/illegalcall/viper-client/internal/rpc"
+)
+
+// spendDateFormat is the format of the days a spend report covers
+const spendDateFormat = "2006-01-02"
+
+// SpendStore lists the accumulated upstream spend
+type SpendStore interface {
+	ListEndpointSpend(from, to time.Time) ([]rpc.EndpointSpend, error)
+}
+
+// SpendTotal is the upstream spend of one endpoint or one app over a period
+// @Description Total requests and upstream cost of an RPC endpoint or an app
+type SpendTotal struct {
+	ID       int     `json:"id" example:"7"`
+	Name     string  `json:"name,omitempty" example:"My Wallet"`
+	Requests int64   `json:"requests" example:"120000"`
+	Cost     float64 `json:"cost" example:"14.25"`
+}
+
+// SpendResponse represents the upstream spend over a period
+// @Description Upstream spend per endpoint, per app and per endpoint and app
+type SpendResponse struct {
+	From       string              `json:"from" example:"2024-05-01"`
+	To         string              `json:"to" example:"2024-05-31"`
+	TotalCost  float64             `json:"total_cost" example:"1520.5"`
+	ByEndpoint []SpendTotal        `json:"by_endpoint"`
+	ByApp      []SpendTotal        `json:"by_app"`
+	Spend      []rpc.EndpointSpend `json:"spend"`
+}
+
+// SpendHandler reports what serving each app costs upstream
+type SpendHandler struct {
+	store SpendStore
+}
+
+// NewSpendHandler creates a new handler for upstream spend reports
+func NewSpendHandler(store SpendStore) *SpendHandler {
+	return &SpendHandler{
+		store: store,
+	}
+}
+
+// RegisterRoutes registers the spend routes
+func (h *SpendHandler) RegisterRoutes(router *gin.RouterGroup) {
+	router.GET("/rpc/spend", h.getSpend)
+}
+
+// getSpend returns the upstream spend per endpoint and app
+// @Summary Get upstream spend
+// @Description Retrieves the requests sent to each RPC endpoint and what the providers bill for them, in total per endpoint, per app and per endpoint and app. Requests not made on behalf of an app are reported under app 0.
+// @Tags RPC
+// @Accept json
+// @Produce json
+// @Param from query string false "First day of the period (YYYY-MM-DD), defaults to the start of the current month"
+// @Param to query string false "Last day of the period (YYYY-MM-DD), defaults to today"
+// @Success 200 {object} SpendResponse "Upstream spend"
+// @Failure 400 {object} ErrorResponse "Invalid date"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Security BearerAuth
+// @Router /internal/rpc/spend [get]
+func (h *SpendHandler) getSpend(c *gin.Context) {
+	now := time.Now().UTC()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	if fromStr := c.Query("from"); fromStr != "" {
+		parsed, err := time.Parse(spendDateFormat, fromStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid from date format. Use YYYY-MM-DD (e.g., 2025-01-02)",
+			})
+			return
+		}
+		from = parsed
+	}
+	if toStr := c.Query("to"); toStr != "" {
+		parsed, err := time.Parse(spendDateFormat, toStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid to date format. Use YYYY-MM-DD (e.g., 2025-01-02)",
+			})
+			return
+		}
+		to = parsed
+	}
+
+	spend, err := h.store.ListEndpointSpend(from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to retrieve upstream spend: " + err.Error(),
+		})
+		return
+	}
+
+	response := SpendResponse{
+		From:  from.Format(spendDateFormat),
+		To:    to.Format(spendDateFormat),
+		Spend: spend,
+	}
+	byEndpoint := make(map[int]*SpendTotal)
+	byApp := make(map[int]*SpendTotal)
+	for _, s := range spend {
+		response.TotalCost += s.Cost
+		addSpend(byEndpoint, s.EndpointID, s.Provider, s)
+		addSpend(byApp, s.AppID, s.AppName, s)
+	}
+	response.ByEndpoint = sortedSpend(byEndpoint)
+	response.ByApp = sortedSpend(byApp)
+
+	c.JSON(http.StatusOK, response)
+}
+
+// addSpend adds spend to the total kept under an ID
+func addSpend(totals map[int]*SpendTotal, id int, name string, spend rpc.EndpointSpend) {
+	total, ok := totals[id]
+	if !ok {
+		total = &SpendTotal{ID: id, Name: name}
+		totals[id] = total
+	}
+	total.Requests += spend.Requests
+	total.Cost += spend.Cost
+}
+
+// sortedSpend returns the totals ordered by decreasing cost
+func sortedSpend(totals map[int]*SpendTotal) []SpendTotal {
+	sorted := make([]SpendTotal, 0, len(totals))
+	for _, total := range totals {
+		sorted = append(sorted, *total)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Cost != sorted[j].Cost {
+			return sorted[i].Cost > sorted[j].Cost
+		}
+		return sorted[i].ID < sorted[j].ID
+	})
+	return sorted
+}
@@ -67,6 +67,26 @@ type SwaggerCreateAppRequest struct {
 	// List of allowed blockchain chain IDs
 	// @example [1, 137, 56]
 	AllowedChains []int `json:"allowed_chains" binding:"required"`
+
+	// Preferred geozone for routing this app's requests
+	// @example "IND"
+	Geozone string `json:"geozone"`
+
+	// Number of endpoints every read is sent to for consensus; 0 disables consensus reads
+	// @example 3
+	ConsensusEndpoints int `json:"consensus_endpoints"`
+
+	// Chain relays through the Viper Network target by default; 0 uses the default target
+	// @example 137
+	ViperChainID int `json:"viper_chain_id"`
+
+	// JSON-RPC methods the app may call, wildcards allowed; empty allows every method
+	// @example ["eth_*", "net_version"]
+	AllowedMethods []string `json:"allowed_methods"`
+
+	// JSON-RPC methods the app may never call; takes precedence over allowed_methods
+	// @example ["debug_*", "trace_*"]
+	DeniedMethods []string `json:"denied_methods"`
 }
 
 // SwaggerCreateAppResponse represents the response for app creation
@@ -102,6 +122,26 @@ type UpdateAppRequest struct {
 	// List of allowed blockchain chain IDs
 	// @example [1, 137, 42161]
 	AllowedChains []int `json:"allowed_chains"`
+
+	// Preferred geozone for routing this app's requests
+	// @example "EU"
+	Geozone string `json:"geozone"`
+
+	// Number of endpoints every read is sent to for consensus; 0 disables consensus reads
+	// @example 3
+	ConsensusEndpoints int `json:"consensus_endpoints"`
+
+	// Chain relays through the Viper Network target by default; 0 restores the default target. Omit to keep the current chain
+	// @example 137
+	ViperChainID int `json:"viper_chain_id"`
+
+	// JSON-RPC methods the app may call, wildcards allowed; empty allows every method. Omit to keep the current list, [] clears it
+	// @example ["eth_*", "net_version"]
+	AllowedMethods []string `json:"allowed_methods"`
+
+	// JSON-RPC methods the app may never call; takes precedence over allowed_methods. Omit to keep the current list, [] clears it
+	// @example ["debug_*", "trace_*"]
+	DeniedMethods []string `json:"denied_methods"`
 }
 
 // AppResponse represents a standard app response
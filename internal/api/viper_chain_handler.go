@@ -0,0 +1,125 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/illegalcall/viper-client/internal/rpc"
+)
+
+// ViperChainsResponse represents the Viper Network chain mapping
+// @Description Viper Network chains and the chain each one serves
+type ViperChainsResponse struct {
+	// Viper chains ordered by identifier
+	Chains []rpc.ViperChain `json:"chains"`
+}
+
+// SetViperChainRequest represents a request to map a Viper Network chain
+// @Description Chain a Viper Network chain serves
+type SetViperChainRequest struct {
+	// Chain ID in chain_static, or null to unmap the Viper chain
+	ChainID *int `json:"chain_id" example:"2"`
+}
+
+// ViperChainHandler manages which chain each Viper Network chain serves
+type ViperChainHandler struct {
+	registry *rpc.ViperChainRegistry
+	network  *rpc.ViperNetworkHandler
+}
+
+// NewViperChainHandler creates a new handler for the Viper Network chain mapping
+func NewViperChainHandler(registry *rpc.ViperChainRegistry, network *rpc.ViperNetworkHandler) *ViperChainHandler {
+	return &ViperChainHandler{
+		registry: registry,
+		network:  network,
+	}
+}
+
+// RegisterRoutes registers the Viper chain routes
+func (h *ViperChainHandler) RegisterRoutes(router *gin.RouterGroup) {
+	router.GET("/rpc/viper-chains", h.getViperChains)
+	router.PUT("/rpc/viper-chains/:viperChain", h.setViperChain)
+	router.POST("/rpc/viper-chains/sync", h.syncViperChains)
+}
+
+// getViperChains returns the Viper Network chain mapping
+// @Summary Get Viper Network chains
+// @Description Retrieves the Viper Network chains and the chain each one serves
+// @Tags RPC
+// @Produce json
+// @Success 200 {object} ViperChainsResponse "Viper chains"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Security BearerAuth
+// @Router /internal/rpc/viper-chains [get]
+func (h *ViperChainHandler) getViperChains(c *gin.Context) {
+	c.JSON(http.StatusOK, ViperChainsResponse{
+		Chains: h.registry.Chains(),
+	})
+}
+
+// setViperChain maps a Viper Network chain to a chain
+// @Summary Map a Viper Network chain
+// @Description Sets the chain a Viper Network chain serves, adding the Viper chain when it is not known yet
+// @Tags RPC
+// @Accept json
+// @Produce json
+// @Param viperChain path string true "Viper Network chain identifier"
+// @Param request body SetViperChainRequest true "Chain served by the Viper chain"
+// @Success 200 {object} ViperChainsResponse "Viper chains"
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 409 {object} ErrorResponse "Chain served by another Viper chain"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Security BearerAuth
+// @Router /internal/rpc/viper-chains/{viperChain} [put]
+func (h *ViperChainHandler) setViperChain(c *gin.Context) {
+	var req SetViperChainRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request body",
+		})
+		return
+	}
+
+	err := h.registry.Map(c.Param("viperChain"), req.ChainID)
+	if errors.Is(err, rpc.ErrChainAlreadyMapped) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to map Viper chain: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, ViperChainsResponse{
+		Chains: h.registry.Chains(),
+	})
+}
+
+// syncViperChains adds the chains the Viper Network serves to the mapping
+// @Summary Sync Viper Network chains
+// @Description Queries the chains the Viper Network supports and adds the ones not known yet
+// @Tags RPC
+// @Produce json
+// @Success 200 {object} ViperChainsResponse "Viper chains"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 502 {object} ErrorResponse "Viper Network unavailable"
+// @Security BearerAuth
+// @Router /internal/rpc/viper-chains/sync [post]
+func (h *ViperChainHandler) syncViperChains(c *gin.Context) {
+	if err := h.registry.Sync(c.Request.Context(), h.network); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error": "Failed to sync Viper chains: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, ViperChainsResponse{
+		Chains: h.registry.Chains(),
+	})
+}
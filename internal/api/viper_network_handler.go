@@ -1,22 +1,27 @@
 package api
 
 import (
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
-	"github.com/illegalcall/viper-client/internal/apps"
+	"github.com/illegalcall/viper-client/internal/models"
+	"github.com/illegalcall/viper-client/internal/relay"
 	"github.com/illegalcall/viper-client/internal/rpc"
+	"golang.org/x/net/websocket"
 )
 
 // ViperNetworkHandler handles direct requests to the Viper Network
 type ViperNetworkHandler struct {
 	viperHandler *rpc.ViperNetworkHandler
-	appsService  *apps.Service
+	appsService  relay.AppStore
 }
 
 // NewViperNetworkHandler creates a new handler for Viper Network
-func NewViperNetworkHandler(viperHandler *rpc.ViperNetworkHandler, appsService *apps.Service) *ViperNetworkHandler {
+func NewViperNetworkHandler(viperHandler *rpc.ViperNetworkHandler, appsService relay.AppStore) *ViperNetworkHandler {
 	return &ViperNetworkHandler{
 		viperHandler: viperHandler,
 		appsService:  appsService,
@@ -61,27 +66,84 @@ func (h *ViperNetworkHandler) authenticate(c *gin.Context) {
 		}
 	}
 
-	// Validate API key
-	valid, err := h.appsService.ValidateAPIKey(apiKey)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to validate API key: " + err.Error(),
+	// Look up the app, whose method lists apply to every request
+	app, err := h.appsService.GetAppByAPIKey(apiKey)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Invalid API key",
 		})
 		c.Abort()
 		return
 	}
-
-	if !valid {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": "Invalid API key",
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to validate API key: " + err.Error(),
 		})
 		c.Abort()
 		return
 	}
 
+	c.Set("app", app)
 	c.Next()
 }
 
+// methodsAllowed reports whether the authenticated app may call every method, answering
+// with an error if it may not
+func methodsAllowed(c *gin.Context, methods []string) bool {
+	appObj, _ := c.Get("app")
+	app, ok := appObj.(*models.App)
+	if !ok {
+		return true
+	}
+	for _, method := range methods {
+		if !app.MethodAllowed(method) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "method not allowed for this app: " + method,
+			})
+			return false
+		}
+	}
+	return true
+}
+
+// viperMethods names the calls of a direct Viper Network request for the app's method lists.
+// Relays are named by the JSON-RPC methods they carry, any other request by its type, e.g.
+// viper_height.
+func viperMethods(requestType string, body []byte) []string {
+	fallback := []string{"viper_" + requestType}
+	if requestType != "relay" {
+		return fallback
+	}
+
+	var request rpc.ViperNetworkRequest
+	if err := json.Unmarshal(body, &request); err != nil || request.Data == "" {
+		return fallback
+	}
+	data := []byte(request.Data)
+
+	var calls []rpc.RPCRequest
+	if rpc.IsBatchRequest(data) {
+		if err := json.Unmarshal(data, &calls); err != nil {
+			return fallback
+		}
+	} else {
+		var call rpc.RPCRequest
+		if err := json.Unmarshal(data, &call); err != nil {
+			return fallback
+		}
+		calls = append(calls, call)
+	}
+
+	methods := make([]string, 0, len(calls))
+	for _, call := range calls {
+		if call.Method == "" {
+			return fallback
+		}
+		methods = append(methods, call.Method)
+	}
+	return methods
+}
+
 // Handler functions for different Viper Network endpoints
 
 func (h *ViperNetworkHandler) handleHeight(c *gin.Context) {
@@ -120,8 +182,30 @@ func (h *ViperNetworkHandler) handleChallenge(c *gin.Context) {
 	h.proxyViperRequest(c, "challenge")
 }
 
+// handleWebSocket upgrades the connection and proxies its messages to a Viper node's client websocket
 func (h *ViperNetworkHandler) handleWebSocket(c *gin.Context) {
-	h.proxyViperRequest(c, "websocket")
+	if !methodsAllowed(c, []string{"viper_websocket"}) {
+		return
+	}
+
+	// Honour an explicitly requested geozone
+	ctx := rpc.WithGeozone(c.Request.Context(), c.GetHeader("X-Geozone"))
+
+	upstream, err := h.viperHandler.DialWebSocket(ctx)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Failed to connect to the Viper Network: " + err.Error(),
+		})
+		return
+	}
+	defer upstream.Close()
+
+	server := websocket.Server{
+		Handler: func(client *websocket.Conn) {
+			rpc.ProxyWebSocket(client, upstream)
+		},
+	}
+	server.ServeHTTP(c.Writer, c.Request)
 }
 
 // proxyViperRequest handles forwarding the request to the Viper Network
@@ -140,8 +224,15 @@ func (h *ViperNetworkHandler) proxyViperRequest(c *gin.Context, requestType stri
 		body = []byte("{}")
 	}
 
+	if !methodsAllowed(c, viperMethods(requestType, body)) {
+		return
+	}
+
+	// Honour an explicitly requested geozone
+	ctx := rpc.WithGeozone(c.Request.Context(), c.GetHeader("X-Geozone"))
+
 	// Forward the request to the Viper Network
-	response, err := h.viperHandler.HandleViperRequest(c.Request.Context(), requestType, body)
+	response, err := h.viperHandler.HandleViperRequest(ctx, requestType, body)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to process Viper Network request: " + err.Error(),
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/illegalcall/viper-client/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// MockAppStore is a mock implementation of relay.AppStore
+type MockAppStore struct {
+	mock.Mock
+}
+
+func (m *MockAppStore) GetAppByAPIKey(apiKey string) (*models.App, error) {
+	args := m.Called(apiKey)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.App), args.Error(1)
+}
+
+func TestViperMethods(t *testing.T) {
+	assert.Equal(t, []string{"viper_height"}, viperMethods("height", []byte(`{}`)))
+	assert.Equal(t, []string{"eth_call"}, viperMethods("relay", []byte(`{"blockchain":"0021","data":"{\"jsonrpc\":\"2.0\",\"method\":\"eth_call\",\"id\":1}"}`)))
+	assert.Equal(t, []string{"eth_chainId", "debug_traceTransaction"}, viperMethods("relay", []byte(`{"data":"[{\"method\":\"eth_chainId\"},{\"method\":\"debug_traceTransaction\"}]"}`)))
+	assert.Equal(t, []string{"viper_relay"}, viperMethods("relay", []byte(`{"path":"/cosmos/bank/v1beta1/balances"}`)))
+}
+
+func TestViperNetworkHandler_EnforcesMethodLists(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	store := new(MockAppStore)
+	store.On("GetAppByAPIKey", "restricted").Return(&models.App{DeniedMethods: []string{"debug_*", "viper_dispatch"}}, nil)
+	store.On("GetAppByAPIKey", "unknown").Return(nil, sql.ErrNoRows)
+
+	handler := NewViperNetworkHandler(nil, store)
+	router := gin.New()
+	handler.RegisterRoutes(router)
+
+	tests := []struct {
+		name   string
+		path   string
+		apiKey string
+		body   string
+		status int
+	}{
+		{"unknown key", "/viper/height", "unknown", `{}`, http.StatusUnauthorized},
+		{"denied route", "/viper/dispatch", "restricted", `{}`, http.StatusForbidden},
+		{"denied relayed method", "/viper/relay", "restricted", `{"data":"{\"method\":\"debug_traceTransaction\",\"id\":1}"}`, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("X-API-Key", tt.apiKey)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.status, w.Code)
+		})
+	}
+	store.AssertExpectations(t)
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/illegalcall/viper-client/internal/relay"
+	"github.com/illegalcall/viper-client/internal/rpc"
+	"golang.org/x/net/websocket"
+)
+
+// WebSocketHandler handles WebSocket relay connections
+type WebSocketHandler struct {
+	relayService *relay.Service
+	gateway      *rpc.WSGateway
+}
+
+// NewWebSocketHandler creates a new WebSocket handler
+func NewWebSocketHandler(relayService *relay.Service, gateway *rpc.WSGateway) *WebSocketHandler {
+	return &WebSocketHandler{
+		relayService: relayService,
+		gateway:      gateway,
+	}
+}
+
+// RegisterRoutes registers the WebSocket routes
+func (h *WebSocketHandler) RegisterRoutes(router *gin.RouterGroup) {
+	// Public route - requires API key in query params, as browsers cannot set upgrade headers
+	router.GET("/ws", h.handleWebSocket)
+}
+
+// handleWebSocket upgrades the connection and relays it through the chain's WebSocket endpoints
+// @Summary Open a WebSocket relay connection
+// @Description Upgrades to a WebSocket connection relaying JSON-RPC calls, including eth_subscribe and eth_unsubscribe, to the chain's WebSocket endpoints. Subscriptions are renewed transparently when an upstream connection drops.
+// @Tags Relay
+// @Param api_key query string true "API Key"
+// @Param chain_id query int true "Chain ID"
+// @Success 101 {string} string "Switching Protocols"
+// @Failure 400 {object} ErrorResponse "Bad request"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 503 {object} ErrorResponse "No WebSocket endpoint available"
+// @Router /ws [get]
+func (h *WebSocketHandler) handleWebSocket(c *gin.Context) {
+	apiKey := c.Query("api_key")
+	if apiKey == "" {
+		apiKey = c.GetHeader("X-API-Key")
+	}
+	if apiKey == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "API key is required",
+		})
+		return
+	}
+
+	chainID, err := strconv.Atoi(c.Query("chain_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid chain ID",
+		})
+		return
+	}
+
+	app, err := h.relayService.Authorize(apiKey, chainID)
+	if err != nil {
+		switch err.Error() {
+		case "invalid API key":
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "Invalid API key",
+			})
+		case "chain not allowed for this app":
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "Chain not allowed for this app",
+			})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to authorize request: " + err.Error(),
+			})
+		}
+		return
+	}
+
+	session, err := h.gateway.Connect(c.Request.Context(), chainID)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Failed to connect upstream: " + err.Error(),
+		})
+		return
+	}
+	// Leaves the upstream even when the upgrade fails
+	defer session.Close()
+
+	server := websocket.Server{
+		Handler: func(conn *websocket.Conn) {
+			session.Serve(conn, app.MethodAllowed)
+		},
+	}
+	server.ServeHTTP(c.Writer, c.Request)
+}
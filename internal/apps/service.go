@@ -29,6 +29,14 @@ type CreateAppRequest struct {
 	Description    string   `json:"description,omitempty"`
 	AllowedOrigins []string `json:"allowed_origins,omitempty"`
 	AllowedChains  []int    `json:"allowed_chains,omitempty"`
+	Geozone        string   `json:"geozone,omitempty"`
+	// ConsensusEndpoints enables consensus reads across this many endpoints; 0 disables them
+	ConsensusEndpoints int `json:"consensus_endpoints,omitempty"`
+	// ViperChainID is the chain relays through the Viper Network target by default
+	ViperChainID int `json:"viper_chain_id,omitempty"`
+	// AllowedMethods and DeniedMethods restrict the JSON-RPC methods the app may call; wildcards such as debug_* are supported
+	AllowedMethods []string `json:"allowed_methods,omitempty"`
+	DeniedMethods  []string `json:"denied_methods,omitempty"`
 }
 
 // CreateAppResponse contains the data returned after creating a new app
@@ -52,6 +60,9 @@ func (s *Service) GenerateAPIKey() (string, error) {
 
 // CreateApp creates a new decentralized application
 func (s *Service) CreateApp(req CreateAppRequest) (*CreateAppResponse, error) {
+	if err := validateMethodLists(req.AllowedMethods, req.DeniedMethods); err != nil {
+		return nil, err
+	}
 
 	// Generate API key and hash
 	apiKey, err := s.GenerateAPIKey()
@@ -70,8 +81,9 @@ func (s *Service) CreateApp(req CreateAppRequest) (*CreateAppResponse, error) {
 	defer tx.Rollback()
 
 	query := `
-		INSERT INTO apps (api_key, user_id, name, description, allowed_origins, allowed_chains, rate_limit)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO apps (api_key, user_id, name, description, allowed_origins, allowed_chains, rate_limit, geozone, consensus_endpoints,
+		                  viper_chain_id, allowed_methods, denied_methods)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), $9, $10, COALESCE($11, '{}'), COALESCE($12, '{}'))
 		RETURNING id, created_at, updated_at
 	`
 
@@ -83,6 +95,11 @@ func (s *Service) CreateApp(req CreateAppRequest) (*CreateAppResponse, error) {
 	app.AllowedOrigins = req.AllowedOrigins
 	app.AllowedChains = models.IntArray(req.AllowedChains)
 	app.RateLimit = rateLimit
+	app.Geozone = req.Geozone
+	app.ConsensusEndpoints = req.ConsensusEndpoints
+	app.ViperChainID = req.ViperChainID
+	app.AllowedMethods = req.AllowedMethods
+	app.DeniedMethods = req.DeniedMethods
 
 	err = tx.QueryRow(
 		query,
@@ -93,6 +110,11 @@ func (s *Service) CreateApp(req CreateAppRequest) (*CreateAppResponse, error) {
 		pq.Array(app.AllowedOrigins),
 		app.AllowedChains,
 		app.RateLimit,
+		app.Geozone,
+		app.ConsensusEndpoints,
+		app.ViperChainID,
+		pq.Array(app.AllowedMethods),
+		pq.Array(app.DeniedMethods),
 	).Scan(&app.ID, &app.CreatedAt, &app.UpdatedAt)
 
 	if err != nil {
@@ -110,17 +132,27 @@ func (s *Service) CreateApp(req CreateAppRequest) (*CreateAppResponse, error) {
 	}, nil
 }
 
+// validateMethodLists rejects method lists holding patterns that could never match, which
+// would leave a deny list silently unenforced
+func validateMethodLists(allowed, denied []string) error {
+	if err := models.ValidateMethodPatterns(allowed); err != nil {
+		return err
+	}
+	return models.ValidateMethodPatterns(denied)
+}
+
 // GetApp retrieves an app by its ID
 func (s *Service) GetApp(id int) (*models.App, error) {
 	query := `
 		SELECT id, api_key, user_id, name, description, allowed_origins, allowed_chains, 
-		       rate_limit, created_at, updated_at
+		       rate_limit, geozone, consensus_endpoints, viper_chain_id, allowed_methods, denied_methods, created_at, updated_at
 		FROM apps
 		WHERE id = $1
 	`
 
 	var app models.App
 	var description sql.NullString
+	var geozone sql.NullString
 
 	err := s.db.QueryRow(query, id).Scan(
 		&app.ID,
@@ -131,6 +163,11 @@ func (s *Service) GetApp(id int) (*models.App, error) {
 		pq.Array(&app.AllowedOrigins),
 		&app.AllowedChains,
 		&app.RateLimit,
+		&geozone,
+		&app.ConsensusEndpoints,
+		&app.ViperChainID,
+		pq.Array(&app.AllowedMethods),
+		pq.Array(&app.DeniedMethods),
 		&app.CreatedAt,
 		&app.UpdatedAt,
 	)
@@ -145,6 +182,9 @@ func (s *Service) GetApp(id int) (*models.App, error) {
 	if description.Valid {
 		app.Description = description.String
 	}
+	if geozone.Valid {
+		app.Geozone = geozone.String
+	}
 
 	return &app, nil
 }
@@ -153,7 +193,7 @@ func (s *Service) GetApp(id int) (*models.App, error) {
 func (s *Service) GetAppsByUserID(userID int) ([]models.App, error) {
 	query := `
 		SELECT id, api_key, user_id, name, description, allowed_origins, allowed_chains, 
-		       rate_limit, created_at, updated_at
+		       rate_limit, geozone, consensus_endpoints, viper_chain_id, allowed_methods, denied_methods, created_at, updated_at
 		FROM apps
 		WHERE user_id = $1
 		ORDER BY created_at DESC
@@ -169,6 +209,7 @@ func (s *Service) GetAppsByUserID(userID int) ([]models.App, error) {
 	for rows.Next() {
 		var app models.App
 		var description sql.NullString
+		var geozone sql.NullString
 
 		err := rows.Scan(
 			&app.ID,
@@ -179,6 +220,11 @@ func (s *Service) GetAppsByUserID(userID int) ([]models.App, error) {
 			pq.Array(&app.AllowedOrigins),
 			&app.AllowedChains,
 			&app.RateLimit,
+			&geozone,
+			&app.ConsensusEndpoints,
+			&app.ViperChainID,
+			pq.Array(&app.AllowedMethods),
+			pq.Array(&app.DeniedMethods),
 			&app.CreatedAt,
 			&app.UpdatedAt,
 		)
@@ -189,6 +235,9 @@ func (s *Service) GetAppsByUserID(userID int) ([]models.App, error) {
 		if description.Valid {
 			app.Description = description.String
 		}
+		if geozone.Valid {
+			app.Geozone = geozone.String
+		}
 
 		apps = append(apps, app)
 	}
@@ -207,10 +256,22 @@ type UpdateAppRequest struct {
 	AllowedOrigins []string `json:"allowed_origins,omitempty"`
 	AllowedChains  []int    `json:"allowed_chains,omitempty"`
 	RateLimit      int      `json:"rate_limit,omitempty"`
+	Geozone        string   `json:"geozone,omitempty"`
+	// ConsensusEndpoints is a pointer so consensus reads can be switched off with 0
+	ConsensusEndpoints *int `json:"consensus_endpoints,omitempty"`
+	// ViperChainID is a pointer so the default Viper Network target can be restored with 0
+	ViperChainID *int `json:"viper_chain_id,omitempty"`
+	// AllowedMethods and DeniedMethods replace the app's method lists; an empty list clears one
+	AllowedMethods []string `json:"allowed_methods,omitempty"`
+	DeniedMethods  []string `json:"denied_methods,omitempty"`
 }
 
 // UpdateApp updates an existing app
 func (s *Service) UpdateApp(id int, userID int, req UpdateAppRequest) (*models.App, error) {
+	if err := validateMethodLists(req.AllowedMethods, req.DeniedMethods); err != nil {
+		return nil, err
+	}
+
 	// First check if the app exists and belongs to the user
 	app, err := s.GetApp(id)
 	if err != nil {
@@ -247,18 +308,45 @@ func (s *Service) UpdateApp(id int, userID int, req UpdateAppRequest) (*models.A
 		rateLimit = req.RateLimit
 	}
 
+	appGeozone := app.Geozone
+	if req.Geozone != "" {
+		appGeozone = req.Geozone
+	}
+
+	consensusEndpoints := app.ConsensusEndpoints
+	if req.ConsensusEndpoints != nil {
+		consensusEndpoints = *req.ConsensusEndpoints
+	}
+
+	viperChainID := app.ViperChainID
+	if req.ViperChainID != nil {
+		viperChainID = *req.ViperChainID
+	}
+
+	allowedMethods := app.AllowedMethods
+	if req.AllowedMethods != nil {
+		allowedMethods = req.AllowedMethods
+	}
+
+	deniedMethods := app.DeniedMethods
+	if req.DeniedMethods != nil {
+		deniedMethods = req.DeniedMethods
+	}
+
 	// Update the app
 	query := `
 		UPDATE apps
 		SET name = $1, description = $2, allowed_origins = $3, allowed_chains = $4, 
-		    rate_limit = $5, updated_at = NOW()
-		WHERE id = $6 AND user_id = $7
+		    rate_limit = $5, geozone = NULLIF($6, ''), consensus_endpoints = $7, viper_chain_id = $8,
+		    allowed_methods = COALESCE($9, '{}'), denied_methods = COALESCE($10, '{}'), updated_at = NOW()
+		WHERE id = $11 AND user_id = $12
 		RETURNING id, api_key, user_id, name, description, allowed_origins, allowed_chains, 
-		         rate_limit, created_at, updated_at
+		         rate_limit, geozone, consensus_endpoints, viper_chain_id, allowed_methods, denied_methods, created_at, updated_at
 	`
 
 	var updatedApp models.App
 	var dbDescription sql.NullString
+	var geozone sql.NullString
 
 	err = s.db.QueryRow(
 		query,
@@ -267,6 +355,11 @@ func (s *Service) UpdateApp(id int, userID int, req UpdateAppRequest) (*models.A
 		pq.Array(allowedOrigins),
 		allowedChains,
 		rateLimit,
+		appGeozone,
+		consensusEndpoints,
+		viperChainID,
+		pq.Array(allowedMethods),
+		pq.Array(deniedMethods),
 		id,
 		userID,
 	).Scan(
@@ -278,6 +371,11 @@ func (s *Service) UpdateApp(id int, userID int, req UpdateAppRequest) (*models.A
 		pq.Array(&updatedApp.AllowedOrigins),
 		&updatedApp.AllowedChains,
 		&updatedApp.RateLimit,
+		&geozone,
+		&updatedApp.ConsensusEndpoints,
+		&updatedApp.ViperChainID,
+		pq.Array(&updatedApp.AllowedMethods),
+		pq.Array(&updatedApp.DeniedMethods),
 		&updatedApp.CreatedAt,
 		&updatedApp.UpdatedAt,
 	)
@@ -292,6 +390,9 @@ func (s *Service) UpdateApp(id int, userID int, req UpdateAppRequest) (*models.A
 	if dbDescription.Valid {
 		updatedApp.Description = dbDescription.String
 	}
+	if geozone.Valid {
+		updatedApp.Geozone = geozone.String
+	}
 
 	return &updatedApp, nil
 }
@@ -355,13 +456,14 @@ func (s *Service) ValidateAPIKey(apiKey string) (bool, error) {
 func (s *Service) GetAppByAPIKey(apiKey string) (*models.App, error) {
 	query := `
 		SELECT id, api_key, user_id, name, description, allowed_origins, allowed_chains, 
-		       rate_limit, created_at, updated_at
+		       rate_limit, geozone, consensus_endpoints, viper_chain_id, allowed_methods, denied_methods, created_at, updated_at
 		FROM apps
 		WHERE api_key = $1
 	`
 
 	var app models.App
 	var description sql.NullString
+	var geozone sql.NullString
 
 	err := s.db.QueryRow(query, apiKey).Scan(
 		&app.ID,
@@ -372,6 +474,11 @@ func (s *Service) GetAppByAPIKey(apiKey string) (*models.App, error) {
 		pq.Array(&app.AllowedOrigins),
 		&app.AllowedChains,
 		&app.RateLimit,
+		&geozone,
+		&app.ConsensusEndpoints,
+		&app.ViperChainID,
+		pq.Array(&app.AllowedMethods),
+		pq.Array(&app.DeniedMethods),
 		&app.CreatedAt,
 		&app.UpdatedAt,
 	)
@@ -386,6 +493,9 @@ func (s *Service) GetAppByAPIKey(apiKey string) (*models.App, error) {
 	if description.Valid {
 		app.Description = description.String
 	}
+	if geozone.Valid {
+		app.Geozone = geozone.String
+	}
 
 	return &app, nil
 }
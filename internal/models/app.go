@@ -2,12 +2,16 @@ package models
 
 import (
 	"database/sql/driver"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
 	"time"
 )
 
+// ErrInvalidMethodPattern is returned for method list entries that could never match a method
+var ErrInvalidMethodPattern = errors.New("invalid method pattern")
+
 // IntArray is a custom type that implements sql.Scanner for handling PostgreSQL integer arrays
 type IntArray []int
 
@@ -70,14 +74,92 @@ func (a IntArray) Value() (driver.Value, error) {
 
 // App represents a decentralized application registered in the system
 type App struct {
-	ID             int       `json:"id"`
-	APIKey         string    `json:"api_key"`
-	UserID         int       `json:"user_id"`
-	Name           string    `json:"name"`
-	Description    string    `json:"description,omitempty"`
-	AllowedOrigins []string  `json:"allowed_origins,omitempty"`
-	AllowedChains  IntArray  `json:"allowed_chains,omitempty"`
-	RateLimit      int       `json:"rate_limit"`
-	CreatedAt      time.Time `json:"created_at"`
-	UpdatedAt      time.Time `json:"updated_at"`
+	ID             int      `json:"id"`
+	APIKey         string   `json:"api_key"`
+	UserID         int      `json:"user_id"`
+	Name           string   `json:"name"`
+	Description    string   `json:"description,omitempty"`
+	AllowedOrigins []string `json:"allowed_origins,omitempty"`
+	AllowedChains  IntArray `json:"allowed_chains,omitempty"`
+	RateLimit      int      `json:"rate_limit"`
+	Geozone        string   `json:"geozone,omitempty"`
+	// ConsensusEndpoints is the number of endpoints consensus reads are sent to; 0 disables them
+	ConsensusEndpoints int `json:"consensus_endpoints,omitempty"`
+	// ViperChainID is the chain relays through the Viper Network target; 0 uses the default target
+	ViperChainID int `json:"viper_chain_id,omitempty"`
+	// AllowedMethods limits the JSON-RPC methods the app may call; empty allows every method.
+	// REST chains name calls by route, e.g. /cosmos/bank/v1beta1/balances, and direct Viper
+	// Network requests by type, e.g. viper_height.
+	AllowedMethods []string `json:"allowed_methods,omitempty"`
+	// DeniedMethods lists JSON-RPC methods the app may never call; it wins over AllowedMethods
+	DeniedMethods []string  `json:"denied_methods,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// MethodAllowed reports whether the app may call a JSON-RPC method. Method lists
+// support wildcards such as "debug_*"; a * matches any run of characters including
+// slashes, so "/cosmos/bank/*" covers every route of the bank module.
+func (a *App) MethodAllowed(method string) bool {
+	if matchesMethod(a.DeniedMethods, method) {
+		return false
+	}
+	return len(a.AllowedMethods) == 0 || matchesMethod(a.AllowedMethods, method)
+}
+
+// ValidateMethodPatterns checks that every entry of a method list is a method name,
+// optionally with * wildcards
+func ValidateMethodPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if pattern == "" {
+			return fmt.Errorf("%w: empty pattern", ErrInvalidMethodPattern)
+		}
+		for _, r := range pattern {
+			if !isMethodRune(r) && r != '*' {
+				return fmt.Errorf("%w: %q contains %q", ErrInvalidMethodPattern, pattern, r)
+			}
+		}
+	}
+	return nil
+}
+
+// isMethodRune reports whether r may appear in a method name, JSON-RPC methods and
+// REST routes alike
+func isMethodRune(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' ||
+		r == '_' || r == '.' || r == '-' || r == '/'
+}
+
+// matchesMethod reports whether a method matches any of the patterns
+func matchesMethod(patterns []string, method string) bool {
+	for _, pattern := range patterns {
+		if matchMethod(pattern, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchMethod reports whether a method matches a pattern in which * stands for any
+// run of characters, including none
+func matchMethod(pattern, method string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == method
+	}
+
+	// The text before the first * and after the last one are anchored at the ends
+	first, last := parts[0], parts[len(parts)-1]
+	if len(method) < len(first)+len(last) || !strings.HasPrefix(method, first) || !strings.HasSuffix(method, last) {
+		return false
+	}
+	rest := method[len(first) : len(method)-len(last)]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(rest, part)
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+len(part):]
+	}
+	return true
 }
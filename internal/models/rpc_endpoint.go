@@ -1,19 +1,170 @@
 package models
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
 	"time"
 )
 
 // RpcEndpoint represents a blockchain RPC endpoint
 type RpcEndpoint struct {
-	ID                   int        `json:"id"`
-	ChainID              int        `json:"chain_id"`
-	EndpointURL          string     `json:"endpoint_url"`
-	Provider             string     `json:"provider,omitempty"`
-	IsActive             bool       `json:"is_active"`
-	Priority             int        `json:"priority"`
-	HealthCheckTimestamp *time.Time `json:"health_check_timestamp,omitempty"`
-	HealthStatus         string     `json:"health_status,omitempty"`
-	CreatedAt            time.Time  `json:"created_at"`
-	UpdatedAt            time.Time  `json:"updated_at"`
+	ID           int      `json:"id"`
+	ChainID      int      `json:"chain_id"`
+	Geozone      string   `json:"geozone,omitempty"`
+	EndpointURL  string   `json:"endpoint_url"`
+	WSURL        string   `json:"ws_url,omitempty"`
+	Provider     string   `json:"provider,omitempty"`
+	IsActive     bool     `json:"is_active"`
+	Priority     int      `json:"priority"`
+	Capabilities []string `json:"capabilities,omitempty"`
+	// RateLimit is the provider's limit in requests per second, 0 for unlimited
+	RateLimit float64 `json:"rate_limit,omitempty"`
+	// RateBurst is the number of requests that may be sent at once, defaulting to RateLimit
+	RateBurst int `json:"rate_burst,omitempty"`
+	// MonthlyQuota is the number of requests the provider allows per calendar month, 0 for unlimited
+	MonthlyQuota int64 `json:"monthly_quota,omitempty"`
+	// PricePerRequest is what the provider bills per request when no method price applies
+	PricePerRequest float64 `json:"price_per_request,omitempty"`
+	// MethodPrices overrides the price per method; keys may be patterns such as "debug_*"
+	MethodPrices map[string]float64 `json:"method_prices,omitempty"`
+	// Auth holds the upstream credentials; its secret values are redacted when marshalled
+	Auth                 *EndpointAuth `json:"auth,omitempty"`
+	HealthCheckTimestamp *time.Time    `json:"health_check_timestamp,omitempty"`
+	HealthStatus         string        `json:"health_status,omitempty"`
+	CreatedAt            time.Time     `json:"created_at"`
+	UpdatedAt            time.Time     `json:"updated_at"`
+}
+
+// RedactedValue replaces secret values in API responses and log lines
+const RedactedValue = "[REDACTED]"
+
+// rpcEndpointFields has the fields of RpcEndpoint without its redacting methods
+type rpcEndpointFields RpcEndpoint
+
+// MarshalJSON marshals the endpoint with the secrets its URLs and auth may carry redacted
+func (e RpcEndpoint) MarshalJSON() ([]byte, error) {
+	e.EndpointURL = RedactURL(e.EndpointURL)
+	if e.WSURL != "" {
+		e.WSURL = RedactURL(e.WSURL)
+	}
+	return json.Marshal(rpcEndpointFields(e))
+}
+
+// RedactURL hides the password, path and query parameter values of a URL, which often carry
+// provider keys, so that it can be logged or returned by the API
+func RedactURL(raw string) string {
+	location, err := url.Parse(raw)
+	if err != nil {
+		return RedactedValue
+	}
+
+	if location.Path != "" && location.Path != "/" {
+		location.Path = "/" + RedactedValue
+		location.RawPath = location.Path
+	}
+	if location.RawQuery != "" {
+		var names []string
+		for name := range location.Query() {
+			names = append(names, url.QueryEscape(name)+"="+RedactedValue)
+		}
+		sort.Strings(names)
+		location.RawQuery = strings.Join(names, "&")
+	}
+	// Redacted hides the password of the user info
+	return location.Redacted()
+}
+
+// EndpointAuth holds the credentials sent with every request to an upstream endpoint.
+// Its values are secrets: they are stored encrypted and redacted whenever the auth is
+// marshalled to JSON or printed.
+type EndpointAuth struct {
+	// Headers are set on every request, e.g. {"x-api-key": "..."}
+	Headers map[string]string `json:"headers,omitempty"`
+	// BearerToken is sent as "Authorization: Bearer <token>"
+	BearerToken string `json:"bearer_token,omitempty"`
+	// Username and Password are sent as HTTP basic auth
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// QueryParams are added to the endpoint URL, e.g. {"apikey": "..."}
+	QueryParams map[string]string `json:"query_params,omitempty"`
+	// PathKey is appended to the endpoint URL path, for providers taking the key there, e.g.
+	// the <key> of Alchemy's /v2/<key>
+	PathKey string `json:"path_key,omitempty"`
+}
+
+// endpointAuthFields has the fields of EndpointAuth without its redacting methods
+type endpointAuthFields EndpointAuth
+
+// IsZero reports whether the auth carries no credentials
+func (a *EndpointAuth) IsZero() bool {
+	return a == nil || (len(a.Headers) == 0 && a.BearerToken == "" && a.Username == "" &&
+		a.Password == "" && len(a.QueryParams) == 0 && a.PathKey == "")
+}
+
+// Redacted returns a copy of the auth with every secret value replaced by RedactedValue.
+// Header and query parameter names and the basic auth username are kept.
+func (a EndpointAuth) Redacted() EndpointAuth {
+	redacted := EndpointAuth{
+		Headers:     redactValues(a.Headers),
+		Username:    a.Username,
+		QueryParams: redactValues(a.QueryParams),
+	}
+	if a.BearerToken != "" {
+		redacted.BearerToken = RedactedValue
+	}
+	if a.Password != "" {
+		redacted.Password = RedactedValue
+	}
+	if a.PathKey != "" {
+		redacted.PathKey = RedactedValue
+	}
+	return redacted
+}
+
+// MarshalJSON marshals the auth with its secret values redacted
+func (a EndpointAuth) MarshalJSON() ([]byte, error) {
+	return json.Marshal(endpointAuthFields(a.Redacted()))
+}
+
+// MarshalSecrets marshals the auth including its secret values, for encrypted storage only
+func (a EndpointAuth) MarshalSecrets() ([]byte, error) {
+	return json.Marshal(endpointAuthFields(a))
+}
+
+// String formats the auth with its secret values redacted
+func (a EndpointAuth) String() string {
+	return fmt.Sprintf("%+v", endpointAuthFields(a.Redacted()))
+}
+
+// GoString formats the auth with its secret values redacted
+func (a EndpointAuth) GoString() string {
+	return fmt.Sprintf("%#v", endpointAuthFields(a.Redacted()))
+}
+
+// redactValues returns a copy of the map with every value replaced by RedactedValue
+func redactValues(values map[string]string) map[string]string {
+	if values == nil {
+		return nil
+	}
+	redacted := make(map[string]string, len(values))
+	for name := range values {
+		redacted[name] = RedactedValue
+	}
+	return redacted
+}
+
+// RoutingRule maps a method pattern, and optionally a block condition, to the
+// endpoint capabilities required to serve matching calls
+type RoutingRule struct {
+	ID int `json:"id"`
+	// ChainID limits the rule to one chain; nil applies it to every chain
+	ChainID              *int      `json:"chain_id,omitempty"`
+	MethodPattern        string    `json:"method_pattern"`
+	BlockCondition       string    `json:"block_condition"`
+	RequiredCapabilities []string  `json:"required_capabilities"`
+	CreatedAt            time.Time `json:"created_at"`
+	UpdatedAt            time.Time `json:"updated_at"`
 }
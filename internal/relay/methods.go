@@ -0,0 +1,124 @@
+package relay
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/illegalcall/viper-client/internal/models"
+	"github.com/illegalcall/viper-client/internal/rpc"
+)
+
+// methodFilter holds a request split into the part the app may send upstream and
+// the errors answering the elements it may not
+type methodFilter struct {
+	// forward is the request body to send upstream, nil when nothing may be sent
+	forward json.RawMessage
+	// batch is set for batch requests
+	batch bool
+	// denied holds an error response per denied batch element, keyed by position
+	denied map[int]*rpc.RPCResponse
+	// answered reports per batch element whether it expects a response
+	answered []bool
+}
+
+// filterMethods checks every call in the request against the app's method lists, naming
+// calls the way the chain's adapter does, e.g. by REST route for Cosmos chains. Malformed
+// requests are passed on unchanged so the dispatcher reports them.
+func filterMethods(app *models.App, request json.RawMessage, adapter rpc.ChainAdapter) methodFilter {
+	if !adapter.JSONRPC() || !rpc.IsBatchRequest(request) {
+		method := adapter.Method(request)
+		if method == "" || app.MethodAllowed(method) {
+			return methodFilter{forward: request}
+		}
+		// Only JSON-RPC requests carry an id to answer under
+		var call rpc.RPCRequest
+		if adapter.JSONRPC() {
+			json.Unmarshal(request, &call)
+		}
+		call.Method = method
+		return methodFilter{denied: map[int]*rpc.RPCResponse{0: methodNotAllowed(call)}}
+	}
+
+	var elements []json.RawMessage
+	if err := json.Unmarshal(request, &elements); err != nil {
+		return methodFilter{forward: request}
+	}
+
+	filter := methodFilter{
+		batch:    true,
+		denied:   make(map[int]*rpc.RPCResponse),
+		answered: make([]bool, len(elements)),
+	}
+	var allowed []json.RawMessage
+	for i, element := range elements {
+		var call rpc.RPCRequest
+		valid := json.Unmarshal(element, &call) == nil && call.Method != ""
+		// Notifications get no response, mirroring the dispatcher
+		filter.answered[i] = !valid || !rpc.IsNotification(element)
+
+		if valid && !app.MethodAllowed(call.Method) {
+			filter.denied[i] = methodNotAllowed(call)
+			continue
+		}
+		allowed = append(allowed, element)
+	}
+
+	if len(filter.denied) == 0 {
+		return methodFilter{forward: request}
+	}
+	if len(allowed) > 0 {
+		filter.forward, _ = json.Marshal(allowed)
+	}
+	return filter
+}
+
+// merge combines the upstream response for the allowed calls with the errors for
+// the denied ones, keeping the order of the original request. The result is empty when
+// nothing needs an answer.
+func (f methodFilter) merge(upstream json.RawMessage) (json.RawMessage, error) {
+	if len(f.denied) == 0 {
+		return upstream, nil
+	}
+	if !f.batch {
+		return json.Marshal(f.denied[0])
+	}
+
+	var forwarded []json.RawMessage
+	if upstream != nil {
+		if err := json.Unmarshal(upstream, &forwarded); err != nil {
+			return nil, fmt.Errorf("invalid batch response: %w", err)
+		}
+	}
+
+	merged := make([]json.RawMessage, 0, len(f.answered))
+	next := 0
+	for i, answered := range f.answered {
+		if denied, ok := f.denied[i]; ok {
+			if answered {
+				response, err := json.Marshal(denied)
+				if err != nil {
+					return nil, err
+				}
+				merged = append(merged, response)
+			}
+			continue
+		}
+		if answered && next < len(forwarded) {
+			merged = append(merged, forwarded[next])
+			next++
+		}
+	}
+	if len(merged) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(merged)
+}
+
+// methodNotAllowed builds the JSON-RPC error answering a call the app may not make
+func methodNotAllowed(call rpc.RPCRequest) *rpc.RPCResponse {
+	return &rpc.RPCResponse{
+		JSONRPC: "2.0",
+		Error:   rpc.MethodNotAllowedError(call.Method),
+		ID:      call.ID,
+	}
+}
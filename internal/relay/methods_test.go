@@ -0,0 +1,126 @@
+package relay
+
+import (
+	"testing"
+
+	"github.com/illegalcall/viper-client/internal/models"
+	"github.com/illegalcall/viper-client/internal/rpc"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	jsonRPC    = rpc.NewAdapterRegistry(nil).Resolve(rpc.ProtocolJSONRPC, false)
+	cosmosREST = rpc.NewAdapterRegistry(nil).Resolve(rpc.ProtocolCosmosREST, false)
+)
+
+func TestApp_MethodAllowed(t *testing.T) {
+	app := &models.App{}
+	assert.True(t, app.MethodAllowed("debug_traceTransaction"))
+
+	app.DeniedMethods = []string{"debug_*", "trace_*"}
+	assert.True(t, app.MethodAllowed("eth_call"))
+	assert.False(t, app.MethodAllowed("debug_traceTransaction"))
+
+	app.AllowedMethods = []string{"eth_*", "debug_traceCall"}
+	assert.True(t, app.MethodAllowed("eth_getBalance"))
+	assert.False(t, app.MethodAllowed("net_version"))
+	assert.False(t, app.MethodAllowed("debug_traceCall"))
+
+	// A wildcard spans the slashes of REST routes
+	app = &models.App{AllowedMethods: []string{"/cosmos/*/balances", "/ibc/*"}}
+	assert.True(t, app.MethodAllowed("/cosmos/bank/v1beta1/balances"))
+	assert.True(t, app.MethodAllowed("/ibc/core/channel/v1/channels"))
+	assert.False(t, app.MethodAllowed("/cosmos/bank/v1beta1/supply"))
+	assert.False(t, app.MethodAllowed("/cosmos/balances"))
+}
+
+func TestValidateMethodPatterns(t *testing.T) {
+	assert.NoError(t, models.ValidateMethodPatterns(nil))
+	assert.NoError(t, models.ValidateMethodPatterns([]string{"eth_*", "*", "/cosmos/bank/v1beta1/*", "viper_height"}))
+	for _, pattern := range []string{"debug_[", "eth_?all", "", "eth call"} {
+		assert.ErrorIs(t, models.ValidateMethodPatterns([]string{"eth_call", pattern}), models.ErrInvalidMethodPattern, pattern)
+	}
+}
+
+func TestFilterMethods_Single(t *testing.T) {
+	app := &models.App{DeniedMethods: []string{"debug_*"}}
+
+	allowed := filterMethods(app, []byte(`{"jsonrpc":"2.0","method":"eth_blockNumber","params":[],"id":1}`), jsonRPC)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","method":"eth_blockNumber","params":[],"id":1}`, string(allowed.forward))
+
+	denied := filterMethods(app, []byte(`{"jsonrpc":"2.0","method":"debug_traceTransaction","params":["0xabc"],"id":7}`), jsonRPC)
+	assert.Nil(t, denied.forward)
+	response, err := denied.merge(nil)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":7,"error":{"code":-32051,"message":"method not allowed for this app: debug_traceTransaction"}}`, string(response))
+
+	malformed := filterMethods(app, []byte(`{"jsonrpc":`), jsonRPC)
+	assert.Equal(t, `{"jsonrpc":`, string(malformed.forward))
+}
+
+func TestFilterMethods_Batch(t *testing.T) {
+	app := &models.App{AllowedMethods: []string{"eth_*"}}
+
+	filter := filterMethods(app, []byte(`[
+		{"jsonrpc":"2.0","method":"eth_chainId","params":[],"id":1},
+		{"jsonrpc":"2.0","method":"debug_traceTransaction","params":["0xabc"],"id":2},
+		{"jsonrpc":"2.0","method":"net_version","params":[]},
+		{"jsonrpc":"2.0","method":"eth_blockNumber","params":[],"id":3}
+	]`), jsonRPC)
+	assert.JSONEq(t, `[
+		{"jsonrpc":"2.0","method":"eth_chainId","params":[],"id":1},
+		{"jsonrpc":"2.0","method":"eth_blockNumber","params":[],"id":3}
+	]`, string(filter.forward))
+
+	response, err := filter.merge([]byte(`[{"jsonrpc":"2.0","id":1,"result":"0x1"},{"jsonrpc":"2.0","id":3,"result":"0x10"}]`))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[
+		{"jsonrpc":"2.0","id":1,"result":"0x1"},
+		{"jsonrpc":"2.0","id":2,"error":{"code":-32051,"message":"method not allowed for this app: debug_traceTransaction"}},
+		{"jsonrpc":"2.0","id":3,"result":"0x10"}
+	]`, string(response))
+}
+
+func TestFilterMethods_BatchAllDenied(t *testing.T) {
+	app := &models.App{DeniedMethods: []string{"*"}}
+
+	filter := filterMethods(app, []byte(`[{"jsonrpc":"2.0","method":"eth_chainId","id":"a"}]`), jsonRPC)
+	assert.Nil(t, filter.forward)
+
+	response, err := filter.merge(nil)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[{"jsonrpc":"2.0","id":"a","error":{"code":-32051,"message":"method not allowed for this app: eth_chainId"}}]`, string(response))
+}
+
+func TestFilterMethods_BatchOfDeniedNotifications(t *testing.T) {
+	app := &models.App{DeniedMethods: []string{"debug_*"}}
+
+	filter := filterMethods(app, []byte(`[
+		{"jsonrpc":"2.0","method":"debug_traceTransaction","params":["0xabc"]},
+		{"jsonrpc":"2.0","method":"debug_traceCall","params":[],"id":null}
+	]`), jsonRPC)
+	assert.Nil(t, filter.forward)
+
+	// An explicit null id is answered, a missing one is not
+	response, err := filter.merge(nil)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[{"jsonrpc":"2.0","id":null,"error":{"code":-32051,"message":"method not allowed for this app: debug_traceCall"}}]`, string(response))
+
+	filter = filterMethods(app, []byte(`[{"jsonrpc":"2.0","method":"debug_traceTransaction","params":["0xabc"]}]`), jsonRPC)
+	response, err = filter.merge(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, response)
+}
+
+func TestFilterMethods_CosmosREST(t *testing.T) {
+	app := &models.App{AllowedMethods: []string{"/cosmos/bank/*"}}
+
+	allowed := filterMethods(app, []byte(`{"path":"/cosmos/bank/v1beta1/balances/cosmos1abc"}`), cosmosREST)
+	assert.Equal(t, `{"path":"/cosmos/bank/v1beta1/balances/cosmos1abc"}`, string(allowed.forward))
+
+	denied := filterMethods(app, []byte(`{"path":"/cosmos/staking/v1beta1/validators"}`), cosmosREST)
+	assert.Nil(t, denied.forward)
+	response, err := denied.merge(nil)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":null,"error":{"code":-32051,"message":"method not allowed for this app: /cosmos/staking/v1beta1/validators"}}`, string(response))
+}
@@ -5,25 +5,32 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
 	"time"
 
-	"github.com/illegalcall/viper-client/internal/apps"
 	"github.com/illegalcall/viper-client/internal/models"
 	"github.com/illegalcall/viper-client/internal/rpc"
+	"github.com/lib/pq"
 )
 
+// AppStore looks up the app an API key belongs to, returning sql.ErrNoRows for unknown keys
+type AppStore interface {
+	GetAppByAPIKey(apiKey string) (*models.App, error)
+}
+
 // Service provides functionality for relaying RPC requests
 // @Description Handles relaying of RPC requests to appropriate blockchain nodes
 type Service struct {
 	db            *sql.DB
-	appsService   *apps.Service
+	appsService   AppStore
 	rpcDispatcher *rpc.Dispatcher
 	httpClient    *http.Client
 }
 
-// NewService creates a new relay service
-func NewService(db *sql.DB, appsService *apps.Service, rpcDispatcher *rpc.Dispatcher) *Service {
+// NewService creates a new relay service. Without a database, requests are relayed without
+// being logged in the stats.
+func NewService(db *sql.DB, appsService AppStore, rpcDispatcher *rpc.Dispatcher) *Service {
 	return &Service{
 		db:            db,
 		appsService:   appsService,
@@ -40,6 +47,16 @@ type RelayRequest struct {
 	APIKey  string          `json:"api_key" binding:"required" example:"your-api-key-here"`
 	ChainID int             `json:"chain_id" binding:"required" example:"1"`
 	Request json.RawMessage `json:"request" binding:"required" example:"{\"jsonrpc\":\"2.0\",\"method\":\"eth_blockNumber\",\"params\":[],\"id\":1}"`
+	// Geozone explicitly requested by the client; overrides the app's configured zone
+	Geozone string `json:"geozone,omitempty" example:"IND"`
+	// BypassCache forces the request upstream instead of answering it from the response cache
+	BypassCache bool `json:"bypass_cache,omitempty" example:"false"`
+	// Consensus asks several endpoints and returns the majority answer
+	Consensus bool `json:"consensus,omitempty" example:"false"`
+	// ConsensusEndpoints overrides the number of endpoints asked for consensus
+	ConsensusEndpoints int `json:"consensus_endpoints,omitempty" example:"3"`
+	// ViperChainID is the chain a relay on the Viper Network chain targets; overrides the app's configured chain
+	ViperChainID int `json:"viper_chain_id,omitempty" example:"2"`
 }
 
 // RelayResponse represents the response from the relay service
@@ -67,25 +84,83 @@ type ErrorResponse struct {
 // @Failure 500 {object} ErrorResponse "Internal server error"
 // @Router /api/relay [post]
 func (s *Service) Relay(ctx context.Context, req RelayRequest) (*RelayResponse, error) {
-	// 1. Verify API key
-	app, err := s.verifyAPIKey(req.APIKey)
+	return s.RelayStream(ctx, req, nil)
+}
+
+// RelayStream relays a request like Relay, but pipes the upstream response of streamed methods
+// such as eth_getLogs to the writer returned by open instead of buffering it. The response is
+// nil once open has been called; a nil open never streams.
+func (s *Service) RelayStream(ctx context.Context, req RelayRequest, open func() io.Writer) (*RelayResponse, error) {
+	// 1. Verify the API key and that the chain is allowed for the app
+	app, err := s.Authorize(req.APIKey, req.ChainID)
 	if err != nil {
 		return nil, err
 	}
 
-	// 2. Check if chain is allowed for this app
-	if !s.isChainAllowed(app, req.ChainID) {
-		return nil, errors.New("chain not allowed for this app")
+	// 2. Route to the explicitly requested geozone, else the app's configured one
+	geozone := req.Geozone
+	if geozone == "" {
+		geozone = app.Geozone
+	}
+	ctx = rpc.WithGeozone(ctx, geozone)
+	ctx = rpc.WithApp(ctx, app.ID)
+	viperChainID := req.ViperChainID
+	if viperChainID == 0 {
+		viperChainID = app.ViperChainID
+	}
+	ctx = rpc.WithViperChain(ctx, viperChainID)
+	if req.BypassCache {
+		ctx = rpc.WithCacheBypass(ctx)
+	}
+
+	// 3. Use consensus reads when the call or the app asks for them
+	consensusEndpoints := app.ConsensusEndpoints
+	if req.ConsensusEndpoints > 0 {
+		consensusEndpoints = req.ConsensusEndpoints
+	}
+	if req.Consensus && consensusEndpoints == 0 {
+		consensusEndpoints = rpc.DefaultConsensusEndpoints
+	}
+	ctx = rpc.WithConsensus(ctx, consensusEndpoints)
+
+	// 4. Answer methods the app may not call without going upstream
+	filter := filterMethods(app, req.Request, s.rpcDispatcher.Adapter(req.ChainID))
+	if filter.forward == nil {
+		response, err := filter.merge(nil)
+		if err != nil {
+			return nil, err
+		}
+		return &RelayResponse{
+			Response: response,
+		}, nil
+	}
+
+	// 5. Pipe large responses straight to the caller when it can take them
+	methods := s.rpcDispatcher.RequestMethods(req.ChainID, filter.forward)
+	if open != nil && s.rpcDispatcher.Streamable(ctx, req.ChainID, filter.forward) {
+		written, err := s.rpcDispatcher.ForwardStream(ctx, req.ChainID, filter.forward, open)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.logRequest(req.APIKey, req.ChainID, methods, written); err != nil {
+			// Log error but don't fail the request
+			// TODO: Add proper error logging
+		}
+		return nil, nil
 	}
 
-	// 3. Forward the request to the RPC dispatcher
-	response, err := s.rpcDispatcher.Forward(ctx, req.ChainID, req.Request)
+	// 6. Forward the request to the RPC dispatcher
+	response, err := s.rpcDispatcher.Forward(ctx, req.ChainID, filter.forward)
+	if err != nil {
+		return nil, err
+	}
+	response, err = filter.merge(response)
 	if err != nil {
 		return nil, err
 	}
 
-	// 4. Log the request in stats
-	if err := s.logRequest(req.APIKey, req.ChainID); err != nil {
+	// 7. Log the request in stats under its method, one entry per forwarded batch element
+	if err := s.logRequest(req.APIKey, req.ChainID, methods, int64(len(response))); err != nil {
 		// Log error but don't fail the request
 		// TODO: Add proper error logging
 	}
@@ -95,6 +170,20 @@ func (s *Service) Relay(ctx context.Context, req RelayRequest) (*RelayResponse,
 	}, nil
 }
 
+// Authorize verifies the API key and that the app may use the chain, returning the app
+func (s *Service) Authorize(apiKey string, chainID int) (*models.App, error) {
+	app, err := s.verifyAPIKey(apiKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if !s.isChainAllowed(app, chainID) {
+		return nil, errors.New("chain not allowed for this app")
+	}
+
+	return app, nil
+}
+
 // verifyAPIKey verifies the API key and returns the associated app
 func (s *Service) verifyAPIKey(apiKey string) (*models.App, error) {
 	// Get app by API key
@@ -119,13 +208,20 @@ func (s *Service) isChainAllowed(app *models.App, chainID int) bool {
 	return false
 }
 
-// logRequest logs the request in the stats table
-func (s *Service) logRequest(apiKey string, chainID int) error {
+// logRequest logs the request in the stats table with one row per call, recorded under
+// the call's method or "relay" when it has none. The response size is recorded on the
+// first row only, so that summing it over the rows gives the bytes sent.
+func (s *Service) logRequest(apiKey string, chainID int, methods []string, responseBytes int64) error {
+	if s.db == nil {
+		return nil
+	}
+
 	query := `
-		INSERT INTO logs (endpoint, api_key, chain_id, created_at, updated_at)
-		VALUES ($1, $2, $3, NOW(), NOW())
+		INSERT INTO logs (endpoint, api_key, chain_id, response_bytes, created_at, updated_at)
+		SELECT COALESCE(NULLIF(method, ''), $1), $2, $3, CASE WHEN position = 1 THEN $5 ELSE 0 END, NOW(), NOW()
+		FROM unnest($4::text[]) WITH ORDINALITY AS calls(method, position)
 	`
 
-	_, err := s.db.Exec(query, "relay", apiKey, chainID)
+	_, err := s.db.Exec(query, "relay", apiKey, chainID, pq.Array(methods), responseBytes)
 	return err
 }
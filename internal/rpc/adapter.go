@@ -0,0 +1,526 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Upstream protocols a chain may declare in chain_details.protocol
+const (
+	// ProtocolJSONRPC is plain JSON-RPC over POST, used by chains that declare no protocol
+	ProtocolJSONRPC = "jsonrpc"
+	// ProtocolEVM is Ethereum JSON-RPC
+	ProtocolEVM = "evm"
+	// ProtocolTendermint is Tendermint/CometBFT JSON-RPC, usually served on port 26657
+	ProtocolTendermint = "tendermint"
+	// ProtocolCosmosREST is the Cosmos SDK REST (LCD) API, queried with GET paths
+	ProtocolCosmosREST = "cosmos-rest"
+)
+
+// DefaultChainProtocolRefreshInterval is how often chain protocols are reloaded from the store
+const DefaultChainProtocolRefreshInterval = time.Minute
+
+var (
+	// ErrInvalidRequest is returned when a request is not valid for the chain's protocol
+	ErrInvalidRequest = errors.New("invalid JSON-RPC request format")
+
+	// ErrInvalidRESTRequest is returned when a Cosmos REST request does not carry a usable GET path
+	ErrInvalidRESTRequest = errors.New("invalid REST request: expected a GET path")
+
+	// ErrBatchNotSupported is returned for batch requests to chains whose protocol has no batches
+	ErrBatchNotSupported = errors.New("batch requests are not supported for this chain")
+)
+
+// ChainAdapter speaks the upstream protocol of a chain type
+type ChainAdapter interface {
+	// Protocol returns the chain_details.protocol value the adapter is registered under
+	Protocol() string
+	// JSONRPC reports whether requests and responses are JSON-RPC envelopes, which batching,
+	// caching, coalescing and consensus reads depend on
+	JSONRPC() bool
+	// ParseRequest validates a client request and returns the call it makes
+	ParseRequest(requestBody []byte) (RPCRequest, error)
+	// NewUpstreamRequest builds the HTTP request sending a validated request to an endpoint
+	NewUpstreamRequest(ctx context.Context, endpointURL string, requestBody []byte) (*http.Request, error)
+	// Probe checks that an endpoint answers and returns its latest block height, or 0 when unknown
+	Probe(ctx context.Context, client *http.Client, endpointURL string) (int64, error)
+	// Method returns the name a request is recorded under in the stats, or "" when it has none
+	Method(requestBody []byte) string
+	// Idempotent reports whether a call of the method, as named by Method, only reads state and
+	// may be sent to more than one endpoint. Unknown methods are not.
+	Idempotent(method string) bool
+}
+
+// ChainProtocol is the upstream protocol declared for a chain
+type ChainProtocol struct {
+	ChainID  int
+	Protocol string
+	IsEVM    bool
+}
+
+// ChainProtocolStore provides the protocol of every chain
+type ChainProtocolStore interface {
+	ListChainProtocols() ([]ChainProtocol, error)
+}
+
+// AdapterRegistry resolves the adapter used for each chain
+type AdapterRegistry struct {
+	store ChainProtocolStore
+
+	mu       sync.RWMutex
+	adapters map[string]ChainAdapter
+	chains   map[int]ChainAdapter
+}
+
+// NewAdapterRegistry creates a registry with the built-in adapters whose chain protocols
+// are loaded from the store
+func NewAdapterRegistry(store ChainProtocolStore) *AdapterRegistry {
+	r := &AdapterRegistry{
+		store:    store,
+		adapters: make(map[string]ChainAdapter),
+		chains:   make(map[int]ChainAdapter),
+	}
+	r.Register(jsonRPCAdapter{})
+	r.Register(evmAdapter{})
+	r.Register(tendermintAdapter{})
+	r.Register(cosmosRESTAdapter{})
+	return r
+}
+
+// Register adds an adapter, replacing any adapter registered for the same protocol
+func (r *AdapterRegistry) Register(adapter ChainAdapter) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.adapters[adapter.Protocol()] = adapter
+}
+
+// Resolve returns the adapter for a protocol. Chains without a known protocol are treated
+// as EVM when flagged so, and as plain JSON-RPC otherwise.
+func (r *AdapterRegistry) Resolve(protocol string, isEVM bool) ChainAdapter {
+	adapter, _ := r.lookup(protocol, isEVM)
+	return adapter
+}
+
+// SetChainProtocol sets the protocol spoken by a chain's endpoints
+func (r *AdapterRegistry) SetChainProtocol(chainID int, protocol string, isEVM bool) error {
+	adapter, ok := r.lookup(protocol, isEVM)
+	if !ok {
+		return fmt.Errorf("unknown chain protocol %q", protocol)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.chains[chainID] = adapter
+	return nil
+}
+
+// ForChain returns the adapter for a chain, plain JSON-RPC when its protocol is not known
+func (r *AdapterRegistry) ForChain(chainID int) ChainAdapter {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if adapter, ok := r.chains[chainID]; ok {
+		return adapter
+	}
+	return r.adapters[ProtocolJSONRPC]
+}
+
+// Reload loads the protocol of every chain from the store
+func (r *AdapterRegistry) Reload() error {
+	protocols, err := r.store.ListChainProtocols()
+	if err != nil {
+		return err
+	}
+
+	chains := make(map[int]ChainAdapter, len(protocols))
+	for _, chain := range protocols {
+		adapter, ok := r.lookup(chain.Protocol, chain.IsEVM)
+		if !ok {
+			log.Printf("Unknown protocol %q for chain %d, falling back to %s", chain.Protocol, chain.ChainID, adapter.Protocol())
+		}
+		chains[chain.ChainID] = adapter
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.chains = chains
+	return nil
+}
+
+// Start reloads the chain protocols on every interval until the context is cancelled
+func (r *AdapterRegistry) Start(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultChainProtocolRefreshInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Reload(); err != nil {
+				log.Printf("Failed to reload chain protocols: %v", err)
+			}
+		}
+	}
+}
+
+// lookup returns the adapter for a protocol and whether the protocol, when given, is known
+func (r *AdapterRegistry) lookup(protocol string, isEVM bool) (ChainAdapter, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if adapter, ok := r.adapters[strings.ToLower(protocol)]; ok {
+		return adapter, true
+	}
+	if isEVM {
+		return r.adapters[ProtocolEVM], protocol == ""
+	}
+	return r.adapters[ProtocolJSONRPC], protocol == ""
+}
+
+// jsonRPCAdapter speaks plain JSON-RPC over POST and only checks endpoints are reachable
+type jsonRPCAdapter struct{}
+
+func (jsonRPCAdapter) Protocol() string { return ProtocolJSONRPC }
+
+func (jsonRPCAdapter) JSONRPC() bool { return true }
+
+func (jsonRPCAdapter) ParseRequest(requestBody []byte) (RPCRequest, error) {
+	var request RPCRequest
+	if err := json.Unmarshal(requestBody, &request); err != nil || request.Method == "" {
+		return RPCRequest{}, ErrInvalidRequest
+	}
+	return request, nil
+}
+
+func (jsonRPCAdapter) NewUpstreamRequest(ctx context.Context, endpointURL string, requestBody []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", endpointURL, bytes.NewReader(requestBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// Probe checks that an endpoint of an unknown protocol answers without a server error
+func (jsonRPCAdapter) Probe(ctx context.Context, client *http.Client, endpointURL string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", endpointURL, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 500 {
+		return 0, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return 0, nil
+}
+
+func (jsonRPCAdapter) Method(requestBody []byte) string {
+	var request RPCRequest
+	if err := json.Unmarshal(requestBody, &request); err != nil {
+		return ""
+	}
+	return request.Method
+}
+
+// Idempotent accepts the reads of every known JSON-RPC protocol, as the chain's is not known
+func (jsonRPCAdapter) Idempotent(method string) bool {
+	return IsIdempotentMethod(method)
+}
+
+// evmAdapter speaks Ethereum JSON-RPC
+type evmAdapter struct {
+	jsonRPCAdapter
+}
+
+func (evmAdapter) Protocol() string { return ProtocolEVM }
+
+func (evmAdapter) Idempotent(method string) bool {
+	return matchesAnyMethod(evmReadMethods, method)
+}
+
+// Probe calls eth_blockNumber and returns the decoded height
+func (evmAdapter) Probe(ctx context.Context, client *http.Client, endpointURL string) (int64, error) {
+	request := []byte(`{"jsonrpc":"2.0","method":"eth_blockNumber","params":[],"id":1}`)
+	body, err := postJSON(ctx, client, endpointURL, request)
+	if err != nil {
+		return 0, err
+	}
+
+	var response RPCResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return 0, fmt.Errorf("invalid eth_blockNumber response: %w", err)
+	}
+	if response.Error != nil {
+		return 0, fmt.Errorf("eth_blockNumber error: %s", response.Error.Message)
+	}
+
+	var hexHeight string
+	if err := json.Unmarshal(response.Result, &hexHeight); err != nil {
+		return 0, fmt.Errorf("invalid eth_blockNumber result: %w", err)
+	}
+	return strconv.ParseInt(strings.TrimPrefix(hexHeight, "0x"), 16, 64)
+}
+
+// tendermintAdapter speaks Tendermint/CometBFT JSON-RPC, whose params are usually named
+type tendermintAdapter struct {
+	jsonRPCAdapter
+}
+
+func (tendermintAdapter) Protocol() string { return ProtocolTendermint }
+
+func (tendermintAdapter) Idempotent(method string) bool {
+	return matchesAnyMethod(tendermintReadMethods, method)
+}
+
+func (a tendermintAdapter) ParseRequest(requestBody []byte) (RPCRequest, error) {
+	request, err := a.jsonRPCAdapter.ParseRequest(requestBody)
+	if err != nil {
+		return RPCRequest{}, err
+	}
+
+	// Params are optional, but must be an object or a positional array when present
+	params := bytes.TrimSpace(request.Params)
+	if len(params) > 0 && params[0] != '{' && params[0] != '[' && !bytes.Equal(params, []byte("null")) {
+		return RPCRequest{}, ErrInvalidRequest
+	}
+	return request, nil
+}
+
+// Probe calls status and returns the latest block height reported in its sync info
+func (tendermintAdapter) Probe(ctx context.Context, client *http.Client, endpointURL string) (int64, error) {
+	request := []byte(`{"jsonrpc":"2.0","method":"status","params":{},"id":1}`)
+	body, err := postJSON(ctx, client, endpointURL, request)
+	if err != nil {
+		return 0, err
+	}
+
+	var response struct {
+		Result struct {
+			SyncInfo struct {
+				LatestBlockHeight string `json:"latest_block_height"`
+			} `json:"sync_info"`
+		} `json:"result"`
+		Error *RPCError `json:"error"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return 0, fmt.Errorf("invalid status response: %w", err)
+	}
+	if response.Error != nil {
+		return 0, fmt.Errorf("status error: %s", response.Error.Message)
+	}
+	return strconv.ParseInt(response.Result.SyncInfo.LatestBlockHeight, 10, 64)
+}
+
+// cosmosLatestBlockPath is the Cosmos REST path returning the latest block
+const cosmosLatestBlockPath = "/cosmos/base/tendermint/v1beta1/blocks/latest"
+
+// cosmosVersionSegment matches the API version segment of a Cosmos REST path, e.g. v1beta1
+var cosmosVersionSegment = regexp.MustCompile(`^v[0-9]+((alpha|beta)[0-9]*)?$`)
+
+// cosmosRESTRequest is a relayed Cosmos REST query
+type cosmosRESTRequest struct {
+	// Path is the GET path including any query string, e.g. /cosmos/bank/v1beta1/balances/cosmos1...
+	Path string `json:"path"`
+}
+
+// cosmosRESTAdapter speaks the Cosmos SDK REST (LCD) API. Clients relay {"path": "..."}
+// and the path is fetched with GET from the endpoint.
+type cosmosRESTAdapter struct{}
+
+func (cosmosRESTAdapter) Protocol() string { return ProtocolCosmosREST }
+
+func (cosmosRESTAdapter) JSONRPC() bool { return false }
+
+// Idempotent accepts every route, as requests are only ever sent as GET queries
+func (cosmosRESTAdapter) Idempotent(method string) bool {
+	return method != ""
+}
+
+func (cosmosRESTAdapter) ParseRequest(requestBody []byte) (RPCRequest, error) {
+	path, err := parseCosmosPath(requestBody)
+	if err != nil {
+		return RPCRequest{}, err
+	}
+	params, _ := json.Marshal([]string{path})
+	return RPCRequest{Method: cosmosMethod(path), Params: params}, nil
+}
+
+func (cosmosRESTAdapter) NewUpstreamRequest(ctx context.Context, endpointURL string, requestBody []byte) (*http.Request, error) {
+	path, err := parseCosmosPath(requestBody)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimRight(endpointURL, "/")+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	return req, nil
+}
+
+// Probe fetches the latest block and returns its height
+func (cosmosRESTAdapter) Probe(ctx context.Context, client *http.Client, endpointURL string) (int64, error) {
+	body, err := getJSON(ctx, client, strings.TrimRight(endpointURL, "/")+cosmosLatestBlockPath)
+	if err != nil {
+		return 0, err
+	}
+
+	var response struct {
+		Block struct {
+			Header struct {
+				Height string `json:"height"`
+			} `json:"header"`
+		} `json:"block"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return 0, fmt.Errorf("invalid latest block response: %w", err)
+	}
+	return strconv.ParseInt(response.Block.Header.Height, 10, 64)
+}
+
+func (cosmosRESTAdapter) Method(requestBody []byte) string {
+	path, err := parseCosmosPath(requestBody)
+	if err != nil {
+		return ""
+	}
+	return cosmosMethod(path)
+}
+
+// parseCosmosPath extracts and validates the GET path of a Cosmos REST request
+func parseCosmosPath(requestBody []byte) (string, error) {
+	var request cosmosRESTRequest
+	if err := json.Unmarshal(requestBody, &request); err != nil || !strings.HasPrefix(request.Path, "/") {
+		return "", ErrInvalidRESTRequest
+	}
+
+	parsed, err := url.Parse(request.Path)
+	if err != nil || parsed.Host != "" || strings.Contains(parsed.Path, "..") {
+		return "", ErrInvalidRESTRequest
+	}
+	return request.Path, nil
+}
+
+// cosmosMethod reduces a Cosmos REST path to its route, dropping the query and any
+// arguments after the resource, e.g. /cosmos/bank/v1beta1/balances/cosmos1... becomes
+// /cosmos/bank/v1beta1/balances
+func cosmosMethod(path string) string {
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+
+	keep := len(segments)
+	for i, segment := range segments {
+		if cosmosVersionSegment.MatchString(segment) {
+			keep = i + 2
+			break
+		}
+	}
+	if keep > len(segments) {
+		keep = len(segments)
+	}
+	return "/" + strings.Join(segments[:keep], "/")
+}
+
+// postJSON sends a JSON body and returns the response body, failing on non-200 statuses
+func postJSON(ctx context.Context, client *http.Client, url string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return readOK(client, req)
+}
+
+// getJSON fetches a URL and returns the response body, failing on non-200 statuses
+func getJSON(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	return readOK(client, req)
+}
+
+// readOK sends a request and returns the response body, failing on non-200 statuses
+func readOK(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return responseBody, nil
+}
+
+// SetAdapterRegistry sets how the upstream protocol of each chain is spoken
+func (d *Dispatcher) SetAdapterRegistry(adapters *AdapterRegistry) {
+	d.adapters = adapters
+}
+
+// SetAdapterRegistry sets how the probe for each chain's protocol is chosen
+func (h *HealthChecker) SetAdapterRegistry(adapters *AdapterRegistry) {
+	h.adapters = adapters
+}
+
+// Adapter returns the adapter speaking the upstream protocol of a chain
+func (d *Dispatcher) Adapter(chainID int) ChainAdapter {
+	return d.adapters.ForChain(chainID)
+}
+
+// idempotent reports whether a call of the method may be sent to more than one endpoint of the chain
+func (d *Dispatcher) idempotent(chainID int, method string) bool {
+	return d.adapters.ForChain(chainID).Idempotent(method)
+}
+
+// RequestMethods returns the stats name of every call in a request, one per batch element.
+// Calls without a recognizable method are returned as "".
+func (d *Dispatcher) RequestMethods(chainID int, requestBody []byte) []string {
+	adapter := d.adapters.ForChain(chainID)
+	if !adapter.JSONRPC() || !IsBatchRequest(requestBody) {
+		return []string{adapter.Method(requestBody)}
+	}
+
+	var elements []json.RawMessage
+	if err := json.Unmarshal(requestBody, &elements); err != nil {
+		return []string{""}
+	}
+	methods := make([]string, len(elements))
+	for i, element := range elements {
+		methods[i] = adapter.Method(element)
+	}
+	return methods
+}
@@ -0,0 +1,134 @@
+package rpc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/illegalcall/viper-client/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// staticProtocolStore is a ChainProtocolStore returning fixed protocols
+type staticProtocolStore []ChainProtocol
+
+func (s staticProtocolStore) ListChainProtocols() ([]ChainProtocol, error) {
+	return s, nil
+}
+
+func TestAdapterRegistry_Reload(t *testing.T) {
+	registry := NewAdapterRegistry(staticProtocolStore{
+		{ChainID: 2, IsEVM: true},
+		{ChainID: 3, Protocol: "tendermint"},
+		{ChainID: 4, Protocol: "Cosmos-REST"},
+		{ChainID: 5, Protocol: "solana"},
+	})
+	assert.NoError(t, registry.Reload())
+
+	assert.Equal(t, ProtocolEVM, registry.ForChain(2).Protocol())
+	assert.Equal(t, ProtocolTendermint, registry.ForChain(3).Protocol())
+	assert.Equal(t, ProtocolCosmosREST, registry.ForChain(4).Protocol())
+	assert.Equal(t, ProtocolJSONRPC, registry.ForChain(5).Protocol())
+	assert.Equal(t, ProtocolJSONRPC, registry.ForChain(99).Protocol())
+
+	assert.Error(t, registry.SetChainProtocol(6, "solana", false))
+}
+
+func TestTendermintAdapter_ParseRequest(t *testing.T) {
+	adapter := tendermintAdapter{}
+
+	request, err := adapter.ParseRequest([]byte(`{"jsonrpc":"2.0","method":"block","params":{"height":"5"},"id":1}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "block", request.Method)
+
+	_, err = adapter.ParseRequest([]byte(`{"jsonrpc":"2.0","method":"status","id":1}`))
+	assert.NoError(t, err)
+
+	_, err = adapter.ParseRequest([]byte(`{"jsonrpc":"2.0","method":"block","params":"5","id":1}`))
+	assert.Equal(t, ErrInvalidRequest, err)
+
+	_, err = adapter.ParseRequest([]byte(`{"jsonrpc":"2.0","id":1}`))
+	assert.Equal(t, ErrInvalidRequest, err)
+}
+
+func TestCosmosRESTAdapter_Method(t *testing.T) {
+	adapter := cosmosRESTAdapter{}
+
+	assert.Equal(t, "/cosmos/bank/v1beta1/balances", adapter.Method([]byte(`{"path":"/cosmos/bank/v1beta1/balances/cosmos1abc?pagination.limit=10"}`)))
+	assert.Equal(t, "/ibc/core/channel/v1/channels", adapter.Method([]byte(`{"path":"/ibc/core/channel/v1/channels/channel-0/ports/transfer"}`)))
+	assert.Equal(t, "/node_info", adapter.Method([]byte(`{"path":"/node_info"}`)))
+	assert.Equal(t, "", adapter.Method([]byte(`{"path":"cosmos/bank"}`)))
+
+	_, err := adapter.ParseRequest([]byte(`{"path":"/cosmos/../admin"}`))
+	assert.Equal(t, ErrInvalidRESTRequest, err)
+	_, err = adapter.ParseRequest([]byte(`{"jsonrpc":"2.0","method":"status","id":1}`))
+	assert.Equal(t, ErrInvalidRESTRequest, err)
+}
+
+func TestDispatcher_Forward_CosmosREST(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "GET", r.Method)
+		assert.Equal(t, "/lcd/cosmos/bank/v1beta1/balances/cosmos1abc", r.URL.Path)
+		assert.Equal(t, "10", r.URL.Query().Get("pagination.limit"))
+		w.Write([]byte(`{"balances":[{"denom":"uatom","amount":"5"}]}`))
+	}))
+	defer server.Close()
+
+	mockManager := new(MockEndpointManager)
+	mockManager.On("GetActiveEndpoints", 4).Return([]models.RpcEndpoint{
+		{ID: 1, ChainID: 4, EndpointURL: server.URL + "/lcd/", Priority: 1},
+	}, nil)
+	mockManager.On("UpdateEndpointHealth", mock.Anything, mock.Anything).Return(nil)
+
+	registry := NewAdapterRegistry(nil)
+	assert.NoError(t, registry.SetChainProtocol(4, ProtocolCosmosREST, false))
+	dispatcher := NewDispatcher(mockManager)
+	dispatcher.SetAdapterRegistry(registry)
+
+	request := []byte(`{"path":"/cosmos/bank/v1beta1/balances/cosmos1abc?pagination.limit=10"}`)
+	response, err := dispatcher.Forward(context.Background(), 4, request)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"balances":[{"denom":"uatom","amount":"5"}]}`, string(response))
+	assert.Equal(t, []string{"/cosmos/bank/v1beta1/balances"}, dispatcher.RequestMethods(4, request))
+
+	_, err = dispatcher.Forward(context.Background(), 4, []byte(`[{"path":"/node_info"}]`))
+	assert.Equal(t, ErrBatchNotSupported, err)
+}
+
+func TestDispatcher_RequestMethods_Batch(t *testing.T) {
+	dispatcher := NewDispatcher(new(MockEndpointManager))
+
+	methods := dispatcher.RequestMethods(2, []byte(`[{"jsonrpc":"2.0","method":"eth_chainId","id":1},{"id":2},{"jsonrpc":"2.0","method":"net_version","id":3}]`))
+	assert.Equal(t, []string{"eth_chainId", "", "net_version"}, methods)
+}
+
+func TestHealthChecker_ProbesByProtocol(t *testing.T) {
+	tendermint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":{"sync_info":{"latest_block_height":"1234"}}}`))
+	}))
+	defer tendermint.Close()
+
+	cosmos := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, cosmosLatestBlockPath, r.URL.Path)
+		w.Write([]byte(`{"block":{"header":{"height":"5678"}}}`))
+	}))
+	defer cosmos.Close()
+
+	checker := NewHealthChecker(&memoryHealthStore{}, 0)
+
+	result := checker.Probe(context.Background(), ProbeTarget{
+		Endpoint: models.RpcEndpoint{ID: 1, ChainID: 3, EndpointURL: tendermint.URL},
+		Protocol: ProtocolTendermint,
+	})
+	assert.True(t, result.Healthy)
+	assert.Equal(t, int64(1234), result.BlockHeight)
+
+	result = checker.Probe(context.Background(), ProbeTarget{
+		Endpoint: models.RpcEndpoint{ID: 2, ChainID: 4, EndpointURL: cosmos.URL},
+		Protocol: ProtocolCosmosREST,
+	})
+	assert.True(t, result.Healthy)
+	assert.Equal(t, int64(5678), result.BlockHeight)
+}
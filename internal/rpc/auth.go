@@ -0,0 +1,151 @@
+package rpc
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/illegalcall/viper-client/internal/models"
+)
+
+// authTransport sends an endpoint's upstream credentials with every request. Credentials
+// are added to a copy of the request, so errors returned by the client quote the URL
+// without them.
+type authTransport struct {
+	base http.RoundTripper
+	auth *models.EndpointAuth
+}
+
+// RoundTrip sends the request with the endpoint's credentials applied
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	authed := req.Clone(req.Context())
+	applyAuth(authed.Header, authed.URL, t.auth)
+	return t.base.RoundTrip(authed)
+}
+
+// authenticatedClient returns a client sending the endpoint's credentials, or the client
+// itself when the endpoint has none
+func authenticatedClient(client *http.Client, endpoint models.RpcEndpoint) *http.Client {
+	if endpoint.Auth.IsZero() {
+		return client
+	}
+
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	authed := *client
+	authed.Transport = &authTransport{base: base, auth: endpoint.Auth}
+	return &authed
+}
+
+// applyAuth sets the credentials on a request's headers and URL
+func applyAuth(header http.Header, location *url.URL, auth *models.EndpointAuth) {
+	if auth.IsZero() {
+		return
+	}
+
+	for name, value := range auth.Headers {
+		header.Set(name, value)
+	}
+	if auth.BearerToken != "" {
+		header.Set("Authorization", "Bearer "+auth.BearerToken)
+	}
+	if auth.Username != "" || auth.Password != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(auth.Username + ":" + auth.Password))
+		header.Set("Authorization", "Basic "+credentials)
+	}
+	if len(auth.QueryParams) > 0 {
+		query := location.Query()
+		for name, value := range auth.QueryParams {
+			query.Set(name, value)
+		}
+		location.RawQuery = query.Encode()
+	}
+	if auth.PathKey != "" {
+		location.Path = strings.TrimSuffix(location.Path, "/") + "/" + auth.PathKey
+		location.RawPath = ""
+	}
+}
+
+// pathKeyPrefixes are the URL paths after which providers take the key, by host suffix
+var pathKeyPrefixes = map[string]string{
+	".alchemy.com": "/v2/",
+	".infura.io":   "/v3/",
+}
+
+// splitURLCredentials moves the user info, query parameters and, for providers listed in
+// pathKeyPrefixes, the path key of an endpoint URL into credentials, returning the URL without
+// them. Keys in the path of other providers are left in place, as their URLs cannot be told
+// apart from plain paths.
+func splitURLCredentials(raw string) (string, models.EndpointAuth, error) {
+	location, err := url.Parse(raw)
+	if err != nil {
+		return "", models.EndpointAuth{}, err
+	}
+
+	var auth models.EndpointAuth
+	if location.User != nil {
+		auth.Username = location.User.Username()
+		auth.Password, _ = location.User.Password()
+		location.User = nil
+	}
+	if location.RawQuery != "" {
+		auth.QueryParams = make(map[string]string)
+		for name, values := range location.Query() {
+			auth.QueryParams[name] = values[0]
+		}
+		location.RawQuery = ""
+	}
+	for suffix, prefix := range pathKeyPrefixes {
+		if !strings.HasSuffix(location.Hostname(), suffix) || !strings.HasPrefix(location.Path, prefix) {
+			continue
+		}
+		if key := strings.TrimPrefix(location.Path, prefix); key != "" && !strings.Contains(key, "/") {
+			auth.PathKey = key
+			location.Path = strings.TrimSuffix(prefix, "/")
+			location.RawPath = ""
+		}
+	}
+	return location.String(), auth, nil
+}
+
+// mergeAuth adds the credentials of moved to stored, keeping the values already stored, which
+// are the ones requests were sent with
+func mergeAuth(stored *models.EndpointAuth, moved models.EndpointAuth) *models.EndpointAuth {
+	merged := moved
+	if stored == nil {
+		return &merged
+	}
+
+	merged.Headers = stored.Headers
+	merged.BearerToken = stored.BearerToken
+	if stored.PathKey != "" {
+		merged.PathKey = stored.PathKey
+	}
+	if stored.Username != "" || stored.Password != "" {
+		merged.Username, merged.Password = stored.Username, stored.Password
+	}
+	if len(stored.QueryParams) > 0 {
+		params := make(map[string]string, len(moved.QueryParams)+len(stored.QueryParams))
+		for name, value := range moved.QueryParams {
+			params[name] = value
+		}
+		for name, value := range stored.QueryParams {
+			params[name] = value
+		}
+		merged.QueryParams = params
+	}
+	return &merged
+}
+
+// redactError hides credentials in the URL quoted by an HTTP client error
+func redactError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		urlErr.URL = models.RedactURL(urlErr.URL)
+	}
+	return err
+}
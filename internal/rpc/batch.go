@@ -0,0 +1,319 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/illegalcall/viper-client/internal/models"
+)
+
+const (
+	// DefaultMaxBatchSize is the maximum number of elements accepted in one JSON-RPC batch
+	DefaultMaxBatchSize = 100
+
+	// DefaultBatchChunkSize is the number of elements sent to one endpoint when a batch is split
+	DefaultBatchChunkSize = 20
+)
+
+// JSON-RPC 2.0 error codes
+const (
+	JSONRPCInvalidRequest = -32600
+	JSONRPCInternalError  = -32603
+)
+
+var (
+	// ErrEmptyBatch is returned for a JSON-RPC batch without elements
+	ErrEmptyBatch = errors.New("empty JSON-RPC batch")
+	// ErrBatchTooLarge is returned when a batch exceeds the configured maximum size
+	ErrBatchTooLarge = errors.New("JSON-RPC batch exceeds the maximum size")
+)
+
+// IsBatchRequest reports whether the request body is a JSON-RPC batch (a JSON array)
+func IsBatchRequest(requestBody []byte) bool {
+	trimmed := bytes.TrimSpace(requestBody)
+	return len(trimmed) > 0 && trimmed[0] == '['
+}
+
+// IsNotification reports whether a JSON-RPC call expects no response, which is only the case
+// when it has no id member at all; a call with an explicit null id is still answered
+func IsNotification(call json.RawMessage) bool {
+	var envelope struct {
+		ID json.RawMessage `json:"id"`
+	}
+	return json.Unmarshal(call, &envelope) == nil && envelope.ID == nil
+}
+
+// BatchSize returns the number of elements in a JSON-RPC batch, or 1 for a single request
+func BatchSize(requestBody []byte) int {
+	if !IsBatchRequest(requestBody) {
+		return 1
+	}
+	var elements []json.RawMessage
+	if err := json.Unmarshal(requestBody, &elements); err != nil {
+		return 1
+	}
+	return len(elements)
+}
+
+// SetBatchLimits sets the maximum batch size and the chunk size used when splitting
+// a batch across endpoints
+func (d *Dispatcher) SetBatchLimits(maxSize, chunkSize int) {
+	if maxSize > 0 {
+		d.maxBatchSize = maxSize
+	}
+	if chunkSize > 0 {
+		d.batchChunkSize = chunkSize
+	}
+}
+
+// batchElement is one element of a batch together with its position in the original request
+type batchElement struct {
+	index   int
+	request RPCRequest
+}
+
+// forwardBatch forwards a JSON-RPC batch, splitting it across endpoints when it is large,
+// and assembles the responses in request order with the callers' ids restored. The result
+// is empty when every element is a notification.
+func (d *Dispatcher) forwardBatch(ctx context.Context, chainID int, requestBody []byte) ([]byte, error) {
+	var rawElements []json.RawMessage
+	if err := json.Unmarshal(requestBody, &rawElements); err != nil {
+		return nil, ErrInvalidRequest
+	}
+	if len(rawElements) == 0 {
+		return nil, ErrEmptyBatch
+	}
+	if len(rawElements) > d.maxBatchSize {
+		return nil, ErrBatchTooLarge
+	}
+
+	responses := make([]*RPCResponse, len(rawElements))
+	notification := make([]bool, len(rawElements))
+	var elements []batchElement
+	for i, raw := range rawElements {
+		var request RPCRequest
+		if err := json.Unmarshal(raw, &request); err != nil || request.Method == "" {
+			responses[i] = batchErrorResponse(nil, JSONRPCInvalidRequest, "invalid request")
+			continue
+		}
+		notification[i] = IsNotification(raw)
+		elements = append(elements, batchElement{index: i, request: request})
+	}
+
+	if len(elements) > 0 {
+		if err := d.forwardBatchElements(ctx, chainID, elements, responses); err != nil {
+			return nil, err
+		}
+	}
+
+	// Notifications get no response; everything else keeps its position
+	result := make([]*RPCResponse, 0, len(responses))
+	for i, response := range responses {
+		if notification[i] {
+			continue
+		}
+		result = append(result, response)
+	}
+	if len(result) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(result)
+}
+
+// forwardBatchElements sends the valid batch elements upstream and fills in their responses
+func (d *Dispatcher) forwardBatchElements(ctx context.Context, chainID int, elements []batchElement, responses []*RPCResponse) error {
+	// The Viper Network has no batch endpoint, so every element is relayed on its own
+	if chainID == ViperNetworkChainID {
+		for _, element := range elements {
+			body, err := json.Marshal(element.request)
+			if err != nil {
+				return err
+			}
+			responses[element.index] = d.singleBatchResponse(element, body, func(body []byte) ([]byte, error) {
+				return d.ForwardToViperNetwork(ctx, body)
+			})
+		}
+		return nil
+	}
+
+	// Calls with a path of their own are sent like single requests, the rest as upstream batches
+	var single, batched []batchElement
+	for _, element := range elements {
+		if d.ownPath(ctx, chainID, element.request.Method) {
+			single = append(single, element)
+		} else {
+			batched = append(batched, element)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, element := range single {
+		body, err := json.Marshal(element.request)
+		if err != nil {
+			return err
+		}
+
+		wg.Add(1)
+		go func(element batchElement) {
+			defer wg.Done()
+			responses[element.index] = d.singleBatchResponse(element, body, func(body []byte) ([]byte, error) {
+				return d.forwardCall(ctx, chainID, element.request, body)
+			})
+		}(element)
+	}
+	if len(batched) == 0 {
+		wg.Wait()
+		return nil
+	}
+
+	requests := make([]RPCRequest, len(batched))
+	for i, element := range batched {
+		requests[i] = element.request
+	}
+	endpoints, err := d.candidateEndpoints(ctx, chainID, requests...)
+	if err != nil {
+		wg.Wait()
+		return err
+	}
+
+	// Split large batches so several endpoints share the work
+	elements = batched
+	chunkSize := len(elements)
+	if len(endpoints) > 1 && len(elements) > d.batchChunkSize {
+		chunkSize = d.batchChunkSize
+	}
+
+	for start := 0; start < len(elements); start += chunkSize {
+		end := start + chunkSize
+		if end > len(elements) {
+			end = len(elements)
+		}
+
+		wg.Add(1)
+		go func(chunk []batchElement) {
+			defer wg.Done()
+			d.forwardBatchChunk(ctx, chainID, endpoints, chunk, responses)
+		}(elements[start:end])
+	}
+	wg.Wait()
+
+	return nil
+}
+
+// ownPath reports whether a batch element must take the path of its method instead of riding
+// in an upstream batch: broadcast transactions, consensus reads and cacheable calls
+func (d *Dispatcher) ownPath(ctx context.Context, chainID int, method string) bool {
+	if method == "eth_sendRawTransaction" && d.broadcastEnabled(chainID) {
+		return true
+	}
+	if consensusFromContext(ctx) > 1 && d.idempotent(chainID, method) {
+		return true
+	}
+	return d.cache != nil && d.cache.cacheable(method)
+}
+
+// forwardBatchChunk sends one chunk of a batch as an upstream batch. Element ids are
+// replaced by their positions so responses can be matched even when callers reuse ids.
+func (d *Dispatcher) forwardBatchChunk(ctx context.Context, chainID int, endpoints []models.RpcEndpoint, chunk []batchElement, responses []*RPCResponse) {
+	upstream := make([]RPCRequest, len(chunk))
+	// A chunk is replayed only when every element in it may be
+	idempotent := true
+	for i, element := range chunk {
+		upstream[i] = element.request
+		upstream[i].ID = i
+		if !d.idempotent(chainID, element.request.Method) {
+			idempotent = false
+		}
+	}
+
+	body, err := json.Marshal(upstream)
+	if err != nil {
+		fillBatchErrors(chunk, responses, err)
+		return
+	}
+
+	responseBody, err := d.forwardWithFailover(ctx, chainID, "batch", idempotent, endpoints, body)
+	if err != nil {
+		fillBatchErrors(chunk, responses, err)
+		return
+	}
+
+	var upstreamResponses []RPCResponse
+	if err := json.Unmarshal(responseBody, &upstreamResponses); err != nil {
+		// Some providers answer a batch with a single error object
+		var single RPCResponse
+		if json.Unmarshal(responseBody, &single) == nil && single.Error != nil {
+			fillBatchErrors(chunk, responses, errors.New(single.Error.Message))
+			return
+		}
+		fillBatchErrors(chunk, responses, fmt.Errorf("invalid batch response from upstream: %w", err))
+		return
+	}
+
+	for _, response := range upstreamResponses {
+		position, ok := batchPosition(response.ID)
+		if !ok || position >= len(chunk) {
+			continue
+		}
+		element := chunk[position]
+		response.ID = element.request.ID
+		if response.JSONRPC == "" {
+			response.JSONRPC = "2.0"
+		}
+		responses[element.index] = &response
+	}
+
+	// Elements the upstream silently dropped still get an answer
+	for _, element := range chunk {
+		if responses[element.index] == nil {
+			responses[element.index] = batchErrorResponse(element.request.ID, JSONRPCInternalError, "no response from upstream")
+		}
+	}
+}
+
+// singleBatchResponse forwards one element on its own and converts the outcome into a response
+func (d *Dispatcher) singleBatchResponse(element batchElement, body []byte, forward func([]byte) ([]byte, error)) *RPCResponse {
+	responseBody, err := forward(body)
+	if err != nil {
+		return batchErrorResponse(element.request.ID, JSONRPCInternalError, err.Error())
+	}
+
+	var response RPCResponse
+	if err := json.Unmarshal(responseBody, &response); err != nil {
+		return batchErrorResponse(element.request.ID, JSONRPCInternalError, "invalid response from upstream")
+	}
+	response.ID = element.request.ID
+	return &response
+}
+
+// batchPosition decodes the position id assigned to an upstream batch element
+func batchPosition(id interface{}) (int, bool) {
+	number, ok := id.(float64)
+	if !ok || number < 0 || number != float64(int(number)) {
+		return 0, false
+	}
+	return int(number), true
+}
+
+// fillBatchErrors answers every element of a chunk with the same error
+func fillBatchErrors(chunk []batchElement, responses []*RPCResponse, err error) {
+	for _, element := range chunk {
+		responses[element.index] = batchErrorResponse(element.request.ID, JSONRPCInternalError, err.Error())
+	}
+}
+
+// batchErrorResponse builds a JSON-RPC error response for a batch element
+func batchErrorResponse(id interface{}, code int, message string) *RPCResponse {
+	return &RPCResponse{
+		JSONRPC: "2.0",
+		Error: &RPCError{
+			Code:    code,
+			Message: message,
+		},
+		ID: id,
+	}
+}
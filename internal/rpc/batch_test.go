@@ -0,0 +1,239 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/illegalcall/viper-client/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// newBatchServer answers upstream batches in reverse order, echoing each method as the result
+func newBatchServer(calls *int64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(calls, 1)
+
+		var requests []RPCRequest
+		if err := json.NewDecoder(r.Body).Decode(&requests); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		responses := make([]RPCResponse, 0, len(requests))
+		for i := len(requests) - 1; i >= 0; i-- {
+			result, _ := json.Marshal(requests[i].Method)
+			responses = append(responses, RPCResponse{JSONRPC: "2.0", Result: result, ID: requests[i].ID})
+		}
+		json.NewEncoder(w).Encode(responses)
+	}))
+}
+
+func TestDispatcher_Forward_Batch(t *testing.T) {
+	var calls int64
+	server := newBatchServer(&calls)
+	defer server.Close()
+
+	mockManager := new(MockEndpointManager)
+	mockManager.On("GetActiveEndpoints", 2).Return([]models.RpcEndpoint{
+		{ID: 1, ChainID: 2, EndpointURL: server.URL, Priority: 1},
+	}, nil)
+	mockManager.On("UpdateEndpointHealth", mock.Anything, mock.Anything).Return(nil)
+
+	dispatcher := NewDispatcher(mockManager)
+
+	request := []byte(`[
+		{"jsonrpc":"2.0","method":"eth_chainId","id":"a"},
+		{"jsonrpc":"2.0","method":"eth_blockNumber","id":7},
+		{"jsonrpc":"2.0","id":8},
+		{"jsonrpc":"2.0","method":"eth_gasPrice"},
+		{"jsonrpc":"2.0","method":"eth_call","id":7}
+	]`)
+	response, err := dispatcher.Forward(context.Background(), 2, request)
+	assert.NoError(t, err)
+
+	var responses []RPCResponse
+	assert.NoError(t, json.Unmarshal(response, &responses))
+	assert.Len(t, responses, 4)
+
+	assert.Equal(t, "a", responses[0].ID)
+	assert.JSONEq(t, `"eth_chainId"`, string(responses[0].Result))
+	assert.Equal(t, float64(7), responses[1].ID)
+	assert.JSONEq(t, `"eth_blockNumber"`, string(responses[1].Result))
+	assert.Nil(t, responses[2].ID)
+	assert.Equal(t, JSONRPCInvalidRequest, responses[2].Error.Code)
+	assert.Equal(t, float64(7), responses[3].ID)
+	assert.JSONEq(t, `"eth_call"`, string(responses[3].Result))
+	assert.Equal(t, int64(1), calls)
+}
+
+func TestDispatcher_Forward_BatchSplitAcrossEndpoints(t *testing.T) {
+	var firstCalls, secondCalls int64
+	first := newBatchServer(&firstCalls)
+	defer first.Close()
+	second := newBatchServer(&secondCalls)
+	defer second.Close()
+
+	mockManager := new(MockEndpointManager)
+	mockManager.On("GetActiveEndpoints", 2).Return([]models.RpcEndpoint{
+		{ID: 1, ChainID: 2, EndpointURL: first.URL, Priority: 1},
+		{ID: 2, ChainID: 2, EndpointURL: second.URL, Priority: 1},
+	}, nil)
+	mockManager.On("UpdateEndpointHealth", mock.Anything, mock.Anything).Return(nil)
+
+	dispatcher := NewDispatcher(mockManager)
+	dispatcher.SetBatchLimits(10, 2)
+
+	request := []byte(`[
+		{"jsonrpc":"2.0","method":"m0","id":0},
+		{"jsonrpc":"2.0","method":"m1","id":1},
+		{"jsonrpc":"2.0","method":"m2","id":2},
+		{"jsonrpc":"2.0","method":"m3","id":3}
+	]`)
+	response, err := dispatcher.Forward(context.Background(), 2, request)
+	assert.NoError(t, err)
+
+	var responses []RPCResponse
+	assert.NoError(t, json.Unmarshal(response, &responses))
+	assert.Len(t, responses, 4)
+	for i, response := range responses {
+		assert.Equal(t, float64(i), response.ID)
+		assert.JSONEq(t, fmt.Sprintf(`"m%d"`, i), string(response.Result))
+	}
+	assert.Equal(t, int64(1), firstCalls)
+	assert.Equal(t, int64(1), secondCalls)
+}
+
+func TestDispatcher_Forward_BatchUpstreamFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"batch requests are not supported"}}`))
+	}))
+	defer server.Close()
+
+	mockManager := new(MockEndpointManager)
+	mockManager.On("GetActiveEndpoints", 2).Return([]models.RpcEndpoint{
+		{ID: 1, ChainID: 2, EndpointURL: server.URL, Priority: 1},
+	}, nil)
+	mockManager.On("UpdateEndpointHealth", mock.Anything, mock.Anything).Return(nil)
+
+	dispatcher := NewDispatcher(mockManager)
+
+	request := []byte(`[{"jsonrpc":"2.0","method":"eth_chainId","id":1},{"jsonrpc":"2.0","method":"eth_gasPrice","id":2}]`)
+	response, err := dispatcher.Forward(context.Background(), 2, request)
+	assert.NoError(t, err)
+
+	var responses []RPCResponse
+	assert.NoError(t, json.Unmarshal(response, &responses))
+	assert.Len(t, responses, 2)
+	for i, response := range responses {
+		assert.Equal(t, float64(i+1), response.ID)
+		assert.Equal(t, JSONRPCInternalError, response.Error.Code)
+		assert.Equal(t, "batch requests are not supported", response.Error.Message)
+	}
+}
+
+func TestDispatcher_Forward_BatchElementsTakeTheirMethodPath(t *testing.T) {
+	var singleCalls, batchCalls int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body json.RawMessage
+		json.NewDecoder(r.Body).Decode(&body)
+		if IsBatchRequest(body) {
+			atomic.AddInt64(&batchCalls, 1)
+			var requests []RPCRequest
+			json.Unmarshal(body, &requests)
+			responses := make([]RPCResponse, len(requests))
+			for i, request := range requests {
+				result, _ := json.Marshal(request.Method)
+				responses[i] = RPCResponse{JSONRPC: "2.0", Result: result, ID: request.ID}
+			}
+			json.NewEncoder(w).Encode(responses)
+			return
+		}
+		atomic.AddInt64(&singleCalls, 1)
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x89"}`))
+	}))
+	defer server.Close()
+
+	mockManager := new(MockEndpointManager)
+	mockManager.On("GetActiveEndpoints", 2).Return([]models.RpcEndpoint{
+		{ID: 1, ChainID: 2, EndpointURL: server.URL, Priority: 1},
+	}, nil)
+	mockManager.On("UpdateEndpointHealth", mock.Anything, mock.Anything).Return(nil)
+
+	dispatcher := NewDispatcher(mockManager)
+	dispatcher.SetResponseCache(NewResponseCache(10))
+
+	request := []byte(`[
+		{"jsonrpc":"2.0","method":"eth_chainId","params":[],"id":1},
+		{"jsonrpc":"2.0","method":"eth_call","params":[],"id":null},
+		{"jsonrpc":"2.0","method":"eth_gasPrice","params":[]}
+	]`)
+	for i := 0; i < 2; i++ {
+		response, err := dispatcher.Forward(context.Background(), 2, request)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `[
+			{"jsonrpc":"2.0","id":1,"result":"0x89"},
+			{"jsonrpc":"2.0","id":null,"result":"eth_call"}
+		]`, string(response))
+	}
+
+	// The cacheable call went upstream once on its own, the others in batches
+	assert.Equal(t, int64(1), singleCalls)
+	assert.Equal(t, int64(2), batchCalls)
+	assert.Equal(t, int64(1), dispatcher.CacheStats().Hits)
+
+	// Nothing is answered for a batch of notifications
+	response, err := dispatcher.Forward(context.Background(), 2, []byte(`[{"jsonrpc":"2.0","method":"eth_gasPrice","params":[]}]`))
+	assert.NoError(t, err)
+	assert.Nil(t, response)
+}
+
+func TestDispatcher_Forward_BatchBroadcastsTransactions(t *testing.T) {
+	store := &recordingBroadcastStore{recorded: make(chan []BroadcastOutcome, 1)}
+	dispatcher := newBroadcastDispatcher(t, store,
+		`{"jsonrpc":"2.0","id":1,"result":"`+testTxHash+`"}`,
+		`{"jsonrpc":"2.0","id":1,"result":"`+testTxHash+`"}`,
+	)
+
+	response, err := dispatcher.Forward(context.Background(), 2, []byte(`[{"jsonrpc":"2.0","method":"eth_sendRawTransaction","params":["0x01"],"id":"tx"}]`))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[{"jsonrpc":"2.0","id":"tx","result":"`+testTxHash+`"}]`, string(response))
+
+	select {
+	case outcomes := <-store.recorded:
+		assert.Len(t, outcomes, 2)
+	case <-time.After(time.Second):
+		t.Fatal("broadcast outcomes were not recorded")
+	}
+}
+
+func TestDispatcher_Forward_BatchLimits(t *testing.T) {
+	dispatcher := NewDispatcher(new(MockEndpointManager))
+	dispatcher.SetBatchLimits(2, 0)
+
+	_, err := dispatcher.Forward(context.Background(), 2, []byte(`[]`))
+	assert.Equal(t, ErrEmptyBatch, err)
+
+	request := []byte(`[{"method":"a","id":1},{"method":"b","id":2},{"method":"c","id":3}]`)
+	_, err = dispatcher.Forward(context.Background(), 2, request)
+	assert.Equal(t, ErrBatchTooLarge, err)
+}
+
+func TestIsNotification(t *testing.T) {
+	assert.True(t, IsNotification([]byte(`{"jsonrpc":"2.0","method":"eth_gasPrice"}`)))
+	assert.False(t, IsNotification([]byte(`{"jsonrpc":"2.0","method":"eth_gasPrice","id":null}`)))
+	assert.False(t, IsNotification([]byte(`{"jsonrpc":"2.0","method":"eth_gasPrice","id":0}`)))
+	assert.False(t, IsNotification([]byte(`"not a call"`)))
+}
+
+func TestBatchSize(t *testing.T) {
+	assert.Equal(t, 1, BatchSize([]byte(`{"method":"eth_chainId","id":1}`)))
+	assert.Equal(t, 3, BatchSize([]byte(` [{"id":1},{"id":2},{"id":3}]`)))
+	assert.Equal(t, 1, BatchSize([]byte(`[not json`)))
+}
@@ -0,0 +1,288 @@
+package rpc
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/illegalcall/viper-client/internal/models"
+)
+
+// BreakerState is the state of an endpoint's circuit breaker
+type BreakerState string
+
+const (
+	// BreakerClosed lets all traffic through
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen blocks all traffic until the cool-down elapses
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen lets trial traffic through to decide whether to close again
+	BreakerHalfOpen BreakerState = "half_open"
+)
+
+// BreakerConfig configures the circuit breakers
+type BreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures that trips the breaker
+	FailureThreshold int
+	// CoolDown is how long a tripped breaker stays open before allowing trial traffic
+	CoolDown time.Duration
+	// HalfOpenSuccesses is the number of consecutive successes in half-open state needed to close,
+	// and the number of trial requests let through at a time while half-open
+	HalfOpenSuccesses int
+}
+
+// DefaultBreakerConfig returns the breaker configuration used when none is configured
+func DefaultBreakerConfig() BreakerConfig {
+	return BreakerConfig{
+		FailureThreshold:  5,
+		CoolDown:          30 * time.Second,
+		HalfOpenSuccesses: 2,
+	}
+}
+
+// BreakerStatus describes the current state of one endpoint's breaker
+// @Description Circuit breaker state for an RPC endpoint
+type BreakerStatus struct {
+	EndpointID          int          `json:"endpoint_id" example:"1"`
+	State               BreakerState `json:"state" example:"closed"`
+	ConsecutiveFailures int          `json:"consecutive_failures" example:"0"`
+	OpenedAt            *time.Time   `json:"opened_at,omitempty"`
+	RetryAt             *time.Time   `json:"retry_at,omitempty"`
+}
+
+// breaker holds the state of a single endpoint's circuit breaker
+type breaker struct {
+	state     BreakerState
+	failures  int
+	successes int
+	openedAt  time.Time
+	// trials counts the half-open trials in flight
+	trials int
+}
+
+// CircuitBreakers keeps an in-process circuit breaker per RpcEndpoint.ID
+type CircuitBreakers struct {
+	mu       sync.Mutex
+	config   BreakerConfig
+	breakers map[int]*breaker
+	now      func() time.Time
+}
+
+// NewCircuitBreakers creates a breaker registry with the given configuration
+func NewCircuitBreakers(config BreakerConfig) *CircuitBreakers {
+	defaults := DefaultBreakerConfig()
+	if config.FailureThreshold < 1 {
+		config.FailureThreshold = defaults.FailureThreshold
+	}
+	if config.CoolDown <= 0 {
+		config.CoolDown = defaults.CoolDown
+	}
+	if config.HalfOpenSuccesses < 1 {
+		config.HalfOpenSuccesses = defaults.HalfOpenSuccesses
+	}
+
+	return &CircuitBreakers{
+		config:   config,
+		breakers: make(map[int]*breaker),
+		now:      time.Now,
+	}
+}
+
+// get returns the breaker for an endpoint, creating a closed one if needed. Caller must hold mu.
+func (cb *CircuitBreakers) get(endpointID int) *breaker {
+	b, ok := cb.breakers[endpointID]
+	if !ok {
+		b = &breaker{state: BreakerClosed}
+		cb.breakers[endpointID] = b
+	}
+	return b
+}
+
+// refresh moves an open breaker to half-open once its cool-down has elapsed. Caller must hold mu.
+func (cb *CircuitBreakers) refresh(b *breaker) {
+	if b.state == BreakerOpen && cb.now().Sub(b.openedAt) >= cb.config.CoolDown {
+		b.state = BreakerHalfOpen
+		b.successes = 0
+		b.trials = 0
+	}
+}
+
+// RecordSuccess records a successful request to an endpoint
+func (cb *CircuitBreakers) RecordSuccess(endpointID int) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	b := cb.get(endpointID)
+	cb.refresh(b)
+
+	b.failures = 0
+	if b.state == BreakerHalfOpen {
+		b.successes++
+		if b.successes >= cb.config.HalfOpenSuccesses {
+			b.state = BreakerClosed
+			b.successes = 0
+		}
+	}
+}
+
+// RecordFailure records a failed request to an endpoint, tripping the breaker when needed
+func (cb *CircuitBreakers) RecordFailure(endpointID int) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	b := cb.get(endpointID)
+	cb.refresh(b)
+
+	b.failures++
+	switch b.state {
+	case BreakerHalfOpen:
+		// A single failed trial re-opens the breaker
+		b.state = BreakerOpen
+		b.openedAt = cb.now()
+	case BreakerClosed:
+		if b.failures >= cb.config.FailureThreshold {
+			b.state = BreakerOpen
+			b.openedAt = cb.now()
+		}
+	}
+}
+
+// Allow reports whether traffic may be sent to the endpoint
+func (cb *CircuitBreakers) Allow(endpointID int) bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	b, ok := cb.breakers[endpointID]
+	if !ok {
+		return true
+	}
+	cb.refresh(b)
+	return b.state != BreakerOpen
+}
+
+// AcquireTrial reserves a request to the endpoint about to be sent. A half-open breaker lets
+// at most HalfOpenSuccesses trials through at a time; the returned release frees the trial
+// once the request is done, whether it reported an outcome or was cancelled. A nil registry
+// allows every request.
+func (cb *CircuitBreakers) AcquireTrial(endpointID int) (func(), bool) {
+	if cb == nil {
+		return func() {}, true
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	b, ok := cb.breakers[endpointID]
+	if !ok {
+		return func() {}, true
+	}
+	cb.refresh(b)
+	switch b.state {
+	case BreakerOpen:
+		return nil, false
+	case BreakerClosed:
+		return func() {}, true
+	}
+	if b.trials >= cb.config.HalfOpenSuccesses {
+		return nil, false
+	}
+	b.trials++
+
+	// Trials of an earlier half-open period were dropped when the breaker re-opened
+	openedAt := b.openedAt
+	return func() {
+		cb.mu.Lock()
+		defer cb.mu.Unlock()
+
+		if b.state == BreakerHalfOpen && b.openedAt.Equal(openedAt) && b.trials > 0 {
+			b.trials--
+		}
+	}, true
+}
+
+// Filter returns the endpoints whose breaker is not open. It does not reserve trials, as
+// only the endpoint eventually picked for a request is tried.
+func (cb *CircuitBreakers) Filter(endpoints []models.RpcEndpoint) []models.RpcEndpoint {
+	var allowed []models.RpcEndpoint
+	for _, endpoint := range endpoints {
+		if cb.Allow(endpoint.ID) {
+			allowed = append(allowed, endpoint)
+		}
+	}
+	return allowed
+}
+
+// Status returns the breaker state of every endpoint that has seen traffic, ordered by endpoint ID
+func (cb *CircuitBreakers) Status() []BreakerStatus {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	statuses := make([]BreakerStatus, 0, len(cb.breakers))
+	for id, b := range cb.breakers {
+		cb.refresh(b)
+		status := BreakerStatus{
+			EndpointID:          id,
+			State:               b.state,
+			ConsecutiveFailures: b.failures,
+		}
+		if b.state != BreakerClosed {
+			openedAt := b.openedAt
+			retryAt := b.openedAt.Add(cb.config.CoolDown)
+			status.OpenedAt = &openedAt
+			status.RetryAt = &retryAt
+		}
+		statuses = append(statuses, status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].EndpointID < statuses[j].EndpointID
+	})
+	return statuses
+}
+
+// BreakerEndpointManager wraps an EndpointManager, feeding its health updates into the
+// circuit breakers and hiding endpoints whose breaker is open
+type BreakerEndpointManager struct {
+	EndpointManager
+	breakers *CircuitBreakers
+}
+
+// NewBreakerEndpointManager wraps the given manager with circuit breakers
+func NewBreakerEndpointManager(manager EndpointManager, breakers *CircuitBreakers) *BreakerEndpointManager {
+	return &BreakerEndpointManager{
+		EndpointManager: manager,
+		breakers:        breakers,
+	}
+}
+
+// GetActiveEndpoints returns the active endpoints for a chain, skipping tripped endpoints
+func (m *BreakerEndpointManager) GetActiveEndpoints(chainID int) ([]models.RpcEndpoint, error) {
+	endpoints, err := m.EndpointManager.GetActiveEndpoints(chainID)
+	if err != nil {
+		return nil, err
+	}
+	return m.breakers.Filter(endpoints), nil
+}
+
+// UpdateEndpointHealth records the outcome in the endpoint's breaker and persists the status.
+// Throttled requests are neither a success nor a failure of the endpoint.
+func (m *BreakerEndpointManager) UpdateEndpointHealth(id int, status string) error {
+	switch status {
+	case "healthy":
+		m.breakers.RecordSuccess(id)
+	case HealthStatusRateLimited:
+	default:
+		m.breakers.RecordFailure(id)
+	}
+	return m.EndpointManager.UpdateEndpointHealth(id, status)
+}
+
+// SetCircuitBreakers limits the trial requests sent to endpoints whose breaker is half-open
+func (d *Dispatcher) SetCircuitBreakers(breakers *CircuitBreakers) {
+	d.breakers = breakers
+}
+
+// Breakers returns the circuit breakers used by the manager
+func (m *BreakerEndpointManager) Breakers() *CircuitBreakers {
+	return m.breakers
+}
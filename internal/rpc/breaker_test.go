@@ -0,0 +1,157 @@
+package rpc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/illegalcall/viper-client/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCircuitBreakers_TripAndRecover(t *testing.T) {
+	now := time.Now()
+	breakers := NewCircuitBreakers(BreakerConfig{
+		FailureThreshold:  2,
+		CoolDown:          time.Minute,
+		HalfOpenSuccesses: 1,
+	})
+	breakers.now = func() time.Time { return now }
+
+	breakers.RecordFailure(1)
+	assert.True(t, breakers.Allow(1))
+
+	// Second consecutive failure trips the breaker
+	breakers.RecordFailure(1)
+	assert.False(t, breakers.Allow(1))
+	assert.Equal(t, BreakerOpen, breakers.Status()[0].State)
+
+	// After the cool-down the breaker lets trial traffic through
+	now = now.Add(time.Minute)
+	assert.True(t, breakers.Allow(1))
+	assert.Equal(t, BreakerHalfOpen, breakers.Status()[0].State)
+
+	// A successful trial closes it again
+	breakers.RecordSuccess(1)
+	assert.Equal(t, BreakerClosed, breakers.Status()[0].State)
+}
+
+func TestCircuitBreakers_HalfOpenFailureReopens(t *testing.T) {
+	now := time.Now()
+	breakers := NewCircuitBreakers(BreakerConfig{FailureThreshold: 1, CoolDown: time.Second})
+	breakers.now = func() time.Time { return now }
+
+	breakers.RecordFailure(1)
+	now = now.Add(time.Second)
+	assert.True(t, breakers.Allow(1))
+
+	breakers.RecordFailure(1)
+	assert.False(t, breakers.Allow(1))
+}
+
+func TestCircuitBreakers_HalfOpenCapsTrials(t *testing.T) {
+	now := time.Now()
+	breakers := NewCircuitBreakers(BreakerConfig{FailureThreshold: 1, CoolDown: time.Minute, HalfOpenSuccesses: 2})
+	breakers.now = func() time.Time { return now }
+
+	breakers.RecordFailure(1)
+	now = now.Add(time.Minute)
+
+	// Listing the endpoint does not use up its trials
+	for i := 0; i < 5; i++ {
+		assert.Len(t, breakers.Filter([]models.RpcEndpoint{{ID: 1}}), 1)
+	}
+
+	// Only as many trials as needed to close are in flight at a time
+	release, ok := breakers.AcquireTrial(1)
+	assert.True(t, ok)
+	_, ok = breakers.AcquireTrial(1)
+	assert.True(t, ok)
+	_, ok = breakers.AcquireTrial(1)
+	assert.False(t, ok)
+
+	// A finished or cancelled trial frees its place
+	release()
+	release, ok = breakers.AcquireTrial(1)
+	assert.True(t, ok)
+
+	// Trials of a half-open period that failed do not count against the next one
+	breakers.RecordFailure(1)
+	release()
+	now = now.Add(time.Minute)
+	for i := 0; i < 2; i++ {
+		_, ok = breakers.AcquireTrial(1)
+		assert.True(t, ok)
+	}
+}
+
+func TestDispatcher_Forward_SkipsHalfOpenEndpointWithBusyTrials(t *testing.T) {
+	var mu sync.Mutex
+	hits := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	breakers := NewCircuitBreakers(BreakerConfig{FailureThreshold: 1, CoolDown: time.Minute, HalfOpenSuccesses: 1})
+	breakers.now = func() time.Time { return now }
+	breakers.RecordFailure(1)
+	now = now.Add(time.Minute)
+
+	mockManager := new(MockEndpointManager)
+	mockManager.On("GetActiveEndpoints", 2).Return([]models.RpcEndpoint{
+		{ID: 1, ChainID: 2, EndpointURL: server.URL + "/recovering", Priority: 100},
+		{ID: 2, ChainID: 2, EndpointURL: server.URL + "/healthy", Priority: 1},
+	}, nil)
+	mockManager.On("UpdateEndpointHealth", mock.Anything, mock.Anything).Return(nil)
+
+	dispatcher := NewDispatcher(NewBreakerEndpointManager(mockManager, breakers))
+	dispatcher.SetCircuitBreakers(breakers)
+
+	// The only trial is taken, so the request fails over without reaching the endpoint
+	release, ok := breakers.AcquireTrial(1)
+	assert.True(t, ok)
+	_, err := dispatcher.Forward(context.Background(), 2, []byte(`{"jsonrpc":"2.0","method":"eth_blockNumber","params":[],"id":1}`))
+	assert.NoError(t, err)
+	mu.Lock()
+	assert.Zero(t, hits["/recovering"])
+	mu.Unlock()
+	release()
+
+	// Once it is free, the trial closes the breaker
+	for i := 0; i < 10 && breakers.Status()[0].State != BreakerClosed; i++ {
+		_, err = dispatcher.Forward(context.Background(), 2, []byte(`{"jsonrpc":"2.0","method":"eth_chainId","params":[],"id":1}`))
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, BreakerClosed, breakers.Status()[0].State)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 1, hits["/recovering"])
+}
+
+func TestBreakerEndpointManager_SkipsTrippedEndpoints(t *testing.T) {
+	mockManager := new(MockEndpointManager)
+	mockManager.On("GetActiveEndpoints", 2).Return([]models.RpcEndpoint{
+		{ID: 1, ChainID: 2},
+		{ID: 2, ChainID: 2},
+	}, nil)
+	mockManager.On("UpdateEndpointHealth", 1, "error").Return(nil)
+
+	manager := NewBreakerEndpointManager(mockManager, NewCircuitBreakers(BreakerConfig{FailureThreshold: 1}))
+	manager.UpdateEndpointHealth(1, "error")
+
+	endpoints, err := manager.GetActiveEndpoints(2)
+	assert.NoError(t, err)
+	assert.Len(t, endpoints, 1)
+	assert.Equal(t, 2, endpoints[0].ID)
+	mockManager.AssertExpectations(t)
+}
@@ -0,0 +1,214 @@
+package rpc
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/illegalcall/viper-client/internal/models"
+	"golang.org/x/crypto/sha3"
+)
+
+// alreadyKnownMarkers are fragments of provider errors meaning the transaction is already in
+// the node's mempool or chain, which counts as a successful broadcast
+var alreadyKnownMarkers = []string{
+	"already known",
+	"alreadyknown",
+	"known transaction",
+	"already imported",
+	"already exists",
+}
+
+// BroadcastOutcome is the result of submitting a transaction to one endpoint
+// @Description Outcome of broadcasting a transaction to one RPC endpoint
+type BroadcastOutcome struct {
+	TxHash     string `json:"tx_hash" example:"0x5c504ed432cb51138bcf09aa5e8a410dd4a1e204ef84bfed1be16dfba1b22060"`
+	ChainID    int    `json:"chain_id" example:"2"`
+	EndpointID int    `json:"endpoint_id" example:"1"`
+	Provider   string `json:"provider,omitempty" example:"alchemy"`
+	// Whether the endpoint accepted the transaction, including "already known" answers
+	Accepted     bool      `json:"accepted" example:"true"`
+	AlreadyKnown bool      `json:"already_known" example:"false"`
+	Error        string    `json:"error,omitempty" example:"nonce too low"`
+	LatencyMs    int64     `json:"latency_ms" example:"87"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// BroadcastStore persists and lists per-endpoint broadcast outcomes
+type BroadcastStore interface {
+	RecordBroadcastOutcomes(outcomes []BroadcastOutcome) error
+	ListBroadcastOutcomes(txHash string) ([]BroadcastOutcome, error)
+}
+
+// broadcastResult is the outcome of one submission together with the endpoint's answer
+type broadcastResult struct {
+	outcome BroadcastOutcome
+	body    []byte
+	err     error
+}
+
+// EnableBroadcast makes eth_sendRawTransaction on the chain go to every active endpoint
+func (d *Dispatcher) EnableBroadcast(chainID int) {
+	d.broadcastMu.Lock()
+	defer d.broadcastMu.Unlock()
+
+	d.broadcastChains[chainID] = true
+}
+
+// SetBroadcastStore sets where per-endpoint broadcast outcomes are recorded
+func (d *Dispatcher) SetBroadcastStore(store BroadcastStore) {
+	d.broadcastStore = store
+}
+
+// BroadcastOutcomes returns the recorded per-endpoint outcomes of a broadcast transaction
+func (d *Dispatcher) BroadcastOutcomes(txHash string) ([]BroadcastOutcome, error) {
+	if d.broadcastStore == nil {
+		return []BroadcastOutcome{}, nil
+	}
+	return d.broadcastStore.ListBroadcastOutcomes(strings.ToLower(txHash))
+}
+
+// broadcastEnabled reports whether transactions on the chain are broadcast
+func (d *Dispatcher) broadcastEnabled(chainID int) bool {
+	d.broadcastMu.RLock()
+	defer d.broadcastMu.RUnlock()
+
+	return d.broadcastChains[chainID]
+}
+
+// forwardBroadcast submits a transaction to every active endpoint of the chain in parallel and
+// returns the first acceptance. The remaining submissions run to completion in the background
+// so every endpoint's outcome can be recorded.
+func (d *Dispatcher) forwardBroadcast(ctx context.Context, chainID int, request RPCRequest, requestBody []byte) ([]byte, error) {
+	endpoints, err := d.capableEndpoints(chainID, request)
+	if err != nil {
+		return nil, err
+	}
+	if len(endpoints) == 0 {
+		return nil, ErrNoEndpoints
+	}
+
+	txHash := rawTransactionHash(request)
+	// Submissions must not be cancelled when the caller returns after the first acceptance
+	sendCtx := context.WithoutCancel(ctx)
+	results := make(chan broadcastResult, len(endpoints))
+	for _, endpoint := range endpoints {
+		go func(endpoint models.RpcEndpoint) {
+			results <- d.submitTransaction(sendCtx, endpoint, txHash, requestBody)
+		}(endpoint)
+	}
+
+	first := make(chan broadcastResult, 1)
+	go func() {
+		outcomes := make([]BroadcastOutcome, 0, len(endpoints))
+		var last broadcastResult
+		answered := false
+		for range endpoints {
+			result := <-results
+			outcomes = append(outcomes, result.outcome)
+			if result.outcome.Accepted && !answered {
+				first <- result
+				answered = true
+			}
+			if len(result.body) > 0 || last.body == nil {
+				last = result
+			}
+		}
+		if !answered {
+			first <- last
+		}
+		d.recordBroadcast(outcomes)
+	}()
+
+	select {
+	case result := <-first:
+		if result.outcome.AlreadyKnown && txHash != "" {
+			// The node did not echo the hash, but it is derived from the raw transaction
+			hash, _ := json.Marshal(txHash)
+			return json.Marshal(RPCResponse{JSONRPC: "2.0", Result: hash, ID: request.ID})
+		}
+		if len(result.body) > 0 {
+			return withResponseID(result.body, request.ID), nil
+		}
+		return nil, result.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// submitTransaction sends the transaction to one endpoint and classifies the answer
+func (d *Dispatcher) submitTransaction(ctx context.Context, endpoint models.RpcEndpoint, txHash string, requestBody []byte) broadcastResult {
+	start := time.Now()
+	result := d.attempt(ctx, endpoint, requestBody)
+
+	outcome := BroadcastOutcome{
+		TxHash:     txHash,
+		ChainID:    endpoint.ChainID,
+		EndpointID: endpoint.ID,
+		Provider:   endpoint.Provider,
+		LatencyMs:  time.Since(start).Milliseconds(),
+		CreatedAt:  start,
+	}
+
+	var response RPCResponse
+	if len(result.body) > 0 && json.Unmarshal(result.body, &response) == nil {
+		switch {
+		case response.Error != nil && isAlreadyKnown(response.Error.Message):
+			outcome.Accepted = true
+			outcome.AlreadyKnown = true
+		case response.Error != nil:
+			outcome.Error = response.Error.Message
+		case result.kind == failureNone:
+			outcome.Accepted = true
+			if outcome.TxHash == "" {
+				json.Unmarshal(response.Result, &outcome.TxHash)
+			}
+		}
+	}
+	if !outcome.Accepted && outcome.Error == "" && result.err != nil {
+		outcome.Error = result.err.Error()
+	}
+
+	return broadcastResult{outcome: outcome, body: result.body, err: result.err}
+}
+
+// recordBroadcast persists the per-endpoint outcomes of a broadcast
+func (d *Dispatcher) recordBroadcast(outcomes []BroadcastOutcome) {
+	if d.broadcastStore == nil {
+		return
+	}
+	if err := d.broadcastStore.RecordBroadcastOutcomes(outcomes); err != nil {
+		log.Printf("Failed to record broadcast outcomes: %v", err)
+	}
+}
+
+// isAlreadyKnown reports whether a provider error means the transaction was already received
+func isAlreadyKnown(message string) bool {
+	message = strings.ToLower(message)
+	for _, marker := range alreadyKnownMarkers {
+		if strings.Contains(message, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// rawTransactionHash returns the keccak256 hash of the signed transaction in an
+// eth_sendRawTransaction request, or "" when the parameter cannot be decoded
+func rawTransactionHash(request RPCRequest) string {
+	var params []string
+	if err := json.Unmarshal(request.Params, &params); err != nil || len(params) == 0 {
+		return ""
+	}
+	raw, err := hex.DecodeString(strings.TrimPrefix(params[0], "0x"))
+	if err != nil || len(raw) == 0 {
+		return ""
+	}
+
+	hash := sha3.NewLegacyKeccak256()
+	hash.Write(raw)
+	return "0x" + hex.EncodeToString(hash.Sum(nil))
+}
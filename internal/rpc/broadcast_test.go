@@ -0,0 +1,113 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/illegalcall/viper-client/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// keccak256(0x01)
+const testTxHash = "0x5fe7f977e71dba2ea1a68e21057beebb9be2ac30c6410aa38d4f3fbe41dcffd2"
+
+// recordingBroadcastStore hands recorded outcomes to the test
+type recordingBroadcastStore struct {
+	recorded chan []BroadcastOutcome
+}
+
+func (s *recordingBroadcastStore) RecordBroadcastOutcomes(outcomes []BroadcastOutcome) error {
+	s.recorded <- outcomes
+	return nil
+}
+
+func (s *recordingBroadcastStore) ListBroadcastOutcomes(txHash string) ([]BroadcastOutcome, error) {
+	return nil, nil
+}
+
+// newBroadcastDispatcher creates a broadcasting dispatcher whose endpoints answer with the given bodies
+func newBroadcastDispatcher(t *testing.T, store BroadcastStore, bodies ...string) *Dispatcher {
+	var endpoints []models.RpcEndpoint
+	for i, body := range bodies {
+		body := body
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(body))
+		}))
+		t.Cleanup(server.Close)
+		endpoints = append(endpoints, models.RpcEndpoint{ID: i + 1, ChainID: 2, EndpointURL: server.URL, Priority: 1})
+	}
+
+	mockManager := new(MockEndpointManager)
+	mockManager.On("GetActiveEndpoints", 2).Return(endpoints, nil)
+	mockManager.On("UpdateEndpointHealth", mock.Anything, mock.Anything).Return(nil)
+
+	dispatcher := NewDispatcher(mockManager)
+	dispatcher.EnableBroadcast(2)
+	dispatcher.SetBroadcastStore(store)
+	return dispatcher
+}
+
+func TestDispatcher_Forward_BroadcastRecordsOutcomes(t *testing.T) {
+	store := &recordingBroadcastStore{recorded: make(chan []BroadcastOutcome, 1)}
+	dispatcher := newBroadcastDispatcher(t, store,
+		`{"jsonrpc":"2.0","id":1,"result":"`+testTxHash+`"}`,
+		`{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"already known"}}`,
+		`{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"nonce too low"}}`,
+	)
+
+	response, err := dispatcher.Forward(context.Background(), 2, []byte(`{"jsonrpc":"2.0","method":"eth_sendRawTransaction","params":["0x01"],"id":9}`))
+	assert.NoError(t, err)
+
+	var rpcResponse RPCResponse
+	assert.NoError(t, json.Unmarshal(response, &rpcResponse))
+	assert.Nil(t, rpcResponse.Error)
+	assert.JSONEq(t, `"`+testTxHash+`"`, string(rpcResponse.Result))
+	assert.Equal(t, float64(9), rpcResponse.ID)
+
+	var outcomes []BroadcastOutcome
+	select {
+	case outcomes = <-store.recorded:
+	case <-time.After(time.Second):
+		t.Fatal("broadcast outcomes were not recorded")
+	}
+	sort.Slice(outcomes, func(i, j int) bool { return outcomes[i].EndpointID < outcomes[j].EndpointID })
+
+	assert.Len(t, outcomes, 3)
+	for _, outcome := range outcomes {
+		assert.Equal(t, testTxHash, outcome.TxHash)
+	}
+	assert.True(t, outcomes[0].Accepted)
+	assert.True(t, outcomes[1].Accepted)
+	assert.True(t, outcomes[1].AlreadyKnown)
+	assert.False(t, outcomes[2].Accepted)
+	assert.Equal(t, "nonce too low", outcomes[2].Error)
+}
+
+func TestDispatcher_Forward_BroadcastAlreadyKnownEverywhere(t *testing.T) {
+	store := &recordingBroadcastStore{recorded: make(chan []BroadcastOutcome, 1)}
+	dispatcher := newBroadcastDispatcher(t, store,
+		`{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"already known"}}`,
+		`{"jsonrpc":"2.0","id":1,"error":{"code":-32010,"message":"Transaction with the same hash was already imported."}}`,
+	)
+
+	response, err := dispatcher.Forward(context.Background(), 2, []byte(`{"jsonrpc":"2.0","method":"eth_sendRawTransaction","params":["0x01"],"id":1}`))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":1,"result":"`+testTxHash+`"}`, string(response))
+}
+
+func TestDispatcher_Forward_BroadcastRejectedEverywhere(t *testing.T) {
+	store := &recordingBroadcastStore{recorded: make(chan []BroadcastOutcome, 1)}
+	dispatcher := newBroadcastDispatcher(t, store,
+		`{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"insufficient funds for gas"}}`,
+	)
+
+	response, err := dispatcher.Forward(context.Background(), 2, []byte(`{"jsonrpc":"2.0","method":"eth_sendRawTransaction","params":["0x01"],"id":4}`))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":4,"error":{"code":-32000,"message":"insufficient funds for gas"}}`, string(response))
+}
@@ -0,0 +1,336 @@
+package rpc
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCacheSize is the default number of responses kept in the cache
+	DefaultCacheSize = 10000
+
+	// DefaultBlockTime is the block time assumed for chains without a configured one
+	DefaultBlockTime = 2 * time.Second
+
+	// DefaultFinalityDepth is the number of blocks after which a block is treated as final
+	DefaultFinalityDepth = 64
+
+	// CacheForever marks a cache policy whose entries never expire
+	CacheForever time.Duration = -1
+)
+
+// CachePolicy describes how long results of a method may be served from the cache
+type CachePolicy struct {
+	// TTL is how long a result stays fresh; CacheForever never expires it
+	TTL time.Duration
+	// BlockTime uses the chain's block time as TTL
+	BlockTime bool
+	// Finalized only caches results whose blockNumber is at least the finality depth
+	// below the chain head last seen by the cache or reported by the dispatcher's sync tracker
+	Finalized bool
+}
+
+// defaultCachePolicies covers deterministic calls and the chain head
+var defaultCachePolicies = map[string]CachePolicy{
+	"eth_chainId":               {TTL: CacheForever},
+	"net_version":               {TTL: CacheForever},
+	"eth_getBlockByHash":        {TTL: CacheForever},
+	"eth_getTransactionByHash":  {TTL: CacheForever, Finalized: true},
+	"eth_getTransactionReceipt": {TTL: CacheForever, Finalized: true},
+	"eth_blockNumber":           {BlockTime: true},
+}
+
+// CacheStats describes response cache activity
+// @Description Response cache counters
+type CacheStats struct {
+	// Requests answered from the cache
+	Hits int64 `json:"hits" example:"5120"`
+	// Cacheable requests that had to go upstream
+	Misses int64 `json:"misses" example:"830"`
+	// Cacheable requests that skipped the cache on the caller's request
+	Bypassed int64 `json:"bypassed" example:"12"`
+	// Entries dropped to make room for new ones
+	Evictions int64 `json:"evictions" example:"0"`
+	// Current and maximum number of entries
+	Entries  int `json:"entries" example:"412"`
+	Capacity int `json:"capacity" example:"10000"`
+}
+
+// cacheBypassContextKey is the context key marking requests that must not be served from the cache
+type cacheBypassContextKey struct{}
+
+// WithCacheBypass returns a context whose requests skip cached responses. Fresh
+// responses are still stored so later requests benefit from them.
+func WithCacheBypass(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cacheBypassContextKey{}, true)
+}
+
+// cacheBypassed reports whether the request asked to skip the cache
+func cacheBypassed(ctx context.Context) bool {
+	bypass, _ := ctx.Value(cacheBypassContextKey{}).(bool)
+	return bypass
+}
+
+// cacheEntry is one cached result
+type cacheEntry struct {
+	key       string
+	result    json.RawMessage
+	expiresAt time.Time
+}
+
+// ResponseCache is a bounded LRU cache of JSON-RPC results keyed by chain, method and params
+type ResponseCache struct {
+	mu         sync.Mutex
+	capacity   int
+	entries    map[string]*list.Element
+	order      *list.List
+	policies   map[string]CachePolicy
+	blockTimes map[int]time.Duration
+	heads      map[int]uint64
+	stats      CacheStats
+	now        func() time.Time
+}
+
+// NewResponseCache creates a cache holding at most capacity results, using the default method policies
+func NewResponseCache(capacity int) *ResponseCache {
+	if capacity <= 0 {
+		capacity = DefaultCacheSize
+	}
+
+	policies := make(map[string]CachePolicy, len(defaultCachePolicies))
+	for method, policy := range defaultCachePolicies {
+		policies[method] = policy
+	}
+
+	return &ResponseCache{
+		capacity:   capacity,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+		policies:   policies,
+		blockTimes: make(map[int]time.Duration),
+		heads:      make(map[int]uint64),
+		now:        time.Now,
+	}
+}
+
+// SetPolicy sets the cache policy of a method. A zero policy disables caching for the method.
+func (c *ResponseCache) SetPolicy(method string, policy CachePolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if policy == (CachePolicy{}) {
+		delete(c.policies, method)
+		return
+	}
+	c.policies[method] = policy
+}
+
+// SetBlockTime sets the block time of a chain, used by block-time policies
+func (c *ResponseCache) SetBlockTime(chainID int, blockTime time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.blockTimes[chainID] = blockTime
+}
+
+// Stats returns the cache counters
+func (c *ResponseCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Entries = c.order.Len()
+	stats.Capacity = c.capacity
+	return stats
+}
+
+// cacheable reports whether responses to the method may be cached
+func (c *ResponseCache) cacheable(method string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, ok := c.policies[method]
+	return ok
+}
+
+// lookup returns the cached response for a request, rewritten to carry the caller's id
+func (c *ResponseCache) lookup(ctx context.Context, chainID int, request RPCRequest) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.policies[request.Method]; !ok {
+		return nil, false
+	}
+	if cacheBypassed(ctx) {
+		c.stats.Bypassed++
+		return nil, false
+	}
+
+	element, ok := c.entries[cacheKey(chainID, request)]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if !entry.expiresAt.IsZero() && !c.now().Before(entry.expiresAt) {
+		c.removeElement(element)
+		c.stats.Misses++
+		return nil, false
+	}
+
+	c.order.MoveToFront(element)
+	c.stats.Hits++
+
+	response, err := json.Marshal(RPCResponse{
+		JSONRPC: "2.0",
+		Result:  entry.result,
+		ID:      request.ID,
+	})
+	if err != nil {
+		return nil, false
+	}
+	return response, true
+}
+
+// store caches a successful upstream response if the method's policy allows it
+func (c *ResponseCache) store(chainID int, request RPCRequest, responseBody []byte) {
+	var response RPCResponse
+	if err := json.Unmarshal(responseBody, &response); err != nil || response.Error != nil {
+		return
+	}
+	// Null results (unknown hash, pending transaction) may change and are never cached
+	if len(response.Result) == 0 || bytes.Equal(response.Result, []byte("null")) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if request.Method == "eth_blockNumber" {
+		if head, ok := parseHexQuantity(response.Result); ok {
+			c.raiseHead(chainID, head)
+		}
+	}
+
+	policy, ok := c.policies[request.Method]
+	if !ok {
+		return
+	}
+	if policy.Finalized && !c.isFinal(chainID, response.Result) {
+		return
+	}
+
+	var expiresAt time.Time
+	switch {
+	case policy.BlockTime:
+		blockTime, ok := c.blockTimes[chainID]
+		if !ok {
+			blockTime = DefaultBlockTime
+		}
+		expiresAt = c.now().Add(blockTime)
+	case policy.TTL > 0:
+		expiresAt = c.now().Add(policy.TTL)
+	case policy.TTL != CacheForever:
+		return
+	}
+
+	key := cacheKey(chainID, request)
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*cacheEntry)
+		entry.result = response.Result
+		entry.expiresAt = expiresAt
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry{
+		key:       key,
+		result:    response.Result,
+		expiresAt: expiresAt,
+	})
+	for c.order.Len() > c.capacity {
+		c.removeElement(c.order.Back())
+		c.stats.Evictions++
+	}
+}
+
+// observeHead records a chain head learned outside the cache, such as from endpoint probes
+func (c *ResponseCache) observeHead(chainID int, head uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.raiseHead(chainID, head)
+}
+
+// raiseHead moves the chain head forward. Caller must hold the cache's mutex.
+func (c *ResponseCache) raiseHead(chainID int, head uint64) {
+	if head > c.heads[chainID] {
+		c.heads[chainID] = head
+	}
+}
+
+// isFinal reports whether the block a result belongs to is deep enough below the chain head.
+// Caller must hold the cache's mutex.
+func (c *ResponseCache) isFinal(chainID int, result json.RawMessage) bool {
+	var located struct {
+		BlockNumber string `json:"blockNumber"`
+	}
+	if err := json.Unmarshal(result, &located); err != nil || located.BlockNumber == "" {
+		return false
+	}
+	block, ok := parseHexQuantity(json.RawMessage(strconv.Quote(located.BlockNumber)))
+	if !ok {
+		return false
+	}
+	head := c.heads[chainID]
+	return head >= DefaultFinalityDepth && block <= head-DefaultFinalityDepth
+}
+
+// removeElement drops an entry. Caller must hold the cache's mutex.
+func (c *ResponseCache) removeElement(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*cacheEntry).key)
+}
+
+// cacheKey identifies a request by chain, method and compacted params, ignoring its id
+func cacheKey(chainID int, request RPCRequest) string {
+	var params bytes.Buffer
+	if err := json.Compact(&params, request.Params); err != nil {
+		params.Reset()
+		params.Write(request.Params)
+	}
+	return fmt.Sprintf("%d:%s:%s", chainID, request.Method, params.String())
+}
+
+// parseHexQuantity decodes a JSON string holding a hex quantity such as "0x1b4"
+func parseHexQuantity(raw json.RawMessage) (uint64, bool) {
+	var quantity string
+	if err := json.Unmarshal(raw, &quantity); err != nil {
+		return 0, false
+	}
+	value, err := strconv.ParseUint(strings.TrimPrefix(quantity, "0x"), 16, 64)
+	if err != nil {
+		return 0, false
+	}
+	return value, true
+}
+
+// SetResponseCache enables serving deterministic calls from a response cache
+func (d *Dispatcher) SetResponseCache(cache *ResponseCache) {
+	d.cache = cache
+}
+
+// CacheStats returns the response cache counters, or zero counters when caching is disabled
+func (d *Dispatcher) CacheStats() CacheStats {
+	if d.cache == nil {
+		return CacheStats{}
+	}
+	return d.cache.Stats()
+}
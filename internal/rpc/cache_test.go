@@ -0,0 +1,143 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/illegalcall/viper-client/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestDispatcher_Forward_CachedResponse(t *testing.T) {
+	var calls int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x89"}`))
+	}))
+	defer server.Close()
+
+	mockManager := new(MockEndpointManager)
+	mockManager.On("GetActiveEndpoints", 2).Return([]models.RpcEndpoint{
+		{ID: 1, ChainID: 2, EndpointURL: server.URL, Priority: 1},
+	}, nil)
+	mockManager.On("UpdateEndpointHealth", mock.Anything, mock.Anything).Return(nil)
+
+	dispatcher := NewDispatcher(mockManager)
+	dispatcher.SetResponseCache(NewResponseCache(10))
+
+	_, err := dispatcher.Forward(context.Background(), 2, []byte(`{"jsonrpc":"2.0","method":"eth_chainId","params":[],"id":1}`))
+	assert.NoError(t, err)
+
+	response, err := dispatcher.Forward(context.Background(), 2, []byte(`{"jsonrpc":"2.0","method":"eth_chainId","params":[ ],"id":"second"}`))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":"second","result":"0x89"}`, string(response))
+	assert.Equal(t, int64(1), calls)
+
+	_, err = dispatcher.Forward(WithCacheBypass(context.Background()), 2, []byte(`{"jsonrpc":"2.0","method":"eth_chainId","params":[],"id":3}`))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), calls)
+
+	stats := dispatcher.CacheStats()
+	assert.Equal(t, int64(1), stats.Hits)
+	assert.Equal(t, int64(1), stats.Misses)
+	assert.Equal(t, int64(1), stats.Bypassed)
+	assert.Equal(t, 1, stats.Entries)
+}
+
+func TestResponseCache_BlockTimeExpiry(t *testing.T) {
+	now := time.Now()
+	cache := NewResponseCache(10)
+	cache.now = func() time.Time { return now }
+	cache.SetBlockTime(2, 12*time.Second)
+
+	request := RPCRequest{Method: "eth_blockNumber", Params: json.RawMessage(`[]`), ID: 1}
+	cache.store(2, request, []byte(`{"jsonrpc":"2.0","id":1,"result":"0x10"}`))
+
+	_, ok := cache.lookup(context.Background(), 2, request)
+	assert.True(t, ok)
+
+	now = now.Add(12 * time.Second)
+	_, ok = cache.lookup(context.Background(), 2, request)
+	assert.False(t, ok)
+}
+
+func TestResponseCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	cache := NewResponseCache(2)
+
+	block := func(hash string) RPCRequest {
+		return RPCRequest{Method: "eth_getBlockByHash", Params: json.RawMessage(`["` + hash + `",false]`), ID: 1}
+	}
+	cache.store(2, block("0xa"), []byte(`{"jsonrpc":"2.0","id":1,"result":{"hash":"0xa"}}`))
+	cache.store(2, block("0xb"), []byte(`{"jsonrpc":"2.0","id":1,"result":{"hash":"0xb"}}`))
+
+	// Touch 0xa so 0xb becomes the least recently used entry
+	_, ok := cache.lookup(context.Background(), 2, block("0xa"))
+	assert.True(t, ok)
+
+	cache.store(2, block("0xc"), []byte(`{"jsonrpc":"2.0","id":1,"result":{"hash":"0xc"}}`))
+
+	_, ok = cache.lookup(context.Background(), 2, block("0xb"))
+	assert.False(t, ok)
+	_, ok = cache.lookup(context.Background(), 2, block("0xa"))
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), cache.Stats().Evictions)
+}
+
+func TestResponseCache_OnlyFinalReceipts(t *testing.T) {
+	cache := NewResponseCache(10)
+	receipt := RPCRequest{Method: "eth_getTransactionReceipt", Params: json.RawMessage(`["0xabc"]`), ID: 1}
+	receiptBody := []byte(`{"jsonrpc":"2.0","id":1,"result":{"blockNumber":"0x64","status":"0x1"}}`)
+
+	// Pending transactions and unknown chain heads are never cached
+	cache.store(2, receipt, []byte(`{"jsonrpc":"2.0","id":1,"result":null}`))
+	cache.store(2, receipt, receiptBody)
+	_, ok := cache.lookup(context.Background(), 2, receipt)
+	assert.False(t, ok)
+
+	// Head 0x64+10 is not deep enough
+	cache.store(2, RPCRequest{Method: "eth_blockNumber"}, []byte(`{"jsonrpc":"2.0","id":1,"result":"0x6e"}`))
+	cache.store(2, receipt, receiptBody)
+	_, ok = cache.lookup(context.Background(), 2, receipt)
+	assert.False(t, ok)
+
+	cache.store(2, RPCRequest{Method: "eth_blockNumber"}, []byte(`{"jsonrpc":"2.0","id":1,"result":"0xa4"}`))
+	cache.store(2, receipt, receiptBody)
+	_, ok = cache.lookup(context.Background(), 2, receipt)
+	assert.True(t, ok)
+}
+
+func TestDispatcher_Forward_CachesReceiptsFinalBySyncTrackerHead(t *testing.T) {
+	var calls int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":{"blockNumber":"0x64","status":"0x1"}}`))
+	}))
+	defer server.Close()
+
+	mockManager := new(MockEndpointManager)
+	mockManager.On("GetActiveEndpoints", 2).Return([]models.RpcEndpoint{
+		{ID: 1, ChainID: 2, EndpointURL: server.URL, Priority: 1},
+	}, nil)
+	mockManager.On("UpdateEndpointHealth", mock.Anything, mock.Anything).Return(nil)
+
+	// The head is only known from probes, no eth_blockNumber call goes through the cache
+	tracker := NewSyncTracker(DefaultMaxBlockLag)
+	tracker.Observe(2, 1, 0xa4)
+
+	dispatcher := NewDispatcher(mockManager)
+	dispatcher.SetResponseCache(NewResponseCache(10))
+	dispatcher.SetSyncTracker(tracker)
+
+	request := []byte(`{"jsonrpc":"2.0","method":"eth_getTransactionReceipt","params":["0xabc"],"id":1}`)
+	for i := 0; i < 2; i++ {
+		_, err := dispatcher.Forward(context.Background(), 2, request)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int64(1), atomic.LoadInt64(&calls))
+}
@@ -0,0 +1,156 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/illegalcall/viper-client/internal/models"
+)
+
+// coalescedCallTimeout bounds a shared upstream call, which does not end with the caller
+// that started it
+const coalescedCallTimeout = 30 * time.Second
+
+// coalescedCall is one upstream call shared by every identical in-flight request
+type coalescedCall struct {
+	done     chan struct{}
+	response []byte
+	err      error
+	// apps are the apps of the callers sharing the call, guarded by requestCoalescer.mu
+	apps []int
+}
+
+// sharedSpendContextKey is the context key under which the spend of a shared call is collected
+type sharedSpendContextKey struct{}
+
+// spendCharge is the calls an endpoint answered during a shared call
+type spendCharge struct {
+	endpoint models.RpcEndpoint
+	methods  []string
+}
+
+// sharedSpend collects the spend of a shared upstream call until its callers are known, then
+// splits it between their apps
+type sharedSpend struct {
+	tracker *SpendTracker
+
+	mu      sync.Mutex
+	apps    []int
+	settled bool
+	charges []spendCharge
+}
+
+// add records the calls an endpoint answered, holding them back until the call is settled
+func (s *sharedSpend) add(endpoint models.RpcEndpoint, methods []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.settled {
+		s.tracker.RecordShared(endpoint, s.apps, methods)
+		return
+	}
+	s.charges = append(s.charges, spendCharge{endpoint: endpoint, methods: methods})
+}
+
+// settle splits the spend collected so far, and any recorded later, between the given apps
+func (s *sharedSpend) settle(apps []int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.apps = apps
+	s.settled = true
+	for _, charge := range s.charges {
+		s.tracker.RecordShared(charge.endpoint, apps, charge.methods)
+	}
+	s.charges = nil
+}
+
+// requestCoalescer collapses identical concurrent requests into a single upstream call
+type requestCoalescer struct {
+	mu    sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+func newRequestCoalescer() *requestCoalescer {
+	return &requestCoalescer{
+		calls: make(map[string]*coalescedCall),
+	}
+}
+
+// do runs forward once per key at a time. Callers arriving while a call is in flight wait
+// for its result instead of sending their own. The shared call is detached from the first
+// caller's cancellation so that caller leaving does not fail everyone else, and is bounded by
+// its own timeout instead; each caller still stops waiting when its own context is done.
+// The spend of the shared call is split between the apps of every caller when tracker is set.
+func (c *requestCoalescer) do(ctx context.Context, key string, tracker *SpendTracker, forward func(context.Context) ([]byte, error)) ([]byte, error) {
+	c.mu.Lock()
+	call, inFlight := c.calls[key]
+	if !inFlight {
+		call = &coalescedCall{done: make(chan struct{})}
+		c.calls[key] = call
+	}
+	call.apps = append(call.apps, appFromContext(ctx))
+	c.mu.Unlock()
+
+	if !inFlight {
+		go func() {
+			spend := &sharedSpend{tracker: tracker}
+			shared := WithApp(context.WithoutCancel(ctx), 0)
+			if tracker != nil {
+				shared = context.WithValue(shared, sharedSpendContextKey{}, spend)
+			}
+			shared, cancel := context.WithTimeout(shared, coalescedCallTimeout)
+			call.response, call.err = forward(shared)
+			cancel()
+
+			c.mu.Lock()
+			delete(c.calls, key)
+			apps := call.apps
+			c.mu.Unlock()
+			close(call.done)
+
+			if tracker != nil {
+				spend.settle(apps)
+			}
+		}()
+	}
+
+	select {
+	case <-call.done:
+		return call.response, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// coalesceKey identifies requests that may share an upstream call. The geozone is part
+// of the key because it decides which endpoints serve the request.
+func coalesceKey(ctx context.Context, chainID int, request RPCRequest) string {
+	return GeozoneFromContext(ctx) + "|" + cacheKey(chainID, request)
+}
+
+// withResponseID returns the response with its JSON-RPC id replaced by the caller's id
+func withResponseID(responseBody []byte, id interface{}) []byte {
+	var response RPCResponse
+	if err := json.Unmarshal(responseBody, &response); err != nil {
+		return responseBody
+	}
+	response.ID = id
+
+	rewritten, err := json.Marshal(response)
+	if err != nil {
+		return responseBody
+	}
+	return rewritten
+}
+
+// SetCoalescing enables or disables collapsing identical in-flight requests into one upstream call
+func (d *Dispatcher) SetCoalescing(enabled bool) {
+	if enabled {
+		d.coalescer = newRequestCoalescer()
+		return
+	}
+	d.coalescer = nil
+}
@@ -0,0 +1,165 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/illegalcall/viper-client/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestDispatcher_Forward_CoalescesIdenticalRequests(t *testing.T) {
+	var calls int64
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		<-release
+		w.Write([]byte(`{"jsonrpc":"2.0","id":0,"result":{"number":"0x10"}}`))
+	}))
+	defer server.Close()
+
+	mockManager := new(MockEndpointManager)
+	mockManager.On("GetActiveEndpoints", 2).Return([]models.RpcEndpoint{
+		{ID: 1, ChainID: 2, EndpointURL: server.URL, Priority: 1},
+	}, nil)
+	mockManager.On("UpdateEndpointHealth", mock.Anything, mock.Anything).Return(nil)
+
+	dispatcher := NewDispatcher(mockManager)
+
+	const callers = 5
+	responses := make([]string, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			request := fmt.Sprintf(`{"jsonrpc":"2.0","method":"eth_getBlockByNumber","params":["latest",false],"id":%d}`, i+1)
+			response, err := dispatcher.Forward(context.Background(), 2, []byte(request))
+			assert.NoError(t, err)
+			responses[i] = string(response)
+		}(i)
+	}
+
+	// Give every caller time to join the in-flight call
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int64(1), calls)
+	for i, response := range responses {
+		assert.JSONEq(t, fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"result":{"number":"0x10"}}`, i+1), response)
+	}
+}
+
+func TestRequestCoalescer_CallerCancellation(t *testing.T) {
+	coalescer := newRequestCoalescer()
+	release := make(chan struct{})
+	forward := func(ctx context.Context) ([]byte, error) {
+		<-release
+		return []byte("shared"), ctx.Err()
+	}
+
+	// The first caller gives up, the second still gets the shared result
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, err := coalescer.do(leaderCtx, "key", nil, forward)
+		leaderErr <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	followerResult := make(chan []byte, 1)
+	go func() {
+		response, err := coalescer.do(context.Background(), "key", nil, forward)
+		assert.NoError(t, err)
+		followerResult <- response
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-leaderErr)
+
+	close(release)
+	assert.Equal(t, []byte("shared"), <-followerResult)
+}
+
+func TestDispatcher_Forward_SplitsCoalescedSpendBetweenApps(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte(`{"jsonrpc":"2.0","id":0,"result":"0x1"}`))
+	}))
+	defer server.Close()
+
+	mockManager := new(MockEndpointManager)
+	mockManager.On("GetActiveEndpoints", 2).Return([]models.RpcEndpoint{
+		{ID: 1, ChainID: 2, EndpointURL: server.URL, Priority: 1, PricePerRequest: 0.5},
+	}, nil)
+	mockManager.On("UpdateEndpointHealth", mock.Anything, mock.Anything).Return(nil)
+
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	day := time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC)
+	store := new(MockSpendStore)
+	store.On("AddEndpointSpend", day, mock.MatchedBy(func(spend []EndpointSpend) bool {
+		byApp := make(map[int]EndpointSpend)
+		for _, s := range spend {
+			byApp[s.AppID] = s
+		}
+		return len(spend) == 2 &&
+			byApp[7] == EndpointSpend{EndpointID: 1, AppID: 7, Requests: 1, Cost: 0.25} &&
+			byApp[8] == EndpointSpend{EndpointID: 1, AppID: 8, Requests: 1, Cost: 0.25}
+	})).Return(nil).Once()
+
+	tracker := NewSpendTracker(store)
+	tracker.now = func() time.Time { return now }
+	dispatcher := NewDispatcher(mockManager)
+	dispatcher.SetSpendTracker(tracker)
+
+	var wg sync.WaitGroup
+	for _, appID := range []int{7, 8} {
+		wg.Add(1)
+		go func(appID int) {
+			defer wg.Done()
+			_, err := dispatcher.Forward(WithApp(context.Background(), appID), 2, []byte(`{"jsonrpc":"2.0","method":"eth_chainId","params":[],"id":1}`))
+			assert.NoError(t, err)
+		}(appID)
+		// The second app joins the call the first one started
+		time.Sleep(50 * time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	// The spend is settled once the shared call completes, just after its callers are answered
+	assert.Eventually(t, func() bool {
+		tracker.mu.Lock()
+		defer tracker.mu.Unlock()
+		return len(tracker.pending) == 2
+	}, time.Second, 10*time.Millisecond)
+	assert.NoError(t, tracker.Flush())
+	store.AssertExpectations(t)
+}
+
+func TestRequestCoalescer_SharedCallHasDeadline(t *testing.T) {
+	coalescer := newRequestCoalescer()
+	forward := func(ctx context.Context) ([]byte, error) {
+		deadline, ok := ctx.Deadline()
+		if !ok || time.Until(deadline) > coalescedCallTimeout {
+			return nil, fmt.Errorf("shared call is not bounded")
+		}
+		if appFromContext(ctx) != 0 {
+			return nil, fmt.Errorf("shared call carries the app of its first caller")
+		}
+		return []byte("shared"), nil
+	}
+
+	response, err := coalescer.do(WithApp(context.Background(), 7), "key", nil, forward)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("shared"), response)
+}
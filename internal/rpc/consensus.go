@@ -0,0 +1,176 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/illegalcall/viper-client/internal/models"
+)
+
+const (
+	// DefaultConsensusEndpoints is the number of endpoints asked when consensus is requested without a count
+	DefaultConsensusEndpoints = 3
+
+	// JSONRPCNoConsensus is the JSON-RPC error code returned when endpoints do not reach a quorum
+	JSONRPCNoConsensus = -32050
+)
+
+// consensusContextKey is the context key under which the number of consensus endpoints is stored
+type consensusContextKey struct{}
+
+// WithConsensus returns a context whose reads are sent to the given number of endpoints
+// and answered with the majority result. Fewer than two endpoints disables consensus.
+func WithConsensus(ctx context.Context, endpoints int) context.Context {
+	if endpoints < 2 {
+		return ctx
+	}
+	return context.WithValue(ctx, consensusContextKey{}, endpoints)
+}
+
+// consensusFromContext returns the number of consensus endpoints requested, or 0
+func consensusFromContext(ctx context.Context) int {
+	endpoints, _ := ctx.Value(consensusContextKey{}).(int)
+	return endpoints
+}
+
+// forwardConsensus sends the request to several endpoints at once and returns the answer
+// shared by a majority of them. Endpoints are not narrowed down by geozone because
+// independent providers matter more than locality here.
+func (d *Dispatcher) forwardConsensus(ctx context.Context, chainID int, request RPCRequest, requestBody []byte, requested int) ([]byte, error) {
+	endpoints, err := d.capableEndpoints(chainID, request)
+	if err != nil {
+		return nil, err
+	}
+	if len(endpoints) == 0 {
+		return nil, ErrNoEndpoints
+	}
+
+	quorum := requested/2 + 1
+	if len(endpoints) < quorum {
+		return noConsensusResponse(request, requested, quorum, 0)
+	}
+
+	strategy := d.strategyFor(chainID)
+	remaining := append([]models.RpcEndpoint(nil), endpoints...)
+	selected := make([]models.RpcEndpoint, 0, requested)
+	for len(selected) < requested && len(remaining) > 0 {
+		endpoint := selectEndpoint(strategy, request.Method, remaining)
+		remaining = removeEndpoint(remaining, endpoint.ID)
+		selected = append(selected, endpoint)
+	}
+
+	results := make(chan attemptResult, len(selected))
+	for _, endpoint := range selected {
+		go func(endpoint models.RpcEndpoint) {
+			results <- d.attempt(ctx, endpoint, requestBody)
+		}(endpoint)
+	}
+
+	// Group endpoints by their normalized answer
+	votes := make(map[string][]attemptResult)
+	var order []string
+	for range selected {
+		result := <-results
+		if result.kind != failureNone {
+			log.Printf("Consensus: endpoint %d did not answer %s on chain %d: %v",
+				result.endpoint.ID, request.Method, chainID, result.err)
+			continue
+		}
+		answer := normalizeAnswer(result.body)
+		if _, seen := votes[answer]; !seen {
+			order = append(order, answer)
+		}
+		votes[answer] = append(votes[answer], result)
+	}
+
+	best := ""
+	for _, answer := range order {
+		if len(votes[answer]) > len(votes[best]) {
+			best = answer
+		}
+	}
+
+	for _, answer := range order {
+		if answer == best && len(votes[best]) >= quorum {
+			continue
+		}
+		for _, result := range votes[answer] {
+			log.Printf("Consensus: endpoint %d (%s) disagreed on %s for chain %d",
+				result.endpoint.ID, models.RedactURL(result.endpoint.EndpointURL), request.Method, chainID)
+		}
+	}
+
+	if len(votes[best]) < quorum {
+		return noConsensusResponse(request, requested, quorum, len(votes[best]))
+	}
+	return withResponseID(votes[best][0].body, request.ID), nil
+}
+
+// normalizeAnswer reduces a response to its result, or its error, in a canonical form so that
+// answers differing only in formatting, key order or hex letter case compare equal
+func normalizeAnswer(responseBody []byte) string {
+	var response RPCResponse
+	if err := json.Unmarshal(responseBody, &response); err != nil {
+		return "invalid:" + string(responseBody)
+	}
+	if response.Error != nil {
+		return fmt.Sprintf("error:%d:%s", response.Error.Code, response.Error.Message)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(response.Result))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return "result:" + string(response.Result)
+	}
+
+	normalized, err := json.Marshal(lowercaseHex(value))
+	if err != nil {
+		return "result:" + string(response.Result)
+	}
+	return "result:" + string(normalized)
+}
+
+// lowercaseHex lower-cases every hex string in a decoded JSON value
+func lowercaseHex(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		if strings.HasPrefix(v, "0x") || strings.HasPrefix(v, "0X") {
+			return strings.ToLower(v)
+		}
+		return v
+	case []interface{}:
+		for i := range v {
+			v[i] = lowercaseHex(v[i])
+		}
+		return v
+	case map[string]interface{}:
+		for key := range v {
+			v[key] = lowercaseHex(v[key])
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// noConsensusResponse builds the JSON-RPC error returned when the endpoints do not agree
+func noConsensusResponse(request RPCRequest, requested, quorum, agreeing int) ([]byte, error) {
+	return json.Marshal(RPCResponse{
+		JSONRPC: "2.0",
+		Error: &RPCError{
+			Code:    JSONRPCNoConsensus,
+			Message: "no consensus among upstream endpoints",
+			Data: map[string]int{
+				"endpoints": requested,
+				"quorum":    quorum,
+				"agreeing":  agreeing,
+			},
+		},
+		ID: request.ID,
+	})
+}
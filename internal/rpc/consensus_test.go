@@ -0,0 +1,77 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/illegalcall/viper-client/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// newConsensusDispatcher creates a dispatcher whose endpoints answer with the given results
+func newConsensusDispatcher(t *testing.T, results ...string) *Dispatcher {
+	var endpoints []models.RpcEndpoint
+	for i, result := range results {
+		body := `{"jsonrpc":"2.0","id":1,"result":` + result + `}`
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(body))
+		}))
+		t.Cleanup(server.Close)
+		endpoints = append(endpoints, models.RpcEndpoint{ID: i + 1, ChainID: 2, EndpointURL: server.URL, Priority: 1})
+	}
+
+	mockManager := new(MockEndpointManager)
+	mockManager.On("GetActiveEndpoints", 2).Return(endpoints, nil)
+	mockManager.On("UpdateEndpointHealth", mock.Anything, mock.Anything).Return(nil)
+	return NewDispatcher(mockManager)
+}
+
+func TestDispatcher_Forward_ConsensusMajority(t *testing.T) {
+	dispatcher := newConsensusDispatcher(t, `"0x1A"`, `"0x2"`, `"0x1a"`)
+
+	ctx := WithConsensus(context.Background(), 3)
+	response, err := dispatcher.Forward(ctx, 2, []byte(`{"jsonrpc":"2.0","method":"eth_getBalance","params":["0xabc","latest"],"id":"w1"}`))
+	assert.NoError(t, err)
+
+	var rpcResponse RPCResponse
+	assert.NoError(t, json.Unmarshal(response, &rpcResponse))
+	assert.Nil(t, rpcResponse.Error)
+	assert.Equal(t, "w1", rpcResponse.ID)
+	assert.Contains(t, []string{`"0x1A"`, `"0x1a"`}, string(rpcResponse.Result))
+}
+
+func TestDispatcher_Forward_ConsensusNoQuorum(t *testing.T) {
+	dispatcher := newConsensusDispatcher(t, `"0x1"`, `"0x2"`, `"0x3"`)
+
+	ctx := WithConsensus(context.Background(), 3)
+	response, err := dispatcher.Forward(ctx, 2, []byte(`{"jsonrpc":"2.0","method":"eth_getBalance","params":["0xabc","latest"],"id":1}`))
+	assert.NoError(t, err)
+
+	var rpcResponse RPCResponse
+	assert.NoError(t, json.Unmarshal(response, &rpcResponse))
+	assert.NotNil(t, rpcResponse.Error)
+	assert.Equal(t, JSONRPCNoConsensus, rpcResponse.Error.Code)
+	assert.Equal(t, float64(1), rpcResponse.ID)
+}
+
+func TestDispatcher_Forward_ConsensusNotEnoughEndpoints(t *testing.T) {
+	dispatcher := newConsensusDispatcher(t, `"0x1"`)
+
+	ctx := WithConsensus(context.Background(), 3)
+	response, err := dispatcher.Forward(ctx, 2, []byte(`{"jsonrpc":"2.0","method":"eth_getBalance","params":[],"id":1}`))
+	assert.NoError(t, err)
+	assert.Contains(t, string(response), `"quorum":2`)
+}
+
+func TestNormalizeAnswer(t *testing.T) {
+	a := normalizeAnswer([]byte(`{"jsonrpc":"2.0","id":1,"result":{"b":"0xAB","a":[1, 2]}}`))
+	b := normalizeAnswer([]byte(`{"id":7,"result":{"a":[1,2],"b":"0xab"},"jsonrpc":"2.0"}`))
+	assert.Equal(t, a, b)
+
+	c := normalizeAnswer([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"header not found"}}`))
+	assert.NotEqual(t, a, c)
+}
@@ -0,0 +1,350 @@
+package rpc
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/illegalcall/viper-client/internal/models"
+)
+
+const (
+	// StrategyCostAware picks the cheapest endpoint for the method among those meeting the cost policy
+	StrategyCostAware = "cost_aware"
+
+	// DefaultSpendFlushInterval is how often accumulated upstream spend is written to the store
+	DefaultSpendFlushInterval = time.Minute
+)
+
+// CostPolicy sets the latency and health an endpoint must offer before cost-aware selection
+// considers its price
+type CostPolicy struct {
+	// MaxLatency is the highest average latency of an endpoint eligible on price
+	MaxLatency time.Duration
+	// MaxErrorRate is the highest average error rate of an endpoint eligible on price
+	MaxErrorRate float64
+}
+
+// DefaultCostPolicy returns the cost policy used when none is configured
+func DefaultCostPolicy() CostPolicy {
+	return CostPolicy{
+		MaxLatency:   500 * time.Millisecond,
+		MaxErrorRate: 0.1,
+	}
+}
+
+// MethodSelectionStrategy is a SelectionStrategy whose choice depends on the method being called
+type MethodSelectionStrategy interface {
+	SelectionStrategy
+	// SelectForMethod returns the endpoint that should serve the next call of the method.
+	// The endpoints slice is never empty.
+	SelectForMethod(method string, endpoints []models.RpcEndpoint) models.RpcEndpoint
+}
+
+// selectEndpoint picks an endpoint for a call of the method with the given strategy
+func selectEndpoint(strategy SelectionStrategy, method string, endpoints []models.RpcEndpoint) models.RpcEndpoint {
+	if methodStrategy, ok := strategy.(MethodSelectionStrategy); ok {
+		return methodStrategy.SelectForMethod(method, endpoints)
+	}
+	return strategy.Select(endpoints)
+}
+
+// RequestCost returns what an endpoint bills for one call of the method. An exact method
+// price wins over patterns, longer patterns win over shorter ones, and the flat price per
+// request applies when no method price matches.
+func RequestCost(endpoint models.RpcEndpoint, method string) float64 {
+	if price, ok := endpoint.MethodPrices[method]; ok {
+		return price
+	}
+
+	patterns := make([]string, 0, len(endpoint.MethodPrices))
+	for pattern := range endpoint.MethodPrices {
+		patterns = append(patterns, pattern)
+	}
+	sort.Slice(patterns, func(i, j int) bool {
+		if len(patterns[i]) != len(patterns[j]) {
+			return len(patterns[i]) > len(patterns[j])
+		}
+		return patterns[i] < patterns[j]
+	})
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, method); matched {
+			return endpoint.MethodPrices[pattern]
+		}
+	}
+
+	return endpoint.PricePerRequest
+}
+
+// CostAwareStrategy picks the cheapest endpoint for the method among the endpoints whose
+// latency and error rate meet the cost policy. When none does, it picks the best scoring
+// endpoint like the latency-aware strategy, so that saving money never means serving
+// requests from an endpoint that is too slow or failing.
+type CostAwareStrategy struct {
+	scorer *EndpointScorer
+	policy CostPolicy
+}
+
+// NewCostAwareStrategy creates a new cost-aware strategy backed by the given scorer
+func NewCostAwareStrategy(scorer *EndpointScorer, policy CostPolicy) *CostAwareStrategy {
+	return &CostAwareStrategy{
+		scorer: scorer,
+		policy: policy,
+	}
+}
+
+// Name returns the configuration name of the strategy
+func (s *CostAwareStrategy) Name() string {
+	return StrategyCostAware
+}
+
+// Select returns the endpoint with the cheapest flat price
+func (s *CostAwareStrategy) Select(endpoints []models.RpcEndpoint) models.RpcEndpoint {
+	return s.SelectForMethod("", endpoints)
+}
+
+// SelectForMethod returns the cheapest eligible endpoint for the method, preferring the
+// better score between endpoints of equal price
+func (s *CostAwareStrategy) SelectForMethod(method string, endpoints []models.RpcEndpoint) models.RpcEndpoint {
+	// Occasional exploration keeps the latency of expensive endpoints known, and lets
+	// cheap endpoints that were excluded prove they recovered
+	if len(endpoints) > 1 && rand.Float64() < explorationRate {
+		return endpoints[rand.Intn(len(endpoints))]
+	}
+
+	maxLatency := float64(s.policy.MaxLatency) / float64(time.Millisecond)
+	var eligible []models.RpcEndpoint
+	for _, endpoint := range endpoints {
+		latency, errorRate := s.scorer.observed(endpoint)
+		if s.policy.MaxLatency > 0 && latency > maxLatency {
+			continue
+		}
+		if s.policy.MaxErrorRate > 0 && errorRate > s.policy.MaxErrorRate {
+			continue
+		}
+		eligible = append(eligible, endpoint)
+	}
+	if len(eligible) == 0 {
+		return s.scorer.best(endpoints)
+	}
+
+	best := eligible[0]
+	bestCost := RequestCost(best, method)
+	bestScore := s.scorer.Score(best)
+	for _, endpoint := range eligible[1:] {
+		cost := RequestCost(endpoint, method)
+		score := s.scorer.Score(endpoint)
+		if cost < bestCost || (cost == bestCost && score > bestScore) {
+			best, bestCost, bestScore = endpoint, cost, score
+		}
+	}
+	return best
+}
+
+// SetCostPolicy sets the latency and health constraints of the cost-aware strategy
+func (d *Dispatcher) SetCostPolicy(policy CostPolicy) {
+	d.costMu.Lock()
+	d.costPolicy = policy
+	d.costMu.Unlock()
+
+	// Strategies are created again with the new policy on their next use
+	d.strategyMu.Lock()
+	defer d.strategyMu.Unlock()
+	d.strategies = make(map[int]SelectionStrategy)
+}
+
+// newStrategy creates a selection strategy by its configuration name, applying the cost policy
+func (d *Dispatcher) newStrategy(name string) (SelectionStrategy, error) {
+	strategy, err := NewSelectionStrategy(name, d.load, d.scorer)
+	if costAware, ok := strategy.(*CostAwareStrategy); ok {
+		d.costMu.RLock()
+		costAware.policy = d.costPolicy
+		d.costMu.RUnlock()
+	}
+	return strategy, err
+}
+
+// appContextKey is the context key under which the app a request is served for is stored
+type appContextKey struct{}
+
+// WithApp returns a context carrying the ID of the app the request is served for, so that
+// upstream spend is attributed to it
+func WithApp(ctx context.Context, appID int) context.Context {
+	return context.WithValue(ctx, appContextKey{}, appID)
+}
+
+// appFromContext returns the app ID stored in the context, or 0
+func appFromContext(ctx context.Context) int {
+	appID, _ := ctx.Value(appContextKey{}).(int)
+	return appID
+}
+
+// EndpointSpend is the upstream spend of one endpoint on behalf of one app
+// @Description Requests sent to an RPC endpoint for an app and what the provider bills for them
+type EndpointSpend struct {
+	EndpointID int    `json:"endpoint_id" example:"1"`
+	Provider   string `json:"provider,omitempty" example:"alchemy"`
+	// App the requests were served for, 0 for requests not made on behalf of an app
+	AppID    int     `json:"app_id" example:"7"`
+	AppName  string  `json:"app_name,omitempty" example:"My Wallet"`
+	Requests int64   `json:"requests" example:"120000"`
+	Cost     float64 `json:"cost" example:"14.25"`
+}
+
+// SpendStore accumulates upstream spend per endpoint, app and day
+type SpendStore interface {
+	AddEndpointSpend(day time.Time, spend []EndpointSpend) error
+}
+
+// spendKey identifies the spend of an endpoint for an app on one day
+type spendKey struct {
+	endpointID int
+	appID      int
+	day        time.Time
+}
+
+// SpendTracker accumulates the upstream spend of requests in memory and writes it to the
+// store periodically, so that accounting does not add a database write to every request
+type SpendTracker struct {
+	mu      sync.Mutex
+	store   SpendStore
+	pending map[spendKey]*EndpointSpend
+	now     func() time.Time
+}
+
+// NewSpendTracker creates a spend tracker writing to the given store
+func NewSpendTracker(store SpendStore) *SpendTracker {
+	return &SpendTracker{
+		store:   store,
+		pending: make(map[spendKey]*EndpointSpend),
+		now:     time.Now,
+	}
+}
+
+// Record adds the calls of the given methods sent to an endpoint on behalf of an app
+func (t *SpendTracker) Record(endpoint models.RpcEndpoint, appID int, methods []string) {
+	t.add(endpoint, appID, int64(len(methods)), requestsCost(endpoint, methods))
+}
+
+// RecordShared adds the calls of the given methods sent to an endpoint once on behalf of
+// several apps. Every app is counted the calls, while their cost is split between the apps.
+func (t *SpendTracker) RecordShared(endpoint models.RpcEndpoint, appIDs []int, methods []string) {
+	if len(appIDs) == 0 {
+		return
+	}
+	cost := requestsCost(endpoint, methods) / float64(len(appIDs))
+	for _, appID := range appIDs {
+		t.add(endpoint, appID, int64(len(methods)), cost)
+	}
+}
+
+// requestsCost returns what an endpoint bills for calls of the given methods
+func requestsCost(endpoint models.RpcEndpoint, methods []string) float64 {
+	var cost float64
+	for _, method := range methods {
+		cost += RequestCost(endpoint, method)
+	}
+	return cost
+}
+
+// add accumulates spend of an endpoint for an app on the current day
+func (t *SpendTracker) add(endpoint models.RpcEndpoint, appID int, requests int64, cost float64) {
+	now := t.now().UTC()
+	key := spendKey{
+		endpointID: endpoint.ID,
+		appID:      appID,
+		day:        time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC),
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	spend, ok := t.pending[key]
+	if !ok {
+		spend = &EndpointSpend{EndpointID: endpoint.ID, AppID: appID}
+		t.pending[key] = spend
+	}
+	spend.Requests += requests
+	spend.Cost += cost
+}
+
+// Flush writes the spend recorded since the last flush to the store
+func (t *SpendTracker) Flush() error {
+	t.mu.Lock()
+	pending := t.pending
+	t.pending = make(map[spendKey]*EndpointSpend)
+	t.mu.Unlock()
+
+	days := make(map[time.Time][]EndpointSpend)
+	for key, spend := range pending {
+		days[key.day] = append(days[key.day], *spend)
+	}
+
+	var lastErr error
+	for day, spend := range days {
+		if err := t.store.AddEndpointSpend(day, spend); err != nil {
+			// Keep the spend for the next flush
+			t.mu.Lock()
+			for _, s := range spend {
+				key := spendKey{endpointID: s.EndpointID, appID: s.AppID, day: day}
+				if existing, ok := t.pending[key]; ok {
+					existing.Requests += s.Requests
+					existing.Cost += s.Cost
+				} else {
+					restored := s
+					t.pending[key] = &restored
+				}
+			}
+			t.mu.Unlock()
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// Start flushes the recorded spend on every interval until the context is cancelled,
+// flushing one last time on the way out
+func (t *SpendTracker) Start(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultSpendFlushInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := t.Flush(); err != nil {
+				log.Printf("Failed to flush endpoint spend: %v", err)
+			}
+			return
+		case <-ticker.C:
+			if err := t.Flush(); err != nil {
+				log.Printf("Failed to flush endpoint spend: %v", err)
+			}
+		}
+	}
+}
+
+// SetSpendTracker accumulates the upstream spend of every request sent to an endpoint
+func (d *Dispatcher) SetSpendTracker(tracker *SpendTracker) {
+	d.spend = tracker
+}
+
+// recordSpend accounts the calls in a request body that an endpoint answered
+func (d *Dispatcher) recordSpend(ctx context.Context, endpoint models.RpcEndpoint, requestBody []byte) {
+	if d.spend == nil {
+		return
+	}
+	methods := d.RequestMethods(endpoint.ChainID, requestBody)
+	// A coalesced call is paid for by every caller sharing it
+	if shared, ok := ctx.Value(sharedSpendContextKey{}).(*sharedSpend); ok {
+		shared.add(endpoint, methods)
+		return
+	}
+	d.spend.Record(endpoint, appFromContext(ctx), methods)
+}
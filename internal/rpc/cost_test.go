@@ -0,0 +1,136 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/illegalcall/viper-client/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// MockSpendStore is a mock implementation of SpendStore
+type MockSpendStore struct {
+	mock.Mock
+}
+
+func (m *MockSpendStore) AddEndpointSpend(day time.Time, spend []EndpointSpend) error {
+	args := m.Called(day, spend)
+	return args.Error(0)
+}
+
+func TestRequestCost(t *testing.T) {
+	endpoint := models.RpcEndpoint{
+		PricePerRequest: 0.001,
+		MethodPrices: map[string]float64{
+			"debug_*":                0.01,
+			"debug_traceTransaction": 0.05,
+			"debug_traceBlock*":      0.02,
+			"eth_getBlockReceipts":   0.004,
+			"trace_*":                0.03,
+		},
+	}
+
+	assert.Equal(t, 0.05, RequestCost(endpoint, "debug_traceTransaction"))
+	assert.Equal(t, 0.02, RequestCost(endpoint, "debug_traceBlockByHash"))
+	assert.Equal(t, 0.01, RequestCost(endpoint, "debug_getRawBlock"))
+	assert.Equal(t, 0.004, RequestCost(endpoint, "eth_getBlockReceipts"))
+	assert.Equal(t, 0.001, RequestCost(endpoint, "eth_call"))
+}
+
+func TestCostAwareStrategy_PrefersCheapestEligibleEndpoint(t *testing.T) {
+	scorer := NewEndpointScorer(DefaultEWMAAlpha)
+	cheap := models.RpcEndpoint{ID: 1, Priority: 1, PricePerRequest: 0.001, MethodPrices: map[string]float64{"trace_*": 0.05}}
+	pricey := models.RpcEndpoint{ID: 2, Priority: 1, PricePerRequest: 0.002, MethodPrices: map[string]float64{"trace_*": 0.01}}
+	slow := models.RpcEndpoint{ID: 3, Priority: 1}
+
+	for i := 0; i < 5; i++ {
+		scorer.Observe(cheap, 80*time.Millisecond, true)
+		scorer.Observe(pricey, 20*time.Millisecond, true)
+		scorer.Observe(slow, 900*time.Millisecond, true)
+	}
+
+	strategy := NewCostAwareStrategy(scorer, DefaultCostPolicy())
+	endpoints := []models.RpcEndpoint{slow, pricey, cheap}
+	counts := make(map[int]int)
+	traceCounts := make(map[int]int)
+	for i := 0; i < 200; i++ {
+		counts[strategy.SelectForMethod("eth_call", endpoints).ID]++
+		traceCounts[strategy.SelectForMethod("trace_block", endpoints).ID]++
+	}
+
+	// The free endpoint is too slow to be picked on price
+	assert.Greater(t, counts[1], 150)
+	assert.Greater(t, traceCounts[2], 150)
+}
+
+func TestCostAwareStrategy_FallsBackToScoreWhenNoneEligible(t *testing.T) {
+	scorer := NewEndpointScorer(DefaultEWMAAlpha)
+	cheap := models.RpcEndpoint{ID: 1, Priority: 1, PricePerRequest: 0.001}
+	pricey := models.RpcEndpoint{ID: 2, Priority: 1, PricePerRequest: 0.002}
+
+	for i := 0; i < 5; i++ {
+		scorer.Observe(cheap, 2*time.Second, true)
+		scorer.Observe(pricey, time.Second, true)
+	}
+
+	strategy := NewCostAwareStrategy(scorer, DefaultCostPolicy())
+	counts := make(map[int]int)
+	for i := 0; i < 200; i++ {
+		counts[strategy.Select([]models.RpcEndpoint{cheap, pricey}).ID]++
+	}
+	assert.Greater(t, counts[2], 150)
+}
+
+func TestDispatcher_Forward_RecordsSpendPerApp(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"jsonrpc":"2.0","id":1,"result":"0x1"},{"jsonrpc":"2.0","id":2,"result":[]}]`))
+	}))
+	defer server.Close()
+
+	mockManager := new(MockEndpointManager)
+	mockManager.On("GetActiveEndpoints", 2).Return([]models.RpcEndpoint{
+		{ID: 1, ChainID: 2, EndpointURL: server.URL, Priority: 1, PricePerRequest: 0.25, MethodPrices: map[string]float64{"eth_getLogs": 1.5}},
+	}, nil)
+	mockManager.On("UpdateEndpointHealth", 1, "healthy").Return(nil)
+
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	store := new(MockSpendStore)
+	store.On("AddEndpointSpend", time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC), []EndpointSpend{
+		{EndpointID: 1, AppID: 7, Requests: 2, Cost: 1.75},
+	}).Return(nil)
+
+	tracker := NewSpendTracker(store)
+	tracker.now = func() time.Time { return now }
+	dispatcher := NewDispatcher(mockManager)
+	dispatcher.SetSpendTracker(tracker)
+
+	request := []byte(`[{"jsonrpc":"2.0","method":"eth_blockNumber","params":[],"id":1},{"jsonrpc":"2.0","method":"eth_getLogs","params":[{}],"id":2}]`)
+	_, err := dispatcher.Forward(WithApp(context.Background(), 7), 2, request)
+	assert.NoError(t, err)
+
+	assert.NoError(t, tracker.Flush())
+	store.AssertExpectations(t)
+}
+
+func TestSpendTracker_KeepsSpendWhenFlushFails(t *testing.T) {
+	day := time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC)
+	store := new(MockSpendStore)
+	store.On("AddEndpointSpend", day, []EndpointSpend{{EndpointID: 1, Requests: 1, Cost: 0.5}}).Return(errors.New("connection refused")).Once()
+	store.On("AddEndpointSpend", day, []EndpointSpend{{EndpointID: 1, Requests: 2, Cost: 1}}).Return(nil).Once()
+
+	tracker := NewSpendTracker(store)
+	tracker.now = func() time.Time { return day.Add(time.Hour) }
+	endpoint := models.RpcEndpoint{ID: 1, PricePerRequest: 0.5}
+
+	tracker.Record(endpoint, 0, []string{"eth_call"})
+	assert.Error(t, tracker.Flush())
+
+	tracker.Record(endpoint, 0, []string{"eth_call"})
+	assert.NoError(t, tracker.Flush())
+	store.AssertExpectations(t)
+}
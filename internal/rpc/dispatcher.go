@@ -6,8 +6,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/illegalcall/viper-client/internal/models"
@@ -49,11 +49,64 @@ type RPCError struct {
 	Data    interface{} `json:"data,omitempty"`
 }
 
+// JSONRPCMethodNotAllowed is the JSON-RPC error code returned for methods the app may not call
+const JSONRPCMethodNotAllowed = -32051
+
+// MethodNotAllowedError builds the error answering a call to a method the app may not call
+func MethodNotAllowedError(method string) *RPCError {
+	return &RPCError{
+		Code:    JSONRPCMethodNotAllowed,
+		Message: "method not allowed for this app: " + method,
+	}
+}
+
 // Dispatcher handles forwarding RPC requests to blockchain nodes
 type Dispatcher struct {
 	endpointManager     EndpointManager
 	httpClient          *http.Client
 	viperNetworkHandler *ViperNetworkHandler
+
+	load            *loadTracker
+	scorer          *EndpointScorer
+	strategyMu      sync.RWMutex
+	defaultStrategy string
+	chainStrategies map[int]string
+	strategies      map[int]SelectionStrategy
+
+	retryMu     sync.RWMutex
+	retryPolicy RetryPolicy
+
+	hedging *hedgeManager
+
+	geozones *GeozoneRouter
+
+	maxBatchSize   int
+	batchChunkSize int
+
+	cache     *ResponseCache
+	coalescer *requestCoalescer
+
+	syncTracker  *SyncTracker
+	capabilities *CapabilityRouter
+
+	broadcastMu     sync.RWMutex
+	broadcastChains map[int]bool
+	broadcastStore  BroadcastStore
+
+	adapters *AdapterRegistry
+
+	maxResponseSize int64
+	streamMethods   []string
+
+	limits   *EndpointLimits
+	breakers *CircuitBreakers
+
+	costMu     sync.RWMutex
+	costPolicy CostPolicy
+	spend      *SpendTracker
+
+	viperChains      *ViperChainRegistry
+	viperTranslators *ViperTranslators
 }
 
 // EndpointManager defines the interface for retrieving and managing RPC endpoints
@@ -72,68 +125,403 @@ func NewDispatcher(manager EndpointManager) *Dispatcher {
 			Timeout: 10 * time.Second,
 		},
 		viperNetworkHandler: viperHandler,
+		load:                newLoadTracker(),
+		scorer:              NewEndpointScorer(DefaultEWMAAlpha),
+		defaultStrategy:     DefaultSelectionStrategy,
+		chainStrategies:     make(map[int]string),
+		strategies:          make(map[int]SelectionStrategy),
+		retryPolicy:         DefaultRetryPolicy(),
+		hedging:             newHedgeManager(),
+		geozones:            NewGeozoneRouter(DefaultGeozone, nil),
+		maxBatchSize:        DefaultMaxBatchSize,
+		batchChunkSize:      DefaultBatchChunkSize,
+		coalescer:           newRequestCoalescer(),
+		broadcastChains:     make(map[int]bool),
+		adapters:            NewAdapterRegistry(nil),
+		maxResponseSize:     DefaultMaxResponseSize,
+		streamMethods:       DefaultStreamMethods,
+		limits:              NewEndpointLimits(nil),
+		costPolicy:          DefaultCostPolicy(),
+		viperChains:         NewViperChainRegistry(nil),
+		viperTranslators:    NewViperTranslators(),
+	}
+}
+
+// SetGeozoneRouter sets how endpoints are narrowed down by geozone, for both
+// regular chains and the Viper Network
+func (d *Dispatcher) SetGeozoneRouter(router *GeozoneRouter) {
+	d.geozones = router
+	d.viperNetworkHandler.SetGeozoneRouter(router)
+}
+
+// SetRetryPolicy sets how many endpoints Forward may try for a single request
+func (d *Dispatcher) SetRetryPolicy(policy RetryPolicy) {
+	d.retryMu.Lock()
+	defer d.retryMu.Unlock()
+
+	d.retryPolicy = policy
+}
+
+// SetDefaultStrategy sets the selection strategy used by chains without an explicit strategy
+func (d *Dispatcher) SetDefaultStrategy(name string) error {
+	if _, err := d.newStrategy(name); err != nil {
+		return err
+	}
+
+	d.strategyMu.Lock()
+	defer d.strategyMu.Unlock()
+
+	d.defaultStrategy = name
+	for chainID := range d.strategies {
+		if _, configured := d.chainStrategies[chainID]; !configured {
+			delete(d.strategies, chainID)
+		}
+	}
+	return nil
+}
+
+// SetChainStrategy sets the selection strategy used for a specific chain
+func (d *Dispatcher) SetChainStrategy(chainID int, name string) error {
+	strategy, err := d.newStrategy(name)
+	if err != nil {
+		return err
+	}
+
+	d.strategyMu.Lock()
+	defer d.strategyMu.Unlock()
+
+	d.chainStrategies[chainID] = name
+	d.strategies[chainID] = strategy
+	return nil
+}
+
+// EndpointScores returns the latency-aware scores of every endpoint that has served traffic
+func (d *Dispatcher) EndpointScores() []EndpointScore {
+	return d.scorer.Scores()
+}
+
+// strategyFor returns the selection strategy for a chain, creating it on first use
+func (d *Dispatcher) strategyFor(chainID int) SelectionStrategy {
+	d.strategyMu.RLock()
+	strategy, ok := d.strategies[chainID]
+	d.strategyMu.RUnlock()
+	if ok {
+		return strategy
+	}
+
+	d.strategyMu.Lock()
+	defer d.strategyMu.Unlock()
+
+	if strategy, ok = d.strategies[chainID]; ok {
+		return strategy
+	}
+	name, ok := d.chainStrategies[chainID]
+	if !ok {
+		name = d.defaultStrategy
 	}
+	strategy, err := d.newStrategy(name)
+	if err != nil {
+		strategy = NewWeightedRoundRobinStrategy()
+	}
+	d.strategies[chainID] = strategy
+	return strategy
 }
 
 // Forward forwards an RPC request to an available endpoint for the given chain
 func (d *Dispatcher) Forward(ctx context.Context, chainID int, requestBody []byte) ([]byte, error) {
+	adapter := d.adapters.ForChain(chainID)
+
+	// Batches are split into their elements and reassembled in request order
+	if IsBatchRequest(requestBody) {
+		if !adapter.JSONRPC() {
+			return nil, ErrBatchNotSupported
+		}
+		return d.forwardBatch(ctx, chainID, requestBody)
+	}
+
 	// Check if this is a request for the Viper Network
 	if chainID == ViperNetworkChainID {
 		return d.ForwardToViperNetwork(ctx, requestBody)
 	}
 
 	// Parse the incoming request to validate and potentially use for caching
-	var rpcRequest RPCRequest
-	if err := json.Unmarshal(requestBody, &rpcRequest); err != nil {
-		return nil, errors.New("invalid JSON-RPC request format")
+	rpcRequest, err := adapter.ParseRequest(requestBody)
+	if err != nil {
+		return nil, err
 	}
 
-	// Get available endpoints for the chain
-	endpoints, err := d.endpointManager.GetActiveEndpoints(chainID)
+	// Responses outside JSON-RPC envelopes cannot be cached, shared or compared
+	if !adapter.JSONRPC() {
+		return d.forwardRequest(ctx, chainID, rpcRequest, requestBody)
+	}
+
+	return d.forwardCall(ctx, chainID, rpcRequest, requestBody)
+}
+
+// forwardCall sends a single JSON-RPC call upstream along the path its method takes:
+// broadcast, consensus, the response cache or the coalescer
+func (d *Dispatcher) forwardCall(ctx context.Context, chainID int, rpcRequest RPCRequest, requestBody []byte) ([]byte, error) {
+	// Signed transactions go to every endpoint so one bad mempool cannot drop them
+	if rpcRequest.Method == "eth_sendRawTransaction" && d.broadcastEnabled(chainID) {
+		return d.forwardBroadcast(ctx, chainID, rpcRequest, requestBody)
+	}
+
+	// Consensus reads ask several endpoints and never trust a single cached answer
+	if endpoints := consensusFromContext(ctx); endpoints > 1 && d.idempotent(chainID, rpcRequest.Method) {
+		return d.forwardConsensus(ctx, chainID, rpcRequest, requestBody, endpoints)
+	}
+
+	// Deterministic calls may be answered without going upstream
+	if d.cache != nil {
+		if response, ok := d.cache.lookup(ctx, chainID, rpcRequest); ok {
+			return response, nil
+		}
+	}
+
+	forward := func(ctx context.Context) ([]byte, error) {
+		response, err := d.forwardRequest(ctx, chainID, rpcRequest, requestBody)
+		if err == nil && d.cache != nil {
+			// Finality is judged against the head the sync tracker has seen, not only eth_blockNumber traffic
+			if d.syncTracker != nil {
+				if head := d.syncTracker.Head(chainID); head > 0 {
+					d.cache.observeHead(chainID, uint64(head))
+				}
+			}
+			d.cache.store(chainID, rpcRequest, response)
+		}
+		return response, err
+	}
+
+	// Identical reads already in flight share one upstream call
+	if d.coalescer == nil || !d.idempotent(chainID, rpcRequest.Method) {
+		return forward(ctx)
+	}
+	response, err := d.coalescer.do(ctx, coalesceKey(ctx, chainID, rpcRequest), d.spend, forward)
 	if err != nil {
 		return nil, err
 	}
+	return withResponseID(response, rpcRequest.ID), nil
+}
 
-	if len(endpoints) == 0 {
-		return nil, ErrNoEndpoints
+// forwardRequest sends a single parsed request upstream, hedging or failing over as configured
+func (d *Dispatcher) forwardRequest(ctx context.Context, chainID int, rpcRequest RPCRequest, requestBody []byte) ([]byte, error) {
+	// Get available endpoints for the chain in the request's geozone
+	endpoints, err := d.candidateEndpoints(ctx, chainID, rpcRequest)
+	if err != nil {
+		return nil, err
 	}
 
-	// Sort endpoints by priority (assuming they are already sorted from the database)
-	// For now, just use the first endpoint
-	selectedEndpoint := endpoints[0]
+	// Latency-sensitive reads may race a second endpoint when hedging is enabled
+	idempotent := d.idempotent(chainID, rpcRequest.Method)
+	if len(endpoints) > 1 && idempotent {
+		if delay, ok := d.hedging.delayFor(chainID, rpcRequest.Method); ok {
+			return d.forwardHedged(ctx, chainID, rpcRequest.Method, endpoints, requestBody, delay)
+		}
+	}
+
+	return d.forwardWithFailover(ctx, chainID, rpcRequest.Method, idempotent, endpoints, requestBody)
+}
 
-	// Forward the request to the selected endpoint
-	req, err := http.NewRequestWithContext(ctx, "POST", selectedEndpoint.EndpointURL, bytes.NewReader(requestBody))
+// candidateEndpoints returns the active endpoints able to serve every one of the requests,
+// narrowed down to the request's geozone
+func (d *Dispatcher) candidateEndpoints(ctx context.Context, chainID int, requests ...RPCRequest) ([]models.RpcEndpoint, error) {
+	// Capabilities are checked before the geozone so that another zone can serve
+	// calls the preferred zone has no suitable node for
+	endpoints, err := d.capableEndpoints(chainID, requests...)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/json")
+	endpoints = d.geozones.Route(ctx, endpoints)
 
-	resp, err := d.httpClient.Do(req)
+	if len(endpoints) == 0 {
+		return nil, ErrNoEndpoints
+	}
+	return endpoints, nil
+}
+
+// capableEndpoints returns the active endpoints of every geozone able to serve the requests
+func (d *Dispatcher) capableEndpoints(chainID int, requests ...RPCRequest) ([]models.RpcEndpoint, error) {
+	endpoints, err := d.endpointManager.GetActiveEndpoints(chainID)
 	if err != nil {
-		// Update endpoint health status
-		d.endpointManager.UpdateEndpointHealth(selectedEndpoint.ID, "error")
 		return nil, err
 	}
+	// Endpoints at their provider limits are skipped rather than tried and throttled
+	if endpoints, err = d.limits.Filter(endpoints); err != nil {
+		return nil, err
+	}
+	if d.capabilities == nil {
+		return endpoints, nil
+	}
+	return d.capabilities.Filter(chainID, requests, endpoints)
+}
+
+// forwardWithFailover sends the request to endpoints chosen by the chain's selection strategy,
+// moving on to the next endpoint on retryable failures until the retry budget or the
+// request context runs out. Requests that are not idempotent are only retried when the
+// failed endpoint cannot have processed them.
+func (d *Dispatcher) forwardWithFailover(ctx context.Context, chainID int, method string, idempotent bool, endpoints []models.RpcEndpoint, requestBody []byte) ([]byte, error) {
+	d.retryMu.RLock()
+	maxAttempts := d.retryPolicy.MaxAttempts
+	d.retryMu.RUnlock()
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	strategy := d.strategyFor(chainID)
+	remaining := append([]models.RpcEndpoint(nil), endpoints...)
+
+	var lastErr error
+	var lastBody []byte
+	for attempt := 0; attempt < maxAttempts && len(remaining) > 0; attempt++ {
+		if err := ctx.Err(); err != nil {
+			if lastErr == nil {
+				lastErr = err
+			}
+			break
+		}
+
+		selectedEndpoint := selectEndpoint(strategy, method, remaining)
+		remaining = removeEndpoint(remaining, selectedEndpoint.ID)
+
+		result := d.attempt(ctx, selectedEndpoint, requestBody)
+		if result.kind == failureNone {
+			return result.body, nil
+		}
+
+		lastErr, lastBody = result.err, result.body
+		if !shouldRetry(result.kind, idempotent) {
+			if result.body == nil {
+				return nil, result.err
+			}
+			break
+		}
+
+		// With nowhere else to go, a short Retry-After is waited out on the same endpoint
+		if result.kind.throttled() && len(remaining) == 0 && d.limits.wait(ctx, selectedEndpoint.ID) {
+			remaining = append(remaining, selectedEndpoint)
+		}
+	}
+
+	// Prefer handing the client the upstream's own answer over a generic error
+	if len(lastBody) > 0 {
+		return lastBody, nil
+	}
+	return nil, lastErr
+}
+
+// attemptResult is the outcome of sending a request to a single endpoint
+type attemptResult struct {
+	endpoint models.RpcEndpoint
+	body     []byte
+	err      error
+	kind     failureKind
+}
+
+// attempt sends the request to one endpoint, classifies the outcome and feeds it into
+// the endpoint scores and health status. Attempts abandoned through context cancellation
+// are not counted against the endpoint.
+func (d *Dispatcher) attempt(ctx context.Context, endpoint models.RpcEndpoint, requestBody []byte) attemptResult {
+	// A recovering endpoint only takes a few trial requests at a time
+	release, ok := d.breakers.AcquireTrial(endpoint.ID)
+	if !ok {
+		return attemptResult{endpoint: endpoint, err: errBreakerTrialsBusy, kind: failureNotSent}
+	}
+	defer release()
+
+	// The endpoint may have reached its limits since it was selected
+	if !d.limits.Acquire(endpoint) {
+		return attemptResult{endpoint: endpoint, err: errEndpointLimited, kind: failureNotSent}
+	}
+
+	start := time.Now()
+	statusCode, header, responseBody, err := d.sendToEndpoint(ctx, endpoint, requestBody)
+	latency := time.Since(start)
+
+	// Every endpoint would send the same oversized answer, and it is not the endpoint's fault
+	if errors.Is(err, ErrResponseTooLarge) {
+		return attemptResult{endpoint: endpoint, err: err, kind: failureFatal}
+	}
+	if err != nil {
+		kind := classifyTransportError(ctx, err)
+		if ctx.Err() == nil {
+			d.scorer.Observe(endpoint, latency, false)
+			d.endpointManager.UpdateEndpointHealth(endpoint.ID, "error")
+		}
+		return attemptResult{endpoint: endpoint, err: err, kind: kind}
+	}
+
+	kind := classifyResponse(statusCode, responseBody)
+	if !kind.throttled() {
+		d.recordSpend(ctx, endpoint, requestBody)
+	}
+	d.scorer.Observe(endpoint, latency, kind == failureNone)
+	if kind == failureNone {
+		d.hedging.observe(endpoint.ChainID, latency)
+		d.observeHeight(endpoint, requestBody, responseBody)
+		d.endpointManager.UpdateEndpointHealth(endpoint.ID, "healthy")
+		return attemptResult{endpoint: endpoint, body: responseBody, kind: kind}
+	}
+
+	if kind.throttled() {
+		d.throttled(endpoint, header)
+	} else {
+		d.endpointManager.UpdateEndpointHealth(endpoint.ID, "error")
+	}
+	return attemptResult{
+		endpoint: endpoint,
+		body:     upstreamAnswer(responseBody),
+		err:      fmt.Errorf("endpoint %d returned status %d", endpoint.ID, statusCode),
+		kind:     kind,
+	}
+}
+
+// sendToEndpoint sends the request to a single endpoint in the chain's protocol and returns the raw response
+func (d *Dispatcher) sendToEndpoint(ctx context.Context, endpoint models.RpcEndpoint, requestBody []byte) (int, http.Header, []byte, error) {
+	d.load.Acquire(endpoint.ID)
+	defer d.load.Release(endpoint.ID)
+
+	adapter := d.adapters.ForChain(endpoint.ChainID)
+	req, err := adapter.NewUpstreamRequest(ctx, endpoint.EndpointURL, requestBody)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	resp, err := authenticatedClient(d.httpClient, endpoint).Do(req)
+	if err != nil {
+		return 0, nil, nil, redactError(err)
+	}
 	defer resp.Body.Close()
 
-	// Read the response
-	responseBody, err := io.ReadAll(resp.Body)
+	responseBody, err := readResponse(resp.Body, d.maxResponseSize)
 	if err != nil {
-		return nil, err
+		return resp.StatusCode, resp.Header, nil, err
 	}
 
-	// Update endpoint health status to healthy
-	d.endpointManager.UpdateEndpointHealth(selectedEndpoint.ID, "healthy")
+	return resp.StatusCode, resp.Header, responseBody, nil
+}
 
-	return responseBody, nil
+// removeEndpoint returns the endpoints without the one with the given ID
+func removeEndpoint(endpoints []models.RpcEndpoint, id int) []models.RpcEndpoint {
+	result := endpoints[:0]
+	for _, endpoint := range endpoints {
+		if endpoint.ID != id {
+			result = append(result, endpoint)
+		}
+	}
+	return result
 }
 
 // ForwardToViperNetwork handles forwarding requests to the Viper Network,
 // translating between JSON-RPC and Viper Network formats
 func (d *Dispatcher) ForwardToViperNetwork(ctx context.Context, requestBody []byte) ([]byte, error) {
+	// The chain the relay targets comes from the request path or the app's configuration
+	blockchain, err := d.viperChains.Resolve(viperChainFromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
 	// Convert from JSON-RPC format to Viper Network format
-	requestType, viperRequest, err := ConvertJSONRPCToViperFormat(requestBody)
+	requestType, viperRequest, err := d.viperTranslators.TranslateRequest(requestBody, blockchain)
 	if err != nil {
 		return nil, err
 	}
@@ -145,7 +533,7 @@ func (d *Dispatcher) ForwardToViperNetwork(ctx context.Context, requestBody []by
 	}
 
 	// Convert the response back to JSON-RPC format
-	jsonRPCResponse, err := ConvertViperResponseToJSONRPC(viperResponse, requestBody)
+	jsonRPCResponse, err := d.viperTranslators.TranslateResponse(requestBody, blockchain, viperResponse)
 	if err != nil {
 		return nil, err
 	}
@@ -231,21 +619,15 @@ func (d *RPCDispatcher) tryEndpoint(endpoint models.RpcEndpoint, request *RPCReq
 	req.Header.Set("Content-Type", "application/json")
 
 	// Send request
-	resp, err := d.httpClient.Do(req)
+	resp, err := authenticatedClient(d.httpClient, endpoint).Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+		return nil, fmt.Errorf("request failed: %w", redactError(err))
 	}
 	defer resp.Body.Close()
 
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
-	}
-
-	// Parse response
+	// Parse response straight from the body
 	var rpcResp RPCResponse
-	if err := json.Unmarshal(body, &rpcResp); err != nil {
+	if err := json.NewDecoder(limitResponse(resp.Body, DefaultMaxResponseSize)).Decode(&rpcResp); err != nil {
 		return nil, fmt.Errorf("failed to parse response: %w", err)
 	}
 
@@ -0,0 +1,269 @@
+package rpc
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/illegalcall/viper-client/internal/models"
+	"github.com/lib/pq"
+)
+
+const (
+	// EndpointChangesChannel is the Postgres channel notified with the chain ID whenever the
+	// endpoints of a chain change
+	EndpointChangesChannel = "rpc_endpoints_changed"
+
+	// DefaultEndpointCacheTTL is how long cached endpoints are served without a notification,
+	// bounding how stale the cache gets if notifications are lost
+	DefaultEndpointCacheTTL = 5 * time.Minute
+
+	// DefaultHealthFlushInterval is how often pending health updates are written to the store
+	DefaultHealthFlushInterval = time.Second
+)
+
+// EndpointHealthUpdate is the latest health status reported for an endpoint
+type EndpointHealthUpdate struct {
+	EndpointID int
+	Status     string
+	CheckedAt  time.Time
+}
+
+// HealthBatchStore writes the health of several endpoints at once
+type HealthBatchStore interface {
+	UpdateEndpointHealthBatch(updates []EndpointHealthUpdate) error
+}
+
+// cachedEndpoints is the active endpoints of a chain as loaded from the store
+type cachedEndpoints struct {
+	endpoints []models.RpcEndpoint
+	loadedAt  time.Time
+}
+
+// CachedEndpointManager keeps the active endpoints of every chain in memory and writes health
+// updates back in batches, so that relaying a request does not query the store. The cache of
+// a chain is dropped when the store notifies a change, or at the latest when its TTL expires.
+type CachedEndpointManager struct {
+	manager EndpointManager
+	ttl     time.Duration
+	now     func() time.Time
+
+	mu     sync.RWMutex
+	chains map[int]*cachedEndpoints
+	// generation changes on every invalidation, so that a load racing one is not cached
+	generation uint64
+
+	healthMu sync.Mutex
+	pending  map[int]EndpointHealthUpdate
+}
+
+// NewCachedEndpointManager creates a cache in front of the given manager
+func NewCachedEndpointManager(manager EndpointManager) *CachedEndpointManager {
+	return &CachedEndpointManager{
+		manager: manager,
+		ttl:     DefaultEndpointCacheTTL,
+		now:     time.Now,
+		chains:  make(map[int]*cachedEndpoints),
+		pending: make(map[int]EndpointHealthUpdate),
+	}
+}
+
+// SetTTL sets how long cached endpoints are served without a notification
+func (c *CachedEndpointManager) SetTTL(ttl time.Duration) {
+	if ttl > 0 {
+		c.ttl = ttl
+	}
+}
+
+// GetActiveEndpoints returns the active endpoints for a chain from the cache, loading them
+// from the store when they are not cached
+func (c *CachedEndpointManager) GetActiveEndpoints(chainID int) ([]models.RpcEndpoint, error) {
+	c.mu.RLock()
+	cached, ok := c.chains[chainID]
+	generation := c.generation
+	c.mu.RUnlock()
+
+	if ok && c.now().Sub(cached.loadedAt) < c.ttl {
+		return append([]models.RpcEndpoint(nil), cached.endpoints...), nil
+	}
+
+	endpoints, err := c.manager.GetActiveEndpoints(chainID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Health not written yet is newer than what the store returned
+	c.healthMu.Lock()
+	for i := range endpoints {
+		if update, ok := c.pending[endpoints[i].ID]; ok {
+			applyHealth(&endpoints[i], update)
+		}
+	}
+	c.healthMu.Unlock()
+
+	c.mu.Lock()
+	if c.generation == generation {
+		c.chains[chainID] = &cachedEndpoints{
+			endpoints: endpoints,
+			loadedAt:  c.now(),
+		}
+	}
+	c.mu.Unlock()
+
+	return append([]models.RpcEndpoint(nil), endpoints...), nil
+}
+
+// UpdateEndpointHealth updates the cached health of an endpoint and queues it to be written
+// to the store with the next batch
+func (c *CachedEndpointManager) UpdateEndpointHealth(id int, status string) error {
+	update := EndpointHealthUpdate{
+		EndpointID: id,
+		Status:     status,
+		CheckedAt:  c.now(),
+	}
+
+	c.healthMu.Lock()
+	c.pending[id] = update
+	c.healthMu.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, cached := range c.chains {
+		for i := range cached.endpoints {
+			if cached.endpoints[i].ID == id {
+				// Cached slices are handed out, so the entry is copied before it changes
+				endpoints := append([]models.RpcEndpoint(nil), cached.endpoints...)
+				applyHealth(&endpoints[i], update)
+				cached.endpoints = endpoints
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// applyHealth sets the health status of an endpoint
+func applyHealth(endpoint *models.RpcEndpoint, update EndpointHealthUpdate) {
+	checkedAt := update.CheckedAt
+	endpoint.HealthStatus = update.Status
+	endpoint.HealthCheckTimestamp = &checkedAt
+}
+
+// Invalidate drops the cached endpoints of a chain
+func (c *CachedEndpointManager) Invalidate(chainID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.chains, chainID)
+	c.generation++
+}
+
+// InvalidateAll drops the cached endpoints of every chain
+func (c *CachedEndpointManager) InvalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.chains = make(map[int]*cachedEndpoints)
+	c.generation++
+}
+
+// Flush writes the health updates queued since the last flush to the store, in one batch
+// when the store supports it
+func (c *CachedEndpointManager) Flush() error {
+	c.healthMu.Lock()
+	pending := c.pending
+	c.pending = make(map[int]EndpointHealthUpdate)
+	c.healthMu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	updates := make([]EndpointHealthUpdate, 0, len(pending))
+	for _, update := range pending {
+		updates = append(updates, update)
+	}
+	sort.Slice(updates, func(i, j int) bool {
+		return updates[i].EndpointID < updates[j].EndpointID
+	})
+
+	var failed []EndpointHealthUpdate
+	var lastErr error
+	if store, ok := c.manager.(HealthBatchStore); ok {
+		if err := store.UpdateEndpointHealthBatch(updates); err != nil {
+			failed, lastErr = updates, err
+		}
+	} else {
+		for _, update := range updates {
+			if err := c.manager.UpdateEndpointHealth(update.EndpointID, update.Status); err != nil {
+				failed, lastErr = append(failed, update), err
+			}
+		}
+	}
+
+	// Keep what could not be written for the next flush, unless a newer status came in
+	if len(failed) > 0 {
+		c.healthMu.Lock()
+		for _, update := range failed {
+			if _, ok := c.pending[update.EndpointID]; !ok {
+				c.pending[update.EndpointID] = update
+			}
+		}
+		c.healthMu.Unlock()
+	}
+	return lastErr
+}
+
+// Start flushes health updates on every interval until the context is cancelled, flushing
+// one last time on the way out
+func (c *CachedEndpointManager) Start(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultHealthFlushInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := c.Flush(); err != nil {
+				log.Printf("Failed to flush endpoint health: %v", err)
+			}
+			return
+		case <-ticker.C:
+			if err := c.Flush(); err != nil {
+				log.Printf("Failed to flush endpoint health: %v", err)
+			}
+		}
+	}
+}
+
+// Watch invalidates the cache on every notification of EndpointChangesChannel until the
+// context is cancelled. A nil notification, sent when the listener reconnects, drops every
+// chain, as changes may have been missed in the meantime.
+func (c *CachedEndpointManager) Watch(ctx context.Context, notifications <-chan *pq.Notification) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case notification, ok := <-notifications:
+			if !ok {
+				return
+			}
+			if notification == nil {
+				c.InvalidateAll()
+				continue
+			}
+			chainID, err := strconv.Atoi(notification.Extra)
+			if err != nil {
+				c.InvalidateAll()
+				continue
+			}
+			c.Invalidate(chainID)
+		}
+	}
+}
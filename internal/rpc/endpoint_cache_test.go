@@ -0,0 +1,132 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/illegalcall/viper-client/internal/models"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// MockBatchEndpointManager is a mock EndpointManager that also writes health in batches
+type MockBatchEndpointManager struct {
+	MockEndpointManager
+}
+
+func (m *MockBatchEndpointManager) UpdateEndpointHealthBatch(updates []EndpointHealthUpdate) error {
+	args := m.Called(updates)
+	return args.Error(0)
+}
+
+func TestCachedEndpointManager_ServesFromCache(t *testing.T) {
+	mockManager := new(MockEndpointManager)
+	mockManager.On("GetActiveEndpoints", 1).Return([]models.RpcEndpoint{
+		{ID: 1, ChainID: 1, EndpointURL: "https://a.example"},
+	}, nil).Once()
+
+	cache := NewCachedEndpointManager(mockManager)
+	for i := 0; i < 3; i++ {
+		endpoints, err := cache.GetActiveEndpoints(1)
+		assert.NoError(t, err)
+		assert.Len(t, endpoints, 1)
+	}
+	mockManager.AssertExpectations(t)
+}
+
+func TestCachedEndpointManager_ReloadsAfterTTL(t *testing.T) {
+	mockManager := new(MockEndpointManager)
+	mockManager.On("GetActiveEndpoints", 1).Return([]models.RpcEndpoint{{ID: 1, ChainID: 1}}, nil).Twice()
+
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	cache := NewCachedEndpointManager(mockManager)
+	cache.SetTTL(time.Minute)
+	cache.now = func() time.Time { return now }
+
+	_, _ = cache.GetActiveEndpoints(1)
+	now = now.Add(2 * time.Minute)
+	_, _ = cache.GetActiveEndpoints(1)
+	mockManager.AssertExpectations(t)
+}
+
+func TestCachedEndpointManager_WatchInvalidatesChain(t *testing.T) {
+	mockManager := new(MockEndpointManager)
+	mockManager.On("GetActiveEndpoints", 1).Return([]models.RpcEndpoint{{ID: 1, ChainID: 1}}, nil).Once()
+	mockManager.On("GetActiveEndpoints", 1).Return([]models.RpcEndpoint{{ID: 1, ChainID: 1}, {ID: 2, ChainID: 1}}, nil).Once()
+	mockManager.On("GetActiveEndpoints", 2).Return([]models.RpcEndpoint{{ID: 3, ChainID: 2}}, nil).Once()
+
+	cache := NewCachedEndpointManager(mockManager)
+	_, _ = cache.GetActiveEndpoints(1)
+	_, _ = cache.GetActiveEndpoints(2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	notifications := make(chan *pq.Notification)
+	done := make(chan struct{})
+	go func() {
+		cache.Watch(ctx, notifications)
+		close(done)
+	}()
+	notifications <- &pq.Notification{Channel: EndpointChangesChannel, Extra: "1"}
+	cancel()
+	<-done
+
+	endpoints, err := cache.GetActiveEndpoints(1)
+	assert.NoError(t, err)
+	assert.Len(t, endpoints, 2)
+
+	// Chain 2 was not notified and stays cached
+	_, _ = cache.GetActiveEndpoints(2)
+	mockManager.AssertExpectations(t)
+}
+
+func TestCachedEndpointManager_HealthIsCachedAndFlushedInBatch(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	mockManager := new(MockBatchEndpointManager)
+	mockManager.On("GetActiveEndpoints", 1).Return([]models.RpcEndpoint{
+		{ID: 1, ChainID: 1, HealthStatus: "healthy"},
+		{ID: 2, ChainID: 1, HealthStatus: "healthy"},
+	}, nil).Once()
+	mockManager.On("UpdateEndpointHealthBatch", []EndpointHealthUpdate{
+		{EndpointID: 1, Status: "healthy", CheckedAt: now},
+		{EndpointID: 2, Status: "unhealthy", CheckedAt: now},
+	}).Return(nil).Once()
+
+	cache := NewCachedEndpointManager(mockManager)
+	cache.now = func() time.Time { return now }
+	_, _ = cache.GetActiveEndpoints(1)
+
+	assert.NoError(t, cache.UpdateEndpointHealth(2, "healthy"))
+	assert.NoError(t, cache.UpdateEndpointHealth(2, "unhealthy"))
+	assert.NoError(t, cache.UpdateEndpointHealth(1, "healthy"))
+
+	endpoints, err := cache.GetActiveEndpoints(1)
+	assert.NoError(t, err)
+	assert.Equal(t, "unhealthy", endpoints[1].HealthStatus)
+
+	assert.NoError(t, cache.Flush())
+	assert.NoError(t, cache.Flush())
+	mockManager.AssertNotCalled(t, "UpdateEndpointHealth", mock.Anything, mock.Anything)
+	mockManager.AssertExpectations(t)
+}
+
+func TestCachedEndpointManager_KeepsHealthWhenFlushFails(t *testing.T) {
+	mockManager := new(MockEndpointManager)
+	mockManager.On("UpdateEndpointHealth", 1, "unhealthy").Return(errors.New("connection refused")).Once()
+	mockManager.On("UpdateEndpointHealth", 1, "unhealthy").Return(nil).Once()
+	mockManager.On("GetActiveEndpoints", 1).Return([]models.RpcEndpoint{{ID: 1, ChainID: 1, HealthStatus: "healthy"}}, nil).Once()
+
+	cache := NewCachedEndpointManager(mockManager)
+	assert.NoError(t, cache.UpdateEndpointHealth(1, "unhealthy"))
+	assert.Error(t, cache.Flush())
+
+	// Endpoints loaded before the health is written carry the pending status
+	endpoints, err := cache.GetActiveEndpoints(1)
+	assert.NoError(t, err)
+	assert.Equal(t, "unhealthy", endpoints[0].HealthStatus)
+
+	assert.NoError(t, cache.Flush())
+	mockManager.AssertExpectations(t)
+}
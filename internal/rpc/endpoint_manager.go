@@ -2,15 +2,42 @@ package rpc
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/illegalcall/viper-client/internal/models"
+	"github.com/illegalcall/viper-client/internal/secrets"
+	"github.com/lib/pq"
 )
 
+// ErrNoSecretsKey is returned when endpoint credentials are stored or read without a secrets key
+var ErrNoSecretsKey = errors.New("no secrets key configured for endpoint credentials")
+
+// EndpointStore provides the chains and endpoints the gateway serves, either from the
+// database or from an endpoints file
+type EndpointStore interface {
+	EndpointManager
+	HealthStore
+	RoutingRuleStore
+	ChainProtocolStore
+	ViperChainStore
+}
+
 // DBEndpointManager manages RPC endpoints using a database
 // @Description Manages RPC endpoints for blockchain networks
 type DBEndpointManager struct {
 	db *sql.DB
+
+	// excludeFailedProbes hides endpoints whose last background probe failed
+	excludeFailedProbes bool
+
+	// cipher encrypts and decrypts the endpoints' upstream credentials
+	cipher *secrets.Cipher
 }
 
 // NewDBEndpointManager creates a new endpoint manager with the provided database connection
@@ -20,9 +47,19 @@ func NewDBEndpointManager(db *sql.DB) *DBEndpointManager {
 	}
 }
 
+// SetExcludeFailedProbes controls whether GetActiveEndpoints skips endpoints whose last probe failed
+func (em *DBEndpointManager) SetExcludeFailedProbes(exclude bool) {
+	em.excludeFailedProbes = exclude
+}
+
+// SetCipher sets the cipher the endpoints' upstream credentials are encrypted with
+func (em *DBEndpointManager) SetCipher(cipher *secrets.Cipher) {
+	em.cipher = cipher
+}
+
 // GetActiveEndpoints returns all active endpoints for a given chain ID, sorted by priority
 // @Summary Get active endpoints
-// @Description Retrieves all active RPC endpoints for a specific chain ID, filtered by Indian geolocation
+// @Description Retrieves all active RPC endpoints for a specific chain ID across all geozones
 // @Tags RPC
 // @Accept json
 // @Produce json
@@ -32,12 +69,16 @@ func NewDBEndpointManager(db *sql.DB) *DBEndpointManager {
 // @Router /internal/rpc/endpoints/{chainID} [get]
 func (em *DBEndpointManager) GetActiveEndpoints(chainID int) ([]models.RpcEndpoint, error) {
 	query := `
-		SELECT id, chain_id, endpoint_url, provider, is_active, priority, 
-		       health_check_timestamp, health_status, created_at, updated_at
+		SELECT id, chain_id, geozone, endpoint_url, ws_url, provider, is_active, priority, capabilities,
+		       COALESCE(rate_limit_rps, 0), COALESCE(rate_limit_burst, 0), COALESCE(monthly_quota, 0),
+		       COALESCE(price_per_request, 0), method_prices, auth_config, health_check_timestamp, health_status, created_at, updated_at
 		FROM rpc_endpoints
-		WHERE chain_id = $1 AND is_active = true AND geozone = 'IND'
-		ORDER BY priority DESC
+		WHERE chain_id = $1 AND is_active = true
 	`
+	if em.excludeFailedProbes {
+		query += ` AND last_probe_ok IS NOT FALSE`
+	}
+	query += ` ORDER BY priority DESC`
 
 	rows, err := em.db.Query(query, chainID)
 	if err != nil {
@@ -51,14 +92,27 @@ func (em *DBEndpointManager) GetActiveEndpoints(chainID int) ([]models.RpcEndpoi
 		var healthCheckTime sql.NullTime
 		var healthStatus sql.NullString
 		var provider sql.NullString
+		var geozone sql.NullString
+		var wsURL sql.NullString
+		var authConfig sql.NullString
+		var methodPrices []byte
 
 		err := rows.Scan(
 			&endpoint.ID,
 			&endpoint.ChainID,
+			&geozone,
 			&endpoint.EndpointURL,
+			&wsURL,
 			&provider,
 			&endpoint.IsActive,
 			&endpoint.Priority,
+			pq.Array(&endpoint.Capabilities),
+			&endpoint.RateLimit,
+			&endpoint.RateBurst,
+			&endpoint.MonthlyQuota,
+			&endpoint.PricePerRequest,
+			&methodPrices,
+			&authConfig,
 			&healthCheckTime,
 			&healthStatus,
 			&endpoint.CreatedAt,
@@ -77,6 +131,23 @@ func (em *DBEndpointManager) GetActiveEndpoints(chainID int) ([]models.RpcEndpoi
 		if provider.Valid {
 			endpoint.Provider = provider.String
 		}
+		if geozone.Valid {
+			endpoint.Geozone = geozone.String
+		}
+		if wsURL.Valid {
+			endpoint.WSURL = wsURL.String
+		}
+		if len(methodPrices) > 0 {
+			if err := json.Unmarshal(methodPrices, &endpoint.MethodPrices); err != nil {
+				log.Printf("Ignoring invalid method prices of endpoint %d: %v", endpoint.ID, err)
+			}
+		}
+
+		// Without its credentials the endpoint would only answer with authorization errors
+		if endpoint.Auth, err = em.decryptAuth(authConfig); err != nil {
+			log.Printf("Skipping endpoint %d: failed to decrypt its credentials: %v", endpoint.ID, err)
+			continue
+		}
 
 		endpoints = append(endpoints, endpoint)
 	}
@@ -111,6 +182,612 @@ func (em *DBEndpointManager) UpdateEndpointHealth(id int, status string) error {
 	return err
 }
 
+// UpdateEndpointHealthBatch updates the health status of several endpoints in one statement
+func (em *DBEndpointManager) UpdateEndpointHealthBatch(updates []EndpointHealthUpdate) error {
+	query := `
+		UPDATE rpc_endpoints e
+		SET health_status = u.status, health_check_timestamp = u.checked_at, updated_at = u.checked_at
+		FROM unnest($1::int[], $2::text[], $3::timestamptz[]) AS u(id, status, checked_at)
+		WHERE e.id = u.id
+	`
+
+	ids := make([]int64, len(updates))
+	statuses := make([]string, len(updates))
+	checkedAt := make([]string, len(updates))
+	for i, update := range updates {
+		ids[i] = int64(update.EndpointID)
+		statuses[i] = update.Status
+		checkedAt[i] = update.CheckedAt.Format(time.RFC3339Nano)
+	}
+
+	_, err := em.db.Exec(query, pq.Array(ids), pq.Array(statuses), pq.Array(checkedAt))
+	return err
+}
+
+// ListProbeTargets returns every endpoint together with whether its chain is EVM-compatible
+// and the protocol it declares
+func (em *DBEndpointManager) ListProbeTargets() ([]ProbeTarget, error) {
+	query := `
+		SELECT e.id, e.chain_id, e.endpoint_url, e.provider, e.is_active, e.priority, e.auth_config,
+		       COALESCE(c.is_evm, false), COALESCE(c.chain_details->>'protocol', '')
+		FROM rpc_endpoints e
+		LEFT JOIN chain_static c ON c.chain_id = e.chain_id
+		ORDER BY e.id
+	`
+
+	rows, err := em.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var targets []ProbeTarget
+	for rows.Next() {
+		var target ProbeTarget
+		var provider sql.NullString
+		var authConfig sql.NullString
+
+		err := rows.Scan(
+			&target.Endpoint.ID,
+			&target.Endpoint.ChainID,
+			&target.Endpoint.EndpointURL,
+			&provider,
+			&target.Endpoint.IsActive,
+			&target.Endpoint.Priority,
+			&authConfig,
+			&target.IsEVM,
+			&target.Protocol,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if provider.Valid {
+			target.Endpoint.Provider = provider.String
+		}
+		if target.Endpoint.Auth, err = em.decryptAuth(authConfig); err != nil {
+			log.Printf("Skipping probe of endpoint %d: failed to decrypt its credentials: %v", target.Endpoint.ID, err)
+			continue
+		}
+
+		targets = append(targets, target)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return targets, nil
+}
+
+// RecordProbeResult stores the outcome of a background health probe
+func (em *DBEndpointManager) RecordProbeResult(endpointID int, result ProbeResult) error {
+	query := `
+		UPDATE rpc_endpoints
+		SET health_status = $1, health_check_timestamp = $2, last_probe_ok = $3, last_probe_at = $2,
+		    probe_latency_ms = $4, probe_block_height = $5, updated_at = $2
+		WHERE id = $6
+	`
+
+	status := "healthy"
+	if !result.Healthy {
+		status = "unhealthy"
+	}
+
+	var blockHeight sql.NullInt64
+	if result.BlockHeight > 0 {
+		blockHeight = sql.NullInt64{Int64: result.BlockHeight, Valid: true}
+	}
+
+	_, err := em.db.Exec(query, status, result.CheckedAt, result.Healthy,
+		result.Latency.Milliseconds(), blockHeight, endpointID)
+	return err
+}
+
+// GetEndpointAuth returns the upstream credentials of an endpoint, nil when it has none
+func (em *DBEndpointManager) GetEndpointAuth(id int) (*models.EndpointAuth, error) {
+	var authConfig sql.NullString
+	err := em.db.QueryRow(`SELECT auth_config FROM rpc_endpoints WHERE id = $1`, id).Scan(&authConfig)
+	if err != nil {
+		return nil, err
+	}
+	return em.decryptAuth(authConfig)
+}
+
+// SetEndpointAuth encrypts and stores the upstream credentials of an endpoint. Empty
+// credentials remove the stored ones.
+func (em *DBEndpointManager) SetEndpointAuth(id int, auth *models.EndpointAuth) error {
+	var authConfig sql.NullString
+	if !auth.IsZero() {
+		if em.cipher == nil {
+			return ErrNoSecretsKey
+		}
+		plaintext, err := auth.MarshalSecrets()
+		if err != nil {
+			return err
+		}
+		encrypted, err := em.cipher.Encrypt(plaintext)
+		if err != nil {
+			return err
+		}
+		authConfig = sql.NullString{String: encrypted, Valid: true}
+	}
+
+	query := `
+		UPDATE rpc_endpoints
+		SET auth_config = $1, updated_at = $2
+		WHERE id = $3
+	`
+
+	result, err := em.db.Exec(query, authConfig, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// MoveURLCredentials moves the user info and query parameters embedded in endpoint URLs into
+// their encrypted credentials, so that the URLs no longer carry secrets. It returns how many
+// endpoints were changed.
+func (em *DBEndpointManager) MoveURLCredentials() (int, error) {
+	if em.cipher == nil {
+		return 0, ErrNoSecretsKey
+	}
+
+	// Path keys cannot be matched in SQL, so every endpoint is checked
+	rows, err := em.db.Query(`
+		SELECT id, endpoint_url, auth_config
+		FROM rpc_endpoints
+	`)
+	if err != nil {
+		return 0, err
+	}
+
+	type urlCredentials struct {
+		id          int
+		endpointURL string
+		authConfig  sql.NullString
+	}
+	var endpoints []urlCredentials
+	for rows.Next() {
+		var endpoint urlCredentials
+		if err := rows.Scan(&endpoint.id, &endpoint.endpointURL, &endpoint.authConfig); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	moved := 0
+	for _, endpoint := range endpoints {
+		endpointURL, urlAuth, err := splitURLCredentials(endpoint.endpointURL)
+		if err != nil || urlAuth.IsZero() {
+			continue
+		}
+		stored, err := em.decryptAuth(endpoint.authConfig)
+		if err != nil {
+			return moved, fmt.Errorf("endpoint %d: %w", endpoint.id, err)
+		}
+
+		plaintext, err := mergeAuth(stored, urlAuth).MarshalSecrets()
+		if err != nil {
+			return moved, err
+		}
+		encrypted, err := em.cipher.Encrypt(plaintext)
+		if err != nil {
+			return moved, err
+		}
+
+		query := `
+			UPDATE rpc_endpoints
+			SET endpoint_url = $1, auth_config = $2, updated_at = $3
+			WHERE id = $4
+		`
+		if _, err := em.db.Exec(query, endpointURL, encrypted, time.Now(), endpoint.id); err != nil {
+			return moved, err
+		}
+		moved++
+	}
+	return moved, nil
+}
+
+// decryptAuth decrypts stored upstream credentials
+func (em *DBEndpointManager) decryptAuth(authConfig sql.NullString) (*models.EndpointAuth, error) {
+	if !authConfig.Valid || authConfig.String == "" {
+		return nil, nil
+	}
+	if em.cipher == nil {
+		return nil, ErrNoSecretsKey
+	}
+
+	plaintext, err := em.cipher.Decrypt(authConfig.String)
+	if err != nil {
+		return nil, err
+	}
+	var auth models.EndpointAuth
+	if err := json.Unmarshal(plaintext, &auth); err != nil {
+		return nil, fmt.Errorf("invalid endpoint credentials: %w", err)
+	}
+	return &auth, nil
+}
+
+// ListRoutingRules returns every method routing rule
+func (em *DBEndpointManager) ListRoutingRules() ([]models.RoutingRule, error) {
+	query := `
+		SELECT id, chain_id, method_pattern, block_condition, required_capabilities, created_at, updated_at
+		FROM routing_rules
+		ORDER BY id
+	`
+
+	rows, err := em.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []models.RoutingRule
+	for rows.Next() {
+		var rule models.RoutingRule
+		var chainID sql.NullInt64
+
+		err := rows.Scan(
+			&rule.ID,
+			&chainID,
+			&rule.MethodPattern,
+			&rule.BlockCondition,
+			pq.Array(&rule.RequiredCapabilities),
+			&rule.CreatedAt,
+			&rule.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if chainID.Valid {
+			id := int(chainID.Int64)
+			rule.ChainID = &id
+		}
+
+		rules = append(rules, rule)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// ListChainProtocols returns the upstream protocol declared in every chain's details
+func (em *DBEndpointManager) ListChainProtocols() ([]ChainProtocol, error) {
+	query := `
+		SELECT chain_id, COALESCE(chain_details->>'protocol', ''), is_evm
+		FROM chain_static
+		ORDER BY chain_id
+	`
+
+	rows, err := em.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var protocols []ChainProtocol
+	for rows.Next() {
+		var protocol ChainProtocol
+		if err := rows.Scan(&protocol.ChainID, &protocol.Protocol, &protocol.IsEVM); err != nil {
+			return nil, err
+		}
+		protocols = append(protocols, protocol)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return protocols, nil
+}
+
+// RecordBroadcastOutcomes stores the per-endpoint outcomes of a transaction broadcast
+func (em *DBEndpointManager) RecordBroadcastOutcomes(outcomes []BroadcastOutcome) error {
+	tx, err := em.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO broadcast_outcomes (tx_hash, chain_id, endpoint_id, accepted, already_known, error, latency_ms, created_at)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8)
+	`
+
+	for _, outcome := range outcomes {
+		_, err := tx.Exec(query, strings.ToLower(outcome.TxHash), outcome.ChainID, outcome.EndpointID,
+			outcome.Accepted, outcome.AlreadyKnown, outcome.Error, outcome.LatencyMs, outcome.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ListBroadcastOutcomes returns the per-endpoint outcomes recorded for a transaction
+func (em *DBEndpointManager) ListBroadcastOutcomes(txHash string) ([]BroadcastOutcome, error) {
+	query := `
+		SELECT b.tx_hash, b.chain_id, b.endpoint_id, e.provider, b.accepted, b.already_known,
+		       b.error, b.latency_ms, b.created_at
+		FROM broadcast_outcomes b
+		LEFT JOIN rpc_endpoints e ON e.id = b.endpoint_id
+		WHERE b.tx_hash = $1
+		ORDER BY b.created_at, b.endpoint_id
+	`
+
+	rows, err := em.db.Query(query, txHash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	outcomes := []BroadcastOutcome{}
+	for rows.Next() {
+		var outcome BroadcastOutcome
+		var provider sql.NullString
+		var errorMessage sql.NullString
+		var latency sql.NullInt64
+
+		err := rows.Scan(
+			&outcome.TxHash,
+			&outcome.ChainID,
+			&outcome.EndpointID,
+			&provider,
+			&outcome.Accepted,
+			&outcome.AlreadyKnown,
+			&errorMessage,
+			&latency,
+			&outcome.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if provider.Valid {
+			outcome.Provider = provider.String
+		}
+		if errorMessage.Valid {
+			outcome.Error = errorMessage.String
+		}
+		if latency.Valid {
+			outcome.LatencyMs = latency.Int64
+		}
+
+		outcomes = append(outcomes, outcome)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return outcomes, nil
+}
+
+// LoadEndpointUsage returns the number of requests sent to each endpoint in the given month
+func (em *DBEndpointManager) LoadEndpointUsage(month time.Time) (map[int]int64, error) {
+	rows, err := em.db.Query(`SELECT endpoint_id, requests FROM endpoint_usage WHERE month = $1`, month)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := make(map[int]int64)
+	for rows.Next() {
+		var endpointID int
+		var requests int64
+		if err := rows.Scan(&endpointID, &requests); err != nil {
+			return nil, err
+		}
+		usage[endpointID] = requests
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return usage, nil
+}
+
+// AddEndpointUsage adds the requests sent to each endpoint to its usage in the given month
+func (em *DBEndpointManager) AddEndpointUsage(month time.Time, usage map[int]int64) error {
+	tx, err := em.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO endpoint_usage (endpoint_id, month, requests)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (endpoint_id, month) DO UPDATE SET requests = endpoint_usage.requests + EXCLUDED.requests
+	`
+
+	for endpointID, requests := range usage {
+		if _, err := tx.Exec(query, endpointID, month, requests); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// AddEndpointSpend adds upstream spend to the totals of each endpoint and app on the given day
+func (em *DBEndpointManager) AddEndpointSpend(day time.Time, spend []EndpointSpend) error {
+	tx, err := em.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO endpoint_spend (endpoint_id, app_id, day, requests, cost)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (endpoint_id, app_id, day) DO UPDATE
+		SET requests = endpoint_spend.requests + EXCLUDED.requests,
+		    cost = endpoint_spend.cost + EXCLUDED.cost
+	`
+
+	for _, s := range spend {
+		if _, err := tx.Exec(query, s.EndpointID, s.AppID, day, s.Requests, s.Cost); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ListEndpointSpend returns the upstream spend per endpoint and app between two days, inclusive
+func (em *DBEndpointManager) ListEndpointSpend(from, to time.Time) ([]EndpointSpend, error) {
+	query := `
+		SELECT s.endpoint_id, e.provider, s.app_id, a.name, SUM(s.requests), SUM(s.cost)
+		FROM endpoint_spend s
+		LEFT JOIN rpc_endpoints e ON e.id = s.endpoint_id
+		LEFT JOIN apps a ON a.id = s.app_id
+		WHERE s.day BETWEEN $1 AND $2
+		GROUP BY s.endpoint_id, e.provider, s.app_id, a.name
+		ORDER BY s.endpoint_id, s.app_id
+	`
+
+	rows, err := em.db.Query(query, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	spend := []EndpointSpend{}
+	for rows.Next() {
+		var s EndpointSpend
+		var provider sql.NullString
+		var appName sql.NullString
+		if err := rows.Scan(&s.EndpointID, &provider, &s.AppID, &appName, &s.Requests, &s.Cost); err != nil {
+			return nil, err
+		}
+		if provider.Valid {
+			s.Provider = provider.String
+		}
+		if appName.Valid {
+			s.AppName = appName.String
+		}
+		spend = append(spend, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return spend, nil
+}
+
+// ListViperChains returns every Viper Network chain and the chain it is mapped to
+func (em *DBEndpointManager) ListViperChains() ([]ViperChain, error) {
+	query := `
+		SELECT v.viper_chain, v.chain_id, c.name
+		FROM viper_chains v
+		LEFT JOIN chain_static c ON c.chain_id = v.chain_id
+		ORDER BY v.viper_chain
+	`
+
+	rows, err := em.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chains := []ViperChain{}
+	for rows.Next() {
+		var chain ViperChain
+		var chainID sql.NullInt64
+		var name sql.NullString
+		if err := rows.Scan(&chain.ViperChain, &chainID, &name); err != nil {
+			return nil, err
+		}
+		if chainID.Valid {
+			id := int(chainID.Int64)
+			chain.ChainID = &id
+		}
+		if name.Valid {
+			chain.Name = name.String
+		}
+		chains = append(chains, chain)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return chains, nil
+}
+
+// SeedViperChains adds the Viper chains not known yet, mapping each to the chain with the same
+// numeric ID when that chain exists and is not mapped already
+func (em *DBEndpointManager) SeedViperChains(viperChains []string) error {
+	tx, err := em.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO viper_chains (viper_chain, chain_id)
+		SELECT $1, (
+			SELECT c.chain_id FROM chain_static c
+			WHERE c.chain_id = $2 AND NOT EXISTS (SELECT 1 FROM viper_chains v WHERE v.chain_id = c.chain_id)
+		)
+		ON CONFLICT (viper_chain) DO NOTHING
+	`
+
+	for _, viperChain := range viperChains {
+		// Identifiers that are not numbers are added unmapped
+		var chainID sql.NullInt64
+		if id, err := strconv.Atoi(viperChain); err == nil {
+			chainID = sql.NullInt64{Int64: int64(id), Valid: true}
+		}
+		if _, err := tx.Exec(query, viperChain, chainID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// SetViperChain maps a Viper chain to a chain, adding the Viper chain when it is not known yet.
+// A chain is served by one Viper chain, so mapping one served already fails.
+func (em *DBEndpointManager) SetViperChain(viperChain string, chainID *int) error {
+	query := `
+		INSERT INTO viper_chains (viper_chain, chain_id)
+		VALUES ($1, $2)
+		ON CONFLICT (viper_chain) DO UPDATE
+		SET chain_id = EXCLUDED.chain_id, updated_at = NOW()
+	`
+
+	var id sql.NullInt64
+	if chainID != nil {
+		id = sql.NullInt64{Int64: int64(*chainID), Valid: true}
+	}
+
+	_, err := em.db.Exec(query, viperChain, id)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return fmt.Errorf("%w: chain %d", ErrChainAlreadyMapped, *chainID)
+	}
+	return err
+}
+
 // ErrorResponse represents an error response
 type ErrorResponse struct {
 	Error string `json:"error"`
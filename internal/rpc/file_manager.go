@@ -0,0 +1,609 @@
+package rpc
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/illegalcall/viper-client/internal/models"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultEndpointsFileInterval is how often the endpoints file is checked for changes
+const DefaultEndpointsFileInterval = 5 * time.Second
+
+// EndpointsFile is the content of a file listing the chains and endpoints served without a database
+type EndpointsFile struct {
+	Chains       []FileChain       `json:"chains" yaml:"chains"`
+	RoutingRules []FileRoutingRule `json:"routing_rules,omitempty" yaml:"routing_rules,omitempty"`
+	// Apps lists the apps allowed to relay when the gateway runs without a database
+	Apps []FileApp `json:"apps,omitempty" yaml:"apps,omitempty"`
+}
+
+// FileChain is a chain and its endpoints in an endpoints file
+type FileChain struct {
+	ChainID  int    `json:"chain_id" yaml:"chain_id"`
+	Name     string `json:"name,omitempty" yaml:"name,omitempty"`
+	Protocol string `json:"protocol,omitempty" yaml:"protocol,omitempty"`
+	IsEVM    bool   `json:"is_evm,omitempty" yaml:"is_evm,omitempty"`
+	// ViperChain is the chain's identifier on the Viper Network, if it is served there
+	ViperChain string         `json:"viper_chain,omitempty" yaml:"viper_chain,omitempty"`
+	Endpoints  []FileEndpoint `json:"endpoints" yaml:"endpoints"`
+}
+
+// FileEndpoint is an RPC endpoint in an endpoints file. Endpoints without an ID are numbered
+// in file order, so IDs should be set when endpoints are added or removed while running.
+type FileEndpoint struct {
+	ID       int    `json:"id,omitempty" yaml:"id,omitempty"`
+	URL      string `json:"url" yaml:"url"`
+	WSURL    string `json:"ws_url,omitempty" yaml:"ws_url,omitempty"`
+	Provider string `json:"provider,omitempty" yaml:"provider,omitempty"`
+	Geozone  string `json:"geozone,omitempty" yaml:"geozone,omitempty"`
+	Priority int    `json:"priority,omitempty" yaml:"priority,omitempty"`
+	// Active defaults to true
+	Active          *bool              `json:"active,omitempty" yaml:"active,omitempty"`
+	Capabilities    []string           `json:"capabilities,omitempty" yaml:"capabilities,omitempty"`
+	RateLimit       float64            `json:"rate_limit_rps,omitempty" yaml:"rate_limit_rps,omitempty"`
+	RateBurst       int                `json:"rate_limit_burst,omitempty" yaml:"rate_limit_burst,omitempty"`
+	MonthlyQuota    int64              `json:"monthly_quota,omitempty" yaml:"monthly_quota,omitempty"`
+	PricePerRequest float64            `json:"price_per_request,omitempty" yaml:"price_per_request,omitempty"`
+	MethodPrices    map[string]float64 `json:"method_prices,omitempty" yaml:"method_prices,omitempty"`
+	Auth            *FileEndpointAuth  `json:"auth,omitempty" yaml:"auth,omitempty"`
+}
+
+// FileEndpointAuth holds the upstream credentials of an endpoint in an endpoints file
+type FileEndpointAuth struct {
+	Headers     map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	BearerToken string            `json:"bearer_token,omitempty" yaml:"bearer_token,omitempty"`
+	Username    string            `json:"username,omitempty" yaml:"username,omitempty"`
+	Password    string            `json:"password,omitempty" yaml:"password,omitempty"`
+	QueryParams map[string]string `json:"query_params,omitempty" yaml:"query_params,omitempty"`
+	PathKey     string            `json:"path_key,omitempty" yaml:"path_key,omitempty"`
+}
+
+// FileRoutingRule is a method routing rule in an endpoints file
+type FileRoutingRule struct {
+	ChainID              *int     `json:"chain_id,omitempty" yaml:"chain_id,omitempty"`
+	MethodPattern        string   `json:"method_pattern" yaml:"method_pattern"`
+	BlockCondition       string   `json:"block_condition,omitempty" yaml:"block_condition,omitempty"`
+	RequiredCapabilities []string `json:"required_capabilities" yaml:"required_capabilities"`
+}
+
+// FileApp is an app in an endpoints file
+type FileApp struct {
+	ID                 int      `json:"id,omitempty" yaml:"id,omitempty"`
+	Name               string   `json:"name" yaml:"name"`
+	APIKey             string   `json:"api_key" yaml:"api_key"`
+	AllowedChains      []int    `json:"allowed_chains" yaml:"allowed_chains"`
+	Geozone            string   `json:"geozone,omitempty" yaml:"geozone,omitempty"`
+	ConsensusEndpoints int      `json:"consensus_endpoints,omitempty" yaml:"consensus_endpoints,omitempty"`
+	ViperChainID       int      `json:"viper_chain_id,omitempty" yaml:"viper_chain_id,omitempty"`
+	AllowedMethods     []string `json:"allowed_methods,omitempty" yaml:"allowed_methods,omitempty"`
+	DeniedMethods      []string `json:"denied_methods,omitempty" yaml:"denied_methods,omitempty"`
+}
+
+// endpointHealth is the health of an endpoint as last reported
+type endpointHealth struct {
+	status    string
+	checkedAt time.Time
+	// probeOK is nil until the endpoint is probed
+	probeOK *bool
+}
+
+// fileState is the content of the endpoints file once validated
+type fileState struct {
+	chains    map[int]FileChain
+	endpoints map[int][]models.RpcEndpoint
+	rules     []models.RoutingRule
+	apps      map[string]models.App
+}
+
+// FileEndpointManager serves chains and endpoints from a YAML or JSON file instead of the
+// database, keeping their health in memory. Files ending in .json are read as JSON, any
+// other file as YAML.
+type FileEndpointManager struct {
+	path string
+
+	// excludeFailedProbes hides endpoints whose last background probe failed
+	excludeFailedProbes bool
+
+	mu      sync.RWMutex
+	state   fileState
+	modTime time.Time
+	size    int64
+	health  map[int]*endpointHealth
+	// viperChains holds Viper chains seeded or mapped while running, on top of the file's
+	viperChains map[string]*int
+}
+
+// NewFileEndpointManager creates an endpoint manager serving the endpoints listed in the file
+func NewFileEndpointManager(path string) *FileEndpointManager {
+	return &FileEndpointManager{
+		path:        path,
+		health:      make(map[int]*endpointHealth),
+		viperChains: make(map[string]*int),
+	}
+}
+
+// SetExcludeFailedProbes controls whether GetActiveEndpoints skips endpoints whose last probe failed
+func (fm *FileEndpointManager) SetExcludeFailedProbes(exclude bool) {
+	fm.excludeFailedProbes = exclude
+}
+
+// Reload reads the file. An invalid file is reported and the endpoints loaded before are kept.
+func (fm *FileEndpointManager) Reload() error {
+	info, err := os.Stat(fm.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(fm.path)
+	if err != nil {
+		return err
+	}
+
+	var file EndpointsFile
+	if strings.EqualFold(filepath.Ext(fm.path), ".json") {
+		err = json.Unmarshal(data, &file)
+	} else {
+		err = yaml.Unmarshal(data, &file)
+	}
+	var state fileState
+	if err == nil {
+		state, err = parseEndpointsFile(file)
+	}
+
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	// An invalid file is not read again until it changes
+	fm.modTime = info.ModTime()
+	fm.size = info.Size()
+	if err != nil {
+		return fmt.Errorf("invalid endpoints file %s: %w", fm.path, err)
+	}
+	fm.state = state
+	return nil
+}
+
+// Start reloads the file whenever it changes until the context is cancelled, checking for
+// changes on every interval
+func (fm *FileEndpointManager) Start(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultEndpointsFileInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !fm.changed() {
+				continue
+			}
+			if err := fm.Reload(); err != nil {
+				log.Printf("Failed to reload endpoints file: %v", err)
+				continue
+			}
+			log.Printf("Reloaded endpoints file %s", fm.path)
+		}
+	}
+}
+
+// changed reports whether the file was modified since it was last loaded
+func (fm *FileEndpointManager) changed() bool {
+	info, err := os.Stat(fm.path)
+	if err != nil {
+		log.Printf("Failed to check endpoints file: %v", err)
+		return false
+	}
+
+	fm.mu.RLock()
+	defer fm.mu.RUnlock()
+
+	return !info.ModTime().Equal(fm.modTime) || info.Size() != fm.size
+}
+
+// parseEndpointsFile validates the content of an endpoints file
+func parseEndpointsFile(file EndpointsFile) (fileState, error) {
+	state := fileState{
+		chains:    make(map[int]FileChain),
+		endpoints: make(map[int][]models.RpcEndpoint),
+		apps:      make(map[string]models.App),
+	}
+
+	ids := make(map[int]bool)
+	for _, chain := range file.Chains {
+		for _, endpoint := range chain.Endpoints {
+			if endpoint.ID > 0 {
+				ids[endpoint.ID] = true
+			}
+		}
+	}
+
+	nextID := 1
+	viperChains := make(map[string]int)
+	for _, chain := range file.Chains {
+		if chain.ChainID <= 0 {
+			return fileState{}, errors.New("chain_id must be set on every chain")
+		}
+		if _, ok := state.chains[chain.ChainID]; ok {
+			return fileState{}, fmt.Errorf("chain %d is listed twice", chain.ChainID)
+		}
+		if chain.ViperChain != "" {
+			if other, ok := viperChains[chain.ViperChain]; ok {
+				return fileState{}, fmt.Errorf("viper chain %s is mapped to chains %d and %d", chain.ViperChain, other, chain.ChainID)
+			}
+			viperChains[chain.ViperChain] = chain.ChainID
+		}
+		state.chains[chain.ChainID] = chain
+
+		for _, e := range chain.Endpoints {
+			if e.URL == "" {
+				return fileState{}, fmt.Errorf("an endpoint of chain %d has no url", chain.ChainID)
+			}
+
+			id := e.ID
+			if id <= 0 {
+				for ids[nextID] {
+					nextID++
+				}
+				id = nextID
+				ids[id] = true
+			} else if _, ok := findEndpoint(state.endpoints, id); ok {
+				return fileState{}, fmt.Errorf("endpoint id %d is used twice", id)
+			}
+
+			endpoint := models.RpcEndpoint{
+				ID:              id,
+				ChainID:         chain.ChainID,
+				Geozone:         e.Geozone,
+				EndpointURL:     e.URL,
+				WSURL:           e.WSURL,
+				Provider:        e.Provider,
+				IsActive:        e.Active == nil || *e.Active,
+				Priority:        e.Priority,
+				Capabilities:    e.Capabilities,
+				RateLimit:       e.RateLimit,
+				RateBurst:       e.RateBurst,
+				MonthlyQuota:    e.MonthlyQuota,
+				PricePerRequest: e.PricePerRequest,
+				MethodPrices:    e.MethodPrices,
+			}
+			if e.Auth != nil {
+				endpoint.Auth = &models.EndpointAuth{
+					Headers:     e.Auth.Headers,
+					BearerToken: e.Auth.BearerToken,
+					Username:    e.Auth.Username,
+					Password:    e.Auth.Password,
+					QueryParams: e.Auth.QueryParams,
+					PathKey:     e.Auth.PathKey,
+				}
+			}
+			state.endpoints[chain.ChainID] = append(state.endpoints[chain.ChainID], endpoint)
+		}
+
+		// Highest priority first, like the database
+		sort.SliceStable(state.endpoints[chain.ChainID], func(i, j int) bool {
+			return state.endpoints[chain.ChainID][i].Priority > state.endpoints[chain.ChainID][j].Priority
+		})
+	}
+
+	for i, rule := range file.RoutingRules {
+		state.rules = append(state.rules, models.RoutingRule{
+			ID:                   i + 1,
+			ChainID:              rule.ChainID,
+			MethodPattern:        rule.MethodPattern,
+			BlockCondition:       rule.BlockCondition,
+			RequiredCapabilities: rule.RequiredCapabilities,
+		})
+	}
+
+	// Apps without an ID are numbered like endpoints, skipping the IDs set in the file
+	appIDs := make(map[int]bool)
+	for _, a := range file.Apps {
+		if a.ID > 0 {
+			if appIDs[a.ID] {
+				return fileState{}, fmt.Errorf("app id %d is used twice", a.ID)
+			}
+			appIDs[a.ID] = true
+		}
+	}
+
+	nextAppID := 1
+	for _, a := range file.Apps {
+		if a.APIKey == "" {
+			return fileState{}, fmt.Errorf("app %q has no api_key", a.Name)
+		}
+		if _, ok := state.apps[a.APIKey]; ok {
+			return fileState{}, fmt.Errorf("app %q reuses the api_key of another app", a.Name)
+		}
+		for _, methods := range [][]string{a.AllowedMethods, a.DeniedMethods} {
+			if err := models.ValidateMethodPatterns(methods); err != nil {
+				return fileState{}, fmt.Errorf("app %q: %w", a.Name, err)
+			}
+		}
+		id := a.ID
+		if id <= 0 {
+			for appIDs[nextAppID] {
+				nextAppID++
+			}
+			id = nextAppID
+			appIDs[id] = true
+		}
+		state.apps[a.APIKey] = models.App{
+			ID:                 id,
+			APIKey:             a.APIKey,
+			Name:               a.Name,
+			AllowedChains:      models.IntArray(a.AllowedChains),
+			Geozone:            a.Geozone,
+			ConsensusEndpoints: a.ConsensusEndpoints,
+			ViperChainID:       a.ViperChainID,
+			AllowedMethods:     a.AllowedMethods,
+			DeniedMethods:      a.DeniedMethods,
+		}
+	}
+
+	return state, nil
+}
+
+// findEndpoint returns the endpoint with the given ID
+func findEndpoint(endpoints map[int][]models.RpcEndpoint, id int) (models.RpcEndpoint, bool) {
+	for _, chainEndpoints := range endpoints {
+		for _, endpoint := range chainEndpoints {
+			if endpoint.ID == id {
+				return endpoint, true
+			}
+		}
+	}
+	return models.RpcEndpoint{}, false
+}
+
+// GetActiveEndpoints returns all active endpoints for a given chain ID, sorted by priority
+func (fm *FileEndpointManager) GetActiveEndpoints(chainID int) ([]models.RpcEndpoint, error) {
+	fm.mu.RLock()
+	defer fm.mu.RUnlock()
+
+	var endpoints []models.RpcEndpoint
+	for _, endpoint := range fm.state.endpoints[chainID] {
+		if !endpoint.IsActive {
+			continue
+		}
+		if health, ok := fm.health[endpoint.ID]; ok {
+			if fm.excludeFailedProbes && health.probeOK != nil && !*health.probeOK {
+				continue
+			}
+			checkedAt := health.checkedAt
+			endpoint.HealthStatus = health.status
+			endpoint.HealthCheckTimestamp = &checkedAt
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints, nil
+}
+
+// UpdateEndpointHealth updates the health status of an endpoint
+func (fm *FileEndpointManager) UpdateEndpointHealth(id int, status string) error {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	health := fm.healthOf(id)
+	health.status = status
+	health.checkedAt = time.Now()
+	return nil
+}
+
+// healthOf returns the health kept for an endpoint, creating it when needed
+func (fm *FileEndpointManager) healthOf(id int) *endpointHealth {
+	health, ok := fm.health[id]
+	if !ok {
+		health = &endpointHealth{}
+		fm.health[id] = health
+	}
+	return health
+}
+
+// ListProbeTargets returns every endpoint together with whether its chain is EVM-compatible
+// and the protocol it declares
+func (fm *FileEndpointManager) ListProbeTargets() ([]ProbeTarget, error) {
+	fm.mu.RLock()
+	defer fm.mu.RUnlock()
+
+	var targets []ProbeTarget
+	for chainID, endpoints := range fm.state.endpoints {
+		chain := fm.state.chains[chainID]
+		for _, endpoint := range endpoints {
+			targets = append(targets, ProbeTarget{
+				Endpoint: endpoint,
+				IsEVM:    chain.IsEVM,
+				Protocol: chain.Protocol,
+			})
+		}
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].Endpoint.ID < targets[j].Endpoint.ID
+	})
+	return targets, nil
+}
+
+// RecordProbeResult keeps the outcome of a background health probe
+func (fm *FileEndpointManager) RecordProbeResult(endpointID int, result ProbeResult) error {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	health := fm.healthOf(endpointID)
+	health.status = "healthy"
+	if !result.Healthy {
+		health.status = "unhealthy"
+	}
+	health.checkedAt = result.CheckedAt
+	probeOK := result.Healthy
+	health.probeOK = &probeOK
+	return nil
+}
+
+// ListRoutingRules returns the method routing rules of the file
+func (fm *FileEndpointManager) ListRoutingRules() ([]models.RoutingRule, error) {
+	fm.mu.RLock()
+	defer fm.mu.RUnlock()
+
+	return append([]models.RoutingRule{}, fm.state.rules...), nil
+}
+
+// ListChainProtocols returns the protocol of every chain in the file
+func (fm *FileEndpointManager) ListChainProtocols() ([]ChainProtocol, error) {
+	fm.mu.RLock()
+	defer fm.mu.RUnlock()
+
+	protocols := make([]ChainProtocol, 0, len(fm.state.chains))
+	for _, chain := range fm.state.chains {
+		protocols = append(protocols, ChainProtocol{
+			ChainID:  chain.ChainID,
+			Protocol: chain.Protocol,
+			IsEVM:    chain.IsEVM,
+		})
+	}
+	sort.Slice(protocols, func(i, j int) bool {
+		return protocols[i].ChainID < protocols[j].ChainID
+	})
+	return protocols, nil
+}
+
+// ListViperChains returns the Viper chains of the file together with the ones seeded or
+// mapped while running, which win over the file
+func (fm *FileEndpointManager) ListViperChains() ([]ViperChain, error) {
+	fm.mu.RLock()
+	defer fm.mu.RUnlock()
+
+	mapping := make(map[string]*int)
+	for _, chain := range fm.state.chains {
+		if chain.ViperChain != "" {
+			chainID := chain.ChainID
+			mapping[chain.ViperChain] = &chainID
+		}
+	}
+	for viperChain, chainID := range fm.viperChains {
+		mapping[viperChain] = chainID
+	}
+
+	// A chain is served by one Viper chain, the one mapped while running if any
+	mapped := make(map[int]string)
+	for viperChain, chainID := range fm.viperChains {
+		if chainID != nil {
+			mapped[*chainID] = viperChain
+		}
+	}
+
+	chains := make([]ViperChain, 0, len(mapping))
+	for viperChain, chainID := range mapping {
+		chain := ViperChain{ViperChain: viperChain}
+		if chainID != nil {
+			if other, ok := mapped[*chainID]; !ok || other == viperChain {
+				chain.ChainID = chainID
+				chain.Name = fm.state.chains[*chainID].Name
+			}
+		}
+		chains = append(chains, chain)
+	}
+	sort.Slice(chains, func(i, j int) bool {
+		return chains[i].ViperChain < chains[j].ViperChain
+	})
+	return chains, nil
+}
+
+// SeedViperChains adds the Viper chains not known yet, mapping each to the chain with the same
+// numeric ID when that chain is in the file and is not mapped already. They are kept in memory only.
+func (fm *FileEndpointManager) SeedViperChains(viperChains []string) error {
+	known, err := fm.ListViperChains()
+	if err != nil {
+		return err
+	}
+	names := make(map[string]bool)
+	mapped := make(map[int]bool)
+	for _, chain := range known {
+		names[chain.ViperChain] = true
+		if chain.ChainID != nil {
+			mapped[*chain.ChainID] = true
+		}
+	}
+
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	for _, viperChain := range viperChains {
+		if names[viperChain] {
+			continue
+		}
+		names[viperChain] = true
+
+		var chainID *int
+		if id, err := strconv.Atoi(viperChain); err == nil && !mapped[id] {
+			if _, ok := fm.state.chains[id]; ok {
+				chainID = &id
+				mapped[id] = true
+			}
+		}
+		fm.viperChains[viperChain] = chainID
+	}
+	return nil
+}
+
+// SetViperChain maps a Viper chain to a chain in memory, until the gateway restarts. Like the
+// database, it refuses to map a chain that another Viper chain serves already.
+func (fm *FileEndpointManager) SetViperChain(viperChain string, chainID *int) error {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	if chainID != nil {
+		id := *chainID
+		chainID = &id
+
+		if other, ok := fm.viperChainOf(id); ok && other != viperChain {
+			return fmt.Errorf("%w: chain %d is served by %s", ErrChainAlreadyMapped, id, other)
+		}
+	}
+	fm.viperChains[viperChain] = chainID
+	return nil
+}
+
+// viperChainOf returns the Viper chain serving a chain, the one mapped while running winning
+// over the file. Callers must hold fm.mu.
+func (fm *FileEndpointManager) viperChainOf(chainID int) (string, bool) {
+	for viperChain, mapped := range fm.viperChains {
+		if mapped != nil && *mapped == chainID {
+			return viperChain, true
+		}
+	}
+	viperChain := fm.state.chains[chainID].ViperChain
+	if viperChain == "" {
+		return "", false
+	}
+	// The file's mapping no longer holds when its Viper chain was mapped elsewhere since
+	if mapped, ok := fm.viperChains[viperChain]; ok && (mapped == nil || *mapped != chainID) {
+		return "", false
+	}
+	return viperChain, true
+}
+
+// GetAppByAPIKey returns the app of the file with the given API key, or sql.ErrNoRows
+func (fm *FileEndpointManager) GetAppByAPIKey(apiKey string) (*models.App, error) {
+	fm.mu.RLock()
+	defer fm.mu.RUnlock()
+
+	app, ok := fm.state.apps[apiKey]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &app, nil
+}
@@ -0,0 +1,170 @@
+package rpc
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/illegalcall/viper-client/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+const testEndpointsFile = `
+chains:
+  - chain_id: 2
+    name: Ethereum
+    protocol: evm
+    is_evm: true
+    viper_chain: "0021"
+    endpoints:
+      - url: https://eth-backup.example.com
+        provider: backup
+        priority: 1
+      - id: 7
+        url: https://eth.example.com
+        provider: primary
+        priority: 10
+        geozone: IND
+        rate_limit_rps: 25
+        auth:
+          bearer_token: secret
+      - url: https://eth-disabled.example.com
+        active: false
+  - chain_id: 137
+    name: Polygon
+    endpoints:
+      - url: https://polygon.example.com
+routing_rules:
+  - method_pattern: debug_*
+    required_capabilities: [debug]
+apps:
+  - name: Local
+    api_key: local-key
+    allowed_chains: [2, 137]
+`
+
+func writeEndpointsFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestFileEndpointManager_Load(t *testing.T) {
+	manager := NewFileEndpointManager(writeEndpointsFile(t, "endpoints.yaml", testEndpointsFile))
+	assert.NoError(t, manager.Reload())
+
+	endpoints, err := manager.GetActiveEndpoints(2)
+	assert.NoError(t, err)
+	assert.Len(t, endpoints, 2)
+	assert.Equal(t, 7, endpoints[0].ID)
+	assert.Equal(t, "IND", endpoints[0].Geozone)
+	assert.Equal(t, 25.0, endpoints[0].RateLimit)
+	assert.Equal(t, "secret", endpoints[0].Auth.BearerToken)
+	assert.Equal(t, 1, endpoints[1].ID)
+
+	targets, err := manager.ListProbeTargets()
+	assert.NoError(t, err)
+	assert.Len(t, targets, 4)
+	assert.True(t, targets[0].IsEVM)
+	assert.Equal(t, ProtocolEVM, targets[0].Protocol)
+
+	rules, err := manager.ListRoutingRules()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"debug"}, rules[0].RequiredCapabilities)
+
+	app, err := manager.GetAppByAPIKey("local-key")
+	assert.NoError(t, err)
+	assert.Equal(t, "Local", app.Name)
+	_, err = manager.GetAppByAPIKey("unknown")
+	assert.Equal(t, sql.ErrNoRows, err)
+}
+
+func TestFileEndpointManager_KeepsHealthInMemory(t *testing.T) {
+	manager := NewFileEndpointManager(writeEndpointsFile(t, "endpoints.yaml", testEndpointsFile))
+	manager.SetExcludeFailedProbes(true)
+	assert.NoError(t, manager.Reload())
+
+	assert.NoError(t, manager.UpdateEndpointHealth(7, "error"))
+	endpoints, _ := manager.GetActiveEndpoints(2)
+	assert.Equal(t, "error", endpoints[0].HealthStatus)
+	assert.NotNil(t, endpoints[0].HealthCheckTimestamp)
+
+	assert.NoError(t, manager.RecordProbeResult(7, ProbeResult{Healthy: false, CheckedAt: time.Now()}))
+	endpoints, _ = manager.GetActiveEndpoints(2)
+	assert.Len(t, endpoints, 1)
+	assert.Equal(t, 1, endpoints[0].ID)
+}
+
+func TestFileEndpointManager_ReloadsChangedFile(t *testing.T) {
+	path := writeEndpointsFile(t, "endpoints.json", `{"chains":[{"chain_id":2,"endpoints":[{"url":"https://eth.example.com"}]}]}`)
+	manager := NewFileEndpointManager(path)
+	assert.NoError(t, manager.Reload())
+	assert.False(t, manager.changed())
+
+	assert.NoError(t, os.WriteFile(path, []byte(`{"chains":[{"chain_id":2,"endpoints":[{"url":"https://eth.example.com"},{"url":"https://eth2.example.com"}]}]}`), 0o600))
+	assert.True(t, manager.changed())
+	assert.NoError(t, manager.Reload())
+	endpoints, _ := manager.GetActiveEndpoints(2)
+	assert.Len(t, endpoints, 2)
+
+	// An invalid file keeps the endpoints loaded before
+	assert.NoError(t, os.WriteFile(path, []byte(`{"chains":[{"chain_id":2,"endpoints":[{"provider":"no url"}]}]}`), 0o600))
+	assert.Error(t, manager.Reload())
+	endpoints, _ = manager.GetActiveEndpoints(2)
+	assert.Len(t, endpoints, 2)
+}
+
+func TestFileEndpointManager_ViperChains(t *testing.T) {
+	manager := NewFileEndpointManager(writeEndpointsFile(t, "endpoints.yml", testEndpointsFile))
+	assert.NoError(t, manager.Reload())
+
+	registry := NewViperChainRegistry(manager)
+	assert.NoError(t, registry.Reload())
+	viperChain, err := registry.Resolve(2)
+	assert.NoError(t, err)
+	assert.Equal(t, "0021", viperChain)
+
+	// Seeded chains map to the chain with the same number when it is not mapped yet
+	assert.NoError(t, manager.SeedViperChains([]string{"0002", "0137", "0021"}))
+	assert.NoError(t, registry.Reload())
+	viperChain, err = registry.Resolve(137)
+	assert.NoError(t, err)
+	assert.Equal(t, "0137", viperChain)
+	assert.Len(t, registry.Chains(), 3)
+
+	// A chain is served by one Viper chain, so it is unmapped before it is mapped again
+	assert.ErrorIs(t, registry.Map("0002", intPtr(2)), ErrChainAlreadyMapped)
+	assert.ErrorIs(t, registry.Map("0002", intPtr(137)), ErrChainAlreadyMapped)
+	assert.NoError(t, registry.Map("0021", nil))
+	assert.NoError(t, registry.Map("0002", intPtr(2)))
+	viperChain, err = registry.Resolve(2)
+	assert.NoError(t, err)
+	assert.Equal(t, "0002", viperChain)
+}
+
+func TestFileEndpointManager_NumbersAppsAroundSetIDs(t *testing.T) {
+	manager := NewFileEndpointManager(writeEndpointsFile(t, "endpoints.json", `{"apps":[
+		{"name":"First","api_key":"first-key"},
+		{"id":1,"name":"Second","api_key":"second-key"},
+		{"name":"Third","api_key":"third-key"}
+	]}`))
+	assert.NoError(t, manager.Reload())
+
+	for apiKey, id := range map[string]int{"first-key": 2, "second-key": 1, "third-key": 3} {
+		app, err := manager.GetAppByAPIKey(apiKey)
+		assert.NoError(t, err)
+		assert.Equal(t, id, app.ID)
+	}
+
+	duplicate := NewFileEndpointManager(writeEndpointsFile(t, "duplicate.json", `{"apps":[
+		{"id":4,"name":"First","api_key":"first-key"},
+		{"id":4,"name":"Second","api_key":"second-key"}
+	]}`))
+	assert.Error(t, duplicate.Reload())
+	malformed := NewFileEndpointManager(writeEndpointsFile(t, "malformed.json", `{"apps":[
+		{"name":"First","api_key":"first-key","denied_methods":["debug_["]}
+	]}`))
+	assert.ErrorIs(t, malformed.Reload(), models.ErrInvalidMethodPattern)
+}
@@ -0,0 +1,92 @@
+package rpc
+
+import (
+	"context"
+	"strings"
+
+	"github.com/illegalcall/viper-client/internal/models"
+)
+
+// DefaultGeozone is used when neither the request, the app nor the server configuration names a zone
+const DefaultGeozone = "IND"
+
+// geozoneContextKey is the context key under which the requested geozone is stored
+type geozoneContextKey struct{}
+
+// WithGeozone returns a context carrying the geozone the request should be served from
+func WithGeozone(ctx context.Context, geozone string) context.Context {
+	if geozone == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, geozoneContextKey{}, strings.ToUpper(geozone))
+}
+
+// GeozoneFromContext returns the geozone stored in the context, if any
+func GeozoneFromContext(ctx context.Context) string {
+	geozone, _ := ctx.Value(geozoneContextKey{}).(string)
+	return geozone
+}
+
+// GeozoneRouter narrows a chain's endpoints down to a single geozone, falling back to
+// other zones in a defined order when the preferred zone has no usable endpoints
+type GeozoneRouter struct {
+	defaultZone string
+	fallback    []string
+}
+
+// NewGeozoneRouter creates a router with a default zone and a fallback order.
+// Zones not listed in the fallback order are tried last, followed by endpoints without a zone.
+func NewGeozoneRouter(defaultZone string, fallback []string) *GeozoneRouter {
+	if defaultZone == "" {
+		defaultZone = DefaultGeozone
+	}
+
+	normalized := make([]string, 0, len(fallback))
+	for _, zone := range fallback {
+		if zone = strings.ToUpper(strings.TrimSpace(zone)); zone != "" {
+			normalized = append(normalized, zone)
+		}
+	}
+
+	return &GeozoneRouter{
+		defaultZone: strings.ToUpper(defaultZone),
+		fallback:    normalized,
+	}
+}
+
+// Route returns the endpoints of the first zone, in preference order, that has any endpoints
+func (r *GeozoneRouter) Route(ctx context.Context, endpoints []models.RpcEndpoint) []models.RpcEndpoint {
+	if len(endpoints) == 0 {
+		return endpoints
+	}
+
+	preferred := GeozoneFromContext(ctx)
+	if preferred == "" {
+		preferred = r.defaultZone
+	}
+
+	byZone := make(map[string][]models.RpcEndpoint)
+	var zones []string
+	for _, endpoint := range endpoints {
+		zone := strings.ToUpper(endpoint.Geozone)
+		if _, seen := byZone[zone]; !seen {
+			zones = append(zones, zone)
+		}
+		byZone[zone] = append(byZone[zone], endpoint)
+	}
+
+	order := append([]string{preferred, r.defaultZone}, r.fallback...)
+	for _, zone := range order {
+		if zoneEndpoints, ok := byZone[zone]; ok && zone != "" {
+			return zoneEndpoints
+		}
+	}
+
+	// Any other named zone, in the order returned by the endpoint manager
+	for _, zone := range zones {
+		if zone != "" {
+			return byZone[zone]
+		}
+	}
+	return byZone[""]
+}
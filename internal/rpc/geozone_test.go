@@ -0,0 +1,49 @@
+package rpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/illegalcall/viper-client/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func endpointIDs(endpoints []models.RpcEndpoint) []int {
+	ids := make([]int, len(endpoints))
+	for i, endpoint := range endpoints {
+		ids[i] = endpoint.ID
+	}
+	return ids
+}
+
+func TestGeozoneRouter_Route(t *testing.T) {
+	endpoints := []models.RpcEndpoint{
+		{ID: 1, Geozone: "IND"},
+		{ID: 2, Geozone: "EU"},
+		{ID: 3, Geozone: "US"},
+		{ID: 4, Geozone: "EU"},
+	}
+	router := NewGeozoneRouter("IND", []string{"US", "EU"})
+
+	// Requested zone wins
+	ctx := WithGeozone(context.Background(), "eu")
+	assert.Equal(t, []int{2, 4}, endpointIDs(router.Route(ctx, endpoints)))
+
+	// No zone on the request uses the server default
+	assert.Equal(t, []int{1}, endpointIDs(router.Route(context.Background(), endpoints)))
+
+	// Unknown zone falls back to the default zone first
+	ctx = WithGeozone(context.Background(), "APAC")
+	assert.Equal(t, []int{1}, endpointIDs(router.Route(ctx, endpoints)))
+
+	// Without the default zone the fallback order applies
+	ctx = WithGeozone(context.Background(), "APAC")
+	assert.Equal(t, []int{3}, endpointIDs(router.Route(ctx, endpoints[1:])))
+}
+
+func TestGeozoneRouter_UnzonedEndpoints(t *testing.T) {
+	endpoints := []models.RpcEndpoint{{ID: 1}, {ID: 2}}
+	router := NewGeozoneRouter("", nil)
+
+	assert.Equal(t, []int{1, 2}, endpointIDs(router.Route(context.Background(), endpoints)))
+}
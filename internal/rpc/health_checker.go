@@ -0,0 +1,158 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/illegalcall/viper-client/internal/models"
+)
+
+const (
+	// DefaultHealthCheckInterval is how often every endpoint is probed when no interval is configured
+	DefaultHealthCheckInterval = 30 * time.Second
+
+	// maxConcurrentProbes bounds the number of probes running at the same time
+	maxConcurrentProbes = 8
+)
+
+// ProbeTarget is an endpoint to be probed together with the chain information needed to pick a probe
+type ProbeTarget struct {
+	Endpoint models.RpcEndpoint
+	IsEVM    bool
+	// Protocol is the chain's declared upstream protocol, "" when it declares none
+	Protocol string
+}
+
+// ProbeResult is the outcome of a single health probe
+type ProbeResult struct {
+	Healthy     bool
+	Latency     time.Duration
+	BlockHeight int64
+	CheckedAt   time.Time
+	Err         error
+}
+
+// HealthStore provides the endpoints to probe and persists probe results
+type HealthStore interface {
+	ListProbeTargets() ([]ProbeTarget, error)
+	RecordProbeResult(endpointID int, result ProbeResult) error
+}
+
+// HealthChecker periodically probes every RPC endpoint in the background
+type HealthChecker struct {
+	store      HealthStore
+	httpClient *http.Client
+	interval   time.Duration
+
+	syncTracker *SyncTracker
+	adapters    *AdapterRegistry
+}
+
+// NewHealthChecker creates a new background health checker
+func NewHealthChecker(store HealthStore, interval time.Duration) *HealthChecker {
+	if interval <= 0 {
+		interval = DefaultHealthCheckInterval
+	}
+
+	return &HealthChecker{
+		store: store,
+		httpClient: &http.Client{
+			Timeout: 5 * time.Second,
+		},
+		interval: interval,
+		adapters: NewAdapterRegistry(nil),
+	}
+}
+
+// Start probes all endpoints immediately and then on every interval until the context is cancelled
+func (h *HealthChecker) Start(ctx context.Context) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := h.CheckAll(ctx); err != nil {
+			log.Printf("Endpoint health check failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckAll probes every endpoint once and records the results
+func (h *HealthChecker) CheckAll(ctx context.Context) error {
+	targets, err := h.store.ListProbeTargets()
+	if err != nil {
+		return fmt.Errorf("failed to list endpoints: %w", err)
+	}
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, maxConcurrentProbes)
+	for _, target := range targets {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(target ProbeTarget) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			result := h.Probe(ctx, target)
+			if result.Healthy && h.syncTracker != nil {
+				h.syncTracker.Observe(target.Endpoint.ChainID, target.Endpoint.ID, result.BlockHeight)
+			}
+			if err := h.store.RecordProbeResult(target.Endpoint.ID, result); err != nil {
+				log.Printf("Failed to record probe result for endpoint %d: %v", target.Endpoint.ID, err)
+			}
+		}(target)
+	}
+	wg.Wait()
+
+	return nil
+}
+
+// Probe runs the chain-appropriate probe against a single endpoint
+func (h *HealthChecker) Probe(ctx context.Context, target ProbeTarget) ProbeResult {
+	start := time.Now()
+
+	var height int64
+	var err error
+	client := authenticatedClient(h.httpClient, target.Endpoint)
+	if target.Endpoint.ChainID == ViperNetworkChainID {
+		height, err = h.probeViperHeight(ctx, client, target.Endpoint.EndpointURL)
+	} else {
+		adapter := h.adapters.Resolve(target.Protocol, target.IsEVM)
+		height, err = adapter.Probe(ctx, client, target.Endpoint.EndpointURL)
+	}
+	err = redactError(err)
+
+	return ProbeResult{
+		Healthy:     err == nil,
+		Latency:     time.Since(start),
+		BlockHeight: height,
+		CheckedAt:   time.Now(),
+		Err:         err,
+	}
+}
+
+// probeViperHeight queries /v1/query/height on a Viper node
+func (h *HealthChecker) probeViperHeight(ctx context.Context, client *http.Client, url string) (int64, error) {
+	body, err := postJSON(ctx, client, url+ViperHeightEndpoint, []byte("{}"))
+	if err != nil {
+		return 0, err
+	}
+
+	var response struct {
+		Height int64 `json:"height"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return 0, fmt.Errorf("invalid height response: %w", err)
+	}
+	return response.Height, nil
+}
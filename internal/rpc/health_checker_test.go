@@ -0,0 +1,69 @@
+package rpc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/illegalcall/viper-client/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryHealthStore is an in-memory HealthStore for tests
+type memoryHealthStore struct {
+	mu      sync.Mutex
+	targets []ProbeTarget
+	results map[int]ProbeResult
+}
+
+func (s *memoryHealthStore) ListProbeTargets() ([]ProbeTarget, error) {
+	return s.targets, nil
+}
+
+func (s *memoryHealthStore) RecordProbeResult(endpointID int, result ProbeResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.results[endpointID] = result
+	return nil
+}
+
+func TestHealthChecker_CheckAll(t *testing.T) {
+	evm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x10"}`))
+	}))
+	defer evm.Close()
+
+	viper := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, ViperHeightEndpoint, r.URL.Path)
+		w.Write([]byte(`{"height":42}`))
+	}))
+	defer viper.Close()
+
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer broken.Close()
+
+	store := &memoryHealthStore{
+		targets: []ProbeTarget{
+			{Endpoint: models.RpcEndpoint{ID: 1, ChainID: 2, EndpointURL: evm.URL}, IsEVM: true},
+			{Endpoint: models.RpcEndpoint{ID: 2, ChainID: ViperNetworkChainID, EndpointURL: viper.URL}},
+			{Endpoint: models.RpcEndpoint{ID: 3, ChainID: 2, EndpointURL: broken.URL}, IsEVM: true},
+		},
+		results: make(map[int]ProbeResult),
+	}
+
+	checker := NewHealthChecker(store, 0)
+	assert.NoError(t, checker.CheckAll(context.Background()))
+
+	assert.True(t, store.results[1].Healthy)
+	assert.Equal(t, int64(16), store.results[1].BlockHeight)
+
+	assert.True(t, store.results[2].Healthy)
+	assert.Equal(t, int64(42), store.results[2].BlockHeight)
+
+	assert.False(t, store.results[3].Healthy)
+	assert.Error(t, store.results[3].Err)
+}
@@ -0,0 +1,291 @@
+package rpc
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/illegalcall/viper-client/internal/models"
+)
+
+const (
+	// DefaultHedgePercentile is the latency percentile after which a hedge request is sent
+	DefaultHedgePercentile = 0.95
+
+	// latencyWindowSize is the number of recent latencies kept per chain for percentile estimates
+	latencyWindowSize = 256
+
+	// minHedgeSamples is the number of samples needed before the percentile is trusted
+	minHedgeSamples = 20
+)
+
+// defaultHedgeMethods are the read-only methods hedged when a policy does not list methods
+var defaultHedgeMethods = []string{
+	"eth_blockNumber",
+	"eth_call",
+	"eth_chainId",
+	"eth_estimateGas",
+	"eth_gasPrice",
+	"eth_getBalance",
+	"eth_getBlockByHash",
+	"eth_getBlockByNumber",
+	"eth_getCode",
+	"eth_getStorageAt",
+	"eth_getTransactionByHash",
+	"eth_getTransactionCount",
+	"eth_getTransactionReceipt",
+}
+
+// HedgePolicy enables hedged requests for a chain
+type HedgePolicy struct {
+	// Methods lists the methods that may be hedged; empty means the default read-only methods
+	Methods []string
+	// Percentile of recent latencies after which the hedge request is sent, e.g. 0.95
+	Percentile float64
+	// MinDelay and MaxDelay clamp the computed hedge delay
+	MinDelay time.Duration
+	MaxDelay time.Duration
+}
+
+// HedgeStats describes hedging activity for a chain
+// @Description Hedged request counters for a chain
+type HedgeStats struct {
+	ChainID int `json:"chain_id" example:"2"`
+	// Requests eligible for hedging
+	Requests int64 `json:"requests" example:"1000"`
+	// Extra upstream calls made by hedging
+	Hedged int64 `json:"hedged" example:"48"`
+	// Hedged calls that answered before the original one
+	HedgeWins int64 `json:"hedge_wins" example:"31"`
+	// Current delay after which a hedge is sent, in milliseconds
+	DelayMs int64 `json:"delay_ms" example:"240"`
+}
+
+// chainHedging holds the hedging policy and state of one chain
+type chainHedging struct {
+	methods    map[string]bool
+	percentile float64
+	minDelay   time.Duration
+	maxDelay   time.Duration
+
+	latencies []time.Duration
+	next      int
+
+	requests  int64
+	hedged    int64
+	hedgeWins int64
+}
+
+// hedgeManager keeps per-chain hedging policies, latency windows and counters
+type hedgeManager struct {
+	mu     sync.Mutex
+	chains map[int]*chainHedging
+}
+
+func newHedgeManager() *hedgeManager {
+	return &hedgeManager{
+		chains: make(map[int]*chainHedging),
+	}
+}
+
+// setPolicy enables hedging for a chain
+func (h *hedgeManager) setPolicy(chainID int, policy HedgePolicy) {
+	methods := policy.Methods
+	if len(methods) == 0 {
+		methods = defaultHedgeMethods
+	}
+	methodSet := make(map[string]bool, len(methods))
+	for _, method := range methods {
+		// State-changing calls must never be sent twice
+		if IsIdempotentMethod(method) {
+			methodSet[method] = true
+		}
+	}
+
+	if policy.Percentile <= 0 || policy.Percentile >= 1 {
+		policy.Percentile = DefaultHedgePercentile
+	}
+	if policy.MinDelay <= 0 {
+		policy.MinDelay = 20 * time.Millisecond
+	}
+	if policy.MaxDelay <= 0 {
+		policy.MaxDelay = 2 * time.Second
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	existing, ok := h.chains[chainID]
+	if !ok {
+		existing = &chainHedging{}
+		h.chains[chainID] = existing
+	}
+	existing.methods = methodSet
+	existing.percentile = policy.Percentile
+	existing.minDelay = policy.MinDelay
+	existing.maxDelay = policy.MaxDelay
+}
+
+// delayFor returns the hedge delay for a request and whether it should be hedged at all
+func (h *hedgeManager) delayFor(chainID int, method string) (time.Duration, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	chain, ok := h.chains[chainID]
+	if !ok || !chain.methods[method] {
+		return 0, false
+	}
+	chain.requests++
+	return chain.delay(), true
+}
+
+// delay computes the current hedge delay. Caller must hold the manager's mutex.
+func (c *chainHedging) delay() time.Duration {
+	if len(c.latencies) < minHedgeSamples {
+		return c.maxDelay
+	}
+
+	sorted := append([]time.Duration(nil), c.latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	delay := sorted[int(float64(len(sorted)-1)*c.percentile)]
+
+	if delay < c.minDelay {
+		return c.minDelay
+	}
+	if delay > c.maxDelay {
+		return c.maxDelay
+	}
+	return delay
+}
+
+// observe records the latency of a successful upstream call for chains with hedging enabled
+func (h *hedgeManager) observe(chainID int, latency time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	chain, ok := h.chains[chainID]
+	if !ok {
+		return
+	}
+	if len(chain.latencies) < latencyWindowSize {
+		chain.latencies = append(chain.latencies, latency)
+		return
+	}
+	chain.latencies[chain.next] = latency
+	chain.next = (chain.next + 1) % latencyWindowSize
+}
+
+// recordHedge counts an extra upstream call made by hedging
+func (h *hedgeManager) recordHedge(chainID int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if chain, ok := h.chains[chainID]; ok {
+		chain.hedged++
+	}
+}
+
+// recordHedgeWin counts a hedge call that answered before the original one
+func (h *hedgeManager) recordHedgeWin(chainID int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if chain, ok := h.chains[chainID]; ok {
+		chain.hedgeWins++
+	}
+}
+
+// stats returns the hedging counters of every chain with hedging enabled
+func (h *hedgeManager) stats() []HedgeStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	stats := make([]HedgeStats, 0, len(h.chains))
+	for chainID, chain := range h.chains {
+		stats = append(stats, HedgeStats{
+			ChainID:   chainID,
+			Requests:  chain.requests,
+			Hedged:    chain.hedged,
+			HedgeWins: chain.hedgeWins,
+			DelayMs:   chain.delay().Milliseconds(),
+		})
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].ChainID < stats[j].ChainID
+	})
+	return stats
+}
+
+// SetHedgePolicy enables hedged requests for a chain
+func (d *Dispatcher) SetHedgePolicy(chainID int, policy HedgePolicy) {
+	d.hedging.setPolicy(chainID, policy)
+}
+
+// HedgeStats returns hedging counters for every chain with hedging enabled
+func (d *Dispatcher) HedgeStats() []HedgeStats {
+	return d.hedging.stats()
+}
+
+// forwardHedged sends the request to one endpoint and, if it has not answered within the
+// delay, to a second one. The first successful answer wins and the other call is cancelled.
+// A failure of either call before a winner is known immediately launches the backup.
+func (d *Dispatcher) forwardHedged(ctx context.Context, chainID int, method string, endpoints []models.RpcEndpoint, requestBody []byte, delay time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	strategy := d.strategyFor(chainID)
+	remaining := append([]models.RpcEndpoint(nil), endpoints...)
+
+	type hedgeResult struct {
+		attemptResult
+		hedge bool
+	}
+	results := make(chan hedgeResult, 2)
+	launched, inFlight := 0, 0
+	launch := func() {
+		endpoint := selectEndpoint(strategy, method, remaining)
+		remaining = removeEndpoint(remaining, endpoint.ID)
+		hedge := launched > 0
+		if hedge {
+			d.hedging.recordHedge(chainID)
+		}
+		launched++
+		inFlight++
+		go func() {
+			results <- hedgeResult{attemptResult: d.attempt(ctx, endpoint, requestBody), hedge: hedge}
+		}()
+	}
+
+	launch()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var last attemptResult
+	for inFlight > 0 {
+		select {
+		case <-timer.C:
+			if launched < 2 && len(remaining) > 0 {
+				launch()
+			}
+		case result := <-results:
+			inFlight--
+			if result.kind == failureNone {
+				if result.hedge {
+					d.hedging.recordHedgeWin(chainID)
+				}
+				return result.body, nil
+			}
+			last = result.attemptResult
+			if launched < 2 && len(remaining) > 0 && result.kind != failureFatal {
+				launch()
+			}
+		}
+	}
+
+	if len(last.body) > 0 {
+		return last.body, nil
+	}
+	return nil, last.err
+}
@@ -0,0 +1,117 @@
+package rpc
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/illegalcall/viper-client/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestDispatcher_Forward_HedgeWins(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Drain the body so the server notices when the client cancels
+		io.ReadAll(r.Body)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"slow"}`))
+	}))
+	defer slow.Close()
+
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"fast"}`))
+	}))
+	defer fast.Close()
+
+	mockManager := new(MockEndpointManager)
+	mockManager.On("GetActiveEndpoints", 2).Return([]models.RpcEndpoint{
+		{ID: 1, ChainID: 2, EndpointURL: slow.URL, Priority: 100},
+		{ID: 2, ChainID: 2, EndpointURL: fast.URL, Priority: 1},
+	}, nil)
+	mockManager.On("UpdateEndpointHealth", mock.Anything, mock.Anything).Return(nil)
+
+	dispatcher := NewDispatcher(mockManager)
+	dispatcher.SetChainStrategy(2, StrategyLeastOutstanding)
+	dispatcher.SetHedgePolicy(2, HedgePolicy{MaxDelay: 50 * time.Millisecond})
+
+	request := []byte(`{"jsonrpc":"2.0","method":"eth_call","params":[],"id":1}`)
+	start := time.Now()
+	response, err := dispatcher.Forward(context.Background(), 2, request)
+
+	assert.NoError(t, err)
+	assert.Contains(t, string(response), `"fast"`)
+	assert.Less(t, time.Since(start), time.Second)
+
+	stats := dispatcher.HedgeStats()
+	assert.Len(t, stats, 1)
+	assert.Equal(t, int64(1), stats[0].Requests)
+	assert.Equal(t, int64(1), stats[0].Hedged)
+	assert.Equal(t, int64(1), stats[0].HedgeWins)
+}
+
+func TestDispatcher_Forward_HedgeSelectsByMethodPrice(t *testing.T) {
+	hits := make(map[string]int)
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer server.Close()
+
+	// The first endpoint is cheaper per request, the second for traces
+	mockManager := new(MockEndpointManager)
+	mockManager.On("GetActiveEndpoints", 2).Return([]models.RpcEndpoint{
+		{ID: 1, ChainID: 2, EndpointURL: server.URL + "/flat", PricePerRequest: 0.001, MethodPrices: map[string]float64{"trace_*": 0.05}},
+		{ID: 2, ChainID: 2, EndpointURL: server.URL + "/trace", PricePerRequest: 0.002, MethodPrices: map[string]float64{"trace_*": 0.01}},
+	}, nil)
+	mockManager.On("UpdateEndpointHealth", mock.Anything, mock.Anything).Return(nil)
+
+	dispatcher := NewDispatcher(mockManager)
+	dispatcher.SetChainStrategy(2, StrategyCostAware)
+	dispatcher.SetHedgePolicy(2, HedgePolicy{Methods: []string{"trace_block"}, MaxDelay: time.Second})
+
+	request := []byte(`{"jsonrpc":"2.0","method":"trace_block","params":["0x1"],"id":1}`)
+	for i := 0; i < 40; i++ {
+		_, err := dispatcher.Forward(context.Background(), 2, request)
+		assert.NoError(t, err)
+	}
+
+	// Exploration aside, the hedged call goes to the endpoint cheapest for the method
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Greater(t, hits["/trace"], 30)
+}
+
+func TestDispatcher_Forward_HedgeNotUsedForWrites(t *testing.T) {
+	dispatcher := NewDispatcher(new(MockEndpointManager))
+	dispatcher.SetHedgePolicy(2, HedgePolicy{Methods: []string{"eth_call", "eth_sendRawTransaction"}})
+
+	_, ok := dispatcher.hedging.delayFor(2, "eth_sendRawTransaction")
+	assert.False(t, ok)
+
+	_, ok = dispatcher.hedging.delayFor(2, "eth_call")
+	assert.True(t, ok)
+}
+
+func TestHedgeManager_PercentileDelay(t *testing.T) {
+	hedging := newHedgeManager()
+	hedging.setPolicy(2, HedgePolicy{Percentile: 0.9, MinDelay: time.Millisecond, MaxDelay: time.Second})
+
+	for i := 1; i <= 100; i++ {
+		hedging.observe(2, time.Duration(i)*time.Millisecond)
+	}
+
+	delay, ok := hedging.delayFor(2, "eth_blockNumber")
+	assert.True(t, ok)
+	assert.Equal(t, 90*time.Millisecond, delay)
+}
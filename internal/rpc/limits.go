@@ -0,0 +1,426 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/illegalcall/viper-client/internal/models"
+)
+
+const (
+	// DefaultRateLimitBackoff is how long an endpoint is skipped after throttling a request
+	// without telling when to come back
+	DefaultRateLimitBackoff = time.Second
+
+	// DefaultMaxRateLimitWait is the longest a request waits for a throttled endpoint when
+	// no other endpoint is left to fail over to
+	DefaultMaxRateLimitWait = 2 * time.Second
+
+	// DefaultUsageFlushInterval is how often the monthly request counters are written to the store
+	DefaultUsageFlushInterval = time.Minute
+
+	// HealthStatusRateLimited is the health status recorded for an endpoint that throttled a request
+	HealthStatusRateLimited = "rate_limited"
+)
+
+var (
+	// ErrEndpointsRateLimited is returned when every endpoint able to serve a request is at its
+	// rate limit, backing off after throttling or out of monthly quota
+	ErrEndpointsRateLimited = errors.New("all endpoints for the requested chain are at their rate limit or quota")
+
+	// errEndpointLimited is returned for an attempt on an endpoint that reached its limit after it was selected
+	errEndpointLimited = errors.New("endpoint is at its rate limit or quota")
+
+	// errBreakerTrialsBusy is returned for an attempt on a half-open endpoint whose trials are all in flight
+	errBreakerTrialsBusy = errors.New("endpoint breaker is open or busy with trial requests")
+)
+
+// UsageStore persists the number of requests sent to each endpoint per calendar month
+type UsageStore interface {
+	LoadEndpointUsage(month time.Time) (map[int]int64, error)
+	AddEndpointUsage(month time.Time, usage map[int]int64) error
+}
+
+// EndpointLimitStatus describes the outbound limits of one endpoint and how much of them is used
+// @Description Outbound rate limit, monthly quota and throttling back-off of an RPC endpoint
+type EndpointLimitStatus struct {
+	EndpointID int `json:"endpoint_id" example:"1"`
+	// Requests per second allowed by the provider, 0 for unlimited
+	RateLimit float64 `json:"rate_limit" example:"25"`
+	// Requests that may currently be sent without waiting for the bucket to refill
+	Tokens float64 `json:"tokens" example:"18.5"`
+	// Requests per month allowed by the provider, 0 for unlimited
+	MonthlyQuota int64 `json:"monthly_quota" example:"3000000"`
+	// Requests sent this month
+	MonthlyUsage int64 `json:"monthly_usage" example:"1250000"`
+	// When the endpoint is routed to again after throttling a request
+	BackoffUntil *time.Time `json:"backoff_until,omitempty"`
+}
+
+// endpointLimit is the token bucket and throttling state of one endpoint
+type endpointLimit struct {
+	rate         float64
+	burst        float64
+	quota        int64
+	tokens       float64
+	lastRefill   time.Time
+	backoffUntil time.Time
+}
+
+// usageKey identifies the requests sent to an endpoint in one month
+type usageKey struct {
+	endpointID int
+	month      time.Time
+}
+
+// EndpointLimits enforces the outbound rate limits and monthly quotas configured on endpoints
+// and keeps endpoints that throttled a request out of rotation until their Retry-After passes.
+//
+// Monthly usage is counted in memory and written to the store periodically, so that counting
+// does not add a database write to every request. Counters are reloaded on every flush to pick
+// up the requests sent by other instances.
+type EndpointLimits struct {
+	mu        sync.Mutex
+	store     UsageStore
+	endpoints map[int]*endpointLimit
+	month     time.Time
+	usage     map[int]int64
+	pending   map[usageKey]int64
+	maxWait   time.Duration
+	now       func() time.Time
+}
+
+// NewEndpointLimits creates endpoint limits persisting monthly usage to the given store,
+// which may be nil to count usage in memory only
+func NewEndpointLimits(store UsageStore) *EndpointLimits {
+	return &EndpointLimits{
+		store:     store,
+		endpoints: make(map[int]*endpointLimit),
+		usage:     make(map[int]int64),
+		pending:   make(map[usageKey]int64),
+		maxWait:   DefaultMaxRateLimitWait,
+		now:       time.Now,
+	}
+}
+
+// SetMaxWait sets the longest a request waits for a throttled endpoint instead of failing
+func (l *EndpointLimits) SetMaxWait(maxWait time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.maxWait = maxWait
+}
+
+// monthOf returns the first day of the calendar month (UTC) of a time
+func monthOf(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// refreshMonth resets the usage counters when a new month starts. Caller must hold mu.
+func (l *EndpointLimits) refreshMonth(now time.Time) {
+	if month := monthOf(now); !month.Equal(l.month) {
+		l.month = month
+		l.usage = make(map[int]int64)
+	}
+}
+
+// get returns the state of an endpoint, applying its current limits and refilling its
+// bucket. Caller must hold mu.
+func (l *EndpointLimits) get(endpoint models.RpcEndpoint, now time.Time) *endpointLimit {
+	state, ok := l.endpoints[endpoint.ID]
+	if !ok {
+		state = &endpointLimit{lastRefill: now}
+		l.endpoints[endpoint.ID] = state
+	}
+
+	burst := float64(endpoint.RateBurst)
+	if burst < 1 {
+		burst = math.Max(1, math.Ceil(endpoint.RateLimit))
+	}
+	if !ok {
+		state.tokens = burst
+	}
+	state.rate = endpoint.RateLimit
+	state.burst = burst
+	state.quota = endpoint.MonthlyQuota
+
+	if state.rate > 0 {
+		elapsed := now.Sub(state.lastRefill).Seconds()
+		state.tokens = math.Min(state.burst, state.tokens+state.rate*elapsed)
+	}
+	state.lastRefill = now
+	return state
+}
+
+// available reports whether a request may be sent to the endpoint. Caller must hold mu.
+func (l *EndpointLimits) available(endpoint models.RpcEndpoint, now time.Time) (*endpointLimit, bool) {
+	l.refreshMonth(now)
+	state := l.get(endpoint, now)
+
+	if now.Before(state.backoffUntil) {
+		return state, false
+	}
+	if state.quota > 0 && l.usage[endpoint.ID] >= state.quota {
+		return state, false
+	}
+	if state.rate > 0 && state.tokens < 1 {
+		return state, false
+	}
+	return state, true
+}
+
+// Filter returns the endpoints a request may currently be sent to. ErrEndpointsRateLimited
+// is returned when there were endpoints but all of them are at their limits.
+func (l *EndpointLimits) Filter(endpoints []models.RpcEndpoint) ([]models.RpcEndpoint, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	var allowed []models.RpcEndpoint
+	for _, endpoint := range endpoints {
+		if _, ok := l.available(endpoint, now); ok {
+			allowed = append(allowed, endpoint)
+		}
+	}
+
+	if len(allowed) == 0 && len(endpoints) > 0 {
+		return nil, ErrEndpointsRateLimited
+	}
+	return allowed, nil
+}
+
+// Acquire takes a token from the endpoint's bucket and counts the request against its monthly
+// quota. It returns false without counting anything when the endpoint is at its limits.
+func (l *EndpointLimits) Acquire(endpoint models.RpcEndpoint) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	state, ok := l.available(endpoint, now)
+	if !ok {
+		return false
+	}
+
+	if state.rate > 0 {
+		state.tokens--
+	}
+	l.usage[endpoint.ID]++
+	l.pending[usageKey{endpointID: endpoint.ID, month: l.month}]++
+	return true
+}
+
+// Backoff keeps the endpoint out of rotation for the given duration after it throttled a
+// request, or for DefaultRateLimitBackoff when the upstream did not say how long
+func (l *EndpointLimits) Backoff(endpointID int, retryAfter time.Duration) {
+	if retryAfter <= 0 {
+		retryAfter = DefaultRateLimitBackoff
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	state, ok := l.endpoints[endpointID]
+	if !ok {
+		state = &endpointLimit{lastRefill: now}
+		l.endpoints[endpointID] = state
+	}
+	if until := now.Add(retryAfter); until.After(state.backoffUntil) {
+		state.backoffUntil = until
+	}
+}
+
+// wait blocks until a throttled endpoint's back-off has passed, as long as that is within the
+// maximum wait. It reports whether the endpoint may be tried again.
+func (l *EndpointLimits) wait(ctx context.Context, endpointID int) bool {
+	l.mu.Lock()
+	var delay time.Duration
+	if state, ok := l.endpoints[endpointID]; ok {
+		delay = state.backoffUntil.Sub(l.now())
+	}
+	maxWait := l.maxWait
+	l.mu.Unlock()
+
+	if delay > maxWait {
+		return false
+	}
+	if delay <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// Load reads this month's usage of every endpoint from the store, keeping requests counted
+// since the last flush
+func (l *EndpointLimits) Load() error {
+	if l.store == nil {
+		return nil
+	}
+
+	month := monthOf(l.now())
+	usage, err := l.store.LoadEndpointUsage(month)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refreshMonth(l.now())
+	if !l.month.Equal(month) {
+		return nil
+	}
+	for key, requests := range l.pending {
+		if key.month.Equal(month) {
+			usage[key.endpointID] += requests
+		}
+	}
+	l.usage = usage
+	return nil
+}
+
+// Flush writes the requests counted since the last flush to the store
+func (l *EndpointLimits) Flush() error {
+	if l.store == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	pending := l.pending
+	l.pending = make(map[usageKey]int64)
+	l.mu.Unlock()
+
+	months := make(map[time.Time]map[int]int64)
+	for key, requests := range pending {
+		if months[key.month] == nil {
+			months[key.month] = make(map[int]int64)
+		}
+		months[key.month][key.endpointID] += requests
+	}
+
+	var lastErr error
+	for month, usage := range months {
+		if err := l.store.AddEndpointUsage(month, usage); err != nil {
+			// Keep the counts for the next flush
+			l.mu.Lock()
+			for endpointID, requests := range usage {
+				l.pending[usageKey{endpointID: endpointID, month: month}] += requests
+			}
+			l.mu.Unlock()
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// Start flushes and reloads the monthly usage on every interval until the context is
+// cancelled, flushing one last time on the way out
+func (l *EndpointLimits) Start(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultUsageFlushInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := l.Flush(); err != nil {
+				log.Printf("Failed to flush endpoint usage: %v", err)
+			}
+			return
+		case <-ticker.C:
+			if err := l.Flush(); err != nil {
+				log.Printf("Failed to flush endpoint usage: %v", err)
+				continue
+			}
+			if err := l.Load(); err != nil {
+				log.Printf("Failed to reload endpoint usage: %v", err)
+			}
+		}
+	}
+}
+
+// Status returns the limits and usage of every endpoint that has seen traffic, ordered by endpoint ID
+func (l *EndpointLimits) Status() []EndpointLimitStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.refreshMonth(now)
+
+	statuses := make([]EndpointLimitStatus, 0, len(l.endpoints))
+	for id, state := range l.endpoints {
+		tokens := state.tokens
+		if state.rate > 0 {
+			tokens = math.Min(state.burst, tokens+state.rate*now.Sub(state.lastRefill).Seconds())
+		}
+		status := EndpointLimitStatus{
+			EndpointID:   id,
+			RateLimit:    state.rate,
+			Tokens:       tokens,
+			MonthlyQuota: state.quota,
+			MonthlyUsage: l.usage[id],
+		}
+		if now.Before(state.backoffUntil) {
+			backoffUntil := state.backoffUntil
+			status.BackoffUntil = &backoffUntil
+		}
+		statuses = append(statuses, status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].EndpointID < statuses[j].EndpointID
+	})
+	return statuses
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return at.Sub(now)
+	}
+	return 0
+}
+
+// SetEndpointLimits sets the outbound limits enforced on endpoints
+func (d *Dispatcher) SetEndpointLimits(limits *EndpointLimits) {
+	d.limits = limits
+}
+
+// EndpointLimits returns the outbound limits and usage of every endpoint that has seen traffic
+func (d *Dispatcher) EndpointLimits() []EndpointLimitStatus {
+	return d.limits.Status()
+}
+
+// throttled records that an endpoint throttled a request, keeping it out of rotation until
+// the upstream's Retry-After passes
+func (d *Dispatcher) throttled(endpoint models.RpcEndpoint, header http.Header) {
+	d.limits.Backoff(endpoint.ID, parseRetryAfter(header.Get("Retry-After"), time.Now()))
+	// Throttling says nothing about the endpoint's health, so it does not trip its breaker
+	d.endpointManager.UpdateEndpointHealth(endpoint.ID, HealthStatusRateLimited)
+}
@@ -0,0 +1,156 @@
+package rpc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/illegalcall/viper-client/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// MockUsageStore is a mock implementation of UsageStore
+type MockUsageStore struct {
+	mock.Mock
+}
+
+func (m *MockUsageStore) LoadEndpointUsage(month time.Time) (map[int]int64, error) {
+	args := m.Called(month)
+	return args.Get(0).(map[int]int64), args.Error(1)
+}
+
+func (m *MockUsageStore) AddEndpointUsage(month time.Time, usage map[int]int64) error {
+	args := m.Called(month, usage)
+	return args.Error(0)
+}
+
+func TestEndpointLimits_RateLimit(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	limits := NewEndpointLimits(nil)
+	limits.now = func() time.Time { return now }
+
+	endpoint := models.RpcEndpoint{ID: 1, RateLimit: 2, RateBurst: 2}
+	assert.True(t, limits.Acquire(endpoint))
+	assert.True(t, limits.Acquire(endpoint))
+	assert.False(t, limits.Acquire(endpoint))
+
+	_, err := limits.Filter([]models.RpcEndpoint{endpoint})
+	assert.Equal(t, ErrEndpointsRateLimited, err)
+
+	// Half a second refills one token at two requests per second
+	now = now.Add(500 * time.Millisecond)
+	assert.True(t, limits.Acquire(endpoint))
+	assert.False(t, limits.Acquire(endpoint))
+}
+
+func TestEndpointLimits_MonthlyQuota(t *testing.T) {
+	now := time.Date(2024, 5, 31, 23, 59, 0, 0, time.UTC)
+	store := new(MockUsageStore)
+	store.On("LoadEndpointUsage", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)).Return(map[int]int64{1: 9}, nil)
+	store.On("AddEndpointUsage", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), map[int]int64{1: 1}).Return(nil)
+
+	limits := NewEndpointLimits(store)
+	limits.now = func() time.Time { return now }
+	assert.NoError(t, limits.Load())
+
+	limited := models.RpcEndpoint{ID: 1, MonthlyQuota: 10}
+	unlimited := models.RpcEndpoint{ID: 2}
+	assert.True(t, limits.Acquire(limited))
+	assert.False(t, limits.Acquire(limited))
+
+	allowed, err := limits.Filter([]models.RpcEndpoint{limited, unlimited})
+	assert.NoError(t, err)
+	assert.Equal(t, []models.RpcEndpoint{unlimited}, allowed)
+
+	// The quota starts over with the new month
+	now = now.Add(2 * time.Minute)
+	assert.True(t, limits.Acquire(limited))
+
+	store.On("AddEndpointUsage", time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), map[int]int64{1: 1}).Return(nil)
+	assert.NoError(t, limits.Flush())
+	store.AssertExpectations(t)
+}
+
+func TestDispatcher_Forward_BacksOffThrottledEndpoint(t *testing.T) {
+	var throttledCalls, healthyCalls int32
+	throttled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&throttledCalls, 1)
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer throttled.Close()
+
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&healthyCalls, 1)
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x2"}`))
+	}))
+	defer healthy.Close()
+
+	mockManager := new(MockEndpointManager)
+	mockManager.On("GetActiveEndpoints", 2).Return([]models.RpcEndpoint{
+		{ID: 1, ChainID: 2, EndpointURL: throttled.URL, Priority: 100},
+		{ID: 2, ChainID: 2, EndpointURL: healthy.URL, Priority: 1},
+	}, nil)
+	mockManager.On("UpdateEndpointHealth", 1, HealthStatusRateLimited).Return(nil)
+	mockManager.On("UpdateEndpointHealth", 2, "healthy").Return(nil)
+
+	dispatcher := NewDispatcher(mockManager)
+
+	// Even a transaction is retried, as the throttled endpoint never processed it
+	request := []byte(`{"jsonrpc":"2.0","method":"eth_sendRawTransaction","params":["0xf8"],"id":1}`)
+	for i := 0; i < 3; i++ {
+		response, err := dispatcher.Forward(context.Background(), 2, request)
+		assert.NoError(t, err)
+		assert.Contains(t, string(response), `"result":"0x2"`)
+	}
+
+	// The throttled endpoint is skipped until its Retry-After passes
+	assert.Equal(t, int32(1), atomic.LoadInt32(&throttledCalls))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&healthyCalls))
+	mockManager.AssertNotCalled(t, "UpdateEndpointHealth", 1, "error")
+
+	statuses := dispatcher.EndpointLimits()
+	assert.Len(t, statuses, 2)
+	assert.NotNil(t, statuses[0].BackoffUntil)
+}
+
+func TestDispatcher_Forward_WaitsOutShortRetryAfter(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer server.Close()
+
+	mockManager := new(MockEndpointManager)
+	mockManager.On("GetActiveEndpoints", 2).Return([]models.RpcEndpoint{
+		{ID: 1, ChainID: 2, EndpointURL: server.URL, Priority: 1},
+	}, nil)
+	mockManager.On("UpdateEndpointHealth", 1, mock.Anything).Return(nil)
+
+	dispatcher := NewDispatcher(mockManager)
+
+	request := []byte(`{"jsonrpc":"2.0","method":"eth_blockNumber","params":[],"id":1}`)
+	response, err := dispatcher.Forward(context.Background(), 2, request)
+
+	assert.NoError(t, err)
+	assert.Contains(t, string(response), `"result":"0x1"`)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, 30*time.Second, parseRetryAfter("30", now))
+	assert.Equal(t, 90*time.Second, parseRetryAfter("Fri, 10 May 2024 12:01:30 GMT", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("", now))
+}
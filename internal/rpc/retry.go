@@ -0,0 +1,244 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"syscall"
+)
+
+// DefaultMaxAttempts is the default number of endpoints tried for a single request
+const DefaultMaxAttempts = 3
+
+// RetryPolicy controls how Forward fails over between endpoints
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of endpoints tried for one request, including the first
+	MaxAttempts int
+}
+
+// DefaultRetryPolicy returns the retry policy used when none is configured
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: DefaultMaxAttempts,
+	}
+}
+
+// evmReadMethods are the Ethereum JSON-RPC methods, as path.Match patterns, that only read
+// chain state and can be sent to more than one endpoint. Filter methods are left out, as a
+// filter only exists on the node that created it.
+var evmReadMethods = []string{
+	"eth_blobBaseFee",
+	"eth_blockNumber",
+	"eth_call",
+	"eth_chainId",
+	"eth_createAccessList",
+	"eth_estimateGas",
+	"eth_feeHistory",
+	"eth_gasPrice",
+	"eth_getBalance",
+	"eth_getBlockByHash",
+	"eth_getBlockByNumber",
+	"eth_getBlockReceipts",
+	"eth_getBlockTransactionCountBy*",
+	"eth_getCode",
+	"eth_getLogs",
+	"eth_getProof",
+	"eth_getStorageAt",
+	"eth_getTransactionBy*",
+	"eth_getTransactionCount",
+	"eth_getTransactionReceipt",
+	"eth_getUncleBy*",
+	"eth_getUncleCountBy*",
+	"eth_maxPriorityFeePerGas",
+	"eth_protocolVersion",
+	"eth_syncing",
+	"net_listening",
+	"net_peerCount",
+	"net_version",
+	"web3_clientVersion",
+	"web3_sha3",
+	"debug_getRaw*",
+	"debug_trace*",
+	"trace_block",
+	"trace_call",
+	"trace_callMany",
+	"trace_filter",
+	"trace_get",
+	"trace_replay*",
+	"trace_transaction",
+}
+
+// tendermintReadMethods are the Tendermint/CometBFT JSON-RPC methods that only read chain
+// state. The broadcast_tx_* methods submit transactions and are left out.
+var tendermintReadMethods = []string{
+	"abci_info",
+	"abci_query",
+	"block",
+	"block_by_hash",
+	"block_results",
+	"block_search",
+	"blockchain",
+	"check_tx",
+	"commit",
+	"consensus_params",
+	"consensus_state",
+	"genesis",
+	"genesis_chunked",
+	"header",
+	"header_by_hash",
+	"health",
+	"net_info",
+	"num_unconfirmed_txs",
+	"status",
+	"tx",
+	"tx_search",
+	"unconfirmed_txs",
+	"validators",
+}
+
+// matchesAnyMethod reports whether the method matches one of the patterns
+func matchesAnyMethod(patterns []string, method string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, method); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// IsIdempotentMethod reports whether a JSON-RPC method is known to only read state, so that it
+// can safely be sent to more than one endpoint, whatever the chain's protocol. Methods that are
+// not known reads, including every method submitting a transaction, are not replayed.
+func IsIdempotentMethod(method string) bool {
+	return matchesAnyMethod(evmReadMethods, method) || matchesAnyMethod(tendermintReadMethods, method)
+}
+
+// rateLimitMarkers are phrases of provider error messages that explicitly report throttling.
+// Error codes such as -32005 and words such as "exceeded" are also used for oversized queries
+// and transaction pool errors, so they are not enough on their own.
+var rateLimitMarkers = []string{
+	"rate limit",
+	"rate-limit",
+	"ratelimit",
+	"too many requests",
+	"throttled",
+}
+
+// failureKind describes how an upstream attempt failed
+type failureKind int
+
+const (
+	// failureNone means the attempt succeeded
+	failureNone failureKind = iota
+	// failureFatal means the failure should be returned to the caller as-is
+	failureFatal
+	// failureNotSent means the request never reached the upstream, so it is always safe to retry
+	failureNotSent
+	// failureRetryable means the upstream may have seen the request but an idempotent retry is safe
+	failureRetryable
+	// failureRateLimited means the upstream answered HTTP 429 without processing the request, so
+	// it is always safe to retry once the endpoint is backed off
+	failureRateLimited
+	// failureThrottled means the response body reports throttling. The endpoint is backed off
+	// like for failureRateLimited, but as the body alone does not prove the request was not
+	// processed, only idempotent requests are retried.
+	failureThrottled
+)
+
+// throttled reports whether the failure means the endpoint is throttling requests
+func (k failureKind) throttled() bool {
+	return k == failureRateLimited || k == failureThrottled
+}
+
+// classifyTransportError classifies an error returned by the HTTP client
+func classifyTransportError(ctx context.Context, err error) failureKind {
+	// The caller's own deadline or cancellation is never retried
+	if ctx.Err() != nil {
+		return failureFatal
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return failureNotSent
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return failureNotSent
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return failureNotSent
+	}
+
+	// Timeouts, resets and other transport errors may have reached the upstream
+	return failureRetryable
+}
+
+// classifyResponse classifies a response received from the upstream
+func classifyResponse(statusCode int, body []byte) failureKind {
+	if statusCode == http.StatusTooManyRequests {
+		return failureRateLimited
+	}
+	if isRateLimitBody(body) {
+		return failureThrottled
+	}
+
+	if statusCode >= 500 {
+		return failureRetryable
+	}
+	// Error pages of proxies and CDNs, e.g. an HTML 403 or 404, are not answers of the chain
+	if (statusCode < 200 || statusCode >= 300) && !json.Valid(body) {
+		return failureRetryable
+	}
+
+	return failureNone
+}
+
+// upstreamAnswer returns the body of a failed response if it may be handed to the client in
+// place of an error, which requires it to be JSON
+func upstreamAnswer(body []byte) []byte {
+	if !json.Valid(body) {
+		return nil
+	}
+	return body
+}
+
+// isRateLimitBody reports whether a JSON-RPC response body carries a provider rate-limit error
+func isRateLimitBody(body []byte) bool {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return false
+	}
+
+	var response RPCResponse
+	if err := json.Unmarshal(trimmed, &response); err != nil || response.Error == nil {
+		return false
+	}
+
+	message := strings.ToLower(response.Error.Message)
+	for _, marker := range rateLimitMarkers {
+		if strings.Contains(message, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldRetry reports whether a failed attempt of a request, idempotent or not, may be retried
+// on another endpoint
+func shouldRetry(kind failureKind, idempotent bool) bool {
+	switch kind {
+	case failureNotSent, failureRateLimited:
+		return true
+	case failureRetryable, failureThrottled:
+		return idempotent
+	default:
+		return false
+	}
+}
@@ -0,0 +1,233 @@
+package rpc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/illegalcall/viper-client/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestDispatcher_Forward_FailoverOn5xx(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer healthy.Close()
+
+	mockManager := new(MockEndpointManager)
+	mockManager.On("GetActiveEndpoints", 2).Return([]models.RpcEndpoint{
+		{ID: 1, ChainID: 2, EndpointURL: failing.URL, Priority: 100},
+		{ID: 2, ChainID: 2, EndpointURL: healthy.URL, Priority: 1},
+	}, nil)
+	mockManager.On("UpdateEndpointHealth", 1, "error").Return(nil)
+	mockManager.On("UpdateEndpointHealth", 2, "healthy").Return(nil)
+
+	dispatcher := NewDispatcher(mockManager)
+
+	request := []byte(`{"jsonrpc":"2.0","method":"eth_blockNumber","params":[],"id":1}`)
+	response, err := dispatcher.Forward(context.Background(), 2, request)
+
+	assert.NoError(t, err)
+	assert.Contains(t, string(response), `"result":"0x1"`)
+	mockManager.AssertExpectations(t)
+}
+
+func TestDispatcher_Forward_FailoverOnRateLimitBody(t *testing.T) {
+	limited := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32005,"message":"rate limit exceeded, retry later"}}`))
+	}))
+	defer limited.Close()
+
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x2"}`))
+	}))
+	defer healthy.Close()
+
+	mockManager := new(MockEndpointManager)
+	mockManager.On("GetActiveEndpoints", 2).Return([]models.RpcEndpoint{
+		{ID: 1, ChainID: 2, EndpointURL: limited.URL, Priority: 100},
+		{ID: 2, ChainID: 2, EndpointURL: healthy.URL, Priority: 1},
+	}, nil)
+	mockManager.On("UpdateEndpointHealth", mock.Anything, mock.Anything).Return(nil)
+
+	dispatcher := NewDispatcher(mockManager)
+
+	request := []byte(`{"jsonrpc":"2.0","method":"eth_getBalance","params":["0x0","latest"],"id":1}`)
+	response, err := dispatcher.Forward(context.Background(), 2, request)
+
+	assert.NoError(t, err)
+	assert.Contains(t, string(response), `"result":"0x2"`)
+}
+
+func TestDispatcher_Forward_NoRetryForThrottledSendRawTransaction(t *testing.T) {
+	var secondCalls int32
+
+	limited := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32005,"message":"rate limit exceeded"}}`))
+	}))
+	defer limited.Close()
+
+	second := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&secondCalls, 1)
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0xhash"}`))
+	}))
+	defer second.Close()
+
+	mockManager := new(MockEndpointManager)
+	mockManager.On("GetActiveEndpoints", 2).Return([]models.RpcEndpoint{
+		{ID: 1, ChainID: 2, EndpointURL: limited.URL, Priority: 100},
+		{ID: 2, ChainID: 2, EndpointURL: second.URL, Priority: 1},
+	}, nil)
+	mockManager.On("UpdateEndpointHealth", mock.Anything, mock.Anything).Return(nil)
+
+	dispatcher := NewDispatcher(mockManager)
+
+	request := []byte(`{"jsonrpc":"2.0","method":"eth_sendRawTransaction","params":["0xf8"],"id":1}`)
+	response, err := dispatcher.Forward(context.Background(), 2, request)
+
+	// The body alone does not prove the transaction was not accepted
+	assert.NoError(t, err)
+	assert.Contains(t, string(response), `"rate limit exceeded"`)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&secondCalls))
+}
+
+func TestDispatcher_Forward_NoRetryForSendRawTransaction(t *testing.T) {
+	var secondCalls int32
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"internal error"}}`))
+	}))
+	defer failing.Close()
+
+	second := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&secondCalls, 1)
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0xhash"}`))
+	}))
+	defer second.Close()
+
+	mockManager := new(MockEndpointManager)
+	mockManager.On("GetActiveEndpoints", 2).Return([]models.RpcEndpoint{
+		{ID: 1, ChainID: 2, EndpointURL: failing.URL, Priority: 100},
+		{ID: 2, ChainID: 2, EndpointURL: second.URL, Priority: 1},
+	}, nil)
+	mockManager.On("UpdateEndpointHealth", 1, "error").Return(nil)
+
+	dispatcher := NewDispatcher(mockManager)
+
+	request := []byte(`{"jsonrpc":"2.0","method":"eth_sendRawTransaction","params":["0xf8"],"id":1}`)
+	response, err := dispatcher.Forward(context.Background(), 2, request)
+
+	// The upstream's error is returned instead of replaying the transaction elsewhere
+	assert.NoError(t, err)
+	assert.Contains(t, string(response), `"internal error"`)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&secondCalls))
+}
+
+func TestDispatcher_Forward_RetryBudget(t *testing.T) {
+	var calls int32
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	endpoints := make([]models.RpcEndpoint, 5)
+	for i := range endpoints {
+		endpoints[i] = models.RpcEndpoint{ID: i + 1, ChainID: 2, EndpointURL: failing.URL, Priority: 1}
+	}
+
+	mockManager := new(MockEndpointManager)
+	mockManager.On("GetActiveEndpoints", 2).Return(endpoints, nil)
+	mockManager.On("UpdateEndpointHealth", mock.Anything, "error").Return(nil)
+
+	dispatcher := NewDispatcher(mockManager)
+	dispatcher.SetRetryPolicy(RetryPolicy{MaxAttempts: 2})
+
+	request := []byte(`{"jsonrpc":"2.0","method":"eth_blockNumber","params":[],"id":1}`)
+	_, err := dispatcher.Forward(context.Background(), 2, request)
+
+	assert.Error(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestShouldRetry(t *testing.T) {
+	assert.True(t, shouldRetry(failureNotSent, false))
+	assert.False(t, shouldRetry(failureRetryable, false))
+	assert.True(t, shouldRetry(failureRetryable, true))
+	assert.False(t, shouldRetry(failureFatal, true))
+	assert.True(t, shouldRetry(failureRateLimited, false))
+	assert.False(t, shouldRetry(failureThrottled, false))
+	assert.True(t, shouldRetry(failureThrottled, true))
+}
+
+func TestChainAdapter_Idempotent(t *testing.T) {
+	adapters := NewAdapterRegistry(nil)
+	evm := adapters.Resolve(ProtocolEVM, true)
+	tendermint := adapters.Resolve(ProtocolTendermint, false)
+
+	assert.True(t, evm.Idempotent("eth_getTransactionByBlockNumberAndIndex"))
+	assert.True(t, evm.Idempotent("debug_traceTransaction"))
+	for _, method := range []string{"eth_sendRawTransaction", "eth_sendBundle", "eth_sendPrivateTransaction", "eth_newFilter", "eth_getFilterChanges", "eth_uninstallFilter", "debug_setHead", "unknown_method"} {
+		assert.False(t, evm.Idempotent(method), method)
+	}
+
+	assert.True(t, tendermint.Idempotent("abci_query"))
+	assert.False(t, tendermint.Idempotent("broadcast_tx_sync"))
+	assert.False(t, tendermint.Idempotent("eth_call"))
+
+	// Chains of unknown protocol accept the reads of every protocol
+	assert.True(t, adapters.Resolve(ProtocolJSONRPC, false).Idempotent("status"))
+	assert.False(t, adapters.Resolve(ProtocolJSONRPC, false).Idempotent("broadcast_tx_commit"))
+
+	assert.True(t, adapters.Resolve(ProtocolCosmosREST, false).Idempotent("/cosmos/bank/v1beta1/balances"))
+}
+
+func TestDispatcher_Forward_NoRetryForUnknownMethods(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	mockManager := new(MockEndpointManager)
+	mockManager.On("GetActiveEndpoints", 2).Return([]models.RpcEndpoint{
+		{ID: 1, ChainID: 2, EndpointURL: server.URL},
+		{ID: 2, ChainID: 2, EndpointURL: server.URL},
+	}, nil)
+	mockManager.On("UpdateEndpointHealth", mock.Anything, "error").Return(nil)
+
+	dispatcher := NewDispatcher(mockManager)
+	_, err := dispatcher.Forward(context.Background(), 2, []byte(`{"jsonrpc":"2.0","method":"eth_sendBundle","params":[],"id":1}`))
+
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestClassifyResponse(t *testing.T) {
+	assert.Equal(t, failureRateLimited, classifyResponse(http.StatusTooManyRequests, nil))
+	assert.Equal(t, failureThrottled, classifyResponse(http.StatusOK, []byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32005,"message":"Too Many Requests"}}`)))
+
+	// Limit errors that are not throttling are the client's answer
+	for _, body := range []string{
+		`{"jsonrpc":"2.0","id":1,"error":{"code":-32005,"message":"query returned more than 10000 results"}}`,
+		`{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"log response size exceeded"}}`,
+		`{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"txpool is full, capacity exceeded"}}`,
+	} {
+		assert.Equal(t, failureNone, classifyResponse(http.StatusOK, []byte(body)), body)
+	}
+
+	// A JSON error passes through, an error page of a proxy does not
+	assert.Equal(t, failureNone, classifyResponse(http.StatusBadRequest, []byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32602,"message":"invalid params"}}`)))
+	assert.Equal(t, failureRetryable, classifyResponse(http.StatusForbidden, []byte(`<html>Access denied</html>`)))
+}
@@ -0,0 +1,271 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/illegalcall/viper-client/internal/models"
+)
+
+// Endpoint capabilities referenced by routing rules
+const (
+	CapabilityArchive   = "archive"
+	CapabilityTrace     = "trace"
+	CapabilityDebug     = "debug"
+	CapabilityWebsocket = "websocket"
+)
+
+// Block conditions a routing rule may require of a call's block parameter
+const (
+	// BlockConditionAny matches every call
+	BlockConditionAny = "any"
+	// BlockConditionHistorical matches calls for blocks a pruned node may no longer have
+	BlockConditionHistorical = "historical"
+	// BlockConditionLatest matches calls for the latest state
+	BlockConditionLatest = "latest"
+)
+
+const (
+	// DefaultArchiveDepth is the number of recent blocks a non-archive node is assumed to keep state for
+	DefaultArchiveDepth = 128
+
+	// DefaultRoutingRulesRefreshInterval is how often routing rules are reloaded from the store
+	DefaultRoutingRulesRefreshInterval = time.Minute
+)
+
+// ErrNoCapableEndpoints is returned when no active endpoint has the capabilities a call requires
+var ErrNoCapableEndpoints = errors.New("no endpoints with the capabilities required for this method")
+
+// blockParamIndex is the position of the block parameter of methods that take one
+var blockParamIndex = map[string]int{
+	"eth_getBalance":          1,
+	"eth_getCode":             1,
+	"eth_getTransactionCount": 1,
+	"eth_call":                1,
+	"eth_estimateGas":         1,
+	"eth_getStorageAt":        2,
+	"eth_getProof":            2,
+	"eth_getBlockByNumber":    0,
+}
+
+// RoutingRuleStore provides the method routing rules
+type RoutingRuleStore interface {
+	ListRoutingRules() ([]models.RoutingRule, error)
+}
+
+// CapabilityRouter restricts calls to endpoints that have the capabilities required by
+// the routing rules matching the call
+type CapabilityRouter struct {
+	mu           sync.RWMutex
+	store        RoutingRuleStore
+	rules        []models.RoutingRule
+	heads        *SyncTracker
+	archiveDepth int64
+}
+
+// NewCapabilityRouter creates a router whose rules are loaded from the store
+func NewCapabilityRouter(store RoutingRuleStore) *CapabilityRouter {
+	return &CapabilityRouter{
+		store:        store,
+		archiveDepth: DefaultArchiveDepth,
+	}
+}
+
+// SetSyncTracker lets the router compare block numbers against the observed chain head.
+// Without it, or before a chain's head is known, block numbers do not count as historical.
+func (r *CapabilityRouter) SetSyncTracker(tracker *SyncTracker) {
+	r.heads = tracker
+}
+
+// SetRules replaces the routing rules
+func (r *CapabilityRouter) SetRules(rules []models.RoutingRule) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.rules = rules
+}
+
+// Reload loads the routing rules from the store
+func (r *CapabilityRouter) Reload() error {
+	rules, err := r.store.ListRoutingRules()
+	if err != nil {
+		return err
+	}
+	r.SetRules(rules)
+	return nil
+}
+
+// Start reloads the routing rules on every interval until the context is cancelled
+func (r *CapabilityRouter) Start(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultRoutingRulesRefreshInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Reload(); err != nil {
+				log.Printf("Failed to reload routing rules: %v", err)
+			}
+		}
+	}
+}
+
+// Required returns the capabilities an endpoint needs to serve the call
+func (r *CapabilityRouter) Required(chainID int, request RPCRequest) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var required []string
+	for _, rule := range r.rules {
+		if rule.ChainID != nil && *rule.ChainID != chainID {
+			continue
+		}
+		if matched, _ := path.Match(rule.MethodPattern, request.Method); !matched {
+			continue
+		}
+		if !r.blockConditionMatches(chainID, rule.BlockCondition, request) {
+			continue
+		}
+		for _, capability := range rule.RequiredCapabilities {
+			if !containsString(required, capability) {
+				required = append(required, capability)
+			}
+		}
+	}
+	return required
+}
+
+// Filter returns the endpoints able to serve every one of the requests. Chains whose endpoints
+// declare no capabilities at all are not routed, as nothing tells their endpoints apart.
+func (r *CapabilityRouter) Filter(chainID int, requests []RPCRequest, endpoints []models.RpcEndpoint) ([]models.RpcEndpoint, error) {
+	var required []string
+	for _, request := range requests {
+		for _, capability := range r.Required(chainID, request) {
+			if !containsString(required, capability) {
+				required = append(required, capability)
+			}
+		}
+	}
+	if len(required) == 0 || !anyCapabilities(endpoints) {
+		return endpoints, nil
+	}
+
+	var capable []models.RpcEndpoint
+	for _, endpoint := range endpoints {
+		if hasCapabilities(endpoint, required) {
+			capable = append(capable, endpoint)
+		}
+	}
+	if len(capable) == 0 {
+		return nil, ErrNoCapableEndpoints
+	}
+	return capable, nil
+}
+
+// blockConditionMatches reports whether the call's block parameter satisfies a rule's condition
+func (r *CapabilityRouter) blockConditionMatches(chainID int, condition string, request RPCRequest) bool {
+	switch condition {
+	case BlockConditionHistorical:
+		return r.isHistorical(chainID, request)
+	case BlockConditionLatest:
+		return !r.isHistorical(chainID, request)
+	default:
+		return true
+	}
+}
+
+// isHistorical reports whether the call reads state older than a pruned node keeps
+func (r *CapabilityRouter) isHistorical(chainID int, request RPCRequest) bool {
+	index, ok := blockParamIndex[request.Method]
+	if !ok {
+		return false
+	}
+	var params []json.RawMessage
+	if err := json.Unmarshal(request.Params, &params); err != nil || len(params) <= index {
+		// A missing block parameter defaults to "latest"
+		return false
+	}
+
+	var block string
+	if err := json.Unmarshal(params[index], &block); err != nil {
+		// EIP-1898 block objects: a hash cannot be placed relative to the head
+		var selector struct {
+			BlockNumber string `json:"blockNumber"`
+			BlockHash   string `json:"blockHash"`
+		}
+		if err := json.Unmarshal(params[index], &selector); err != nil {
+			return false
+		}
+		if selector.BlockNumber == "" {
+			return selector.BlockHash != ""
+		}
+		block = selector.BlockNumber
+	}
+
+	switch block {
+	case "latest", "pending", "safe", "finalized":
+		return false
+	case "earliest":
+		return true
+	}
+
+	number, err := strconv.ParseUint(strings.TrimPrefix(block, "0x"), 16, 64)
+	if err != nil {
+		return false
+	}
+	if r.heads == nil {
+		return false
+	}
+	head := r.heads.Head(chainID)
+	if head == 0 {
+		return false
+	}
+	return int64(number) < head-r.archiveDepth
+}
+
+// hasCapabilities reports whether the endpoint has every required capability
+func hasCapabilities(endpoint models.RpcEndpoint, required []string) bool {
+	for _, capability := range required {
+		if !containsString(endpoint.Capabilities, capability) {
+			return false
+		}
+	}
+	return true
+}
+
+// anyCapabilities reports whether any of the endpoints declares a capability
+func anyCapabilities(endpoints []models.RpcEndpoint) bool {
+	for _, endpoint := range endpoints {
+		if len(endpoint.Capabilities) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// containsString reports whether the value is in the list, ignoring case
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetCapabilityRouter restricts calls to endpoints with the capabilities their method requires
+func (d *Dispatcher) SetCapabilityRouter(router *CapabilityRouter) {
+	d.capabilities = router
+}
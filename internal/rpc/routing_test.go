@@ -0,0 +1,109 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/illegalcall/viper-client/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func testRoutingRules() []models.RoutingRule {
+	return []models.RoutingRule{
+		{MethodPattern: "trace_*", BlockCondition: BlockConditionAny, RequiredCapabilities: []string{CapabilityTrace}},
+		{MethodPattern: "debug_*", BlockCondition: BlockConditionAny, RequiredCapabilities: []string{CapabilityDebug}},
+		{MethodPattern: "eth_getBalance", BlockCondition: BlockConditionHistorical, RequiredCapabilities: []string{CapabilityArchive}},
+	}
+}
+
+func TestCapabilityRouter_Required(t *testing.T) {
+	tracker := NewSyncTracker(10)
+	tracker.Observe(2, 1, 1000)
+
+	router := NewCapabilityRouter(nil)
+	router.SetSyncTracker(tracker)
+	router.SetRules(testRoutingRules())
+
+	request := func(method, params string) RPCRequest {
+		return RPCRequest{Method: method, Params: json.RawMessage(params)}
+	}
+
+	assert.Equal(t, []string{CapabilityTrace}, router.Required(2, request("trace_block", `["0x1"]`)))
+	assert.Equal(t, []string{CapabilityDebug}, router.Required(2, request("debug_traceTransaction", `["0xabc"]`)))
+	assert.Empty(t, router.Required(2, request("eth_blockNumber", `[]`)))
+
+	assert.Empty(t, router.Required(2, request("eth_getBalance", `["0xabc","latest"]`)))
+	assert.Empty(t, router.Required(2, request("eth_getBalance", `["0xabc"]`)))
+	assert.Empty(t, router.Required(2, request("eth_getBalance", `["0xabc","0x3e0"]`)))
+	assert.Equal(t, []string{CapabilityArchive}, router.Required(2, request("eth_getBalance", `["0xabc","0x10"]`)))
+	assert.Equal(t, []string{CapabilityArchive}, router.Required(2, request("eth_getBalance", `["0xabc","earliest"]`)))
+	assert.Equal(t, []string{CapabilityArchive}, router.Required(2, request("eth_getBalance", `["0xabc",{"blockHash":"0xdef"}]`)))
+
+	// Without a known head block numbers cannot be placed, so they are not historical
+	assert.Empty(t, router.Required(137, request("eth_getBalance", `["0xabc","0x10"]`)))
+	router.SetSyncTracker(nil)
+	assert.Empty(t, router.Required(2, request("eth_getBalance", `["0xabc","0x10"]`)))
+}
+
+func TestCapabilityRouter_FilterWithoutTaggedEndpoints(t *testing.T) {
+	router := NewCapabilityRouter(nil)
+	router.SetRules(testRoutingRules())
+	trace := []RPCRequest{{Method: "trace_block", Params: json.RawMessage(`["0x1"]`)}}
+
+	// Endpoints declaring no capabilities serve every call, like before routing rules existed
+	untagged := []models.RpcEndpoint{{ID: 1}, {ID: 2}}
+	endpoints, err := router.Filter(2, trace, untagged)
+	assert.NoError(t, err)
+	assert.Equal(t, untagged, endpoints)
+
+	_, err = router.Filter(2, trace, []models.RpcEndpoint{{ID: 1}, {ID: 2, Capabilities: []string{CapabilityArchive}}})
+	assert.Equal(t, ErrNoCapableEndpoints, err)
+}
+
+func TestCapabilityRouter_ChainSpecificRules(t *testing.T) {
+	chainID := 137
+	router := NewCapabilityRouter(nil)
+	router.SetRules([]models.RoutingRule{
+		{ChainID: &chainID, MethodPattern: "bor_*", BlockCondition: BlockConditionAny, RequiredCapabilities: []string{CapabilityArchive}},
+	})
+
+	assert.Equal(t, []string{CapabilityArchive}, router.Required(137, RPCRequest{Method: "bor_getAuthor"}))
+	assert.Empty(t, router.Required(2, RPCRequest{Method: "bor_getAuthor"}))
+}
+
+func TestDispatcher_Forward_RoutesByCapability(t *testing.T) {
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"plain"}`))
+	}))
+	defer plain.Close()
+
+	tracing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"tracing"}`))
+	}))
+	defer tracing.Close()
+
+	mockManager := new(MockEndpointManager)
+	mockManager.On("GetActiveEndpoints", 2).Return([]models.RpcEndpoint{
+		{ID: 1, ChainID: 2, EndpointURL: plain.URL, Priority: 100},
+		{ID: 2, ChainID: 2, EndpointURL: tracing.URL, Priority: 1, Capabilities: []string{CapabilityTrace}},
+	}, nil)
+	mockManager.On("UpdateEndpointHealth", mock.Anything, mock.Anything).Return(nil)
+
+	router := NewCapabilityRouter(nil)
+	router.SetRules(testRoutingRules())
+	dispatcher := NewDispatcher(mockManager)
+	dispatcher.SetCapabilityRouter(router)
+
+	for i := 0; i < 3; i++ {
+		response, err := dispatcher.Forward(context.Background(), 2, []byte(`{"jsonrpc":"2.0","method":"trace_block","params":["0x1"],"id":1}`))
+		assert.NoError(t, err)
+		assert.Contains(t, string(response), `"tracing"`)
+	}
+
+	_, err := dispatcher.Forward(context.Background(), 2, []byte(`{"jsonrpc":"2.0","method":"debug_traceTransaction","params":["0xabc"],"id":1}`))
+	assert.Equal(t, ErrNoCapableEndpoints, err)
+}
@@ -0,0 +1,190 @@
+package rpc
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/illegalcall/viper-client/internal/models"
+)
+
+const (
+	// StrategyLatencyAware picks the endpoint with the best latency/error/priority score
+	StrategyLatencyAware = "latency_aware"
+
+	// DefaultEWMAAlpha is the smoothing factor applied to new latency and error samples
+	DefaultEWMAAlpha = 0.3
+
+	// initialLatency is assumed for endpoints that have not served any request yet,
+	// so new endpoints get a chance to receive traffic
+	initialLatency = 200 * time.Millisecond
+
+	// explorationRate is the share of requests sent to a random endpoint so the scores
+	// of endpoints that are currently losing still get refreshed
+	explorationRate = 0.05
+)
+
+// EndpointScore describes the moving averages and resulting score of one endpoint
+// @Description Latency-aware score of an RPC endpoint
+type EndpointScore struct {
+	EndpointID int       `json:"endpoint_id" example:"1"`
+	ChainID    int       `json:"chain_id" example:"2"`
+	Priority   int       `json:"priority" example:"10"`
+	LatencyMs  float64   `json:"latency_ms" example:"85.4"`
+	ErrorRate  float64   `json:"error_rate" example:"0.02"`
+	Samples    int64     `json:"samples" example:"1200"`
+	Score      float64   `json:"score" example:"114.7"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// endpointStats holds the moving averages for one endpoint
+type endpointStats struct {
+	chainID   int
+	priority  int
+	latency   float64 // milliseconds
+	errorRate float64
+	samples   int64
+	updatedAt time.Time
+}
+
+// EndpointScorer keeps an exponentially weighted moving average of latency and error rate per endpoint
+type EndpointScorer struct {
+	mu    sync.RWMutex
+	alpha float64
+	stats map[int]*endpointStats
+}
+
+// NewEndpointScorer creates a scorer with the given EWMA smoothing factor
+func NewEndpointScorer(alpha float64) *EndpointScorer {
+	if alpha <= 0 || alpha > 1 {
+		alpha = DefaultEWMAAlpha
+	}
+
+	return &EndpointScorer{
+		alpha: alpha,
+		stats: make(map[int]*endpointStats),
+	}
+}
+
+// Observe records the latency and outcome of a request to an endpoint
+func (s *EndpointScorer) Observe(endpoint models.RpcEndpoint, latency time.Duration, success bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sample := float64(latency) / float64(time.Millisecond)
+	errorSample := 0.0
+	if !success {
+		errorSample = 1.0
+	}
+
+	st, ok := s.stats[endpoint.ID]
+	if !ok {
+		st = &endpointStats{
+			latency:   sample,
+			errorRate: errorSample,
+		}
+		s.stats[endpoint.ID] = st
+	} else {
+		st.latency = s.alpha*sample + (1-s.alpha)*st.latency
+		st.errorRate = s.alpha*errorSample + (1-s.alpha)*st.errorRate
+	}
+
+	st.chainID = endpoint.ChainID
+	st.priority = endpoint.Priority
+	st.samples++
+	st.updatedAt = time.Now()
+}
+
+// Score returns the score of an endpoint; higher is better
+func (s *EndpointScorer) Score(endpoint models.RpcEndpoint) float64 {
+	latency, errorRate := s.observed(endpoint)
+	return computeScore(endpointWeight(endpoint), latency, errorRate)
+}
+
+// observed returns the average latency in milliseconds and the error rate of an endpoint,
+// assuming initialLatency and no errors for endpoints that have not served a request yet
+func (s *EndpointScorer) observed(endpoint models.RpcEndpoint) (float64, float64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if st, ok := s.stats[endpoint.ID]; ok {
+		return st.latency, st.errorRate
+	}
+	return float64(initialLatency) / float64(time.Millisecond), 0
+}
+
+// best returns the endpoint with the highest score
+func (s *EndpointScorer) best(endpoints []models.RpcEndpoint) models.RpcEndpoint {
+	best := endpoints[0]
+	bestScore := s.Score(best)
+	for _, endpoint := range endpoints[1:] {
+		if score := s.Score(endpoint); score > bestScore {
+			best = endpoint
+			bestScore = score
+		}
+	}
+	return best
+}
+
+// computeScore combines priority weight, latency and error rate into a single score.
+// The score is proportional to the weight, inversely proportional to latency and drops
+// quadratically as the error rate approaches one.
+func computeScore(weight int, latencyMs, errorRate float64) float64 {
+	if latencyMs < 1 {
+		latencyMs = 1
+	}
+	success := 1 - errorRate
+	return float64(weight) * success * success * 1000 / latencyMs
+}
+
+// Scores returns the current score of every observed endpoint, ordered by endpoint ID
+func (s *EndpointScorer) Scores() []EndpointScore {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	scores := make([]EndpointScore, 0, len(s.stats))
+	for id, st := range s.stats {
+		scores = append(scores, EndpointScore{
+			EndpointID: id,
+			ChainID:    st.chainID,
+			Priority:   st.priority,
+			LatencyMs:  st.latency,
+			ErrorRate:  st.errorRate,
+			Samples:    st.samples,
+			Score:      computeScore(endpointWeight(models.RpcEndpoint{Priority: st.priority}), st.latency, st.errorRate),
+			UpdatedAt:  st.updatedAt,
+		})
+	}
+
+	sort.Slice(scores, func(i, j int) bool {
+		return scores[i].EndpointID < scores[j].EndpointID
+	})
+	return scores
+}
+
+// LatencyAwareStrategy picks the endpoint with the highest score, occasionally exploring others
+type LatencyAwareStrategy struct {
+	scorer *EndpointScorer
+}
+
+// NewLatencyAwareStrategy creates a new latency-aware strategy backed by the given scorer
+func NewLatencyAwareStrategy(scorer *EndpointScorer) *LatencyAwareStrategy {
+	return &LatencyAwareStrategy{
+		scorer: scorer,
+	}
+}
+
+// Name returns the configuration name of the strategy
+func (s *LatencyAwareStrategy) Name() string {
+	return StrategyLatencyAware
+}
+
+// Select returns the best scoring endpoint
+func (s *LatencyAwareStrategy) Select(endpoints []models.RpcEndpoint) models.RpcEndpoint {
+	if len(endpoints) > 1 && rand.Float64() < explorationRate {
+		return endpoints[rand.Intn(len(endpoints))]
+	}
+
+	return s.scorer.best(endpoints)
+}
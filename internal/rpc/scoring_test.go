@@ -0,0 +1,56 @@
+package rpc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/illegalcall/viper-client/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEndpointScorer_EWMA(t *testing.T) {
+	scorer := NewEndpointScorer(0.5)
+	endpoint := models.RpcEndpoint{ID: 1, ChainID: 2, Priority: 1}
+
+	scorer.Observe(endpoint, 100*time.Millisecond, true)
+	scorer.Observe(endpoint, 200*time.Millisecond, false)
+
+	scores := scorer.Scores()
+	assert.Len(t, scores, 1)
+	assert.InDelta(t, 150, scores[0].LatencyMs, 0.001)
+	assert.InDelta(t, 0.5, scores[0].ErrorRate, 0.001)
+	assert.Equal(t, int64(2), scores[0].Samples)
+}
+
+func TestLatencyAwareStrategy_PrefersFastEndpoint(t *testing.T) {
+	scorer := NewEndpointScorer(DefaultEWMAAlpha)
+	fast := models.RpcEndpoint{ID: 1, Priority: 1}
+	slow := models.RpcEndpoint{ID: 2, Priority: 1}
+
+	for i := 0; i < 5; i++ {
+		scorer.Observe(fast, 20*time.Millisecond, true)
+		scorer.Observe(slow, 400*time.Millisecond, true)
+	}
+
+	assert.Greater(t, scorer.Score(fast), scorer.Score(slow))
+
+	strategy := NewLatencyAwareStrategy(scorer)
+	counts := make(map[int]int)
+	for i := 0; i < 200; i++ {
+		counts[strategy.Select([]models.RpcEndpoint{slow, fast}).ID]++
+	}
+	assert.Greater(t, counts[1], counts[2])
+}
+
+func TestEndpointScorer_ErrorsOutweighPriority(t *testing.T) {
+	scorer := NewEndpointScorer(DefaultEWMAAlpha)
+	flaky := models.RpcEndpoint{ID: 1, Priority: 10}
+	stable := models.RpcEndpoint{ID: 2, Priority: 5}
+
+	for i := 0; i < 10; i++ {
+		scorer.Observe(flaky, 50*time.Millisecond, false)
+		scorer.Observe(stable, 50*time.Millisecond, true)
+	}
+
+	assert.Greater(t, scorer.Score(stable), scorer.Score(flaky))
+}
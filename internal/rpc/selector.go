@@ -0,0 +1,212 @@
+package rpc
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+
+	"github.com/illegalcall/viper-client/internal/models"
+)
+
+const (
+	// StrategyWeightedRoundRobin distributes requests proportionally to endpoint priority
+	StrategyWeightedRoundRobin = "weighted_round_robin"
+	// StrategyLeastOutstanding picks the endpoint with the fewest in-flight requests
+	StrategyLeastOutstanding = "least_outstanding"
+	// StrategyRandomTwoChoices samples two endpoints and picks the less loaded one
+	StrategyRandomTwoChoices = "random_two_choices"
+
+	// DefaultSelectionStrategy is used for chains without an explicit strategy
+	DefaultSelectionStrategy = StrategyWeightedRoundRobin
+)
+
+// SelectionStrategy chooses one endpoint out of the active endpoints for a chain
+type SelectionStrategy interface {
+	// Name returns the configuration name of the strategy
+	Name() string
+	// Select returns the endpoint that should serve the next request.
+	// The endpoints slice is never empty.
+	Select(endpoints []models.RpcEndpoint) models.RpcEndpoint
+}
+
+// LoadReporter reports the number of in-flight requests per endpoint
+type LoadReporter interface {
+	Outstanding(endpointID int) int64
+}
+
+// NewSelectionStrategy creates a strategy by its configuration name
+func NewSelectionStrategy(name string, load LoadReporter, scorer *EndpointScorer) (SelectionStrategy, error) {
+	switch name {
+	case StrategyWeightedRoundRobin, "":
+		return NewWeightedRoundRobinStrategy(), nil
+	case StrategyLeastOutstanding:
+		return NewLeastOutstandingStrategy(load), nil
+	case StrategyRandomTwoChoices:
+		return NewRandomTwoChoicesStrategy(load), nil
+	case StrategyLatencyAware:
+		return NewLatencyAwareStrategy(scorer), nil
+	case StrategyCostAware:
+		return NewCostAwareStrategy(scorer, DefaultCostPolicy()), nil
+	default:
+		return nil, fmt.Errorf("unknown selection strategy: %s", name)
+	}
+}
+
+// endpointWeight returns the weight of an endpoint derived from its priority
+func endpointWeight(endpoint models.RpcEndpoint) int {
+	if endpoint.Priority < 1 {
+		return 1
+	}
+	return endpoint.Priority
+}
+
+// WeightedRoundRobinStrategy implements smooth weighted round-robin using RpcEndpoint.Priority as weight
+type WeightedRoundRobinStrategy struct {
+	mu      sync.Mutex
+	current map[int]int
+}
+
+// NewWeightedRoundRobinStrategy creates a new weighted round-robin strategy
+func NewWeightedRoundRobinStrategy() *WeightedRoundRobinStrategy {
+	return &WeightedRoundRobinStrategy{
+		current: make(map[int]int),
+	}
+}
+
+// Name returns the configuration name of the strategy
+func (s *WeightedRoundRobinStrategy) Name() string {
+	return StrategyWeightedRoundRobin
+}
+
+// Select returns the next endpoint in the weighted rotation
+func (s *WeightedRoundRobinStrategy) Select(endpoints []models.RpcEndpoint) models.RpcEndpoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	total := 0
+	best := -1
+	for i, endpoint := range endpoints {
+		weight := endpointWeight(endpoint)
+		total += weight
+		s.current[endpoint.ID] += weight
+		if best == -1 || s.current[endpoint.ID] > s.current[endpoints[best].ID] {
+			best = i
+		}
+	}
+
+	s.current[endpoints[best].ID] -= total
+	return endpoints[best]
+}
+
+// LeastOutstandingStrategy picks the endpoint with the fewest in-flight requests,
+// breaking ties by priority
+type LeastOutstandingStrategy struct {
+	load LoadReporter
+}
+
+// NewLeastOutstandingStrategy creates a new least-outstanding-requests strategy
+func NewLeastOutstandingStrategy(load LoadReporter) *LeastOutstandingStrategy {
+	return &LeastOutstandingStrategy{
+		load: load,
+	}
+}
+
+// Name returns the configuration name of the strategy
+func (s *LeastOutstandingStrategy) Name() string {
+	return StrategyLeastOutstanding
+}
+
+// Select returns the least loaded endpoint
+func (s *LeastOutstandingStrategy) Select(endpoints []models.RpcEndpoint) models.RpcEndpoint {
+	best := endpoints[0]
+	bestLoad := s.load.Outstanding(best.ID)
+	for _, endpoint := range endpoints[1:] {
+		load := s.load.Outstanding(endpoint.ID)
+		if load < bestLoad || (load == bestLoad && endpoint.Priority > best.Priority) {
+			best = endpoint
+			bestLoad = load
+		}
+	}
+	return best
+}
+
+// RandomTwoChoicesStrategy samples two random endpoints and picks the one with fewer in-flight requests
+type RandomTwoChoicesStrategy struct {
+	load LoadReporter
+}
+
+// NewRandomTwoChoicesStrategy creates a new power-of-two-choices strategy
+func NewRandomTwoChoicesStrategy(load LoadReporter) *RandomTwoChoicesStrategy {
+	return &RandomTwoChoicesStrategy{
+		load: load,
+	}
+}
+
+// Name returns the configuration name of the strategy
+func (s *RandomTwoChoicesStrategy) Name() string {
+	return StrategyRandomTwoChoices
+}
+
+// Select returns the less loaded of two randomly sampled endpoints
+func (s *RandomTwoChoicesStrategy) Select(endpoints []models.RpcEndpoint) models.RpcEndpoint {
+	if len(endpoints) == 1 {
+		return endpoints[0]
+	}
+
+	i := rand.Intn(len(endpoints))
+	j := rand.Intn(len(endpoints) - 1)
+	if j >= i {
+		j++
+	}
+
+	first, second := endpoints[i], endpoints[j]
+	if s.load.Outstanding(second.ID) < s.load.Outstanding(first.ID) {
+		return second
+	}
+	return first
+}
+
+// loadTracker counts in-flight requests per endpoint
+type loadTracker struct {
+	mu       sync.RWMutex
+	counters map[int]*int64
+}
+
+func newLoadTracker() *loadTracker {
+	return &loadTracker{
+		counters: make(map[int]*int64),
+	}
+}
+
+func (t *loadTracker) counter(endpointID int) *int64 {
+	t.mu.RLock()
+	c, ok := t.counters[endpointID]
+	t.mu.RUnlock()
+	if ok {
+		return c
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c, ok = t.counters[endpointID]; !ok {
+		c = new(int64)
+		t.counters[endpointID] = c
+	}
+	return c
+}
+
+// Acquire marks the start of a request to the endpoint
+func (t *loadTracker) Acquire(endpointID int) {
+	atomic.AddInt64(t.counter(endpointID), 1)
+}
+
+// Release marks the end of a request to the endpoint
+func (t *loadTracker) Release(endpointID int) {
+	atomic.AddInt64(t.counter(endpointID), -1)
+}
+
+// Outstanding returns the number of in-flight requests for the endpoint
+func (t *loadTracker) Outstanding(endpointID int) int64 {
+	return atomic.LoadInt64(t.counter(endpointID))
+}
@@ -0,0 +1,89 @@
+package rpc
+
+import (
+	"testing"
+
+	"github.com/illegalcall/viper-client/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// staticLoad is a LoadReporter backed by a fixed map
+type staticLoad map[int]int64
+
+func (l staticLoad) Outstanding(endpointID int) int64 {
+	return l[endpointID]
+}
+
+func TestWeightedRoundRobinStrategy_Distribution(t *testing.T) {
+	strategy := NewWeightedRoundRobinStrategy()
+	endpoints := []models.RpcEndpoint{
+		{ID: 1, Priority: 3},
+		{ID: 2, Priority: 1},
+	}
+
+	counts := make(map[int]int)
+	for i := 0; i < 8; i++ {
+		counts[strategy.Select(endpoints).ID]++
+	}
+
+	// Traffic should be split proportionally to priority
+	assert.Equal(t, 6, counts[1])
+	assert.Equal(t, 2, counts[2])
+}
+
+func TestWeightedRoundRobinStrategy_ZeroPriority(t *testing.T) {
+	strategy := NewWeightedRoundRobinStrategy()
+	endpoints := []models.RpcEndpoint{
+		{ID: 1, Priority: 0},
+		{ID: 2, Priority: 0},
+	}
+
+	counts := make(map[int]int)
+	for i := 0; i < 4; i++ {
+		counts[strategy.Select(endpoints).ID]++
+	}
+
+	// Endpoints without a priority should still receive traffic
+	assert.Equal(t, 2, counts[1])
+	assert.Equal(t, 2, counts[2])
+}
+
+func TestLeastOutstandingStrategy_Select(t *testing.T) {
+	strategy := NewLeastOutstandingStrategy(staticLoad{1: 5, 2: 1, 3: 1})
+	endpoints := []models.RpcEndpoint{
+		{ID: 1, Priority: 10},
+		{ID: 2, Priority: 1},
+		{ID: 3, Priority: 5},
+	}
+
+	// Endpoint 3 ties with 2 on load but has the higher priority
+	assert.Equal(t, 3, strategy.Select(endpoints).ID)
+}
+
+func TestRandomTwoChoicesStrategy_Select(t *testing.T) {
+	strategy := NewRandomTwoChoicesStrategy(staticLoad{1: 100, 2: 0})
+	endpoints := []models.RpcEndpoint{
+		{ID: 1},
+		{ID: 2},
+	}
+
+	// With two endpoints both are always sampled, so the idle one wins
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, 2, strategy.Select(endpoints).ID)
+	}
+}
+
+func TestNewSelectionStrategy_Unknown(t *testing.T) {
+	_, err := NewSelectionStrategy("fastest", newLoadTracker(), NewEndpointScorer(0))
+	assert.Error(t, err)
+}
+
+func TestDispatcher_SetChainStrategy(t *testing.T) {
+	dispatcher := NewDispatcher(new(MockEndpointManager))
+
+	assert.NoError(t, dispatcher.SetChainStrategy(2, StrategyLeastOutstanding))
+	assert.Error(t, dispatcher.SetChainStrategy(3, "unknown"))
+
+	assert.Equal(t, StrategyLeastOutstanding, dispatcher.strategyFor(2).Name())
+	assert.Equal(t, DefaultSelectionStrategy, dispatcher.strategyFor(3).Name())
+}
@@ -0,0 +1,297 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/illegalcall/viper-client/internal/models"
+)
+
+const (
+	// DefaultMaxResponseSize is the largest upstream response body relayed to a client
+	DefaultMaxResponseSize int64 = 32 << 20
+
+	// streamPeekSize is how much of a streamed response is read before committing to it.
+	// Responses that fit are classified like buffered ones, so small errors still fail over.
+	streamPeekSize = 4096
+)
+
+// DefaultStreamMethods are the methods whose responses are piped to the client instead of buffered
+var DefaultStreamMethods = []string{
+	"eth_getLogs",
+	"eth_getBlockReceipts",
+	"debug_trace*",
+	"trace_*",
+}
+
+// ErrResponseTooLarge is returned when an upstream response exceeds the maximum response size
+var ErrResponseTooLarge = errors.New("upstream response exceeds the maximum response size")
+
+// SetMaxResponseSize sets the largest upstream response body relayed to a client, for both
+// regular chains and the Viper Network
+func (d *Dispatcher) SetMaxResponseSize(limit int64) {
+	if limit > 0 {
+		d.maxResponseSize = limit
+		d.viperNetworkHandler.SetMaxResponseSize(limit)
+	}
+}
+
+// SetStreamMethods sets the method patterns, such as "trace_*", whose responses are streamed
+func (d *Dispatcher) SetStreamMethods(patterns []string) {
+	d.streamMethods = patterns
+}
+
+// Streamable reports whether the response to a request is piped to the client by ForwardStream.
+// Batches, Viper Network calls, consensus reads and cacheable calls are always buffered.
+func (d *Dispatcher) Streamable(ctx context.Context, chainID int, requestBody []byte) bool {
+	if chainID == ViperNetworkChainID || IsBatchRequest(requestBody) {
+		return false
+	}
+
+	adapter := d.adapters.ForChain(chainID)
+	rpcRequest, err := adapter.ParseRequest(requestBody)
+	if err != nil {
+		return false
+	}
+	// Responses outside JSON-RPC envelopes never go through the cache
+	if !adapter.JSONRPC() {
+		return true
+	}
+
+	if consensusFromContext(ctx) > 1 || (d.cache != nil && d.cache.cacheable(rpcRequest.Method)) {
+		return false
+	}
+	for _, pattern := range d.streamMethods {
+		if matched, _ := path.Match(pattern, rpcRequest.Method); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// ForwardStream forwards a request and copies the chosen endpoint's response to the writer
+// returned by open, without holding the whole response in memory. open is only called once
+// an endpoint has answered successfully; until then failures are retried on other endpoints
+// and returned like Forward's. It returns the number of response bytes written.
+func (d *Dispatcher) ForwardStream(ctx context.Context, chainID int, requestBody []byte, open func() io.Writer) (int64, error) {
+	rpcRequest, err := d.adapters.ForChain(chainID).ParseRequest(requestBody)
+	if err != nil {
+		return 0, err
+	}
+
+	endpoints, err := d.candidateEndpoints(ctx, chainID, rpcRequest)
+	if err != nil {
+		return 0, err
+	}
+
+	d.retryMu.RLock()
+	maxAttempts := d.retryPolicy.MaxAttempts
+	d.retryMu.RUnlock()
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	strategy := d.strategyFor(chainID)
+	remaining := append([]models.RpcEndpoint(nil), endpoints...)
+
+	var lastErr error
+	var lastBody []byte
+	for attempt := 0; attempt < maxAttempts && len(remaining) > 0; attempt++ {
+		if err := ctx.Err(); err != nil {
+			if lastErr == nil {
+				lastErr = err
+			}
+			break
+		}
+
+		selectedEndpoint := selectEndpoint(strategy, rpcRequest.Method, remaining)
+		remaining = removeEndpoint(remaining, selectedEndpoint.ID)
+
+		result := d.streamAttempt(ctx, selectedEndpoint, requestBody, open)
+		if result.kind == failureNone || result.written > 0 {
+			return result.written, result.err
+		}
+
+		lastErr, lastBody = result.err, result.body
+		if !shouldRetry(result.kind, d.idempotent(chainID, rpcRequest.Method)) {
+			if result.body == nil {
+				return 0, result.err
+			}
+			break
+		}
+
+		if result.kind.throttled() && len(remaining) == 0 && d.limits.wait(ctx, selectedEndpoint.ID) {
+			remaining = append(remaining, selectedEndpoint)
+		}
+	}
+
+	// Prefer handing the client the upstream's own answer over a generic error
+	if len(lastBody) > 0 {
+		written, err := open().Write(lastBody)
+		return int64(written), err
+	}
+	return 0, lastErr
+}
+
+// streamResult is the outcome of streaming a request from a single endpoint
+type streamResult struct {
+	// written is the number of bytes copied to the client, set once the response was committed to
+	written int64
+	// body is the complete response of a failed attempt small enough to be read up front
+	body []byte
+	err  error
+	kind failureKind
+}
+
+// streamAttempt sends the request to one endpoint and, unless the answer is a failure that can
+// be retried elsewhere, copies the response to the writer returned by open. The endpoint scores
+// and health status are fed like in attempt.
+func (d *Dispatcher) streamAttempt(ctx context.Context, endpoint models.RpcEndpoint, requestBody []byte, open func() io.Writer) streamResult {
+	release, ok := d.breakers.AcquireTrial(endpoint.ID)
+	if !ok {
+		return streamResult{err: errBreakerTrialsBusy, kind: failureNotSent}
+	}
+	defer release()
+
+	if !d.limits.Acquire(endpoint) {
+		return streamResult{err: errEndpointLimited, kind: failureNotSent}
+	}
+	d.load.Acquire(endpoint.ID)
+	defer d.load.Release(endpoint.ID)
+
+	start := time.Now()
+	req, err := d.adapters.ForChain(endpoint.ChainID).NewUpstreamRequest(ctx, endpoint.EndpointURL, requestBody)
+	if err != nil {
+		return streamResult{err: err, kind: failureFatal}
+	}
+
+	resp, err := authenticatedClient(d.httpClient, endpoint).Do(req)
+	if err != nil {
+		return d.streamFailed(ctx, endpoint, start, redactError(err))
+	}
+	defer resp.Body.Close()
+
+	// A response announced as too large is refused before anything is read
+	if resp.ContentLength > d.maxResponseSize {
+		return streamResult{err: ErrResponseTooLarge, kind: failureFatal}
+	}
+
+	head := make([]byte, streamPeekSize)
+	n, err := io.ReadFull(resp.Body, head)
+	head = head[:n]
+	complete := err == io.EOF || err == io.ErrUnexpectedEOF
+	if err != nil && !complete {
+		return d.streamFailed(ctx, endpoint, start, err)
+	}
+
+	if int64(len(head)) > d.maxResponseSize {
+		return streamResult{err: ErrResponseTooLarge, kind: failureFatal}
+	}
+	// Only successful answers are streamed, anything else is read in full and judged like a
+	// buffered response, so that an error page never reaches the client as a result
+	if !complete && (resp.StatusCode < 200 || resp.StatusCode >= 300) {
+		rest, err := readResponse(resp.Body, d.maxResponseSize-int64(len(head)))
+		if errors.Is(err, ErrResponseTooLarge) {
+			return streamResult{err: err, kind: failureFatal}
+		}
+		if err != nil {
+			return d.streamFailed(ctx, endpoint, start, err)
+		}
+		head = append(head, rest...)
+		complete = true
+	}
+	// Providers bill every answered call except the ones they throttled
+	if resp.StatusCode != http.StatusTooManyRequests && !isRateLimitBody(head) {
+		d.recordSpend(ctx, endpoint, requestBody)
+	}
+
+	if complete {
+		kind := classifyResponse(resp.StatusCode, head)
+		if kind != failureNone {
+			d.scorer.Observe(endpoint, time.Since(start), false)
+			if kind.throttled() {
+				d.throttled(endpoint, resp.Header)
+			} else {
+				d.endpointManager.UpdateEndpointHealth(endpoint.ID, "error")
+			}
+			return streamResult{
+				body: upstreamAnswer(head),
+				err:  fmt.Errorf("endpoint %d returned status %d", endpoint.ID, resp.StatusCode),
+				kind: kind,
+			}
+		}
+	}
+
+	// From here on the response belongs to the client, so failures are no longer retried
+	w := open()
+	written, err := w.Write(head)
+	total := int64(written)
+	if err == nil && !complete {
+		var copied int64
+		copied, err = io.Copy(w, limitResponse(resp.Body, d.maxResponseSize-total))
+		total += copied
+	}
+	latency := time.Since(start)
+
+	switch {
+	case err == nil:
+		d.scorer.Observe(endpoint, latency, true)
+		d.hedging.observe(endpoint.ChainID, latency)
+		d.endpointManager.UpdateEndpointHealth(endpoint.ID, "healthy")
+		return streamResult{written: total, kind: failureNone}
+	case errors.Is(err, ErrResponseTooLarge):
+		return streamResult{written: total, err: err, kind: failureFatal}
+	default:
+		if ctx.Err() == nil {
+			d.scorer.Observe(endpoint, latency, false)
+			d.endpointManager.UpdateEndpointHealth(endpoint.ID, "error")
+		}
+		return streamResult{written: total, err: err, kind: failureFatal}
+	}
+}
+
+// streamFailed records a transport failure that happened before the response was committed to
+func (d *Dispatcher) streamFailed(ctx context.Context, endpoint models.RpcEndpoint, start time.Time, err error) streamResult {
+	kind := classifyTransportError(ctx, err)
+	if ctx.Err() == nil {
+		d.scorer.Observe(endpoint, time.Since(start), false)
+		d.endpointManager.UpdateEndpointHealth(endpoint.ID, "error")
+	}
+	return streamResult{err: err, kind: kind}
+}
+
+// responseLimiter reads at most limit bytes, failing with ErrResponseTooLarge beyond that
+type responseLimiter struct {
+	reader    io.Reader
+	remaining int64
+}
+
+// limitResponse wraps an upstream response body so that reading more than limit bytes fails
+func limitResponse(body io.Reader, limit int64) io.Reader {
+	return &responseLimiter{reader: body, remaining: limit}
+}
+
+func (l *responseLimiter) Read(p []byte) (int, error) {
+	// One byte past the limit is enough to tell that the body is too large
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.reader.Read(p)
+	if int64(n) > l.remaining {
+		n = int(l.remaining)
+		l.remaining = 0
+		return n, ErrResponseTooLarge
+	}
+	l.remaining -= int64(n)
+	return n, err
+}
+
+// readResponse reads a whole upstream response body of at most limit bytes
+func readResponse(body io.Reader, limit int64) ([]byte, error) {
+	return io.ReadAll(limitResponse(body, limit))
+}
@@ -0,0 +1,136 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/illegalcall/viper-client/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// largeLogsResponse is an eth_getLogs response well past the peek size
+var largeLogsResponse = `{"jsonrpc":"2.0","id":1,"result":["` + strings.Repeat("ab", 10000) + `"]}`
+
+func TestDispatcher_Streamable(t *testing.T) {
+	dispatcher := NewDispatcher(new(MockEndpointManager))
+	dispatcher.SetResponseCache(NewResponseCache(10))
+
+	assert.True(t, dispatcher.Streamable(context.Background(), 2, []byte(`{"jsonrpc":"2.0","method":"eth_getLogs","params":[{}],"id":1}`)))
+	assert.True(t, dispatcher.Streamable(context.Background(), 2, []byte(`{"jsonrpc":"2.0","method":"debug_traceTransaction","params":["0x1"],"id":1}`)))
+	assert.False(t, dispatcher.Streamable(context.Background(), 2, []byte(`{"jsonrpc":"2.0","method":"eth_chainId","params":[],"id":1}`)))
+	assert.False(t, dispatcher.Streamable(context.Background(), 2, []byte(`[{"jsonrpc":"2.0","method":"eth_getLogs","params":[{}],"id":1}]`)))
+	assert.False(t, dispatcher.Streamable(WithConsensus(context.Background(), 3), 2, []byte(`{"jsonrpc":"2.0","method":"eth_getLogs","params":[{}],"id":1}`)))
+	assert.False(t, dispatcher.Streamable(context.Background(), ViperNetworkChainID, []byte(`{"jsonrpc":"2.0","method":"eth_getLogs","params":[{}],"id":1}`)))
+}
+
+func TestDispatcher_ForwardStream(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(largeLogsResponse))
+	}))
+	defer server.Close()
+
+	mockManager := new(MockEndpointManager)
+	mockManager.On("GetActiveEndpoints", 2).Return([]models.RpcEndpoint{
+		{ID: 1, ChainID: 2, EndpointURL: failing.URL, Priority: 100},
+		{ID: 2, ChainID: 2, EndpointURL: server.URL, Priority: 1},
+	}, nil)
+	mockManager.On("UpdateEndpointHealth", mock.Anything, mock.Anything).Return(nil)
+
+	dispatcher := NewDispatcher(mockManager)
+
+	var response bytes.Buffer
+	opened := 0
+	written, err := dispatcher.ForwardStream(context.Background(), 2, []byte(`{"jsonrpc":"2.0","method":"eth_getLogs","params":[{}],"id":1}`), func() io.Writer {
+		opened++
+		return &response
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, opened)
+	assert.Equal(t, int64(len(largeLogsResponse)), written)
+	assert.Equal(t, largeLogsResponse, response.String())
+	mockManager.AssertCalled(t, "UpdateEndpointHealth", 1, "error")
+	mockManager.AssertCalled(t, "UpdateEndpointHealth", 2, "healthy")
+}
+
+func TestDispatcher_ForwardStream_TooLarge(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Flushing first sends the body chunked, without a Content-Length
+		w.(http.Flusher).Flush()
+		w.Write([]byte(largeLogsResponse))
+	}))
+	defer server.Close()
+
+	mockManager := new(MockEndpointManager)
+	mockManager.On("GetActiveEndpoints", 2).Return([]models.RpcEndpoint{
+		{ID: 1, ChainID: 2, EndpointURL: server.URL, Priority: 1},
+	}, nil)
+	mockManager.On("UpdateEndpointHealth", mock.Anything, mock.Anything).Return(nil)
+
+	dispatcher := NewDispatcher(mockManager)
+	dispatcher.SetMaxResponseSize(10000)
+
+	var response bytes.Buffer
+	written, err := dispatcher.ForwardStream(context.Background(), 2, []byte(`{"jsonrpc":"2.0","method":"eth_getLogs","params":[{}],"id":1}`), func() io.Writer {
+		return &response
+	})
+	assert.Equal(t, ErrResponseTooLarge, err)
+	assert.Equal(t, int64(10000), written)
+	assert.Equal(t, 10000, response.Len())
+	mockManager.AssertNotCalled(t, "UpdateEndpointHealth", 1, "error")
+
+	// The buffered path refuses the same response without answering
+	_, err = dispatcher.Forward(context.Background(), 2, []byte(`{"jsonrpc":"2.0","method":"eth_getLogs","params":[{}],"id":1}`))
+	assert.Equal(t, ErrResponseTooLarge, err)
+}
+
+func TestDispatcher_ForwardStream_ErrorPage(t *testing.T) {
+	errorPage := "<html><body>" + strings.Repeat("Access denied. ", 1000) + "</body></html>"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(errorPage))
+	}))
+	defer server.Close()
+
+	mockManager := new(MockEndpointManager)
+	mockManager.On("GetActiveEndpoints", 2).Return([]models.RpcEndpoint{
+		{ID: 1, ChainID: 2, EndpointURL: server.URL, Priority: 1},
+	}, nil)
+	mockManager.On("UpdateEndpointHealth", mock.Anything, mock.Anything).Return(nil)
+
+	dispatcher := NewDispatcher(mockManager)
+
+	opened := 0
+	written, err := dispatcher.ForwardStream(context.Background(), 2, []byte(`{"jsonrpc":"2.0","method":"eth_getLogs","params":[{}],"id":1}`), func() io.Writer {
+		opened++
+		return io.Discard
+	})
+	assert.EqualError(t, err, "endpoint 1 returned status 403")
+	assert.Zero(t, written)
+	assert.Zero(t, opened)
+	mockManager.AssertCalled(t, "UpdateEndpointHealth", 1, "error")
+
+	// The buffered path refuses the same response
+	_, err = dispatcher.Forward(context.Background(), 2, []byte(`{"jsonrpc":"2.0","method":"eth_getLogs","params":[{}],"id":1}`))
+	assert.EqualError(t, err, "endpoint 1 returned status 403")
+}
+
+func TestReadResponse(t *testing.T) {
+	body, err := readResponse(strings.NewReader("0123456789"), 10)
+	assert.NoError(t, err)
+	assert.Equal(t, "0123456789", string(body))
+
+	_, err = readResponse(strings.NewReader("0123456789a"), 10)
+	assert.Equal(t, ErrResponseTooLarge, err)
+}
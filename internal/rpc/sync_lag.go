@@ -0,0 +1,258 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/illegalcall/viper-client/internal/models"
+)
+
+const (
+	// DefaultMaxBlockLag is the number of blocks an endpoint may trail the chain head before it is excluded
+	DefaultMaxBlockLag = 10
+
+	// syncObservationMaxAge is how long a height observation is trusted. Endpoints whose
+	// last observation is older are routed to again until a fresh observation arrives.
+	syncObservationMaxAge = 5 * time.Minute
+)
+
+// EndpointLag describes how far an endpoint trails the head of its chain
+// @Description Block height and sync lag of an RPC endpoint
+type EndpointLag struct {
+	EndpointID int `json:"endpoint_id" example:"1"`
+	ChainID    int `json:"chain_id" example:"2"`
+	// Highest block number observed from the endpoint
+	BlockHeight int64 `json:"block_height" example:"18250000"`
+	// Highest block number observed from any endpoint of the chain
+	ChainHead int64 `json:"chain_head" example:"18250003"`
+	// Blocks behind the chain head at the time of the endpoint's last observation
+	Lag int64 `json:"lag" example:"3"`
+	// Whether the endpoint is currently excluded from selection
+	Excluded   bool      `json:"excluded" example:"false"`
+	ObservedAt time.Time `json:"observed_at"`
+}
+
+// endpointHeight is the latest height observation of one endpoint
+type endpointHeight struct {
+	height     int64
+	lag        int64
+	observedAt time.Time
+}
+
+// SyncTracker tracks the highest block observed per endpoint and excludes endpoints
+// that trail their chain's head by more than the allowed lag.
+//
+// An endpoint's lag is computed when its height is observed, against the heights the
+// other endpoints reported up to that moment. Comparing against later observations
+// would make an endpoint look stale merely because it was not asked recently.
+type SyncTracker struct {
+	mu          sync.Mutex
+	maxLag      int64
+	chainMaxLag map[int]int64
+	chains      map[int]map[int]*endpointHeight
+	now         func() time.Time
+}
+
+// NewSyncTracker creates a tracker excluding endpoints more than maxLag blocks behind
+func NewSyncTracker(maxLag int64) *SyncTracker {
+	if maxLag <= 0 {
+		maxLag = DefaultMaxBlockLag
+	}
+
+	return &SyncTracker{
+		maxLag:      maxLag,
+		chainMaxLag: make(map[int]int64),
+		chains:      make(map[int]map[int]*endpointHeight),
+		now:         time.Now,
+	}
+}
+
+// SetChainMaxLag overrides the allowed lag for a chain, e.g. for chains with fast blocks
+func (t *SyncTracker) SetChainMaxLag(chainID int, maxLag int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.chainMaxLag[chainID] = maxLag
+}
+
+// Observe records a block height reported by an endpoint
+func (t *SyncTracker) Observe(chainID, endpointID int, height int64) {
+	if height <= 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	endpoints, ok := t.chains[chainID]
+	if !ok {
+		endpoints = make(map[int]*endpointHeight)
+		t.chains[chainID] = endpoints
+	}
+	observation, ok := endpoints[endpointID]
+	if !ok {
+		observation = &endpointHeight{}
+		endpoints[endpointID] = observation
+	}
+
+	now := t.now()
+	if height > observation.height || !t.fresh(observation, now) {
+		observation.height = height
+	}
+	observation.observedAt = now
+
+	observation.lag = 0
+	if head := t.head(chainID, now); head > observation.height {
+		observation.lag = head - observation.height
+	}
+}
+
+// Filter returns the endpoints that are not lagging behind their chain's head
+func (t *SyncTracker) Filter(chainID int, endpoints []models.RpcEndpoint) []models.RpcEndpoint {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	observed, ok := t.chains[chainID]
+	if !ok {
+		return endpoints
+	}
+
+	now := t.now()
+	maxLag := t.maxLagFor(chainID)
+	var inSync []models.RpcEndpoint
+	for _, endpoint := range endpoints {
+		observation, ok := observed[endpoint.ID]
+		if ok && t.fresh(observation, now) && observation.lag > maxLag {
+			continue
+		}
+		inSync = append(inSync, endpoint)
+	}
+	return inSync
+}
+
+// Lags returns the sync lag of every endpoint with a height observation
+func (t *SyncTracker) Lags() []EndpointLag {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	lags := make([]EndpointLag, 0)
+	for chainID, endpoints := range t.chains {
+		head := t.head(chainID, now)
+		maxLag := t.maxLagFor(chainID)
+		for endpointID, observation := range endpoints {
+			lags = append(lags, EndpointLag{
+				EndpointID:  endpointID,
+				ChainID:     chainID,
+				BlockHeight: observation.height,
+				ChainHead:   head,
+				Lag:         observation.lag,
+				Excluded:    t.fresh(observation, now) && observation.lag > maxLag,
+				ObservedAt:  observation.observedAt,
+			})
+		}
+	}
+
+	sort.Slice(lags, func(i, j int) bool {
+		if lags[i].ChainID != lags[j].ChainID {
+			return lags[i].ChainID < lags[j].ChainID
+		}
+		return lags[i].EndpointID < lags[j].EndpointID
+	})
+	return lags
+}
+
+// Head returns the highest block recently observed for a chain, or 0 when unknown
+func (t *SyncTracker) Head(chainID int) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.head(chainID, t.now())
+}
+
+// head returns the highest fresh height of a chain. Caller must hold the tracker's mutex.
+func (t *SyncTracker) head(chainID int, now time.Time) int64 {
+	var head int64
+	for _, observation := range t.chains[chainID] {
+		if t.fresh(observation, now) && observation.height > head {
+			head = observation.height
+		}
+	}
+	return head
+}
+
+// maxLagFor returns the allowed lag of a chain. Caller must hold the tracker's mutex.
+func (t *SyncTracker) maxLagFor(chainID int) int64 {
+	if maxLag, ok := t.chainMaxLag[chainID]; ok {
+		return maxLag
+	}
+	return t.maxLag
+}
+
+// fresh reports whether an observation is recent enough to be trusted
+func (t *SyncTracker) fresh(observation *endpointHeight, now time.Time) bool {
+	return now.Sub(observation.observedAt) < syncObservationMaxAge
+}
+
+// SyncLagEndpointManager wraps an EndpointManager, hiding endpoints that trail the chain head
+type SyncLagEndpointManager struct {
+	EndpointManager
+	tracker *SyncTracker
+}
+
+// NewSyncLagEndpointManager wraps the given manager with sync-lag exclusion
+func NewSyncLagEndpointManager(manager EndpointManager, tracker *SyncTracker) *SyncLagEndpointManager {
+	return &SyncLagEndpointManager{
+		EndpointManager: manager,
+		tracker:         tracker,
+	}
+}
+
+// GetActiveEndpoints returns the active endpoints for a chain, skipping lagging endpoints
+func (m *SyncLagEndpointManager) GetActiveEndpoints(chainID int) ([]models.RpcEndpoint, error) {
+	endpoints, err := m.EndpointManager.GetActiveEndpoints(chainID)
+	if err != nil {
+		return nil, err
+	}
+	return m.tracker.Filter(chainID, endpoints), nil
+}
+
+// SetSyncTracker feeds block heights seen in eth_blockNumber responses into the tracker
+func (d *Dispatcher) SetSyncTracker(tracker *SyncTracker) {
+	d.syncTracker = tracker
+}
+
+// EndpointLags returns the sync lag of every endpoint with a height observation
+func (d *Dispatcher) EndpointLags() []EndpointLag {
+	if d.syncTracker == nil {
+		return []EndpointLag{}
+	}
+	return d.syncTracker.Lags()
+}
+
+// observeHeight records the height returned by an eth_blockNumber call
+func (d *Dispatcher) observeHeight(endpoint models.RpcEndpoint, requestBody, responseBody []byte) {
+	if d.syncTracker == nil || !bytes.Contains(requestBody, []byte(`"eth_blockNumber"`)) {
+		return
+	}
+
+	var request RPCRequest
+	if err := json.Unmarshal(requestBody, &request); err != nil || request.Method != "eth_blockNumber" {
+		return
+	}
+	var response RPCResponse
+	if err := json.Unmarshal(responseBody, &response); err != nil || response.Error != nil {
+		return
+	}
+	if height, ok := parseHexQuantity(response.Result); ok {
+		d.syncTracker.Observe(endpoint.ChainID, endpoint.ID, int64(height))
+	}
+}
+
+// SetSyncTracker feeds the block heights reported by probes into the tracker
+func (h *HealthChecker) SetSyncTracker(tracker *SyncTracker) {
+	h.syncTracker = tracker
+}
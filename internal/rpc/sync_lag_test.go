@@ -0,0 +1,90 @@
+package rpc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/illegalcall/viper-client/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSyncTracker_ExcludesLaggingEndpoints(t *testing.T) {
+	tracker := NewSyncTracker(10)
+	endpoints := []models.RpcEndpoint{{ID: 1}, {ID: 2}, {ID: 3}}
+
+	tracker.Observe(2, 1, 1000)
+	tracker.Observe(2, 2, 995)
+	tracker.Observe(2, 3, 950)
+
+	filtered := tracker.Filter(2, endpoints)
+	assert.Len(t, filtered, 2)
+	assert.Equal(t, 1, filtered[0].ID)
+	assert.Equal(t, 2, filtered[1].ID)
+
+	// Endpoint 3 catches up and is routed to again
+	tracker.Observe(2, 3, 1001)
+	assert.Len(t, tracker.Filter(2, endpoints), 3)
+
+	// Chains without observations are not filtered
+	assert.Len(t, tracker.Filter(137, endpoints), 3)
+}
+
+func TestSyncTracker_LagMeasuredAtObservation(t *testing.T) {
+	tracker := NewSyncTracker(10)
+
+	// Endpoint 2 was in sync when last probed; newer heights from endpoint 1 alone
+	// must not turn it into a lagging endpoint
+	tracker.Observe(2, 2, 1000)
+	tracker.Observe(2, 1, 1000)
+	tracker.Observe(2, 1, 1050)
+
+	assert.Len(t, tracker.Filter(2, []models.RpcEndpoint{{ID: 1}, {ID: 2}}), 2)
+}
+
+func TestSyncTracker_StaleObservationsExpire(t *testing.T) {
+	now := time.Now()
+	tracker := NewSyncTracker(10)
+	tracker.now = func() time.Time { return now }
+	tracker.SetChainMaxLag(2, 5)
+
+	tracker.Observe(2, 1, 1000)
+	tracker.Observe(2, 2, 990)
+	assert.Len(t, tracker.Filter(2, []models.RpcEndpoint{{ID: 1}, {ID: 2}}), 1)
+
+	lags := tracker.Lags()
+	assert.Len(t, lags, 2)
+	assert.Equal(t, int64(10), lags[1].Lag)
+	assert.True(t, lags[1].Excluded)
+
+	now = now.Add(syncObservationMaxAge)
+	assert.Len(t, tracker.Filter(2, []models.RpcEndpoint{{ID: 1}, {ID: 2}}), 2)
+}
+
+func TestDispatcher_Forward_ObservesBlockNumber(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x3e8"}`))
+	}))
+	defer server.Close()
+
+	mockManager := new(MockEndpointManager)
+	mockManager.On("GetActiveEndpoints", 2).Return([]models.RpcEndpoint{
+		{ID: 1, ChainID: 2, EndpointURL: server.URL, Priority: 1},
+	}, nil)
+	mockManager.On("UpdateEndpointHealth", mock.Anything, mock.Anything).Return(nil)
+
+	tracker := NewSyncTracker(10)
+	dispatcher := NewDispatcher(mockManager)
+	dispatcher.SetSyncTracker(tracker)
+
+	_, err := dispatcher.Forward(context.Background(), 2, []byte(`{"jsonrpc":"2.0","method":"eth_blockNumber","params":[],"id":1}`))
+	assert.NoError(t, err)
+
+	lags := dispatcher.EndpointLags()
+	assert.Len(t, lags, 1)
+	assert.Equal(t, int64(1000), lags[0].BlockHeight)
+	assert.Equal(t, int64(1000), lags[0].ChainHead)
+}
@@ -0,0 +1,218 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultViperTargetChainID is the chain relayed to through the Viper Network when neither
+	// the request nor the app names one
+	DefaultViperTargetChainID = 2
+
+	// DefaultViperChain is the Viper Network identifier of the default target chain, used until
+	// the mapping says otherwise
+	DefaultViperChain = "0002"
+
+	// ViperNetworkChain is the Viper Network identifier of the Viper Network's own chain, the
+	// only chain whose heights and blocks the Viper Network answers for itself
+	ViperNetworkChain = "0001"
+
+	// DefaultViperChainRefreshInterval is how often the Viper chain mapping is reloaded from the store
+	DefaultViperChainRefreshInterval = time.Minute
+)
+
+// ErrUnknownViperChain is returned when no Viper Network chain is mapped to the requested chain
+var ErrUnknownViperChain = errors.New("no Viper Network chain is mapped to the requested chain")
+
+// ErrChainAlreadyMapped is returned when mapping a chain that another Viper chain serves already
+var ErrChainAlreadyMapped = errors.New("chain is already served by another Viper Network chain")
+
+// ViperChain maps a chain to its identifier on the Viper Network
+// @Description Viper Network chain identifier and the chain it serves
+type ViperChain struct {
+	// Identifier of the chain on the Viper Network
+	ViperChain string `json:"viper_chain" example:"0002"`
+	// Chain ID in chain_static served by the Viper chain, unset while it is not mapped
+	ChainID *int `json:"chain_id,omitempty" example:"2"`
+	// Name of the mapped chain
+	Name string `json:"name,omitempty" example:"Ethereum"`
+}
+
+// ViperChainStore persists the mapping between chains and Viper Network chain identifiers
+type ViperChainStore interface {
+	ListViperChains() ([]ViperChain, error)
+	// SeedViperChains adds Viper chains not known yet, mapping each to the chain with the same numeric ID when there is one
+	SeedViperChains(viperChains []string) error
+	// SetViperChain maps a Viper chain to a chain, or unmaps it when chainID is nil
+	SetViperChain(viperChain string, chainID *int) error
+}
+
+// viperTargetContextKey is the context key under which the chain targeted through the Viper Network is stored
+type viperTargetContextKey struct{}
+
+// WithViperChain returns a context carrying the chain a request sent to the Viper Network targets
+func WithViperChain(ctx context.Context, chainID int) context.Context {
+	if chainID <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, viperTargetContextKey{}, chainID)
+}
+
+// viperChainFromContext returns the chain targeted through the Viper Network, or 0
+func viperChainFromContext(ctx context.Context) int {
+	chainID, _ := ctx.Value(viperTargetContextKey{}).(int)
+	return chainID
+}
+
+// ViperChainRegistry resolves the Viper Network chain identifier of the chain a relay targets
+type ViperChainRegistry struct {
+	store ViperChainStore
+
+	mu     sync.RWMutex
+	chains []ViperChain
+	byID   map[int]string
+}
+
+// NewViperChainRegistry creates a registry whose mapping is loaded from the store
+func NewViperChainRegistry(store ViperChainStore) *ViperChainRegistry {
+	return &ViperChainRegistry{
+		store: store,
+		byID:  make(map[int]string),
+	}
+}
+
+// SetChains replaces the mapping
+func (r *ViperChainRegistry) SetChains(chains []ViperChain) {
+	byID := make(map[int]string, len(chains))
+	for _, chain := range chains {
+		if chain.ChainID != nil {
+			byID[*chain.ChainID] = chain.ViperChain
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.chains = chains
+	r.byID = byID
+}
+
+// Resolve returns the Viper Network chain identifier of a chain, or of the default target
+// chain when chainID is 0
+func (r *ViperChainRegistry) Resolve(chainID int) (string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if chainID <= 0 {
+		if viperChain, ok := r.byID[DefaultViperTargetChainID]; ok {
+			return viperChain, nil
+		}
+		return DefaultViperChain, nil
+	}
+
+	viperChain, ok := r.byID[chainID]
+	if !ok {
+		return "", ErrUnknownViperChain
+	}
+	return viperChain, nil
+}
+
+// Chains returns every known Viper chain, ordered by identifier
+func (r *ViperChainRegistry) Chains() []ViperChain {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	chains := append([]ViperChain{}, r.chains...)
+	sort.Slice(chains, func(i, j int) bool {
+		return chains[i].ViperChain < chains[j].ViperChain
+	})
+	return chains
+}
+
+// Reload loads the mapping from the store
+func (r *ViperChainRegistry) Reload() error {
+	chains, err := r.store.ListViperChains()
+	if err != nil {
+		return err
+	}
+	r.SetChains(chains)
+	return nil
+}
+
+// Map maps a Viper chain to a chain, or unmaps it when chainID is nil
+func (r *ViperChainRegistry) Map(viperChain string, chainID *int) error {
+	if err := r.store.SetViperChain(viperChain, chainID); err != nil {
+		return err
+	}
+	return r.Reload()
+}
+
+// Sync asks the Viper Network which chains it serves and adds the ones not known yet
+func (r *ViperChainRegistry) Sync(ctx context.Context, network *ViperNetworkHandler) error {
+	response, err := network.HandleViperRequest(ctx, "supportedchains", []byte(`{}`))
+	if err != nil {
+		return err
+	}
+
+	viperChains, err := parseSupportedChains(response)
+	if err != nil {
+		return err
+	}
+	if err := r.store.SeedViperChains(viperChains); err != nil {
+		return err
+	}
+	return r.Reload()
+}
+
+// Start reloads the mapping on every interval until the context is cancelled
+func (r *ViperChainRegistry) Start(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultViperChainRefreshInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Reload(); err != nil {
+				log.Printf("Failed to reload Viper chains: %v", err)
+			}
+		}
+	}
+}
+
+// parseSupportedChains reads the chain identifiers of a supportedchains response, which is
+// either a list of identifiers or an object carrying the list
+func parseSupportedChains(response []byte) ([]string, error) {
+	var chains []string
+	if err := json.Unmarshal(response, &chains); err == nil {
+		return chains, nil
+	}
+
+	var wrapped struct {
+		Chains          []string `json:"chains"`
+		SupportedChains []string `json:"supported_chains"`
+	}
+	if err := json.Unmarshal(response, &wrapped); err != nil {
+		return nil, fmt.Errorf("invalid supported chains response: %w", err)
+	}
+	if wrapped.Chains != nil {
+		return wrapped.Chains, nil
+	}
+	return wrapped.SupportedChains, nil
+}
+
+// SetViperChains sets how the chain targeted by a relay on the Viper Network chain is resolved
+func (d *Dispatcher) SetViperChains(registry *ViperChainRegistry) {
+	d.viperChains = registry
+}
@@ -0,0 +1,137 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/illegalcall/viper-client/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// MockViperChainStore is a mock implementation of ViperChainStore
+type MockViperChainStore struct {
+	mock.Mock
+}
+
+func (m *MockViperChainStore) ListViperChains() ([]ViperChain, error) {
+	args := m.Called()
+	return args.Get(0).([]ViperChain), args.Error(1)
+}
+
+func (m *MockViperChainStore) SeedViperChains(viperChains []string) error {
+	args := m.Called(viperChains)
+	return args.Error(0)
+}
+
+func (m *MockViperChainStore) SetViperChain(viperChain string, chainID *int) error {
+	args := m.Called(viperChain, chainID)
+	return args.Error(0)
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestViperChainRegistry_Resolve(t *testing.T) {
+	registry := NewViperChainRegistry(nil)
+
+	// Relays without a target keep going to Ethereum until a mapping is loaded
+	viperChain, err := registry.Resolve(0)
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultViperChain, viperChain)
+
+	registry.SetChains([]ViperChain{
+		{ViperChain: "0021", ChainID: intPtr(2)},
+		{ViperChain: "0009", ChainID: intPtr(137)},
+		{ViperChain: "0040"},
+	})
+
+	viperChain, err = registry.Resolve(0)
+	assert.NoError(t, err)
+	assert.Equal(t, "0021", viperChain)
+
+	viperChain, err = registry.Resolve(137)
+	assert.NoError(t, err)
+	assert.Equal(t, "0009", viperChain)
+
+	_, err = registry.Resolve(56)
+	assert.Equal(t, ErrUnknownViperChain, err)
+}
+
+func TestViperChainRegistry_Sync(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, ViperSupportedChains, r.URL.Path)
+		w.Write([]byte(`["0001","0002","0009"]`))
+	}))
+	defer server.Close()
+
+	mockManager := new(MockEndpointManager)
+	mockManager.On("GetActiveEndpoints", ViperNetworkChainID).Return([]models.RpcEndpoint{
+		{ID: 1, ChainID: ViperNetworkChainID, EndpointURL: server.URL, Priority: 1},
+	}, nil)
+	mockManager.On("UpdateEndpointHealth", 1, mock.Anything).Return(nil)
+
+	store := new(MockViperChainStore)
+	store.On("SeedViperChains", []string{"0001", "0002", "0009"}).Return(nil)
+	store.On("ListViperChains").Return([]ViperChain{
+		{ViperChain: "0001", ChainID: intPtr(1)},
+		{ViperChain: "0002", ChainID: intPtr(2)},
+		{ViperChain: "0009"},
+	}, nil)
+
+	registry := NewViperChainRegistry(store)
+	assert.NoError(t, registry.Sync(context.Background(), NewViperNetworkHandler(mockManager)))
+	assert.Len(t, registry.Chains(), 3)
+	store.AssertExpectations(t)
+}
+
+func TestParseSupportedChains(t *testing.T) {
+	chains, err := parseSupportedChains([]byte(`["0001","0021"]`))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0001", "0021"}, chains)
+
+	chains, err = parseSupportedChains([]byte(`{"chains":["0002"]}`))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0002"}, chains)
+
+	_, err = parseSupportedChains([]byte(`not json`))
+	assert.Error(t, err)
+}
+
+func TestDispatcher_ForwardToViperNetwork_UsesMappedChain(t *testing.T) {
+	var blockchain string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request ViperNetworkRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		blockchain = request.Blockchain
+		w.Write([]byte(`"0x1"`))
+	}))
+	defer server.Close()
+
+	mockManager := new(MockEndpointManager)
+	mockManager.On("GetActiveEndpoints", ViperNetworkChainID).Return([]models.RpcEndpoint{
+		{ID: 1, ChainID: ViperNetworkChainID, EndpointURL: server.URL, Priority: 1},
+	}, nil)
+	mockManager.On("UpdateEndpointHealth", 1, mock.Anything).Return(nil)
+
+	registry := NewViperChainRegistry(nil)
+	registry.SetChains([]ViperChain{
+		{ViperChain: "0002", ChainID: intPtr(2)},
+		{ViperChain: "0009", ChainID: intPtr(137)},
+	})
+	dispatcher := NewDispatcher(mockManager)
+	dispatcher.SetViperChains(registry)
+
+	request := []byte(`{"jsonrpc":"2.0","method":"eth_chainId","params":[],"id":1}`)
+	response, err := dispatcher.Forward(WithViperChain(context.Background(), 137), ViperNetworkChainID, request)
+	assert.NoError(t, err)
+	assert.Contains(t, string(response), `"result":"0x1"`)
+	assert.Equal(t, "0009", blockchain)
+
+	_, err = dispatcher.Forward(WithViperChain(context.Background(), 56), ViperNetworkChainID, request)
+	assert.Equal(t, ErrUnknownViperChain, err)
+}
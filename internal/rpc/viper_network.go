@@ -4,11 +4,12 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"time"
+
+	"golang.org/x/net/websocket"
 )
 
 const (
@@ -50,6 +51,8 @@ type ViperNetworkRequest struct {
 type ViperNetworkHandler struct {
 	endpointManager EndpointManager
 	httpClient      *http.Client
+	geozones        *GeozoneRouter
+	maxResponseSize int64
 }
 
 // NewViperNetworkHandler creates a new handler for Viper Network interactions
@@ -59,9 +62,44 @@ func NewViperNetworkHandler(manager EndpointManager) *ViperNetworkHandler {
 		httpClient: &http.Client{
 			Timeout: 15 * time.Second, // Longer timeout for viper-network requests
 		},
+		geozones:        NewGeozoneRouter(DefaultGeozone, nil),
+		maxResponseSize: DefaultMaxResponseSize,
 	}
 }
 
+// SetGeozoneRouter sets how Viper Network endpoints are narrowed down by geozone
+func (v *ViperNetworkHandler) SetGeozoneRouter(router *GeozoneRouter) {
+	v.geozones = router
+}
+
+// SetMaxResponseSize sets the largest Viper Network response body read from a node
+func (v *ViperNetworkHandler) SetMaxResponseSize(limit int64) {
+	if limit > 0 {
+		v.maxResponseSize = limit
+	}
+}
+
+// DialWebSocket opens a WebSocket connection to the client websocket of the highest
+// priority Viper node in the request's geozone
+func (v *ViperNetworkHandler) DialWebSocket(ctx context.Context) (*websocket.Conn, error) {
+	endpoints, err := v.endpointManager.GetActiveEndpoints(ViperNetworkChainID)
+	if err != nil {
+		return nil, err
+	}
+	endpoints = v.geozones.Route(ctx, endpoints)
+
+	if len(endpoints) == 0 {
+		return nil, ErrNoEndpoints
+	}
+	endpoint := endpoints[0]
+
+	wsURL := endpoint.WSURL
+	if wsURL == "" {
+		wsURL = websocketURL(endpoint.EndpointURL) + ViperWebSocketEndpoint
+	}
+	return dialWebSocket(ctx, wsURL, endpoint.EndpointURL, endpoint.Auth)
+}
+
 // HandleViperRequest handles a request specifically for the Viper Network
 func (v *ViperNetworkHandler) HandleViperRequest(ctx context.Context, requestType string, requestData []byte) ([]byte, error) {
 	// Parse the incoming request
@@ -75,6 +113,7 @@ func (v *ViperNetworkHandler) HandleViperRequest(ctx context.Context, requestTyp
 	if err != nil {
 		return nil, err
 	}
+	endpoints = v.geozones.Route(ctx, endpoints)
 
 	if len(endpoints) == 0 {
 		return nil, ErrNoEndpoints
@@ -120,23 +159,24 @@ func (v *ViperNetworkHandler) HandleViperRequest(ctx context.Context, requestTyp
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := v.httpClient.Do(req)
+	resp, err := authenticatedClient(v.httpClient, selectedEndpoint).Do(req)
 	if err != nil {
 		// Update endpoint health
 		v.endpointManager.UpdateEndpointHealth(selectedEndpoint.ID, "error")
-		return nil, err
+		return nil, redactError(err)
 	}
 	defer resp.Body.Close()
 
 	// Check if response is successful
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
+		// Error bodies are only quoted, so a short prefix is enough
+		bodyBytes, _ := io.ReadAll(io.LimitReader(resp.Body, streamPeekSize))
 		v.endpointManager.UpdateEndpointHealth(selectedEndpoint.ID, "error")
 		return nil, fmt.Errorf("error from viper network: status %d, body: %s", resp.StatusCode, string(bodyBytes))
 	}
 
 	// Read and return the response
-	responseBody, err := io.ReadAll(resp.Body)
+	responseBody, err := readResponse(resp.Body, v.maxResponseSize)
 	if err != nil {
 		return nil, err
 	}
@@ -147,151 +187,17 @@ func (v *ViperNetworkHandler) HandleViperRequest(ctx context.Context, requestTyp
 	return responseBody, nil
 }
 
-// ConvertJSONRPCToViperFormat converts standard JSON-RPC format to viper-network format
-func ConvertJSONRPCToViperFormat(jsonRPCRequest []byte) (string, []byte, error) {
-	var rpcRequest map[string]interface{}
-	if err := json.Unmarshal(jsonRPCRequest, &rpcRequest); err != nil {
-		return "", nil, fmt.Errorf("invalid JSON-RPC request: %w", err)
-	}
-
-	// Extract the method to determine the viper request type
-	method, ok := rpcRequest["method"].(string)
-	if !ok {
-		return "", nil, errors.New("missing or invalid method in JSON-RPC request")
-	}
-
-	var requestType string
-	var viperRequest ViperNetworkRequest
-
-	// Convert JSON-RPC to the appropriate Viper Network format
-	switch method {
-	case "eth_blockNumber":
-		requestType = "height"
-		viperRequest = ViperNetworkRequest{}
-
-	case "eth_getBlockByNumber", "eth_getBlockByHash":
-		requestType = "block"
-		params, ok := rpcRequest["params"].([]interface{})
-		if !ok || len(params) == 0 {
-			return "", nil, errors.New("missing or invalid params for block request")
-		}
+// defaultViperTranslators holds the built-in translations used by the conversion functions
+var defaultViperTranslators = NewViperTranslators()
 
-		var height int64 = 0
-		if blockNum, ok := params[0].(string); ok && blockNum != "latest" {
-			// Convert hex block number to int if needed
-			// For simplicity, we're just setting to 0 here which means latest
-			height = 0
-		}
-
-		viperRequest = ViperNetworkRequest{
-			Height: height,
-		}
-
-	case "eth_sendRawTransaction":
-		requestType = "relay"
-		params, ok := rpcRequest["params"].([]interface{})
-		if !ok || len(params) == 0 {
-			return "", nil, errors.New("missing or invalid params for transaction request")
-		}
-
-		txData, ok := params[0].(string)
-		if !ok {
-			return "", nil, errors.New("invalid transaction data")
-		}
-
-		viperRequest = ViperNetworkRequest{
-			Blockchain: "0002", // Ethereum chain ID in viper network
-			Data:       txData,
-			Method:     "POST",
-			Headers: map[string]string{
-				"Content-Type": "application/json",
-			},
-		}
-
-	case "eth_call", "eth_estimateGas":
-		// For contract calls and gas estimates
-		requestType = "relay"
-
-		// Convert params to JSON string
-		paramsData, err := json.Marshal(rpcRequest)
-		if err != nil {
-			return "", nil, fmt.Errorf("error serializing params: %w", err)
-		}
-
-		viperRequest = ViperNetworkRequest{
-			Blockchain: "0002", // Ethereum chain ID in viper network
-			Data:       string(paramsData),
-			Method:     "POST",
-			Headers: map[string]string{
-				"Content-Type": "application/json",
-			},
-		}
-
-	default:
-		// For any other methods, we'll use a generic relay format
-		requestType = "relay"
-
-		// Convert the entire request to a JSON string
-		requestData, err := json.Marshal(rpcRequest)
-		if err != nil {
-			return "", nil, fmt.Errorf("error serializing request: %w", err)
-		}
-
-		viperRequest = ViperNetworkRequest{
-			Blockchain: "0002", // Default to Ethereum chain ID in viper network
-			Data:       string(requestData),
-			Method:     "POST",
-			Headers: map[string]string{
-				"Content-Type": "application/json",
-			},
-		}
-	}
-
-	// Convert the prepared viper request to JSON
-	viperRequestJSON, err := json.Marshal(viperRequest)
-	if err != nil {
-		return "", nil, fmt.Errorf("error serializing viper request: %w", err)
-	}
-
-	return requestType, viperRequestJSON, nil
+// ConvertJSONRPCToViperFormat converts standard JSON-RPC format to viper-network format,
+// relaying to the given Viper Network blockchain
+func ConvertJSONRPCToViperFormat(jsonRPCRequest []byte, blockchain string) (string, []byte, error) {
+	return defaultViperTranslators.TranslateRequest(jsonRPCRequest, blockchain)
 }
 
-// ConvertViperResponseToJSONRPC converts viper-network response format to standard JSON-RPC
-func ConvertViperResponseToJSONRPC(viperResponse []byte, originalRequest []byte) ([]byte, error) {
-	// Parse the original request to get the ID for the response
-	var originalRPCRequest map[string]interface{}
-	if err := json.Unmarshal(originalRequest, &originalRPCRequest); err != nil {
-		return nil, fmt.Errorf("error parsing original request: %w", err)
-	}
-
-	requestID := originalRPCRequest["id"]
-
-	// Parse the Viper response
-	var viperResponseData interface{}
-	if err := json.Unmarshal(viperResponse, &viperResponseData); err != nil {
-		// If we can't parse as JSON, treat as string
-		jsonRPCResponse := map[string]interface{}{
-			"jsonrpc": "2.0",
-			"id":      requestID,
-			"result":  string(viperResponse),
-		}
-		return json.Marshal(jsonRPCResponse)
-	}
-
-	// Construct a proper JSON-RPC response
-	jsonRPCResponse := map[string]interface{}{
-		"jsonrpc": "2.0",
-		"id":      requestID,
-		"result":  viperResponseData,
-	}
-
-	// If the viper response has an "error" field, include it
-	if viperResponseMap, ok := viperResponseData.(map[string]interface{}); ok {
-		if errorData, hasError := viperResponseMap["error"]; hasError {
-			jsonRPCResponse["error"] = errorData
-			delete(jsonRPCResponse, "result")
-		}
-	}
-
-	return json.Marshal(jsonRPCResponse)
+// ConvertViperResponseToJSONRPC converts viper-network response back to JSON-RPC format for a
+// request relayed to the given Viper Network blockchain
+func ConvertViperResponseToJSONRPC(viperResponse []byte, originalRequest []byte, blockchain string) ([]byte, error) {
+	return defaultViperTranslators.TranslateResponse(originalRequest, blockchain, viperResponse)
 }
@@ -0,0 +1,399 @@
+package rpc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrInvalidViperParams is returned when the params of a call cannot be translated for the Viper Network
+var ErrInvalidViperParams = errors.New("invalid params for Viper Network request")
+
+// ViperCall is a JSON-RPC call being translated for the Viper Network
+type ViperCall struct {
+	ID     json.RawMessage
+	Method string
+	// Params holds the positional params of the call, nil when it has none or names them
+	Params []json.RawMessage
+	// Request is the JSON-RPC request body as sent by the client
+	Request []byte
+	// Blockchain is the Viper Network chain the call is relayed to
+	Blockchain string
+}
+
+// ViperResult is the JSON-RPC outcome of a Viper Network response; only one of Result and Error is set
+type ViperResult struct {
+	Result json.RawMessage
+	Error  json.RawMessage
+}
+
+// ViperTranslator translates calls of a JSON-RPC method to Viper Network requests, and their
+// Viper Network responses back to JSON-RPC results
+type ViperTranslator interface {
+	// TranslateRequest returns the Viper Network request type and the request for a call
+	TranslateRequest(call ViperCall) (string, ViperNetworkRequest, error)
+	// TranslateResponse returns the JSON-RPC outcome of the Viper Network response to a call
+	TranslateResponse(call ViperCall, viperResponse []byte) (ViperResult, error)
+}
+
+// ViperTranslators resolves the translator of each JSON-RPC method sent to the Viper Network.
+// Methods without a translator of their own are relayed to the target chain as they are.
+type ViperTranslators struct {
+	mu          sync.RWMutex
+	translators map[string]ViperTranslator
+	// chainTranslators are translators used only for calls relayed to one blockchain
+	chainTranslators map[string]map[string]ViperTranslator
+	fallback         ViperTranslator
+}
+
+// NewViperTranslators creates a registry with the built-in translations
+func NewViperTranslators() *ViperTranslators {
+	t := &ViperTranslators{
+		translators:      make(map[string]ViperTranslator),
+		chainTranslators: make(map[string]map[string]ViperTranslator),
+		fallback:         relayTranslator{},
+	}
+	// Viper Network height and block queries describe its own chain, other chains are asked directly
+	t.RegisterForChain(ViperNetworkChain, "eth_blockNumber", heightTranslator{})
+	t.RegisterForChain(ViperNetworkChain, "eth_getBlockByNumber", blockTranslator{})
+	t.Register("eth_sendRawTransaction", rawTransactionTranslator{})
+	t.Register("eth_getBlockByHash", hashRelayTranslator{})
+	t.Register("eth_getTransactionByHash", hashRelayTranslator{})
+	t.Register("eth_getTransactionReceipt", hashRelayTranslator{})
+	return t
+}
+
+// Register sets the translator of a method, replacing any translator registered for it
+func (t *ViperTranslators) Register(method string, translator ViperTranslator) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.translators[method] = translator
+}
+
+// RegisterForChain sets the translator of a method for calls relayed to one blockchain,
+// taking precedence over the translator registered for every blockchain
+func (t *ViperTranslators) RegisterForChain(blockchain, method string, translator ViperTranslator) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.chainTranslators[blockchain] == nil {
+		t.chainTranslators[blockchain] = make(map[string]ViperTranslator)
+	}
+	t.chainTranslators[blockchain][method] = translator
+}
+
+// lookup returns the translator of a method called on a blockchain
+func (t *ViperTranslators) lookup(method, blockchain string) ViperTranslator {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if translator, ok := t.chainTranslators[blockchain][method]; ok {
+		return translator
+	}
+	if translator, ok := t.translators[method]; ok {
+		return translator
+	}
+	return t.fallback
+}
+
+// TranslateRequest converts a JSON-RPC request to the Viper Network request type and request
+// relayed to the given blockchain
+func (t *ViperTranslators) TranslateRequest(requestBody []byte, blockchain string) (string, []byte, error) {
+	call, err := parseViperCall(requestBody, blockchain)
+	if err != nil {
+		return "", nil, err
+	}
+
+	requestType, viperRequest, err := t.lookup(call.Method, call.Blockchain).TranslateRequest(call)
+	if err != nil {
+		return "", nil, err
+	}
+
+	viperRequestJSON, err := json.Marshal(viperRequest)
+	if err != nil {
+		return "", nil, fmt.Errorf("error serializing viper request: %w", err)
+	}
+	return requestType, viperRequestJSON, nil
+}
+
+// TranslateResponse converts the Viper Network response to a JSON-RPC request relayed to the
+// given blockchain into a JSON-RPC response
+func (t *ViperTranslators) TranslateResponse(requestBody []byte, blockchain string, viperResponse []byte) ([]byte, error) {
+	call, err := parseViperCall(requestBody, blockchain)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing original request: %w", err)
+	}
+
+	var result ViperResult
+	switch {
+	case !json.Valid(viperResponse):
+		// A response that is not JSON is returned as a string
+		result.Result, _ = json.Marshal(string(viperResponse))
+	case viperError(viperResponse) != nil:
+		result.Error = viperError(viperResponse)
+	default:
+		result, err = t.lookup(call.Method, call.Blockchain).TranslateResponse(call, viperResponse)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	response := struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Result  json.RawMessage `json:"result,omitempty"`
+		Error   json.RawMessage `json:"error,omitempty"`
+	}{
+		JSONRPC: "2.0",
+		ID:      call.ID,
+		Result:  result.Result,
+		Error:   result.Error,
+	}
+	if response.ID == nil {
+		response.ID = json.RawMessage("null")
+	}
+	if response.Result == nil && response.Error == nil {
+		response.Result = json.RawMessage("null")
+	}
+	return json.Marshal(response)
+}
+
+// parseViperCall reads the call a JSON-RPC request makes
+func parseViperCall(requestBody []byte, blockchain string) (ViperCall, error) {
+	var request struct {
+		ID     json.RawMessage `json:"id"`
+		Method *string         `json:"method"`
+		Params json.RawMessage `json:"params"`
+	}
+	if err := json.Unmarshal(requestBody, &request); err != nil {
+		return ViperCall{}, fmt.Errorf("invalid JSON-RPC request: %w", err)
+	}
+	if request.Method == nil {
+		return ViperCall{}, errors.New("missing or invalid method in JSON-RPC request")
+	}
+
+	call := ViperCall{
+		ID:         request.ID,
+		Method:     *request.Method,
+		Request:    requestBody,
+		Blockchain: blockchain,
+	}
+	// Named params are left to translators that read the request themselves
+	json.Unmarshal(request.Params, &call.Params)
+	return call, nil
+}
+
+// viperError returns the error a Viper Network response carries, or nil
+func viperError(viperResponse []byte) json.RawMessage {
+	var response struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(viperResponse, &response); err != nil || len(response.Error) == 0 || string(response.Error) == "null" {
+		return nil
+	}
+	return response.Error
+}
+
+// param returns the positional param at index i
+func (c ViperCall) param(i int) (json.RawMessage, error) {
+	if i >= len(c.Params) {
+		return nil, fmt.Errorf("%w: %s expects at least %d params", ErrInvalidViperParams, c.Method, i+1)
+	}
+	return c.Params[i], nil
+}
+
+// relayRequest returns the request relaying data to the call's blockchain
+func (c ViperCall) relayRequest(data string) ViperNetworkRequest {
+	return ViperNetworkRequest{
+		Blockchain: c.Blockchain,
+		Data:       data,
+		Method:     "POST",
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}
+}
+
+// parseQuantity decodes a hex encoded quantity such as "0x1b4"
+func parseQuantity(value string) (int64, error) {
+	digits, ok := strings.CutPrefix(value, "0x")
+	if !ok {
+		digits, ok = strings.CutPrefix(value, "0X")
+	}
+	if !ok || digits == "" {
+		return 0, fmt.Errorf("%w: %q is not a hex quantity", ErrInvalidViperParams, value)
+	}
+	quantity, err := strconv.ParseInt(digits, 16, 64)
+	if err != nil || quantity < 0 {
+		return 0, fmt.Errorf("%w: %q is not a hex quantity", ErrInvalidViperParams, value)
+	}
+	return quantity, nil
+}
+
+// parseBlockHeight decodes a block number or tag to a Viper Network height, where 0 means the
+// latest block
+func parseBlockHeight(param json.RawMessage) (int64, error) {
+	var block string
+	if err := json.Unmarshal(param, &block); err != nil {
+		// EIP-1898 names the block in an object
+		var object struct {
+			BlockNumber *string `json:"blockNumber"`
+		}
+		if err := json.Unmarshal(param, &object); err != nil || object.BlockNumber == nil {
+			return 0, fmt.Errorf("%w: block must be a number or tag", ErrInvalidViperParams)
+		}
+		block = *object.BlockNumber
+	}
+
+	switch block {
+	case "latest", "pending", "safe", "finalized":
+		return 0, nil
+	case "earliest":
+		return 1, nil
+	}
+
+	height, err := parseQuantity(block)
+	if err != nil {
+		return 0, err
+	}
+	if height == 0 {
+		// Height 0 asks the Viper Network for the latest block, so the first block is the earliest
+		height = 1
+	}
+	return height, nil
+}
+
+// parseHash decodes a 32 byte hex encoded hash
+func parseHash(param json.RawMessage) (string, error) {
+	var hash string
+	if err := json.Unmarshal(param, &hash); err != nil || !isHex(hash, 64) {
+		return "", fmt.Errorf("%w: expected a 32 byte hex hash", ErrInvalidViperParams)
+	}
+	return hash, nil
+}
+
+// isHex reports whether value is 0x prefixed hex data, of the given number of digits unless it is 0
+func isHex(value string, digits int) bool {
+	data, ok := strings.CutPrefix(value, "0x")
+	if !ok || (digits > 0 && len(data) != digits) || (digits == 0 && (data == "" || len(data)%2 != 0)) {
+		return false
+	}
+	for _, c := range data {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// heightTranslator answers eth_blockNumber on the Viper Network's own chain with its height
+type heightTranslator struct{}
+
+func (heightTranslator) TranslateRequest(call ViperCall) (string, ViperNetworkRequest, error) {
+	return "height", ViperNetworkRequest{}, nil
+}
+
+func (heightTranslator) TranslateResponse(call ViperCall, viperResponse []byte) (ViperResult, error) {
+	var response struct {
+		Height *int64 `json:"height"`
+	}
+	if err := json.Unmarshal(viperResponse, &response); err != nil || response.Height == nil {
+		return ViperResult{Result: viperResponse}, nil
+	}
+	result, err := json.Marshal(fmt.Sprintf("0x%x", *response.Height))
+	return ViperResult{Result: result}, err
+}
+
+// blockTranslator queries a block of the Viper Network's own chain by number or tag
+type blockTranslator struct{}
+
+func (blockTranslator) TranslateRequest(call ViperCall) (string, ViperNetworkRequest, error) {
+	param, err := call.param(0)
+	if err != nil {
+		return "", ViperNetworkRequest{}, err
+	}
+	height, err := parseBlockHeight(param)
+	if err != nil {
+		return "", ViperNetworkRequest{}, err
+	}
+	return "block", ViperNetworkRequest{Height: height}, nil
+}
+
+func (blockTranslator) TranslateResponse(call ViperCall, viperResponse []byte) (ViperResult, error) {
+	return ViperResult{Result: viperResponse}, nil
+}
+
+// relayTranslator relays the JSON-RPC request to the target chain as it is
+type relayTranslator struct{}
+
+func (relayTranslator) TranslateRequest(call ViperCall) (string, ViperNetworkRequest, error) {
+	return "relay", call.relayRequest(string(call.Request)), nil
+}
+
+// TranslateResponse unwraps the target chain's JSON-RPC response from the relay response
+func (relayTranslator) TranslateResponse(call ViperCall, viperResponse []byte) (ViperResult, error) {
+	var relay struct {
+		Response *string `json:"response"`
+	}
+	if err := json.Unmarshal(viperResponse, &relay); err != nil || relay.Response == nil {
+		return ViperResult{Result: viperResponse}, nil
+	}
+
+	var response struct {
+		Result json.RawMessage `json:"result"`
+		Error  json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(*relay.Response), &response); err != nil || (response.Result == nil && response.Error == nil) {
+		// The chain did not answer with a JSON-RPC envelope
+		result, err := json.Marshal(*relay.Response)
+		return ViperResult{Result: result}, err
+	}
+	if response.Error != nil && string(response.Error) != "null" {
+		return ViperResult{Error: response.Error}, nil
+	}
+	return ViperResult{Result: response.Result}, nil
+}
+
+// rawTransactionTranslator relays a signed transaction to the target chain once the
+// transaction data is validated
+type rawTransactionTranslator struct {
+	relayTranslator
+}
+
+func (rawTransactionTranslator) TranslateRequest(call ViperCall) (string, ViperNetworkRequest, error) {
+	param, err := call.param(0)
+	if err != nil {
+		return "", ViperNetworkRequest{}, err
+	}
+	var txData string
+	if err := json.Unmarshal(param, &txData); err != nil || !isHex(txData, 0) {
+		return "", ViperNetworkRequest{}, fmt.Errorf("%w: invalid transaction data", ErrInvalidViperParams)
+	}
+	return relayTranslator{}.TranslateRequest(call)
+}
+
+// hashRelayTranslator relays lookups by hash to the target chain once the hash is validated,
+// as Viper Network queries only address blocks by height
+type hashRelayTranslator struct {
+	relayTranslator
+}
+
+func (hashRelayTranslator) TranslateRequest(call ViperCall) (string, ViperNetworkRequest, error) {
+	param, err := call.param(0)
+	if err != nil {
+		return "", ViperNetworkRequest{}, err
+	}
+	if _, err := parseHash(param); err != nil {
+		return "", ViperNetworkRequest{}, err
+	}
+	return relayTranslator{}.TranslateRequest(call)
+}
+
+// SetViperTranslators sets how calls sent to the Viper Network are translated
+func (d *Dispatcher) SetViperTranslators(translators *ViperTranslators) {
+	d.viperTranslators = translators
+}
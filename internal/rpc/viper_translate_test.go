@@ -0,0 +1,129 @@
+package rpc
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestViperTranslators_BlockByNumber(t *testing.T) {
+	translators := NewViperTranslators()
+
+	tests := []struct {
+		block  string
+		height int64
+	}{
+		{`"0x1b4"`, 436},
+		{`"0x0"`, 1},
+		{`"earliest"`, 1},
+		{`"latest"`, 0},
+		{`"finalized"`, 0},
+		{`{"blockNumber":"0x10"}`, 16},
+	}
+	for _, tt := range tests {
+		requestType, viperRequest, err := translators.TranslateRequest([]byte(`{"jsonrpc":"2.0","method":"eth_getBlockByNumber","params":[`+tt.block+`,false],"id":1}`), ViperNetworkChain)
+		assert.NoError(t, err, tt.block)
+		assert.Equal(t, "block", requestType)
+
+		var request ViperNetworkRequest
+		assert.NoError(t, json.Unmarshal(viperRequest, &request))
+		assert.Equal(t, tt.height, request.Height, tt.block)
+	}
+
+	_, _, err := translators.TranslateRequest([]byte(`{"jsonrpc":"2.0","method":"eth_getBlockByNumber","params":["436"],"id":1}`), ViperNetworkChain)
+	assert.True(t, errors.Is(err, ErrInvalidViperParams))
+
+	// Blocks of other chains are asked from the chain itself
+	request := []byte(`{"jsonrpc":"2.0","method":"eth_getBlockByNumber","params":["0x1b4",false],"id":1}`)
+	requestType, viperRequest, err := translators.TranslateRequest(request, "0002")
+	assert.NoError(t, err)
+	assert.Equal(t, "relay", requestType)
+
+	var relayed ViperNetworkRequest
+	assert.NoError(t, json.Unmarshal(viperRequest, &relayed))
+	assert.Equal(t, "0002", relayed.Blockchain)
+	assert.Equal(t, string(request), relayed.Data)
+}
+
+func TestViperTranslators_ValidatesHashes(t *testing.T) {
+	translators := NewViperTranslators()
+	hash := "0x88df016429689c079f3b2f6ad39fa052532c56795b733da78a91ebe6a713944b"
+
+	requestType, viperRequest, err := translators.TranslateRequest([]byte(`{"jsonrpc":"2.0","method":"eth_getTransactionByHash","params":["`+hash+`"],"id":1}`), "0021")
+	assert.NoError(t, err)
+	assert.Equal(t, "relay", requestType)
+
+	var request ViperNetworkRequest
+	assert.NoError(t, json.Unmarshal(viperRequest, &request))
+	assert.Equal(t, "0021", request.Blockchain)
+	assert.Contains(t, request.Data, hash)
+
+	_, _, err = translators.TranslateRequest([]byte(`{"jsonrpc":"2.0","method":"eth_getBlockByHash","params":["0x1234",false],"id":1}`), "0021")
+	assert.True(t, errors.Is(err, ErrInvalidViperParams))
+
+	_, _, err = translators.TranslateRequest([]byte(`{"jsonrpc":"2.0","method":"eth_sendRawTransaction","params":["signed"],"id":1}`), "0021")
+	assert.True(t, errors.Is(err, ErrInvalidViperParams))
+
+	// A signed transaction is relayed as the JSON-RPC request carrying it
+	send := []byte(`{"jsonrpc":"2.0","method":"eth_sendRawTransaction","params":["0xf86c0a85"],"id":2}`)
+	requestType, viperRequest, err = translators.TranslateRequest(send, "0021")
+	assert.NoError(t, err)
+	assert.Equal(t, "relay", requestType)
+	request = ViperNetworkRequest{}
+	assert.NoError(t, json.Unmarshal(viperRequest, &request))
+	assert.Equal(t, string(send), request.Data)
+}
+
+func TestViperTranslators_TranslateResponse(t *testing.T) {
+	translators := NewViperTranslators()
+
+	blockNumber := []byte(`{"jsonrpc":"2.0","method":"eth_blockNumber","params":[],"id":7}`)
+	response, err := translators.TranslateResponse(blockNumber, ViperNetworkChain, []byte(`{"height":1234}`))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":7,"result":"0x4d2"}`, string(response))
+
+	response, err = translators.TranslateResponse(blockNumber, "0002", []byte(`{"response":"{\"jsonrpc\":\"2.0\",\"id\":1,\"result\":\"0x12a05f2\"}"}`))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":7,"result":"0x12a05f2"}`, string(response))
+
+	// Relayed calls answer with the target chain's response
+	request := []byte(`{"jsonrpc":"2.0","method":"eth_chainId","params":[],"id":"a"}`)
+	response, err = translators.TranslateResponse(request, "0002", []byte(`{"response":"{\"jsonrpc\":\"2.0\",\"id\":1,\"result\":\"0x89\"}","signature":"ab"}`))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":"a","result":"0x89"}`, string(response))
+
+	response, err = translators.TranslateResponse(request, "0002", []byte(`{"response":"{\"jsonrpc\":\"2.0\",\"id\":1,\"error\":{\"code\":-32601,\"message\":\"method not found\"}}"}`))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":"a","error":{"code":-32601,"message":"method not found"}}`, string(response))
+
+	response, err = translators.TranslateResponse(request, "0002", []byte(`{"error":{"code":90,"message":"no session"}}`))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":"a","error":{"code":90,"message":"no session"}}`, string(response))
+}
+
+// chainIDTranslator answers eth_chainId without going to the Viper Network
+type chainIDTranslator struct{}
+
+func (chainIDTranslator) TranslateRequest(call ViperCall) (string, ViperNetworkRequest, error) {
+	return "supportedchains", ViperNetworkRequest{}, nil
+}
+
+func (chainIDTranslator) TranslateResponse(call ViperCall, viperResponse []byte) (ViperResult, error) {
+	return ViperResult{Result: json.RawMessage(`"0x1"`)}, nil
+}
+
+func TestViperTranslators_Register(t *testing.T) {
+	translators := NewViperTranslators()
+	translators.Register("eth_chainId", chainIDTranslator{})
+
+	request := []byte(`{"jsonrpc":"2.0","method":"eth_chainId","params":[],"id":1}`)
+	requestType, _, err := translators.TranslateRequest(request, "0002")
+	assert.NoError(t, err)
+	assert.Equal(t, "supportedchains", requestType)
+
+	response, err := translators.TranslateResponse(request, "0002", []byte(`["0002"]`))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":1,"result":"0x1"}`, string(response))
+}
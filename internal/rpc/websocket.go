@@ -0,0 +1,742 @@
+package rpc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/illegalcall/viper-client/internal/models"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// DefaultWSReconnectDelay is the first wait before a dropped upstream WebSocket is redialled
+	DefaultWSReconnectDelay = time.Second
+
+	// maxWSReconnectDelay caps the backoff between redial attempts
+	maxWSReconnectDelay = 30 * time.Second
+
+	// wsDialTimeout bounds a single upstream WebSocket dial
+	wsDialTimeout = 10 * time.Second
+
+	// wsSessionQueueSize is how many messages may wait for a client before the session is closed
+	wsSessionQueueSize = 256
+
+	// wsWriteTimeout bounds a single write to a client
+	wsWriteTimeout = 10 * time.Second
+)
+
+var (
+	// ErrNoWebSocketEndpoints is returned when no active endpoint of a chain has a WebSocket URL
+	ErrNoWebSocketEndpoints = errors.New("no active WebSocket endpoints available for the requested chain")
+
+	// errUpstreamDisconnected is returned for calls made while the upstream is being redialled
+	errUpstreamDisconnected = errors.New("upstream WebSocket connection unavailable")
+)
+
+// WSGateway relays client WebSocket sessions over one shared upstream WebSocket connection
+// per chain. Request and subscription ids are rewritten so that sessions cannot see or
+// collide with each other, and subscriptions are renewed when the upstream connection drops.
+type WSGateway struct {
+	endpointManager EndpointManager
+	reconnectDelay  time.Duration
+
+	mu        sync.Mutex
+	upstreams map[int]*wsUpstream
+	dialing   map[int]*wsDial
+}
+
+// wsDial is an upstream dial in progress, shared by the sessions connecting meanwhile
+type wsDial struct {
+	done chan struct{}
+	err  error
+}
+
+// NewWSGateway creates a gateway dialling the WebSocket URLs of the manager's endpoints
+func NewWSGateway(manager EndpointManager) *WSGateway {
+	return &WSGateway{
+		endpointManager: manager,
+		reconnectDelay:  DefaultWSReconnectDelay,
+		upstreams:       make(map[int]*wsUpstream),
+		dialing:         make(map[int]*wsDial),
+	}
+}
+
+// SetReconnectDelay sets the first wait before a dropped upstream connection is redialled
+func (g *WSGateway) SetReconnectDelay(delay time.Duration) {
+	if delay > 0 {
+		g.reconnectDelay = delay
+	}
+}
+
+// Connect joins the chain's shared upstream connection, dialling it for the first session.
+// Sessions connecting while the chain is dialled wait for that dial instead of starting their own.
+func (g *WSGateway) Connect(ctx context.Context, chainID int) (*WSSession, error) {
+	session := newWSSession()
+	for {
+		g.mu.Lock()
+		if upstream, ok := g.upstreams[chainID]; ok && upstream.join(session) {
+			g.mu.Unlock()
+			return session, nil
+		}
+		if dial, ok := g.dialing[chainID]; ok {
+			g.mu.Unlock()
+			select {
+			case <-dial.done:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			// The session that dialled may have given up, which says nothing about the endpoints
+			if dial.err != nil && !errors.Is(dial.err, context.Canceled) && !errors.Is(dial.err, context.DeadlineExceeded) {
+				return nil, dial.err
+			}
+			continue
+		}
+		dial := &wsDial{done: make(chan struct{})}
+		g.dialing[chainID] = dial
+		g.mu.Unlock()
+
+		conn, err := g.dial(ctx, chainID)
+
+		g.mu.Lock()
+		delete(g.dialing, chainID)
+		if err == nil {
+			g.upstreams[chainID] = g.newUpstream(chainID, conn, session)
+		}
+		dial.err = err
+		close(dial.done)
+		g.mu.Unlock()
+
+		if err != nil {
+			return nil, err
+		}
+		return session, nil
+	}
+}
+
+// newUpstream starts reading a freshly dialled upstream connection joined by its first session
+func (g *WSGateway) newUpstream(chainID int, conn *websocket.Conn, session *WSSession) *wsUpstream {
+	upstream := &wsUpstream{
+		gateway:       g,
+		chainID:       chainID,
+		conn:          conn,
+		done:          make(chan struct{}),
+		sessions:      make(map[*WSSession]bool),
+		pending:       make(map[uint64]wsPending),
+		subscriptions: make(map[string]*wsSubscription),
+	}
+	upstream.join(session)
+	go upstream.run(conn)
+
+	return upstream
+}
+
+// remove forgets an upstream connection once its last session has left
+func (g *WSGateway) remove(upstream *wsUpstream) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.upstreams[upstream.chainID] == upstream {
+		delete(g.upstreams, upstream.chainID)
+	}
+}
+
+// dial connects to the highest priority endpoint of the chain that accepts WebSocket connections
+func (g *WSGateway) dial(ctx context.Context, chainID int) (*websocket.Conn, error) {
+	endpoints, err := g.endpointManager.GetActiveEndpoints(chainID)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []models.RpcEndpoint
+	for _, endpoint := range endpoints {
+		if endpoint.WSURL != "" {
+			candidates = append(candidates, endpoint)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, ErrNoWebSocketEndpoints
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Priority > candidates[j].Priority
+	})
+
+	for _, endpoint := range candidates {
+		conn, dialErr := dialWebSocket(ctx, endpoint.WSURL, endpoint.EndpointURL, endpoint.Auth)
+		if dialErr == nil {
+			return conn, nil
+		}
+		err = dialErr
+		log.Printf("WebSocket: failed to dial endpoint %d for chain %d: %v", endpoint.ID, chainID, dialErr)
+	}
+	return nil, err
+}
+
+// wsPending is where the answer to a call sent upstream goes
+type wsPending struct {
+	// session is nil for calls whose answer is discarded
+	session *WSSession
+	// id is the id the client used for the call
+	id interface{}
+	// subscription is set for eth_subscribe calls
+	subscription *wsSubscription
+	// resubscribe marks calls renewing a subscription after a reconnect, which the client never made
+	resubscribe bool
+}
+
+// wsSubscription is a client subscription. Its id stays the same across upstream reconnects
+// while the upstream subscription id changes.
+type wsSubscription struct {
+	session    *WSSession
+	id         string
+	params     json.RawMessage
+	upstreamID string
+}
+
+// wsUpstream is the shared upstream connection of a chain
+type wsUpstream struct {
+	gateway *WSGateway
+	chainID int
+	done    chan struct{}
+
+	writeMu sync.Mutex
+
+	mu            sync.Mutex
+	conn          *websocket.Conn
+	closed        bool
+	sessions      map[*WSSession]bool
+	nextID        uint64
+	pending       map[uint64]wsPending
+	subscriptions map[string]*wsSubscription
+}
+
+// join adds a session, reporting false when the connection is already shutting down
+func (u *wsUpstream) join(session *WSSession) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.closed {
+		return false
+	}
+	session.upstream = u
+	u.sessions[session] = true
+	return true
+}
+
+// leave removes a session and its subscriptions, closing the connection after the last session
+func (u *wsUpstream) leave(session *WSSession) {
+	u.mu.Lock()
+	if !u.sessions[session] {
+		u.mu.Unlock()
+		return
+	}
+	delete(u.sessions, session)
+
+	var upstreamIDs []string
+	for id, subscription := range session.subscriptions {
+		delete(session.subscriptions, id)
+		if subscription.upstreamID != "" {
+			delete(u.subscriptions, subscription.upstreamID)
+			upstreamIDs = append(upstreamIDs, subscription.upstreamID)
+		}
+	}
+
+	last := len(u.sessions) == 0
+	conn := u.conn
+	if last {
+		u.closed = true
+		close(u.done)
+	}
+	u.mu.Unlock()
+
+	if last {
+		u.gateway.remove(u)
+		if conn != nil {
+			conn.Close()
+		}
+		return
+	}
+	for _, upstreamID := range upstreamIDs {
+		u.unsubscribe(upstreamID)
+	}
+}
+
+// call sends a request upstream under a fresh id and registers where its answer goes
+func (u *wsUpstream) call(method string, params json.RawMessage, pending wsPending) error {
+	u.mu.Lock()
+	conn := u.conn
+	if conn == nil {
+		u.mu.Unlock()
+		return errUpstreamDisconnected
+	}
+	u.nextID++
+	id := u.nextID
+	u.pending[id] = pending
+	u.mu.Unlock()
+
+	body, err := json.Marshal(RPCRequest{JSONRPC: "2.0", Method: method, Params: params, ID: id})
+	if err != nil {
+		return err
+	}
+
+	u.writeMu.Lock()
+	err = websocket.Message.Send(conn, string(body))
+	u.writeMu.Unlock()
+	if err != nil {
+		u.mu.Lock()
+		delete(u.pending, id)
+		u.mu.Unlock()
+		// The read loop notices the closed connection and redials
+		conn.Close()
+		return errUpstreamDisconnected
+	}
+	return nil
+}
+
+// unsubscribe cancels an upstream subscription, discarding the answer
+func (u *wsUpstream) unsubscribe(upstreamID string) {
+	params, _ := json.Marshal([]string{upstreamID})
+	u.call("eth_unsubscribe", params, wsPending{})
+}
+
+// run reads from the upstream connection, redialling it whenever it drops, until the
+// last session has left
+func (u *wsUpstream) run(conn *websocket.Conn) {
+	for conn != nil {
+		for {
+			var message []byte
+			if err := websocket.Message.Receive(conn, &message); err != nil {
+				break
+			}
+			u.dispatch(message)
+		}
+		conn = u.reconnect()
+	}
+}
+
+// wsIncoming is a message received from the upstream: a call's answer or a subscription notification
+type wsIncoming struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *RPCError       `json:"error"`
+}
+
+// wsNotificationParams are the params of an eth_subscription notification
+type wsNotificationParams struct {
+	Subscription string          `json:"subscription"`
+	Result       json.RawMessage `json:"result"`
+}
+
+// wsNotification is a subscription notification sent to a client
+type wsNotification struct {
+	JSONRPC string               `json:"jsonrpc"`
+	Method  string               `json:"method"`
+	Params  wsNotificationParams `json:"params"`
+}
+
+// dispatch routes an upstream message to the session waiting for it
+func (u *wsUpstream) dispatch(message []byte) {
+	var incoming wsIncoming
+	if err := json.Unmarshal(message, &incoming); err != nil {
+		return
+	}
+	if incoming.Method != "" {
+		u.notify(incoming)
+		return
+	}
+
+	id, err := strconv.ParseUint(string(incoming.ID), 10, 64)
+	if err != nil {
+		return
+	}
+	u.mu.Lock()
+	pending, ok := u.pending[id]
+	delete(u.pending, id)
+	u.mu.Unlock()
+	if !ok || pending.session == nil {
+		return
+	}
+
+	if pending.subscription != nil {
+		u.subscribed(pending, incoming)
+		return
+	}
+	pending.session.send(RPCResponse{JSONRPC: "2.0", Result: incoming.Result, Error: incoming.Error, ID: pending.id})
+}
+
+// subscribed records the upstream id of a new or renewed subscription and answers the client
+func (u *wsUpstream) subscribed(pending wsPending, incoming wsIncoming) {
+	subscription := pending.subscription
+
+	var upstreamID string
+	if incoming.Error != nil || json.Unmarshal(incoming.Result, &upstreamID) != nil || upstreamID == "" {
+		if pending.resubscribe {
+			// The client would wait for notifications that never come, so the dead subscription
+			// is dropped and the session closed for the client to subscribe again
+			log.Printf("WebSocket: failed to renew subscription %s on chain %d, closing its session", subscription.id, u.chainID)
+			u.mu.Lock()
+			if subscription.session.subscriptions[subscription.id] == subscription {
+				delete(subscription.session.subscriptions, subscription.id)
+			}
+			u.mu.Unlock()
+			subscription.session.stop()
+			return
+		}
+		pending.session.send(RPCResponse{JSONRPC: "2.0", Result: incoming.Result, Error: incoming.Error, ID: pending.id})
+		return
+	}
+
+	u.mu.Lock()
+	session := subscription.session
+	// A renewed subscription may have been cancelled by the client in the meantime
+	active := u.sessions[session] && (!pending.resubscribe || session.subscriptions[subscription.id] == subscription)
+	if active {
+		subscription.upstreamID = upstreamID
+		subscription.session.subscriptions[subscription.id] = subscription
+		u.subscriptions[upstreamID] = subscription
+	}
+	u.mu.Unlock()
+
+	// The session left or cancelled the subscription before it was confirmed
+	if !active {
+		u.unsubscribe(upstreamID)
+		return
+	}
+	if !pending.resubscribe {
+		result, _ := json.Marshal(subscription.id)
+		pending.session.send(RPCResponse{JSONRPC: "2.0", Result: result, ID: pending.id})
+	}
+}
+
+// notify forwards a subscription notification under the client's subscription id
+func (u *wsUpstream) notify(incoming wsIncoming) {
+	var params wsNotificationParams
+	if err := json.Unmarshal(incoming.Params, &params); err != nil {
+		return
+	}
+
+	u.mu.Lock()
+	subscription, ok := u.subscriptions[params.Subscription]
+	u.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	params.Subscription = subscription.id
+	subscription.session.send(wsNotification{JSONRPC: "2.0", Method: incoming.Method, Params: params})
+}
+
+// reconnect fails the calls lost with the dropped connection, redials with backoff and renews
+// every subscription. It returns nil once the last session has left.
+func (u *wsUpstream) reconnect() *websocket.Conn {
+	u.mu.Lock()
+	u.conn = nil
+	lost := u.pending
+	u.pending = make(map[uint64]wsPending)
+	u.subscriptions = make(map[string]*wsSubscription)
+	u.mu.Unlock()
+
+	for _, pending := range lost {
+		if pending.session != nil && !pending.resubscribe {
+			pending.session.send(batchErrorResponse(pending.id, JSONRPCInternalError, "upstream WebSocket connection lost"))
+		}
+	}
+
+	delay := u.gateway.reconnectDelay
+	for {
+		select {
+		case <-u.done:
+			return nil
+		default:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), wsDialTimeout)
+		conn, err := u.gateway.dial(ctx, u.chainID)
+		cancel()
+		if err == nil {
+			u.mu.Lock()
+			if u.closed {
+				u.mu.Unlock()
+				conn.Close()
+				return nil
+			}
+			u.conn = conn
+			var renew []*wsSubscription
+			for session := range u.sessions {
+				for _, subscription := range session.subscriptions {
+					subscription.upstreamID = ""
+					renew = append(renew, subscription)
+				}
+			}
+			u.mu.Unlock()
+
+			for _, subscription := range renew {
+				u.call("eth_subscribe", subscription.params, wsPending{
+					session:      subscription.session,
+					subscription: subscription,
+					resubscribe:  true,
+				})
+			}
+			return conn
+		}
+		log.Printf("WebSocket: failed to reconnect chain %d: %v", u.chainID, err)
+
+		select {
+		case <-u.done:
+			return nil
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxWSReconnectDelay {
+			delay = maxWSReconnectDelay
+		}
+	}
+}
+
+// WSSession is one client connection relayed through a chain's shared upstream connection.
+// Messages for the client are queued and written by the session's own writer, so a slow
+// client never holds up the upstream connection shared with other sessions.
+type WSSession struct {
+	upstream *wsUpstream
+	outbox   chan []byte
+	done     chan struct{}
+	stopOnce sync.Once
+
+	// subscriptions are the session's confirmed subscriptions by client id, guarded by upstream.mu
+	subscriptions map[string]*wsSubscription
+}
+
+// newWSSession creates a session not yet joined to an upstream connection
+func newWSSession() *WSSession {
+	return &WSSession{
+		outbox:        make(chan []byte, wsSessionQueueSize),
+		done:          make(chan struct{}),
+		subscriptions: make(map[string]*wsSubscription),
+	}
+}
+
+// Serve relays calls from the client connection until it closes. Calls to methods that
+// allowed rejects are answered with an error without going upstream.
+func (s *WSSession) Serve(client *websocket.Conn, allowed func(method string) bool) {
+	go s.write(client)
+	defer s.Close()
+
+	for {
+		var message []byte
+		if err := websocket.Message.Receive(client, &message); err != nil {
+			return
+		}
+		s.handle(message, allowed)
+	}
+}
+
+// Close leaves the upstream connection and cancels the session's subscriptions
+func (s *WSSession) Close() {
+	s.stop()
+	s.upstream.leave(s)
+}
+
+// stop makes the writer close the client connection, which ends Serve
+func (s *WSSession) stop() {
+	s.stopOnce.Do(func() { close(s.done) })
+}
+
+// write sends queued messages to the client until the session stops or a write fails
+func (s *WSSession) write(client *websocket.Conn) {
+	defer client.Close()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case body := <-s.outbox:
+			client.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := websocket.Message.Send(client, string(body)); err != nil {
+				s.stop()
+				return
+			}
+		}
+	}
+}
+
+// handle relays one client call
+func (s *WSSession) handle(message []byte, allowed func(method string) bool) {
+	if IsBatchRequest(message) {
+		s.send(batchErrorResponse(nil, JSONRPCInvalidRequest, "batch requests are not supported over WebSocket"))
+		return
+	}
+
+	var request RPCRequest
+	if err := json.Unmarshal(message, &request); err != nil || request.Method == "" {
+		s.send(batchErrorResponse(request.ID, JSONRPCInvalidRequest, "invalid JSON-RPC request"))
+		return
+	}
+	if allowed != nil && !allowed(request.Method) {
+		s.send(RPCResponse{JSONRPC: "2.0", Error: MethodNotAllowedError(request.Method), ID: request.ID})
+		return
+	}
+
+	var err error
+	switch request.Method {
+	case "eth_subscribe":
+		subscription := &wsSubscription{session: s, id: newSubscriptionID(), params: request.Params}
+		err = s.upstream.call(request.Method, request.Params, wsPending{session: s, id: request.ID, subscription: subscription})
+	case "eth_unsubscribe":
+		s.unsubscribe(request)
+	default:
+		err = s.upstream.call(request.Method, request.Params, wsPending{session: s, id: request.ID})
+	}
+	if err != nil {
+		s.send(batchErrorResponse(request.ID, JSONRPCInternalError, err.Error()))
+	}
+}
+
+// unsubscribe cancels one of the session's subscriptions and answers whether it existed
+func (s *WSSession) unsubscribe(request RPCRequest) {
+	var ids []string
+	json.Unmarshal(request.Params, &ids)
+
+	var subscription *wsSubscription
+	var upstreamID string
+	u := s.upstream
+	u.mu.Lock()
+	if len(ids) > 0 {
+		subscription = s.subscriptions[ids[0]]
+	}
+	if subscription != nil {
+		delete(s.subscriptions, subscription.id)
+		upstreamID = subscription.upstreamID
+		if upstreamID != "" {
+			delete(u.subscriptions, upstreamID)
+		}
+	}
+	u.mu.Unlock()
+
+	if upstreamID != "" {
+		u.unsubscribe(upstreamID)
+	}
+	result, _ := json.Marshal(subscription != nil)
+	s.send(RPCResponse{JSONRPC: "2.0", Result: result, ID: request.ID})
+}
+
+// send queues a message for the client without blocking. A client too slow to keep up with
+// its queue is disconnected rather than allowed to delay other sessions.
+func (s *WSSession) send(message interface{}) {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return
+	}
+
+	select {
+	case <-s.done:
+	case s.outbox <- body:
+	default:
+		log.Printf("WebSocket: closing session on chain %d whose client is not keeping up", s.upstream.chainID)
+		s.stop()
+	}
+}
+
+// newSubscriptionID returns a random client-facing subscription id
+func newSubscriptionID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return "0x" + hex.EncodeToString(id)
+}
+
+// wsFrame is a WebSocket message together with its frame type
+type wsFrame struct {
+	data        []byte
+	payloadType byte
+}
+
+// frameCodec sends and receives messages without changing their frame type
+var frameCodec = websocket.Codec{
+	Marshal: func(v interface{}) ([]byte, byte, error) {
+		frame := v.(wsFrame)
+		return frame.data, frame.payloadType, nil
+	},
+	Unmarshal: func(data []byte, payloadType byte, v interface{}) error {
+		*v.(*wsFrame) = wsFrame{data: data, payloadType: payloadType}
+		return nil
+	},
+}
+
+// ProxyWebSocket copies messages between two WebSocket connections until either closes
+func ProxyWebSocket(client, upstream *websocket.Conn) {
+	done := make(chan struct{}, 2)
+	copyMessages := func(dst, src *websocket.Conn) {
+		defer func() { done <- struct{}{} }()
+		for {
+			var frame wsFrame
+			if err := frameCodec.Receive(src, &frame); err != nil {
+				return
+			}
+			if err := frameCodec.Send(dst, frame); err != nil {
+				return
+			}
+		}
+	}
+
+	go copyMessages(upstream, client)
+	go copyMessages(client, upstream)
+	<-done
+	client.Close()
+	upstream.Close()
+	<-done
+}
+
+// dialWebSocket opens a WebSocket connection with the endpoint's credentials, sending the
+// endpoint's HTTP URL as the origin
+func dialWebSocket(ctx context.Context, wsURL, origin string, auth *models.EndpointAuth) (*websocket.Conn, error) {
+	if origin == "" {
+		origin = httpURL(wsURL)
+	}
+	config, err := websocket.NewConfig(wsURL, origin)
+	if err != nil {
+		return nil, err
+	}
+	applyAuth(config.Header, config.Location, auth)
+
+	conn, err := config.DialContext(ctx)
+	if err != nil {
+		// Dial errors quote the location, which now carries the credentials
+		var dialErr *websocket.DialError
+		if errors.As(err, &dialErr) {
+			return nil, fmt.Errorf("websocket.Dial %s: %w", models.RedactURL(wsURL), dialErr.Err)
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+// websocketURL converts an http(s) URL into the matching ws(s) URL
+func websocketURL(url string) string {
+	switch {
+	case strings.HasPrefix(url, "https://"):
+		return "wss://" + strings.TrimPrefix(url, "https://")
+	case strings.HasPrefix(url, "http://"):
+		return "ws://" + strings.TrimPrefix(url, "http://")
+	}
+	return url
+}
+
+// httpURL converts a ws(s) URL into the matching http(s) URL
+func httpURL(url string) string {
+	switch {
+	case strings.HasPrefix(url, "wss://"):
+		return "https://" + strings.TrimPrefix(url, "wss://")
+	case strings.HasPrefix(url, "ws://"):
+		return "http://" + strings.TrimPrefix(url, "ws://")
+	}
+	return url
+}
@@ -0,0 +1,276 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/illegalcall/viper-client/internal/models"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/websocket"
+)
+
+// fakeWSNode is an upstream node answering calls and subscriptions over WebSocket
+type fakeWSNode struct {
+	server *httptest.Server
+
+	mu            sync.Mutex
+	conns         []*websocket.Conn
+	subscribes    int
+	unsubscribed  []string
+	subscriptions map[string]*websocket.Conn
+}
+
+func newFakeWSNode() *fakeWSNode {
+	node := &fakeWSNode{subscriptions: make(map[string]*websocket.Conn)}
+	node.server = httptest.NewServer(websocket.Handler(node.serve))
+	return node
+}
+
+func (n *fakeWSNode) url() string {
+	return websocketURL(n.server.URL)
+}
+
+func (n *fakeWSNode) serve(conn *websocket.Conn) {
+	n.mu.Lock()
+	n.conns = append(n.conns, conn)
+	n.mu.Unlock()
+
+	for {
+		var request RPCRequest
+		if err := websocket.JSON.Receive(conn, &request); err != nil {
+			return
+		}
+
+		var result interface{}
+		switch request.Method {
+		case "eth_subscribe":
+			n.mu.Lock()
+			n.subscribes++
+			id := "0xup" + strconv.Itoa(n.subscribes)
+			n.subscriptions[id] = conn
+			n.mu.Unlock()
+			result = id
+		case "eth_unsubscribe":
+			var ids []string
+			json.Unmarshal(request.Params, &ids)
+			n.mu.Lock()
+			n.unsubscribed = append(n.unsubscribed, ids...)
+			n.mu.Unlock()
+			result = true
+		default:
+			result = request.Method
+		}
+		websocket.JSON.Send(conn, map[string]interface{}{"jsonrpc": "2.0", "id": request.ID, "result": result})
+	}
+}
+
+// publish sends a notification to the connection holding the upstream subscription
+func (n *fakeWSNode) publish(upstreamID string, result string) {
+	n.mu.Lock()
+	conn := n.subscriptions[upstreamID]
+	n.mu.Unlock()
+
+	websocket.JSON.Send(conn, map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  "eth_subscription",
+		"params":  map[string]interface{}{"subscription": upstreamID, "result": result},
+	})
+}
+
+// drop closes every open upstream connection
+func (n *fakeWSNode) drop() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, conn := range n.conns {
+		conn.Close()
+	}
+	n.conns = nil
+}
+
+func (n *fakeWSNode) subscribeCount() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.subscribes
+}
+
+// newTestGateway serves client sessions of chain 2 through a gateway dialling the node
+func newTestGateway(t *testing.T, node *fakeWSNode) *httptest.Server {
+	mockManager := new(MockEndpointManager)
+	mockManager.On("GetActiveEndpoints", 2).Return([]models.RpcEndpoint{
+		{ID: 1, ChainID: 2, EndpointURL: node.server.URL, WSURL: node.url(), Priority: 1},
+	}, nil)
+
+	gateway := NewWSGateway(mockManager)
+	gateway.SetReconnectDelay(10 * time.Millisecond)
+
+	return httptest.NewServer(websocket.Handler(func(conn *websocket.Conn) {
+		session, err := gateway.Connect(context.Background(), 2)
+		if !assert.NoError(t, err) {
+			return
+		}
+		session.Serve(conn, func(method string) bool {
+			return !strings.HasPrefix(method, "debug_")
+		})
+	}))
+}
+
+func dialTestClient(t *testing.T, server *httptest.Server) *websocket.Conn {
+	conn, err := websocket.Dial(websocketURL(server.URL), "", server.URL)
+	if err != nil {
+		t.Fatalf("failed to dial gateway: %v", err)
+	}
+	return conn
+}
+
+func receive(t *testing.T, conn *websocket.Conn) map[string]interface{} {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var message map[string]interface{}
+	if err := websocket.JSON.Receive(conn, &message); err != nil {
+		t.Fatalf("failed to receive message: %v", err)
+	}
+	return message
+}
+
+func TestWSGateway_Call(t *testing.T) {
+	node := newFakeWSNode()
+	defer node.server.Close()
+	gateway := newTestGateway(t, node)
+	defer gateway.Close()
+
+	client := dialTestClient(t, gateway)
+	defer client.Close()
+
+	websocket.Message.Send(client, `{"jsonrpc":"2.0","method":"eth_blockNumber","params":[],"id":"abc"}`)
+	response := receive(t, client)
+	assert.Equal(t, "abc", response["id"])
+	assert.Equal(t, "eth_blockNumber", response["result"])
+
+	websocket.Message.Send(client, `{"jsonrpc":"2.0","method":"debug_traceTransaction","params":["0x1"],"id":7}`)
+	response = receive(t, client)
+	assert.Equal(t, float64(7), response["id"])
+	assert.Equal(t, float64(JSONRPCMethodNotAllowed), response["error"].(map[string]interface{})["code"])
+
+	websocket.Message.Send(client, `[{"jsonrpc":"2.0","method":"eth_chainId","id":1}]`)
+	response = receive(t, client)
+	assert.Equal(t, float64(JSONRPCInvalidRequest), response["error"].(map[string]interface{})["code"])
+}
+
+func TestWSGateway_SubscriptionSurvivesReconnect(t *testing.T) {
+	node := newFakeWSNode()
+	defer node.server.Close()
+	gateway := newTestGateway(t, node)
+	defer gateway.Close()
+
+	client := dialTestClient(t, gateway)
+	defer client.Close()
+
+	websocket.Message.Send(client, `{"jsonrpc":"2.0","method":"eth_subscribe","params":["newHeads"],"id":1}`)
+	response := receive(t, client)
+	subscriptionID, ok := response["result"].(string)
+	if !ok {
+		t.Fatalf("unexpected subscribe response: %v", response)
+	}
+	assert.NotEqual(t, "0xup1", subscriptionID)
+
+	node.publish("0xup1", "0xblock1")
+	notification := receive(t, client)
+	assert.Equal(t, "eth_subscription", notification["method"])
+	assert.Equal(t, subscriptionID, notification["params"].(map[string]interface{})["subscription"])
+	assert.Equal(t, "0xblock1", notification["params"].(map[string]interface{})["result"])
+
+	// The gateway redials and renews the subscription under a new upstream id
+	node.drop()
+	assert.Eventually(t, func() bool { return node.subscribeCount() == 2 }, 5*time.Second, 10*time.Millisecond)
+	// Wait for the renewal to be confirmed before publishing on it
+	websocket.Message.Send(client, `{"jsonrpc":"2.0","method":"net_version","id":2}`)
+	assert.Equal(t, "net_version", receive(t, client)["result"])
+
+	node.publish("0xup2", "0xblock2")
+	notification = receive(t, client)
+	assert.Equal(t, subscriptionID, notification["params"].(map[string]interface{})["subscription"])
+	assert.Equal(t, "0xblock2", notification["params"].(map[string]interface{})["result"])
+
+	websocket.Message.Send(client, `{"jsonrpc":"2.0","method":"eth_unsubscribe","params":["`+subscriptionID+`"],"id":3}`)
+	response = receive(t, client)
+	assert.Equal(t, true, response["result"])
+	assert.Eventually(t, func() bool {
+		node.mu.Lock()
+		defer node.mu.Unlock()
+		return len(node.unsubscribed) == 1 && node.unsubscribed[0] == "0xup2"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	websocket.Message.Send(client, `{"jsonrpc":"2.0","method":"eth_unsubscribe","params":["`+subscriptionID+`"],"id":4}`)
+	assert.Equal(t, false, receive(t, client)["result"])
+}
+
+func TestWSGateway_ConcurrentConnectsShareOneDial(t *testing.T) {
+	node := newFakeWSNode()
+	defer node.server.Close()
+
+	mockManager := new(MockEndpointManager)
+	mockManager.On("GetActiveEndpoints", 2).Return([]models.RpcEndpoint{
+		{ID: 1, ChainID: 2, EndpointURL: node.server.URL, WSURL: node.url(), Priority: 1},
+	}, nil)
+	gateway := NewWSGateway(mockManager)
+
+	var wg sync.WaitGroup
+	sessions := make([]*WSSession, 5)
+	for i := range sessions {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			session, err := gateway.Connect(context.Background(), 2)
+			assert.NoError(t, err)
+			sessions[i] = session
+		}(i)
+	}
+	wg.Wait()
+
+	node.mu.Lock()
+	assert.Len(t, node.conns, 1)
+	node.mu.Unlock()
+	for _, session := range sessions {
+		assert.Same(t, sessions[0].upstream, session.upstream)
+		session.Close()
+	}
+}
+
+func TestWSUpstream_FailedRenewalClosesSession(t *testing.T) {
+	session := newWSSession()
+	upstream := &wsUpstream{chainID: 2, sessions: map[*WSSession]bool{session: true}, subscriptions: make(map[string]*wsSubscription)}
+	session.upstream = upstream
+	subscription := &wsSubscription{session: session, id: "0xclient", params: json.RawMessage(`["newHeads"]`)}
+	session.subscriptions[subscription.id] = subscription
+
+	upstream.subscribed(wsPending{session: session, subscription: subscription, resubscribe: true}, wsIncoming{
+		Error: &RPCError{Code: -32000, Message: "subscriptions not available"},
+	})
+
+	assert.Empty(t, session.subscriptions)
+	select {
+	case <-session.done:
+	default:
+		t.Fatal("session was not closed after its subscription could not be renewed")
+	}
+}
+
+func TestWSSession_ClosesWhenClientFallsBehind(t *testing.T) {
+	session := newWSSession()
+	session.upstream = &wsUpstream{chainID: 2}
+
+	for i := 0; i <= wsSessionQueueSize; i++ {
+		session.send(RPCResponse{JSONRPC: "2.0", ID: i})
+	}
+
+	select {
+	case <-session.done:
+	default:
+		t.Fatal("session was not closed after its queue overflowed")
+	}
+}
@@ -0,0 +1,43 @@
+package secrets
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCipher_RoundTrip(t *testing.T) {
+	cipher, err := NewCipher(strings.Repeat("ab", 32))
+	assert.NoError(t, err)
+
+	encrypted, err := cipher.Encrypt([]byte(`{"bearer_token":"secret"}`))
+	assert.NoError(t, err)
+	assert.NotContains(t, encrypted, "secret")
+
+	again, err := cipher.Encrypt([]byte(`{"bearer_token":"secret"}`))
+	assert.NoError(t, err)
+	assert.NotEqual(t, encrypted, again)
+
+	decrypted, err := cipher.Decrypt(encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"bearer_token":"secret"}`, string(decrypted))
+}
+
+func TestCipher_WrongKey(t *testing.T) {
+	cipher, err := NewCipher(strings.Repeat("ab", 32))
+	assert.NoError(t, err)
+	other, err := NewCipher("AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=")
+	assert.NoError(t, err)
+
+	encrypted, err := cipher.Encrypt([]byte("secret"))
+	assert.NoError(t, err)
+
+	_, err = other.Decrypt(encrypted)
+	assert.Equal(t, ErrInvalidCiphertext, err)
+	_, err = cipher.Decrypt("not base64!")
+	assert.Equal(t, ErrInvalidCiphertext, err)
+
+	_, err = NewCipher("too-short")
+	assert.Equal(t, ErrInvalidKey, err)
+}
@@ -24,6 +24,7 @@ func NewService(db *sql.DB) *Service {
 type LogStats struct {
 	Period     string `json:"period" example:"2024-03-20T10:00:00Z"`
 	Count      int    `json:"count" example:"150"`
+	Bytes      int64  `json:"bytes" example:"48213"`
 	ChainID    int    `json:"chain_id" example:"1"`
 	Endpoint   string `json:"endpoint,omitempty" example:"eth_blockNumber"`
 	APIKey     string `json:"api_key,omitempty" example:"your-api-key-here"`
@@ -64,6 +65,7 @@ func (s *Service) GetStats(filter StatsFilter) ([]LogStats, error) {
 	baseQuery := `
 		SELECT 
 			COUNT(*) as request_count,
+			COALESCE(SUM(response_bytes), 0) as response_bytes,
 			chain_id,
 			%s
 			api_key,
@@ -151,7 +153,7 @@ func (s *Service) GetStats(filter StatsFilter) ([]LogStats, error) {
 		var stat LogStats
 		var period time.Time
 
-		if err := rows.Scan(&stat.Count, &stat.ChainID, &period, &stat.APIKey, &stat.Endpoint); err != nil {
+		if err := rows.Scan(&stat.Count, &stat.Bytes, &stat.ChainID, &period, &stat.APIKey, &stat.Endpoint); err != nil {
 			return nil, err
 		}
 
@@ -4,4 +4,4 @@ VALUES
 
 INSERT INTO rpc_endpoints (chain_id, endpoint_url, provider, is_active, priority, geozone)
 VALUES 
-    ((SELECT id FROM chain_static WHERE chain_id = 0002), 'https://eth-mainnet.g.alchemy.com/v2/IpUziTXbC3yeVTYO6I71KRGtcS9QGUuv', 'Alchemy', true, 10, 'IND');
\ No newline at end of file
+    ((SELECT id FROM chain_static WHERE chain_id = 0002), 'https://eth-mainnet.g.alchemy.com/v2', 'Alchemy', true, 10, 'IND');
\ No newline at end of file
@@ -0,0 +1,5 @@
+ALTER TABLE rpc_endpoints
+    DROP COLUMN IF EXISTS last_probe_ok,
+    DROP COLUMN IF EXISTS last_probe_at,
+    DROP COLUMN IF EXISTS probe_latency_ms,
+    DROP COLUMN IF EXISTS probe_block_height;
@@ -0,0 +1,5 @@
+ALTER TABLE rpc_endpoints
+    ADD COLUMN IF NOT EXISTS last_probe_ok BOOLEAN,
+    ADD COLUMN IF NOT EXISTS last_probe_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS probe_latency_ms INTEGER,
+    ADD COLUMN IF NOT EXISTS probe_block_height BIGINT;
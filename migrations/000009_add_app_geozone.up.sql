@@ -0,0 +1 @@
+ALTER TABLE apps ADD COLUMN IF NOT EXISTS geozone VARCHAR(100);
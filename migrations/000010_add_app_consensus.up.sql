@@ -0,0 +1 @@
+ALTER TABLE apps ADD COLUMN IF NOT EXISTS consensus_endpoints INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS routing_rules;
+ALTER TABLE rpc_endpoints DROP COLUMN IF EXISTS capabilities;
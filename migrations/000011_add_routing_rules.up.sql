@@ -0,0 +1,24 @@
+ALTER TABLE rpc_endpoints ADD COLUMN IF NOT EXISTS capabilities TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE TABLE IF NOT EXISTS routing_rules (
+  id SERIAL PRIMARY KEY,
+  chain_id INTEGER REFERENCES chain_static(chain_id) ON DELETE CASCADE,
+  method_pattern VARCHAR(255) NOT NULL,
+  block_condition VARCHAR(50) NOT NULL DEFAULT 'any',
+  required_capabilities TEXT[] NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Rules without a chain apply to every chain
+INSERT INTO routing_rules (chain_id, method_pattern, block_condition, required_capabilities) VALUES
+  (NULL, 'trace_*', 'any', '{trace}'),
+  (NULL, 'debug_*', 'any', '{debug}'),
+  (NULL, 'eth_subscribe', 'any', '{websocket}'),
+  (NULL, 'eth_getBalance', 'historical', '{archive}'),
+  (NULL, 'eth_getCode', 'historical', '{archive}'),
+  (NULL, 'eth_getStorageAt', 'historical', '{archive}'),
+  (NULL, 'eth_getTransactionCount', 'historical', '{archive}'),
+  (NULL, 'eth_getProof', 'historical', '{archive}'),
+  (NULL, 'eth_call', 'historical', '{archive}'),
+  (NULL, 'eth_estimateGas', 'historical', '{archive}');
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS broadcast_outcomes (
+  id SERIAL PRIMARY KEY,
+  tx_hash VARCHAR(66) NOT NULL,
+  chain_id INTEGER NOT NULL,
+  endpoint_id INTEGER NOT NULL REFERENCES rpc_endpoints(id) ON DELETE CASCADE,
+  accepted BOOLEAN NOT NULL,
+  already_known BOOLEAN NOT NULL DEFAULT FALSE,
+  error TEXT,
+  latency_ms INTEGER,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_broadcast_outcomes_tx_hash ON broadcast_outcomes(tx_hash);
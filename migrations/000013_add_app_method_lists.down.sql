@@ -0,0 +1,3 @@
+ALTER TABLE apps
+    DROP COLUMN IF EXISTS allowed_methods,
+    DROP COLUMN IF EXISTS denied_methods;
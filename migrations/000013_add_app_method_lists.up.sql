@@ -0,0 +1,3 @@
+ALTER TABLE apps
+    ADD COLUMN IF NOT EXISTS allowed_methods TEXT[] NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS denied_methods TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1 @@
+UPDATE chain_static SET chain_details = chain_details - 'protocol' WHERE chain_details ? 'protocol';
@@ -0,0 +1,5 @@
+-- Declare the upstream protocol of existing chains; chains without one are treated
+-- as EVM when is_evm is set and as plain JSON-RPC otherwise
+UPDATE chain_static
+SET chain_details = COALESCE(chain_details, '{}'::jsonb) || '{"protocol": "evm"}'::jsonb
+WHERE is_evm;
@@ -0,0 +1,2 @@
+-- WebSocket URL of the endpoint, used by the WebSocket gateway for subscriptions
+ALTER TABLE rpc_endpoints ADD COLUMN IF NOT EXISTS ws_url TEXT;
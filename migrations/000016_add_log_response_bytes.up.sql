@@ -0,0 +1,2 @@
+-- Size of the response sent for the request, recorded on the first row of a batch
+ALTER TABLE logs ADD COLUMN IF NOT EXISTS response_bytes BIGINT NOT NULL DEFAULT 0;
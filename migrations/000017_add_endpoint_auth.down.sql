@@ -0,0 +1 @@
+ALTER TABLE rpc_endpoints DROP COLUMN IF EXISTS auth_config;
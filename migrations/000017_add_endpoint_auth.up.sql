@@ -0,0 +1,3 @@
+-- Upstream credentials of the endpoint: headers, bearer token, basic auth and query keys,
+-- stored as JSON encrypted with the server's ENDPOINT_SECRETS_KEY
+ALTER TABLE rpc_endpoints ADD COLUMN IF NOT EXISTS auth_config TEXT;
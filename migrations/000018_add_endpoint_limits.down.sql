@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS endpoint_usage;
+ALTER TABLE rpc_endpoints DROP COLUMN IF EXISTS monthly_quota;
+ALTER TABLE rpc_endpoints DROP COLUMN IF EXISTS rate_limit_burst;
+ALTER TABLE rpc_endpoints DROP COLUMN IF EXISTS rate_limit_rps;
@@ -0,0 +1,13 @@
+-- Outbound limits of the upstream provider. A rate limit of 0 or NULL and a quota of NULL
+-- mean the endpoint is unlimited; the burst defaults to the rate limit rounded up.
+ALTER TABLE rpc_endpoints ADD COLUMN IF NOT EXISTS rate_limit_rps NUMERIC(10, 2);
+ALTER TABLE rpc_endpoints ADD COLUMN IF NOT EXISTS rate_limit_burst INT;
+ALTER TABLE rpc_endpoints ADD COLUMN IF NOT EXISTS monthly_quota BIGINT;
+
+-- Requests sent to each endpoint per calendar month (UTC), counted against its quota
+CREATE TABLE IF NOT EXISTS endpoint_usage (
+    endpoint_id INT NOT NULL REFERENCES rpc_endpoints(id) ON DELETE CASCADE,
+    month DATE NOT NULL,
+    requests BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (endpoint_id, month)
+);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_endpoint_spend_day;
+DROP TABLE IF EXISTS endpoint_spend;
+ALTER TABLE rpc_endpoints DROP COLUMN IF EXISTS method_prices;
+ALTER TABLE rpc_endpoints DROP COLUMN IF EXISTS price_per_request;
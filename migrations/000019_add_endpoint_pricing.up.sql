@@ -0,0 +1,16 @@
+-- What the provider bills per request, and per-method overrides such as {"debug_*": 0.0004}
+ALTER TABLE rpc_endpoints ADD COLUMN IF NOT EXISTS price_per_request NUMERIC(20, 10);
+ALTER TABLE rpc_endpoints ADD COLUMN IF NOT EXISTS method_prices JSONB;
+
+-- Upstream spend per endpoint, app and day (UTC). Requests not made on behalf of an app
+-- are recorded under app 0.
+CREATE TABLE IF NOT EXISTS endpoint_spend (
+    endpoint_id INT NOT NULL REFERENCES rpc_endpoints(id) ON DELETE CASCADE,
+    app_id INT NOT NULL DEFAULT 0,
+    day DATE NOT NULL,
+    requests BIGINT NOT NULL DEFAULT 0,
+    cost NUMERIC(20, 10) NOT NULL DEFAULT 0,
+    PRIMARY KEY (endpoint_id, app_id, day)
+);
+
+CREATE INDEX IF NOT EXISTS idx_endpoint_spend_day ON endpoint_spend(day);
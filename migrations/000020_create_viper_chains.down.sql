@@ -0,0 +1,2 @@
+ALTER TABLE apps DROP COLUMN IF EXISTS viper_chain_id;
+DROP TABLE IF EXISTS viper_chains;
@@ -0,0 +1,17 @@
+-- Viper Network chain identifiers and the chain_static chain each one serves. Chains
+-- reported by /v1/query/supportedchains are added unmapped unless a chain with the same
+-- numeric ID exists.
+CREATE TABLE IF NOT EXISTS viper_chains (
+    viper_chain VARCHAR(16) PRIMARY KEY,
+    chain_id INTEGER UNIQUE REFERENCES chain_static(chain_id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Ethereum was the only chain relayed to through the Viper Network so far
+INSERT INTO viper_chains (viper_chain, chain_id)
+SELECT '0002', chain_id FROM chain_static WHERE chain_id = 0002
+ON CONFLICT (viper_chain) DO NOTHING;
+
+-- Chain an app's relays through the Viper Network target; 0 uses the default target
+ALTER TABLE apps ADD COLUMN IF NOT EXISTS viper_chain_id INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS rpc_endpoints_changed ON rpc_endpoints;
+DROP FUNCTION IF EXISTS notify_rpc_endpoints_changed();
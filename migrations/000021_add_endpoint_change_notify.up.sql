@@ -0,0 +1,27 @@
+-- Tell gateways caching the active endpoints that the endpoints of a chain changed. Health
+-- and probe bookkeeping written by the gateways themselves does not notify, except for a
+-- change of last_probe_ok, which decides whether an endpoint is served.
+CREATE OR REPLACE FUNCTION notify_rpc_endpoints_changed() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND
+       to_jsonb(NEW) - 'health_status' - 'health_check_timestamp' - 'last_probe_at' - 'probe_latency_ms' - 'probe_block_height' - 'updated_at'
+       = to_jsonb(OLD) - 'health_status' - 'health_check_timestamp' - 'last_probe_at' - 'probe_latency_ms' - 'probe_block_height' - 'updated_at' THEN
+        RETURN NULL;
+    END IF;
+
+    IF TG_OP = 'DELETE' THEN
+        PERFORM pg_notify('rpc_endpoints_changed', OLD.chain_id::text);
+    ELSE
+        PERFORM pg_notify('rpc_endpoints_changed', NEW.chain_id::text);
+        IF TG_OP = 'UPDATE' AND OLD.chain_id <> NEW.chain_id THEN
+            PERFORM pg_notify('rpc_endpoints_changed', OLD.chain_id::text);
+        END IF;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS rpc_endpoints_changed ON rpc_endpoints;
+CREATE TRIGGER rpc_endpoints_changed
+AFTER INSERT OR UPDATE OR DELETE ON rpc_endpoints
+FOR EACH ROW EXECUTE FUNCTION notify_rpc_endpoints_changed();
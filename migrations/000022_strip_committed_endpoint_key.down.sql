@@ -0,0 +1,2 @@
+-- The removed key is not restored
+SELECT 1;
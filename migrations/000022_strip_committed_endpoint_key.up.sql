@@ -0,0 +1,6 @@
+-- The Alchemy key seeded by 000007 was committed to the repository in plain text. It is
+-- removed from the endpoint URL, matched by hash so that it is not repeated here. The key
+-- must be rotated with the provider and the new one stored as the endpoint's path_key.
+UPDATE rpc_endpoints
+SET endpoint_url = 'https://eth-mainnet.g.alchemy.com/v2', updated_at = NOW()
+WHERE md5(endpoint_url) = '219f86e45c906f4640c530bb61237beb';